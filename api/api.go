@@ -0,0 +1,723 @@
+package api
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"henrymeds-takehome/model"
+	"time"
+)
+
+// Envelope wraps every successful /v1 response body
+type Envelope[T any] struct {
+	Data T `json:"data"`
+	// only set on lists
+	Page *Page `json:"page,omitempty"`
+}
+
+// Page tells a list's caller how to get the rest of it
+type Page struct {
+	// pass it back as the cursor query parameter for the next page, empty on the last page
+	Next string `json:"next,omitempty"`
+}
+
+// ErrorEnvelope wraps every failed /v1 response body
+type ErrorEnvelope struct {
+	Error Error `json:"error"`
+}
+
+type Error struct {
+	// the HTTP status, repeated so the body stands on its own
+	Status int `json:"status"`
+	// machine readable, one of the Code* constants
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// quote this when reporting a problem, it ties the failure to the server's logs
+	RequestID string `json:"requestId,omitempty"`
+}
+
+const (
+	CodeInvalidRequest   = "invalid_request"
+	CodeUnauthorized     = "unauthorized"
+	CodeNotFound         = "not_found"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeConflict         = "conflict"
+	CodeUnprocessable    = "unprocessable"
+	CodeRateLimited      = "rate_limited"
+	CodeInternal         = "internal"
+	CodeUnavailable      = "unavailable"
+)
+
+type User struct {
+	ID             string `json:"id"`
+	OrganizationID string `json:"organizationId,omitempty"`
+	Username       string `json:"username"`
+	// where the user is located, a two letter state code, left out when it isn't on file
+	State string `json:"state,omitempty"`
+}
+
+// UpdateUser sets where the user is located, an empty state clears it
+type UpdateUser struct {
+	State string `json:"state"`
+}
+
+type TimeRange struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+type Availability struct {
+	ID             string    `json:"id"`
+	OrganizationID string    `json:"organizationId,omitempty"`
+	ProviderID     string    `json:"providerId"`
+	Start          time.Time `json:"start"`
+	End            time.Time `json:"end"`
+	// how many clients can book the same time
+	Capacity int `json:"capacity"`
+	// video, phone or in_person
+	Modalities []string `json:"modalities"`
+	// where in_person visits happen
+	LocationID string `json:"locationId,omitempty"`
+}
+
+// CreateAvailability is the body of a create, without a capacity the availability has one seat and without
+// modalities it offers video and phone visits
+type CreateAvailability struct {
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	Capacity   int       `json:"capacity,omitempty"`
+	Modalities []string  `json:"modalities,omitempty"`
+	// required for in_person
+	LocationID string `json:"locationId,omitempty"`
+}
+
+// Slot is a stretch of a provider's time that can be booked, and how many seats it has left
+type Slot struct {
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	Capacity   int       `json:"capacity"`
+	Remaining  int       `json:"remaining"`
+	Modalities []string  `json:"modalities"`
+	// where the visit happens when it's in_person
+	LocationID string `json:"locationId,omitempty"`
+}
+
+type CreateReservation struct {
+	ClientID   string    `json:"clientId"`
+	ProviderID string    `json:"providerId"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	// left out for the first one the availability offers
+	Modality string `json:"modality,omitempty"`
+	// when set, the reservation has to be as long as it and in a modality it allows
+	AppointmentType string `json:"appointmentType,omitempty"`
+	// why the client is booking, stored encrypted
+	Reason string `json:"reason,omitempty"`
+}
+
+type Reservation struct {
+	ID             string    `json:"id"`
+	OrganizationID string    `json:"organizationId,omitempty"`
+	ClientID       string    `json:"clientId"`
+	ProviderID     string    `json:"providerId"`
+	Start          time.Time `json:"start"`
+	End            time.Time `json:"end"`
+	// held, confirmed, expired or cancelled
+	Status    string    `json:"status"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	// only set once the reservation is cancelled
+	CancelledAt *time.Time `json:"cancelledAt,omitempty"`
+	// only returned when the reservation is created, it's what the client needs to confirm it
+	ConfirmationID string `json:"confirmationId,omitempty"`
+	// video, phone or in_person
+	Modality string `json:"modality"`
+	// where an in_person visit happens
+	LocationID string `json:"locationId,omitempty"`
+	// the room of a video visit, set once the reservation is confirmed
+	VideoURL string `json:"videoUrl,omitempty"`
+	// why the client is booking, left out of events
+	Reason string `json:"reason,omitempty"`
+}
+
+func FromUser(user model.User) User {
+	return User{
+		ID:             user.ID,
+		OrganizationID: user.OrganizationID,
+		Username:       user.Username,
+		State:          user.State,
+	}
+}
+
+func FromAvailability(availability model.Availability) Availability {
+	return Availability{
+		ID:             availability.ID,
+		OrganizationID: availability.OrganizationID,
+		ProviderID:     availability.ProviderID,
+		Start:          availability.Start,
+		End:            availability.End,
+		Capacity:       availability.Capacity,
+		Modalities:     availability.Modalities,
+		LocationID:     availability.LocationID,
+	}
+}
+
+func FromAvailabilities(availabilities []model.Availability) []Availability {
+	result := make([]Availability, 0, len(availabilities))
+	for _, availability := range availabilities {
+		result = append(result, FromAvailability(availability))
+	}
+	return result
+}
+
+func FromSlots(slots []model.Slot) []Slot {
+	result := make([]Slot, 0, len(slots))
+	for _, slot := range slots {
+		result = append(result, Slot{
+			Start:      slot.Start,
+			End:        slot.End,
+			Capacity:   slot.Capacity,
+			Remaining:  slot.Remaining,
+			Modalities: slot.Modalities,
+			LocationID: slot.LocationID,
+		})
+	}
+	return result
+}
+
+func FromReservation(reservation model.Reservation, now time.Time) Reservation {
+	converted := Reservation{
+		ID:             reservation.ID,
+		OrganizationID: reservation.OrganizationID,
+		ClientID:       reservation.ClientID,
+		ProviderID:     reservation.ProviderID,
+		Start:          reservation.Start,
+		End:            reservation.End,
+		Status:         reservation.Status(now),
+		ExpiresAt:      reservation.ExpiresAt,
+		Modality:       reservation.Modality,
+		LocationID:     reservation.LocationID,
+		VideoURL:       reservation.VideoURL,
+		Reason:         reservation.Reason,
+	}
+	if !reservation.CancelledAt.IsZero() {
+		cancelledAt := reservation.CancelledAt
+		converted.CancelledAt = &cancelledAt
+	}
+	return converted
+}
+
+// CalendarToken is a freshly issued calendar feed token, shown once
+type CalendarToken struct {
+	Token string `json:"token"`
+	// the feed URL with the token in it, what a calendar app subscribes to
+	URL string `json:"url"`
+}
+
+// UpdateAvailability is the body of a PATCH, the fields that are left out stay as they are
+type UpdateAvailability struct {
+	Start    *time.Time `json:"start,omitempty"`
+	End      *time.Time `json:"end,omitempty"`
+	Capacity *int       `json:"capacity,omitempty"`
+}
+
+// AvailabilityChange is the result of editing or deleting an availability
+type AvailabilityChange struct {
+	// the availability as it is now, absent after a delete
+	Availability *Availability `json:"availability,omitempty"`
+	// the reservations that lost their availability and were cancelled
+	CancelledReservations []Reservation `json:"cancelledReservations"`
+}
+
+func FromAvailabilityChange(change model.AvailabilityChange, now time.Time) AvailabilityChange {
+	converted := AvailabilityChange{
+		CancelledReservations: make([]Reservation, 0, len(change.Cancelled)),
+	}
+	if change.Availability.ID != "" {
+		availability := FromAvailability(change.Availability)
+		converted.Availability = &availability
+	}
+	for _, reservation := range change.Cancelled {
+		converted.CancelledReservations = append(converted.CancelledReservations, FromReservation(reservation, now))
+	}
+	return converted
+}
+
+// ImportReport is the outcome of a bulk availability import, row by row
+type ImportReport struct {
+	DryRun bool `json:"dryRun"`
+	// whether the rows were inserted, false for a dry run or when any row was rejected
+	Committed bool           `json:"committed"`
+	Total     int            `json:"total"`
+	Rejected  int            `json:"rejected"`
+	Rows      []ImportResult `json:"rows"`
+}
+
+type ImportResult struct {
+	Row int `json:"row"`
+	// created, valid (dry run or held back by another row), invalid or conflict
+	Status string `json:"status"`
+	// absent when the row couldn't be read
+	Start          *time.Time `json:"start,omitempty"`
+	End            *time.Time `json:"end,omitempty"`
+	Capacity       int        `json:"capacity,omitempty"`
+	Modalities     []string   `json:"modalities,omitempty"`
+	LocationID     string     `json:"locationId,omitempty"`
+	AvailabilityID string     `json:"availabilityId,omitempty"`
+	Error          string     `json:"error,omitempty"`
+}
+
+func FromImportReport(report model.ImportReport) ImportReport {
+	result := ImportReport{
+		DryRun:    report.DryRun,
+		Committed: report.Committed,
+		Total:     len(report.Results),
+		Rejected:  report.Rejected(),
+		Rows:      make([]ImportResult, 0, len(report.Results)),
+	}
+	for _, row := range report.Results {
+		converted := ImportResult{
+			Row:            row.Row,
+			Status:         row.Status,
+			Modalities:     row.Modalities,
+			LocationID:     row.LocationID,
+			AvailabilityID: row.AvailabilityID,
+			Error:          row.Error,
+		}
+		if !row.Start.IsZero() {
+			start, end := row.Start, row.End
+			converted.Start, converted.End, converted.Capacity = &start, &end, row.Capacity
+		}
+		result.Rows = append(result.Rows, converted)
+	}
+	return result
+}
+
+// BusySource is an outside calendar whose busy time blocks the provider's availability
+type BusySource struct {
+	ID         string `json:"id"`
+	ProviderID string `json:"providerId"`
+	// upload or url
+	Kind string `json:"kind"`
+	URL  string `json:"url,omitempty"`
+	// busy blocks stored by the last import
+	Blocks int `json:"blocks"`
+	// recurring events the last import couldn't expand and left out
+	Skipped    int        `json:"skipped"`
+	ImportedAt *time.Time `json:"importedAt,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+// CreateBusySource registers a calendar served at a URL, an uploaded calendar is sent as text/calendar instead
+type CreateBusySource struct {
+	URL string `json:"url"`
+}
+
+func FromBusySource(source model.BusySource) BusySource {
+	converted := BusySource{
+		ID:         source.ID,
+		ProviderID: source.ProviderID,
+		Kind:       source.Kind,
+		URL:        source.URL,
+		Blocks:     source.Blocks,
+		Skipped:    source.Skipped,
+		CreatedAt:  source.CreatedAt,
+	}
+	if !source.ImportedAt.IsZero() {
+		importedAt := source.ImportedAt
+		converted.ImportedAt = &importedAt
+	}
+	return converted
+}
+
+func FromBusySources(sources []model.BusySource) []BusySource {
+	converted := make([]BusySource, 0, len(sources))
+	for _, source := range sources {
+		converted = append(converted, FromBusySource(source))
+	}
+	return converted
+}
+
+// ProviderLicense lets the provider see clients located in its state
+type ProviderLicense struct {
+	ID            string `json:"id"`
+	ProviderID    string `json:"providerId"`
+	State         string `json:"state"`
+	LicenseNumber string `json:"licenseNumber"`
+	// the last day the license is valid, YYYY-MM-DD
+	ExpiresOn string    `json:"expiresOn"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// PutProviderLicense adds or renews the provider's license for the state in the path
+type PutProviderLicense struct {
+	LicenseNumber string `json:"licenseNumber"`
+	// YYYY-MM-DD
+	ExpiresOn string `json:"expiresOn"`
+}
+
+func FromProviderLicense(license model.ProviderLicense) ProviderLicense {
+	return ProviderLicense{
+		ID:            license.ID,
+		ProviderID:    license.ProviderID,
+		State:         license.State,
+		LicenseNumber: license.LicenseNumber,
+		ExpiresOn:     license.ExpiresOn.Format(time.DateOnly),
+		CreatedAt:     license.CreatedAt,
+	}
+}
+
+func FromProviderLicenses(licenses []model.ProviderLicense) []ProviderLicense {
+	converted := make([]ProviderLicense, 0, len(licenses))
+	for _, license := range licenses {
+		converted = append(converted, FromProviderLicense(license))
+	}
+	return converted
+}
+
+// Location is a place in-person visits happen at
+type Location struct {
+	ID             string `json:"id"`
+	OrganizationID string `json:"organizationId,omitempty"`
+	Name           string `json:"name"`
+	Address        string `json:"address"`
+	// IANA, ex: America/Los_Angeles
+	TimeZone  string    `json:"timeZone"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type CreateLocation struct {
+	Name     string `json:"name"`
+	Address  string `json:"address"`
+	TimeZone string `json:"timeZone"`
+}
+
+func FromLocation(location model.Location) Location {
+	return Location{
+		ID:             location.ID,
+		OrganizationID: location.OrganizationID,
+		Name:           location.Name,
+		Address:        location.Address,
+		TimeZone:       location.TimeZone,
+		CreatedAt:      location.CreatedAt,
+	}
+}
+
+func FromLocations(locations []model.Location) []Location {
+	converted := make([]Location, 0, len(locations))
+	for _, location := range locations {
+		converted = append(converted, FromLocation(location))
+	}
+	return converted
+}
+
+// Event is a domain event as the outbox publishes it to a sink
+type Event struct {
+	// unique per event, an event can be delivered more than once and this is what tells the copies apart
+	ID string `json:"id"`
+	// ex: reservation.held
+	Type string `json:"type"`
+	// the organization of the reservation or availability
+	OrganizationID string    `json:"organizationId,omitempty"`
+	OccurredAt     time.Time `json:"occurredAt"`
+	// the reservation or availability as it was after the change
+	Data json.RawMessage `json:"data"`
+}
+
+func FromEvent(event model.Event) Event {
+	return Event{
+		ID:             event.ID,
+		Type:           event.Type,
+		OrganizationID: event.OrganizationID,
+		OccurredAt:     event.CreatedAt,
+		Data:           event.Payload,
+	}
+}
+
+// Organization is a tenant, the brand or clinic users and everything about them belong to
+type Organization struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// only returned when the organization is created, requests made with it are scoped to the organization
+	APIKey    string    `json:"apiKey,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type CreateOrganization struct {
+	Name string `json:"name"`
+}
+
+// OrganizationKey is a freshly issued organization API key, shown once
+type OrganizationKey struct {
+	APIKey string `json:"apiKey"`
+}
+
+type WebhookSubscription struct {
+	ID string `json:"id"`
+	// the organization whose events the subscription gets
+	OrganizationID string   `json:"organizationId"`
+	URL            string   `json:"url"`
+	EventTypes     []string `json:"eventTypes"`
+	Active         bool     `json:"active"`
+	// only returned when the subscription is created, it's what the receiver checks the signatures with
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type CreateWebhookSubscription struct {
+	// the subscription only gets this organization's events
+	OrganizationID string   `json:"organizationId"`
+	URL            string   `json:"url"`
+	EventTypes     []string `json:"eventTypes"`
+	// generated when left out
+	Secret string `json:"secret,omitempty"`
+}
+
+// UpdateWebhookSubscription is the body of a PATCH, the fields that are left out stay as they are
+type UpdateWebhookSubscription struct {
+	URL        *string  `json:"url,omitempty"`
+	EventTypes []string `json:"eventTypes,omitempty"`
+	Active     *bool    `json:"active,omitempty"`
+}
+
+type WebhookDelivery struct {
+	ID             string `json:"id"`
+	SubscriptionID string `json:"subscriptionId"`
+	EventID        string `json:"eventId"`
+	EventType      string `json:"eventType"`
+	// pending, delivered or dead
+	Status   string `json:"status"`
+	Attempts int    `json:"attempts"`
+	// only set while the delivery is pending
+	NextAttemptAt *time.Time `json:"nextAttemptAt,omitempty"`
+	// absent when the last attempt got no response
+	LastStatusCode int        `json:"lastStatusCode,omitempty"`
+	LastError      string     `json:"lastError,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	DeliveredAt    *time.Time `json:"deliveredAt,omitempty"`
+	// the event as it's POSTed, only returned when the delivery is looked up on its own
+	Payload json.RawMessage `json:"payload,omitempty"`
+	// likewise, every attempt in the order they were made
+	AttemptLog []WebhookAttempt `json:"attemptLog,omitempty"`
+}
+
+type WebhookAttempt struct {
+	AttemptedAt time.Time `json:"attemptedAt"`
+	// absent when the receiver couldn't be reached
+	StatusCode int    `json:"statusCode,omitempty"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int    `json:"durationMs"`
+}
+
+func FromOrganization(organization model.Organization) Organization {
+	return Organization{
+		ID:        organization.ID,
+		Name:      organization.Name,
+		CreatedAt: organization.CreatedAt,
+	}
+}
+
+func FromOrganizations(organizations []model.Organization) []Organization {
+	result := make([]Organization, 0, len(organizations))
+	for _, organization := range organizations {
+		result = append(result, FromOrganization(organization))
+	}
+	return result
+}
+
+func FromWebhookSubscription(subscription model.WebhookSubscription) WebhookSubscription {
+	return WebhookSubscription{
+		ID:             subscription.ID,
+		OrganizationID: subscription.OrganizationID,
+		URL:            subscription.URL,
+		EventTypes:     subscription.EventTypes,
+		Active:         subscription.Active,
+		CreatedAt:      subscription.CreatedAt,
+		UpdatedAt:      subscription.UpdatedAt,
+	}
+}
+
+func FromWebhookSubscriptions(subscriptions []model.WebhookSubscription) []WebhookSubscription {
+	result := make([]WebhookSubscription, 0, len(subscriptions))
+	for _, subscription := range subscriptions {
+		result = append(result, FromWebhookSubscription(subscription))
+	}
+	return result
+}
+
+func FromWebhookDelivery(delivery model.WebhookDelivery) WebhookDelivery {
+	converted := WebhookDelivery{
+		ID:             delivery.ID,
+		SubscriptionID: delivery.SubscriptionID,
+		EventID:        delivery.EventID,
+		EventType:      delivery.EventType,
+		Status:         delivery.Status,
+		Attempts:       delivery.Attempts,
+		LastStatusCode: delivery.LastStatusCode,
+		LastError:      delivery.LastError,
+		CreatedAt:      delivery.CreatedAt,
+	}
+	if delivery.Status == model.WebhookPending {
+		nextAttemptAt := delivery.NextAttemptAt
+		converted.NextAttemptAt = &nextAttemptAt
+	}
+	if !delivery.DeliveredAt.IsZero() {
+		deliveredAt := delivery.DeliveredAt
+		converted.DeliveredAt = &deliveredAt
+	}
+	return converted
+}
+
+func FromWebhookDeliveries(deliveries []model.WebhookDelivery) []WebhookDelivery {
+	result := make([]WebhookDelivery, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		result = append(result, FromWebhookDelivery(delivery))
+	}
+	return result
+}
+
+// FromWebhookDeliveryDetail is the delivery with its payload and attempts, for looking one up on its own
+func FromWebhookDeliveryDetail(delivery model.WebhookDelivery, attempts []model.WebhookAttempt) WebhookDelivery {
+	converted := FromWebhookDelivery(delivery)
+	converted.Payload = delivery.Payload
+	converted.AttemptLog = make([]WebhookAttempt, 0, len(attempts))
+	for _, attempt := range attempts {
+		converted.AttemptLog = append(converted.AttemptLog, WebhookAttempt{
+			AttemptedAt: attempt.AttemptedAt,
+			StatusCode:  attempt.StatusCode,
+			Error:       attempt.Error,
+			DurationMs:  attempt.DurationMs,
+		})
+	}
+	return converted
+}
+
+type CreateWaitlistEntry struct {
+	ClientID string `json:"clientId"`
+	// left out for any provider
+	ProviderID      string `json:"providerId,omitempty"`
+	AppointmentType string `json:"appointmentType"`
+	// left out for any modality the appointment type allows
+	Modality string    `json:"modality,omitempty"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Priority int       `json:"priority,omitempty"`
+}
+
+type WaitlistEntry struct {
+	ID              string `json:"id"`
+	ClientID        string `json:"clientId"`
+	ProviderID      string `json:"providerId,omitempty"`
+	AppointmentType string `json:"appointmentType"`
+	Modality        string `json:"modality,omitempty"`
+	// the client takes any slot inside it
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Priority int       `json:"priority"`
+	// waiting, offered, booked, expired or cancelled
+	Status string `json:"status"`
+	// the hold the entry was offered, absent until it's offered one
+	ReservationID string     `json:"reservationId,omitempty"`
+	OfferedAt     *time.Time `json:"offeredAt,omitempty"`
+	// only while the offer is open, the hold with what the client needs to confirm it
+	Offer     *Reservation `json:"offer,omitempty"`
+	CreatedAt time.Time    `json:"createdAt"`
+}
+
+func FromWaitlistEntry(entry model.WaitlistEntry, now time.Time) WaitlistEntry {
+	converted := WaitlistEntry{
+		ID:              entry.ID,
+		ClientID:        entry.ClientID,
+		ProviderID:      entry.ProviderID,
+		AppointmentType: entry.AppointmentType,
+		Modality:        entry.Modality,
+		Start:           entry.Start,
+		End:             entry.End,
+		Priority:        entry.Priority,
+		Status:          entry.StatusAt(now),
+		ReservationID:   entry.ReservationID,
+		CreatedAt:       entry.CreatedAt,
+	}
+	if !entry.OfferedAt.IsZero() {
+		offeredAt := entry.OfferedAt
+		converted.OfferedAt = &offeredAt
+	}
+	return converted
+}
+
+// FromWaitlistOffer is the entry with the hold it was offered, the offer is left out once it's no longer held
+func FromWaitlistOffer(entry model.WaitlistEntry, offer model.Reservation, now time.Time) WaitlistEntry {
+	converted := FromWaitlistEntry(entry, now)
+	if offer.ID != "" && offer.Status(now) == model.ReservationStatusHeld {
+		reservation := FromReservation(offer, now)
+		reservation.ConfirmationID = offer.ConfirmationID
+		converted.Offer = &reservation
+	}
+	return converted
+}
+
+type AuditEntry struct {
+	ID string `json:"id"`
+	// the entry's place in its organization's chain
+	Seq int64 `json:"seq"`
+	// absent for rows that aren't any organization's, ex: webhook subscriptions
+	OrganizationID string    `json:"organizationId,omitempty"`
+	OccurredAt     time.Time `json:"occurredAt"`
+	Actor          string    `json:"actor"`
+	Action         string    `json:"action"`
+	EntityType     string    `json:"entityType"`
+	EntityID       string    `json:"entityId"`
+	RequestID      string    `json:"requestId,omitempty"`
+	// the row by column, absent before an insert and after a delete
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+	// hex SHA-256, absent for the first entry of a chain
+	PrevHash string `json:"prevHash,omitempty"`
+	Hash     string `json:"hash"`
+}
+
+type AuditVerification struct {
+	// absent for the chain of rows that aren't any organization's
+	OrganizationID string `json:"organizationId,omitempty"`
+	Checked        int    `json:"checked"`
+	Valid          bool   `json:"valid"`
+	BrokenAt       string `json:"brokenAt,omitempty"`
+}
+
+func FromAuditEntry(entry model.AuditEntry) AuditEntry {
+	return AuditEntry{
+		ID:             entry.ID,
+		Seq:            entry.Seq,
+		OrganizationID: entry.OrganizationID,
+		OccurredAt:     entry.OccurredAt,
+		Actor:          entry.Actor,
+		Action:         entry.Action,
+		EntityType:     entry.EntityType,
+		EntityID:       entry.EntityID,
+		RequestID:      entry.RequestID,
+		Before:         entry.Before,
+		After:          entry.After,
+		PrevHash:       hex.EncodeToString(entry.PrevHash),
+		Hash:           hex.EncodeToString(entry.Hash),
+	}
+}
+
+func FromAuditEntries(entries []model.AuditEntry) []AuditEntry {
+	result := make([]AuditEntry, 0, len(entries))
+	for _, entry := range entries {
+		result = append(result, FromAuditEntry(entry))
+	}
+	return result
+}
+
+func FromAuditVerifications(verifications []model.AuditVerification) []AuditVerification {
+	result := make([]AuditVerification, 0, len(verifications))
+	for _, verification := range verifications {
+		result = append(result, AuditVerification{
+			OrganizationID: verification.OrganizationID,
+			Checked:        verification.Checked,
+			Valid:          verification.Valid,
+			BrokenAt:       verification.BrokenAt,
+		})
+	}
+	return result
+}
@@ -0,0 +1,89 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"henrymeds-takehome/model"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// who made a change, besides an organization's API key
+const (
+	// work no request asked for, ex: the workers and the CLI
+	ActorSystem = "system"
+	// a request made without an API key, ex: a confirmation link, or any request with auth disabled
+	ActorAnonymous = "anonymous"
+	ActorAdmin     = "admin"
+	// the shared API key
+	ActorAPIKey = "api_key"
+)
+
+// OrganizationActor is the actor of a request made with the organization's API key
+func OrganizationActor(id string) string {
+	return "organization:" + id
+}
+
+type contextKey struct{}
+
+// WithActor records who is making the changes further down the stack
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, contextKey{}, actor)
+}
+
+// Actor is who is making the change, ActorSystem unless WithActor said otherwise
+func Actor(ctx context.Context) string {
+	if actor, ok := ctx.Value(contextKey{}).(string); ok {
+		return actor
+	}
+	return ActorSystem
+}
+
+// Middleware marks requests anonymous, the API key check names the actor of the ones made with a key
+func Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.SetRequest(c.Request().WithContext(WithActor(c.Request().Context(), ActorAnonymous)))
+			return next(c)
+		}
+	}
+}
+
+// Hash is the entry's link in its chain, over previous, the hash of the entry before it, and every field the entry
+// was written with. The ID and seq are the database's and left out
+func Hash(previous []byte, entry model.AuditEntry) []byte {
+	sum := sha256.New()
+	for _, field := range []string{
+		string(previous),
+		entry.OrganizationID,
+		// the column keeps microseconds and no zone
+		entry.OccurredAt.UTC().Truncate(time.Microsecond).Format(time.RFC3339Nano),
+		entry.Actor,
+		entry.Action,
+		entry.EntityType,
+		entry.EntityID,
+		entry.RequestID,
+		string(entry.Before),
+		string(entry.After),
+	} {
+		// length prefixed so moving bytes from one field to the next changes the hash
+		fmt.Fprintf(sum, "%d:%s", len(field), field)
+	}
+	return sum.Sum(nil)
+}
+
+// Verify checks that the entries, in chain order, each chain to the one before them and match their hash. previous
+// is the hash of the entry before the first one, nil at the start of the chain. broken is the index of the first
+// entry that doesn't check out, -1 if they all do
+func Verify(previous []byte, entries []model.AuditEntry) (broken int) {
+	for i, entry := range entries {
+		if !bytes.Equal(entry.PrevHash, previous) || !bytes.Equal(entry.Hash, Hash(previous, entry)) {
+			return i
+		}
+		previous = entry.Hash
+	}
+	return -1
+}
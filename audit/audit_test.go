@@ -0,0 +1,117 @@
+package audit
+
+import (
+	"encoding/json"
+	"henrymeds-takehome/model"
+	"testing"
+	"time"
+)
+
+// chain links the entries like the DAO writes them
+func chain(entries ...model.AuditEntry) []model.AuditEntry {
+	var previous []byte
+	for i := range entries {
+		entries[i].PrevHash = previous
+		entries[i].Hash = Hash(previous, entries[i])
+		previous = entries[i].Hash
+	}
+	return entries
+}
+
+func testChain() []model.AuditEntry {
+	at := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	return chain(
+		model.AuditEntry{OrganizationID: "org", OccurredAt: at, Actor: ActorAdmin, Action: "create", EntityType: "organizations", EntityID: "org", After: json.RawMessage(`{"name":"clinic"}`)},
+		model.AuditEntry{OrganizationID: "org", OccurredAt: at.Add(time.Minute), Actor: OrganizationActor("org"), Action: "create", EntityType: "availabilities", EntityID: "a1", RequestID: "r1", After: json.RawMessage(`{"capacity":1}`)},
+		model.AuditEntry{OrganizationID: "org", OccurredAt: at.Add(2 * time.Minute), Actor: OrganizationActor("org"), Action: "update", EntityType: "availabilities", EntityID: "a1", RequestID: "r2", Before: json.RawMessage(`{"capacity":1}`), After: json.RawMessage(`{"capacity":2}`)},
+		model.AuditEntry{OrganizationID: "org", OccurredAt: at.Add(3 * time.Minute), Actor: ActorSystem, Action: "expire", EntityType: "reservations", EntityID: "r1"},
+	)
+}
+
+func TestVerify(t *testing.T) {
+	entries := testChain()
+	if broken := Verify(nil, entries); broken != -1 {
+		t.Fatalf("an untouched chain is broken at %d", broken)
+	}
+	// a page of the chain checks out from the entry before it
+	if broken := Verify(entries[1].Hash, entries[2:]); broken != -1 {
+		t.Fatalf("the end of the chain is broken at %d", broken)
+	}
+	// the column keeps microseconds, what it rounds off isn't part of the hash
+	read := testChain()
+	read[2].OccurredAt = read[2].OccurredAt.Add(300 * time.Nanosecond).In(time.FixedZone("EST", -5*60*60))
+	if broken := Verify(nil, read); broken != -1 {
+		t.Fatalf("the chain as read back is broken at %d", broken)
+	}
+}
+
+func TestVerifyFindsTheBrokenEntry(t *testing.T) {
+	for name, tamper := range map[string]func(entries []model.AuditEntry) []model.AuditEntry{
+		"organization": func(entries []model.AuditEntry) []model.AuditEntry {
+			entries[2].OrganizationID = "another"
+			return entries
+		},
+		"occurred at": func(entries []model.AuditEntry) []model.AuditEntry {
+			entries[2].OccurredAt = entries[2].OccurredAt.Add(time.Microsecond)
+			return entries
+		},
+		"actor": func(entries []model.AuditEntry) []model.AuditEntry {
+			entries[2].Actor = ActorAdmin
+			return entries
+		},
+		"action": func(entries []model.AuditEntry) []model.AuditEntry {
+			entries[2].Action = "delete"
+			return entries
+		},
+		"entity type": func(entries []model.AuditEntry) []model.AuditEntry {
+			entries[2].EntityType = "reservations"
+			return entries
+		},
+		"entity ID": func(entries []model.AuditEntry) []model.AuditEntry {
+			entries[2].EntityID = "a2"
+			return entries
+		},
+		"request ID": func(entries []model.AuditEntry) []model.AuditEntry {
+			entries[2].RequestID = ""
+			return entries
+		},
+		"before": func(entries []model.AuditEntry) []model.AuditEntry {
+			entries[2].Before = json.RawMessage(`{"capacity":3}`)
+			return entries
+		},
+		"after": func(entries []model.AuditEntry) []model.AuditEntry {
+			entries[2].After = nil
+			return entries
+		},
+		// the length prefixes keep a byte moved from one field to the next from hashing the same
+		"a byte moved between fields": func(entries []model.AuditEntry) []model.AuditEntry {
+			entries[2].EntityType, entries[2].EntityID = "availabilitiesa", "1"
+			return entries
+		},
+		"deleted": func(entries []model.AuditEntry) []model.AuditEntry {
+			return append(entries[:2], entries[3])
+		},
+		"reordered": func(entries []model.AuditEntry) []model.AuditEntry {
+			entries[2], entries[3] = entries[3], entries[2]
+			return entries
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if broken := Verify(nil, tamper(testChain())); broken != 2 {
+				t.Fatalf("broken at %d, want 2", broken)
+			}
+		})
+	}
+
+	// rehashed, the entry matches its own hash, but the next one chains to the old one
+	entries := testChain()
+	entries[2].Action = "delete"
+	entries[2].Hash = Hash(entries[2].PrevHash, entries[2])
+	if broken := Verify(nil, entries); broken != 3 {
+		t.Fatalf("a rehashed entry broke the chain at %d, want 3", broken)
+	}
+
+	if broken := Verify([]byte("another chain"), testChain()); broken != 0 {
+		t.Fatalf("a chain checked from the wrong entry is broken at %d, want 0", broken)
+	}
+}
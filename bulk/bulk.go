@@ -0,0 +1,201 @@
+package bulk
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"henrymeds-takehome/model"
+	"io"
+	"mime"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the formats an availability import can come in, a spreadsheet export or what a script would produce
+const (
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+)
+
+var ErrUnsupportedFormat = errors.New("unsupported format, use CSV or a JSON array")
+
+// FormatOfContentType picks the format from a Content-Type header, ex: text/csv; charset=utf-8
+func FormatOfContentType(contentType string) (string, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", ErrUnsupportedFormat
+	}
+	switch mediaType {
+	case "text/csv":
+		return FormatCSV, nil
+	case "application/json":
+		return FormatJSON, nil
+	}
+	return "", ErrUnsupportedFormat
+}
+
+// FormatOfFile picks the format from a file's extension
+func FormatOfFile(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return FormatCSV, nil
+	case ".json":
+		return FormatJSON, nil
+	}
+	return "", ErrUnsupportedFormat
+}
+
+// ParseAvailabilities reads the start and end times of availabilities, in RFC3339, and optionally their capacity,
+// modalities and location. CSV needs a header row with start and end columns, in any order, capacity, modalities
+// (separated by |, ex: video|in_person) and locationId columns are optional and other columns are ignored. JSON is an
+// array of {"start": ..., "end": ..., "capacity": ..., "modalities": [...], "locationId": ...} objects, all but start
+// and end can be left out. The modalities themselves are checked on import.
+// A row that can't be read doesn't fail the whole file, it comes back with its ParseError set so it can be reported
+// with the others. Rows are numbered from 1, not counting the CSV header
+func ParseAvailabilities(r io.Reader, format string) (rows []model.ImportRow, err error) {
+	switch format {
+	case FormatCSV:
+		return parseCSV(r)
+	case FormatJSON:
+		return parseJSON(r)
+	}
+	return nil, ErrUnsupportedFormat
+}
+
+func parseCSV(r io.Reader) (rows []model.ImportRow, err error) {
+	var (
+		reader      = csv.NewReader(r)
+		header      []string
+		startCol    = -1
+		endCol      = -1
+		capacityCol = -1
+		modalityCol = -1
+		locationCol = -1
+	)
+	// rows are checked one by one, a short row is that row's problem and not the file's
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err = reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, errors.New("the CSV is empty, it needs a header row with start and end columns")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	for i, column := range header {
+		// spreadsheets like to start their exports with a byte order mark
+		switch strings.ToLower(strings.TrimSpace(strings.TrimPrefix(column, "\ufeff"))) {
+		case "start":
+			startCol = i
+		case "end":
+			endCol = i
+		case "capacity":
+			capacityCol = i
+		case "modalities":
+			modalityCol = i
+		case "locationid":
+			locationCol = i
+		}
+	}
+	if startCol < 0 || endCol < 0 {
+		return nil, errors.New("the CSV header needs start and end columns")
+	}
+
+	for number := 1; ; number++ {
+		var record []string
+		record, err = reader.Read()
+		if errors.Is(err, io.EOF) {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV row %d: %w", number, err)
+		}
+		row := model.ImportRow{Row: number}
+		if startCol >= len(record) || endCol >= len(record) {
+			row.ParseError = "missing start or end column"
+		} else {
+			row.TimeRange, row.ParseError = parseTimeRange(record[startCol], record[endCol])
+		}
+		// an empty cell is the default, like leaving the column out
+		if row.ParseError == "" && capacityCol >= 0 && capacityCol < len(record) && strings.TrimSpace(record[capacityCol]) != "" {
+			row.Capacity, row.ParseError = parseCapacity(record[capacityCol])
+		}
+		if modalityCol >= 0 && modalityCol < len(record) {
+			row.Modalities = parseModalities(record[modalityCol])
+		}
+		if locationCol >= 0 && locationCol < len(record) {
+			row.LocationID = strings.TrimSpace(record[locationCol])
+		}
+		rows = append(rows, row)
+	}
+}
+
+func parseJSON(r io.Reader) (rows []model.ImportRow, err error) {
+	var elements []json.RawMessage
+	err = json.NewDecoder(r).Decode(&elements)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JSON, expected an array of objects with start and end: %w", err)
+	}
+	for i, element := range elements {
+		var (
+			row    = model.ImportRow{Row: i + 1}
+			fields struct {
+				Start      string   `json:"start"`
+				End        string   `json:"end"`
+				Capacity   *int     `json:"capacity"`
+				Modalities []string `json:"modalities"`
+				LocationID string   `json:"locationId"`
+			}
+		)
+		if json.Unmarshal(element, &fields) != nil {
+			row.ParseError = "expected an object with start and end"
+		} else {
+			row.TimeRange, row.ParseError = parseTimeRange(fields.Start, fields.End)
+			if row.ParseError == "" && fields.Capacity != nil {
+				if *fields.Capacity < 1 {
+					row.ParseError = fmt.Sprintf("invalid capacity %d, it must be at least 1", *fields.Capacity)
+				} else {
+					row.Capacity = *fields.Capacity
+				}
+			}
+			row.Modalities, row.LocationID = fields.Modalities, strings.TrimSpace(fields.LocationID)
+		}
+		rows = append(rows, row)
+	}
+	return
+}
+
+func parseTimeRange(start string, end string) (timeRange model.TimeRange, parseError string) {
+	var err error
+	timeRange.Start, err = time.Parse(time.RFC3339, strings.TrimSpace(start))
+	if err != nil {
+		return model.TimeRange{}, fmt.Sprintf("invalid start %q, please use RFC3339", start)
+	}
+	timeRange.End, err = time.Parse(time.RFC3339, strings.TrimSpace(end))
+	if err != nil {
+		return model.TimeRange{}, fmt.Sprintf("invalid end %q, please use RFC3339", end)
+	}
+	return
+}
+
+func parseCapacity(capacity string) (seats int, parseError string) {
+	seats, err := strconv.Atoi(strings.TrimSpace(capacity))
+	if err != nil || seats < 1 {
+		return 0, fmt.Sprintf("invalid capacity %q, it must be a whole number of at least 1", capacity)
+	}
+	return
+}
+
+// parseModalities splits a cell like video|in_person, an empty cell is the default like leaving the column out
+func parseModalities(cell string) (modalities []string) {
+	for _, modality := range strings.Split(cell, "|") {
+		if modality = strings.TrimSpace(modality); modality != "" {
+			modalities = append(modalities, modality)
+		}
+	}
+	return
+}
@@ -0,0 +1,140 @@
+package bulk
+
+import (
+	"errors"
+	"henrymeds-takehome/model"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	nine  = time.Date(2030, 1, 7, 9, 0, 0, 0, time.UTC)
+	shift = model.TimeRange{Start: nine, End: nine.Add(8 * time.Hour)}
+)
+
+// a row that can't be read comes back with why, the rows after it are still read
+func TestParseAvailabilities(t *testing.T) {
+	for name, test := range map[string]struct {
+		format string
+		source string
+		want   []model.ImportRow
+	}{
+		"CSV": {
+			format: FormatCSV,
+			source: "\ufeffnotes,End,start,capacity,modalities,locationId\n" +
+				"first,2030-01-07T17:00:00Z,2030-01-07T09:00:00Z,,,\n" +
+				"group, 2030-01-07T17:00:00Z, 2030-01-07T09:00:00Z, 3, video | in_person ,00000000-0000-4000-8000-0000000000f1\n",
+			want: []model.ImportRow{
+				{Row: 1, TimeRange: shift},
+				{Row: 2, TimeRange: shift, Capacity: 3, Modalities: []string{"video", "in_person"}, LocationID: "00000000-0000-4000-8000-0000000000f1"},
+			},
+		},
+		"CSV with bad rows": {
+			format: FormatCSV,
+			source: "start,end,capacity\n" +
+				"tomorrow,2030-01-07T17:00:00Z\n" +
+				"2030-01-07T09:00:00Z,5pm\n" +
+				"2030-01-07T09:00:00Z\n" +
+				"2030-01-07T09:00:00Z,2030-01-07T17:00:00Z,0\n" +
+				"2030-01-07T09:00:00Z,2030-01-07T17:00:00Z,a few\n" +
+				"2030-01-07T09:00:00Z,2030-01-07T17:00:00Z,2\n",
+			want: []model.ImportRow{
+				{Row: 1, ParseError: `invalid start "tomorrow", please use RFC3339`},
+				{Row: 2, ParseError: `invalid end "5pm", please use RFC3339`},
+				{Row: 3, ParseError: "missing start or end column"},
+				{Row: 4, TimeRange: shift, ParseError: `invalid capacity "0", it must be a whole number of at least 1`},
+				{Row: 5, TimeRange: shift, ParseError: `invalid capacity "a few", it must be a whole number of at least 1`},
+				{Row: 6, TimeRange: shift, Capacity: 2},
+			},
+		},
+		"header only": {format: FormatCSV, source: "start,end\n"},
+		"JSON": {
+			format: FormatJSON,
+			source: `[
+				{"start": "2030-01-07T09:00:00Z", "end": "2030-01-07T17:00:00Z"},
+				{"start": "2030-01-07T09:00:00Z", "end": "2030-01-07T17:00:00Z", "capacity": 3, "modalities": ["in_person"], "locationId": " 00000000-0000-4000-8000-0000000000f1 "}
+			]`,
+			want: []model.ImportRow{
+				{Row: 1, TimeRange: shift},
+				{Row: 2, TimeRange: shift, Capacity: 3, Modalities: []string{"in_person"}, LocationID: "00000000-0000-4000-8000-0000000000f1"},
+			},
+		},
+		"JSON with bad rows": {
+			format: FormatJSON,
+			source: `[
+				"2030-01-07T09:00:00Z",
+				{"start": "2030-01-07T09:00:00Z"},
+				{"start": "2030-01-07T09:00:00Z", "end": "2030-01-07T17:00:00Z", "capacity": 0},
+				{"start": "2030-01-07T09:00:00Z", "end": "2030-01-07T17:00:00Z"}
+			]`,
+			want: []model.ImportRow{
+				{Row: 1, ParseError: "expected an object with start and end"},
+				{Row: 2, ParseError: `invalid end "", please use RFC3339`},
+				{Row: 3, TimeRange: shift, ParseError: "invalid capacity 0, it must be at least 1"},
+				{Row: 4, TimeRange: shift},
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rows, err := ParseAvailabilities(strings.NewReader(test.source), test.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(rows, test.want) {
+				t.Fatalf("got %+v, want %+v", rows, test.want)
+			}
+		})
+	}
+}
+
+// a file that can't be read at all fails as a whole
+func TestParseAvailabilitiesFails(t *testing.T) {
+	for name, test := range map[string]struct {
+		format string
+		source string
+		want   string
+	}{
+		"empty CSV":          {format: FormatCSV, want: "the CSV is empty"},
+		"no start column":    {format: FormatCSV, source: "from,end\n", want: "the CSV header needs start and end columns"},
+		"unbalanced quote":   {format: FormatCSV, source: "start,end\n\"2030-01-07T09:00:00Z,2030-01-07T17:00:00Z\n", want: "failed to read CSV row 1"},
+		"JSON object":        {format: FormatJSON, source: `{"start": "2030-01-07T09:00:00Z", "end": "2030-01-07T17:00:00Z"}`, want: "failed to read JSON"},
+		"unsupported format": {format: "xlsx", want: ErrUnsupportedFormat.Error()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rows, err := ParseAvailabilities(strings.NewReader(test.source), test.format)
+			if err == nil || !strings.Contains(err.Error(), test.want) || rows != nil {
+				t.Fatalf("got %+v, err %v, want an error about %q", rows, err, test.want)
+			}
+		})
+	}
+}
+
+func TestFormat(t *testing.T) {
+	for contentType, want := range map[string]string{
+		"text/csv":                        FormatCSV,
+		"text/csv; charset=utf-8":         FormatCSV,
+		"application/json":                FormatJSON,
+		"application/json; charset=utf-8": FormatJSON,
+		"text/plain":                      "",
+		"":                                "",
+	} {
+		format, err := FormatOfContentType(contentType)
+		if format != want || (want == "") != errors.Is(err, ErrUnsupportedFormat) {
+			t.Errorf("%q is %q, err %v, want %q", contentType, format, err, want)
+		}
+	}
+	for path, want := range map[string]string{
+		"shifts.csv":  FormatCSV,
+		"SHIFTS.CSV":  FormatCSV,
+		"shifts.json": FormatJSON,
+		"shifts.xlsx": "",
+		"shifts":      "",
+	} {
+		format, err := FormatOfFile(path)
+		if format != want || (want == "") != errors.Is(err, ErrUnsupportedFormat) {
+			t.Errorf("%q is %q, err %v, want %q", path, format, err, want)
+		}
+	}
+}
@@ -0,0 +1,529 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"henrymeds-takehome/api"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// the client only speaks the current version of the API
+const apiPrefix = "/v1"
+
+// Client is a typed client for the scheduling API, safe for concurrent use
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	apiKey     string
+	tenantID   string
+	maxRetries int
+	backoff    time.Duration
+}
+
+type Option func(*Client)
+
+// WithHTTPClient replaces the default http.Client, ex: to set timeouts or a transport
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithAPIKey sends the key as a bearer token, needed when the server has auth enabled
+func WithAPIKey(apiKey string) Option {
+	return func(c *Client) {
+		c.apiKey = apiKey
+	}
+}
+
+// WithTenant scopes every request to the organization, for callers the server lets pick one, see
+// tenancy.headerCallers. A client made with an organization's own key is scoped to it already
+func WithTenant(organizationID string) Option {
+	return func(c *Client) {
+		c.tenantID = organizationID
+	}
+}
+
+// WithRetries sets how many times a failed request is retried and the initial backoff between attempts,
+// the backoff doubles on every attempt. The default is 3 retries starting at 100ms, 0 disables retries
+func WithRetries(maxRetries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+		c.backoff = backoff
+	}
+}
+
+// New returns a client for the API at baseURL, ex: http://localhost:9001
+func New(baseURL string, options ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		maxRetries: 3,
+		backoff:    100 * time.Millisecond,
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// Page selects a page of a list, the zero value is the first page at the server's default size
+type Page struct {
+	Limit int
+	// api sort order, "start" or "-start"
+	Sort string
+	// the next cursor returned with the previous page
+	Cursor string
+}
+
+func (p Page) query(query url.Values) url.Values {
+	if p.Limit > 0 {
+		query.Set("limit", strconv.Itoa(p.Limit))
+	}
+	if p.Sort != "" {
+		query.Set("sort", p.Sort)
+	}
+	if p.Cursor != "" {
+		query.Set("cursor", p.Cursor)
+	}
+	return query
+}
+
+// GetAvailabilities returns a page of availabilities, next is the cursor for the following page, empty on the last one
+func (c *Client) GetAvailabilities(ctx context.Context, providerID string, start, end time.Time, page Page) (availabilities []api.Availability, next string, err error) {
+	query := page.query(url.Values{
+		"start": {start.Format(time.RFC3339)},
+		"end":   {end.Format(time.RFC3339)},
+	})
+	envelope := &api.Envelope[any]{Data: &availabilities}
+	err = c.do(ctx, http.MethodGet, "/users/"+url.PathEscape(providerID)+"/availabilities?"+query.Encode(), nil, envelope)
+	if err == nil && envelope.Page != nil {
+		next = envelope.Page.Next
+	}
+	return
+}
+
+func (c *Client) GetAvailability(ctx context.Context, providerID string, availabilityID string) (availability api.Availability, err error) {
+	err = c.do(ctx, http.MethodGet, "/users/"+url.PathEscape(providerID)+"/availabilities/"+url.PathEscape(availabilityID), nil, &availability)
+	return
+}
+
+// CreateAvailability adds a block of availability, with one seat unless the request sets a capacity
+func (c *Client) CreateAvailability(ctx context.Context, providerID string, request api.CreateAvailability) (availability api.Availability, err error) {
+	err = c.do(ctx, http.MethodPost, "/users/"+url.PathEscape(providerID)+"/availabilities", request, &availability)
+	return
+}
+
+// GetSlots lists the provider's bookable slots with the seats they have left. They're one slot interval long, or as
+// long as the appointment type when it's not empty
+func (c *Client) GetSlots(ctx context.Context, providerID string, start, end time.Time, appointmentType string) (slots []api.Slot, err error) {
+	return c.GetSlotsForClient(ctx, providerID, "", start, end, appointmentType)
+}
+
+// GetSlotsForClient is GetSlots leaving out the slots the provider isn't licensed to see the client for
+func (c *Client) GetSlotsForClient(ctx context.Context, providerID string, clientID string, start, end time.Time, appointmentType string) (slots []api.Slot, err error) {
+	return c.FindSlots(ctx, providerID, start, end, SlotFilter{ClientID: clientID, AppointmentType: appointmentType})
+}
+
+// SlotFilter narrows down the slots FindSlots lists, the zero value lists them all one slot interval long
+type SlotFilter struct {
+	// makes the slots as long as the appointment type and keeps to the modalities it allows
+	AppointmentType string
+	// leaves out the slots the provider isn't licensed to see the client for
+	ClientID string
+	// video, phone or in_person
+	Modality string
+	// keeps to the slots that can be booked in person at the location
+	LocationID string
+}
+
+// FindSlots is GetSlots with every filter the server has
+func (c *Client) FindSlots(ctx context.Context, providerID string, start, end time.Time, filter SlotFilter) (slots []api.Slot, err error) {
+	query := url.Values{
+		"start": {start.Format(time.RFC3339)},
+		"end":   {end.Format(time.RFC3339)},
+	}
+	for name, value := range map[string]string{
+		"appointmentType": filter.AppointmentType,
+		"clientId":        filter.ClientID,
+		"modality":        filter.Modality,
+		"locationId":      filter.LocationID,
+	} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	err = c.do(ctx, http.MethodGet, "/users/"+url.PathEscape(providerID)+"/slots?"+query.Encode(), nil, &slots)
+	return
+}
+
+// UpdateAvailability moves either end of an availability or changes its capacity. With force it cancels the confirmed reservations the change
+// leaves without availability, without it the change is refused with ErrConflict
+func (c *Client) UpdateAvailability(ctx context.Context, providerID string, availabilityID string, update api.UpdateAvailability, force bool) (change api.AvailabilityChange, err error) {
+	query := url.Values{"force": {strconv.FormatBool(force)}}
+	err = c.do(ctx, http.MethodPatch, "/users/"+url.PathEscape(providerID)+"/availabilities/"+url.PathEscape(availabilityID)+"?"+query.Encode(), update, &change)
+	return
+}
+
+// DeleteAvailability removes an availability, under the same rules as UpdateAvailability
+func (c *Client) DeleteAvailability(ctx context.Context, providerID string, availabilityID string, force bool) (change api.AvailabilityChange, err error) {
+	query := url.Values{"force": {strconv.FormatBool(force)}}
+	err = c.do(ctx, http.MethodDelete, "/users/"+url.PathEscape(providerID)+"/availabilities/"+url.PathEscape(availabilityID)+"?"+query.Encode(), nil, &change)
+	return
+}
+
+// RotateCalendarToken issues a new calendar feed token for the user, the returned URL is what a calendar app
+// subscribes to. The previous token stops working
+func (c *Client) RotateCalendarToken(ctx context.Context, userID string) (token api.CalendarToken, err error) {
+	err = c.do(ctx, http.MethodPost, "/users/"+url.PathEscape(userID)+"/calendar/token", nil, &token)
+	return
+}
+
+// ImportAvailabilities creates all of the availabilities or none of them. When some are rejected the report says why,
+// and err stays nil, check report.Committed
+func (c *Client) ImportAvailabilities(ctx context.Context, providerID string, availabilities []api.CreateAvailability, dryRun bool) (report api.ImportReport, err error) {
+	query := url.Values{"dryRun": {strconv.FormatBool(dryRun)}}
+	err = c.do(ctx, http.MethodPost, "/users/"+url.PathEscape(providerID)+"/availabilities:bulk?"+query.Encode(), availabilities, &report)
+	return
+}
+
+// CreateBusySource registers the calendar served at calendarURL as the provider's outside busy time and imports it
+func (c *Client) CreateBusySource(ctx context.Context, providerID string, calendarURL string) (source api.BusySource, err error) {
+	err = c.do(ctx, http.MethodPost, "/users/"+url.PathEscape(providerID)+"/busy-sources", api.CreateBusySource{URL: calendarURL}, &source)
+	return
+}
+
+func (c *Client) GetBusySources(ctx context.Context, providerID string) (sources []api.BusySource, err error) {
+	err = c.do(ctx, http.MethodGet, "/users/"+url.PathEscape(providerID)+"/busy-sources", nil, &sources)
+	return
+}
+
+func (c *Client) GetBusySource(ctx context.Context, providerID string, sourceID string) (source api.BusySource, err error) {
+	err = c.do(ctx, http.MethodGet, "/users/"+url.PathEscape(providerID)+"/busy-sources/"+url.PathEscape(sourceID), nil, &source)
+	return
+}
+
+// ImportBusySource fetches a URL source again and replaces its busy time with what it has now
+func (c *Client) ImportBusySource(ctx context.Context, providerID string, sourceID string) (source api.BusySource, err error) {
+	err = c.do(ctx, http.MethodPost, "/users/"+url.PathEscape(providerID)+"/busy-sources/"+url.PathEscape(sourceID)+"/import", nil, &source)
+	return
+}
+
+func (c *Client) DeleteBusySource(ctx context.Context, providerID string, sourceID string) (err error) {
+	return c.do(ctx, http.MethodDelete, "/users/"+url.PathEscape(providerID)+"/busy-sources/"+url.PathEscape(sourceID), nil, nil)
+}
+
+func (c *Client) GetProviderLicenses(ctx context.Context, providerID string) (licenses []api.ProviderLicense, err error) {
+	err = c.do(ctx, http.MethodGet, "/users/"+url.PathEscape(providerID)+"/licenses", nil, &licenses)
+	return
+}
+
+// PutProviderLicense adds the provider's license for the state, or renews the one they have
+func (c *Client) PutProviderLicense(ctx context.Context, providerID string, state string, license api.PutProviderLicense) (stored api.ProviderLicense, err error) {
+	err = c.do(ctx, http.MethodPut, "/users/"+url.PathEscape(providerID)+"/licenses/"+url.PathEscape(state), license, &stored)
+	return
+}
+
+func (c *Client) DeleteProviderLicense(ctx context.Context, providerID string, state string) (err error) {
+	return c.do(ctx, http.MethodDelete, "/users/"+url.PathEscape(providerID)+"/licenses/"+url.PathEscape(state), nil, nil)
+}
+
+// CreateReservation holds a slot, the returned reservation carries the ConfirmationID needed to confirm it
+func (c *Client) CreateReservation(ctx context.Context, request api.CreateReservation) (reservation api.Reservation, err error) {
+	err = c.do(ctx, http.MethodPost, "/reservations", request, &reservation)
+	return
+}
+
+func (c *Client) GetReservation(ctx context.Context, reservationID string) (reservation api.Reservation, err error) {
+	err = c.do(ctx, http.MethodGet, "/reservations/"+url.PathEscape(reservationID), nil, &reservation)
+	return
+}
+
+func (c *Client) ConfirmReservation(ctx context.Context, confirmationID string) (reservation api.Reservation, err error) {
+	err = c.do(ctx, http.MethodPost, "/reservations/confirm/"+url.PathEscape(confirmationID), nil, &reservation)
+	return
+}
+
+// ConfirmReservationByToken confirms with the token of a confirmation link, each token works once
+func (c *Client) ConfirmReservationByToken(ctx context.Context, token string) (reservation api.Reservation, err error) {
+	err = c.do(ctx, http.MethodGet, "/reservations/confirm?"+url.Values{"token": {token}}.Encode(), nil, &reservation)
+	return
+}
+
+// CreateWaitlistEntry puts a client in line for a slot, it's offered one as a hold once time frees up
+// CreateLocation adds a place in-person visits happen at
+func (c *Client) CreateLocation(ctx context.Context, request api.CreateLocation) (location api.Location, err error) {
+	err = c.do(ctx, http.MethodPost, "/locations", request, &location)
+	return
+}
+
+func (c *Client) GetLocations(ctx context.Context) (locations []api.Location, err error) {
+	err = c.do(ctx, http.MethodGet, "/locations", nil, &locations)
+	return
+}
+
+func (c *Client) GetLocation(ctx context.Context, locationID string) (location api.Location, err error) {
+	err = c.do(ctx, http.MethodGet, "/locations/"+url.PathEscape(locationID), nil, &location)
+	return
+}
+
+func (c *Client) CreateWaitlistEntry(ctx context.Context, request api.CreateWaitlistEntry) (entry api.WaitlistEntry, err error) {
+	err = c.do(ctx, http.MethodPost, "/waitlist", request, &entry)
+	return
+}
+
+func (c *Client) GetWaitlistEntry(ctx context.Context, entryID string) (entry api.WaitlistEntry, err error) {
+	err = c.do(ctx, http.MethodGet, "/waitlist/"+url.PathEscape(entryID), nil, &entry)
+	return
+}
+
+func (c *Client) CancelWaitlistEntry(ctx context.Context, entryID string) (err error) {
+	return c.do(ctx, http.MethodDelete, "/waitlist/"+url.PathEscape(entryID), nil, nil)
+}
+
+func (c *Client) GetUser(ctx context.Context, userID string) (user api.User, err error) {
+	err = c.do(ctx, http.MethodGet, "/users/"+url.PathEscape(userID), nil, &user)
+	return
+}
+
+// UpdateUser records where the user is located, clients can only be booked with providers licensed there
+func (c *Client) UpdateUser(ctx context.Context, userID string, update api.UpdateUser) (user api.User, err error) {
+	err = c.do(ctx, http.MethodPatch, "/users/"+url.PathEscape(userID), update, &user)
+	return
+}
+
+// CreateOrganization adds an organization, the returned organization is the only one carrying its API key. The
+// organization calls need the admin key
+func (c *Client) CreateOrganization(ctx context.Context, request api.CreateOrganization) (organization api.Organization, err error) {
+	err = c.do(ctx, http.MethodPost, "/admin/organizations", request, &organization)
+	return
+}
+
+func (c *Client) GetOrganizations(ctx context.Context) (organizations []api.Organization, err error) {
+	err = c.do(ctx, http.MethodGet, "/admin/organizations", nil, &organizations)
+	return
+}
+
+func (c *Client) GetOrganization(ctx context.Context, organizationID string) (organization api.Organization, err error) {
+	err = c.do(ctx, http.MethodGet, "/admin/organizations/"+url.PathEscape(organizationID), nil, &organization)
+	return
+}
+
+// RotateOrganizationKey issues a new API key for the organization, the old one stops working
+func (c *Client) RotateOrganizationKey(ctx context.Context, organizationID string) (key api.OrganizationKey, err error) {
+	err = c.do(ctx, http.MethodPost, "/admin/organizations/"+url.PathEscape(organizationID)+"/key", nil, &key)
+	return
+}
+
+// CreateWebhookSubscription subscribes a URL to event types, the returned subscription is the only one carrying its
+// secret. The webhook calls need the admin key
+func (c *Client) CreateWebhookSubscription(ctx context.Context, request api.CreateWebhookSubscription) (subscription api.WebhookSubscription, err error) {
+	err = c.do(ctx, http.MethodPost, "/admin/webhooks", request, &subscription)
+	return
+}
+
+func (c *Client) GetWebhookSubscriptions(ctx context.Context) (subscriptions []api.WebhookSubscription, err error) {
+	err = c.do(ctx, http.MethodGet, "/admin/webhooks", nil, &subscriptions)
+	return
+}
+
+func (c *Client) GetWebhookSubscription(ctx context.Context, subscriptionID string) (subscription api.WebhookSubscription, err error) {
+	err = c.do(ctx, http.MethodGet, "/admin/webhooks/"+url.PathEscape(subscriptionID), nil, &subscription)
+	return
+}
+
+func (c *Client) UpdateWebhookSubscription(ctx context.Context, subscriptionID string, update api.UpdateWebhookSubscription) (subscription api.WebhookSubscription, err error) {
+	err = c.do(ctx, http.MethodPatch, "/admin/webhooks/"+url.PathEscape(subscriptionID), update, &subscription)
+	return
+}
+
+func (c *Client) DeleteWebhookSubscription(ctx context.Context, subscriptionID string) (err error) {
+	return c.do(ctx, http.MethodDelete, "/admin/webhooks/"+url.PathEscape(subscriptionID), nil, nil)
+}
+
+// GetWebhookDeliveries returns a page of the delivery log, newest first. subscriptionID and status narrow it down
+// when set, status "dead" lists the dead letters
+func (c *Client) GetWebhookDeliveries(ctx context.Context, subscriptionID string, status string, page Page) (deliveries []api.WebhookDelivery, next string, err error) {
+	query := page.query(url.Values{})
+	if subscriptionID != "" {
+		query.Set("webhookId", subscriptionID)
+	}
+	if status != "" {
+		query.Set("status", status)
+	}
+	envelope := &api.Envelope[any]{Data: &deliveries}
+	err = c.do(ctx, http.MethodGet, "/admin/webhook-deliveries?"+query.Encode(), nil, envelope)
+	if err == nil && envelope.Page != nil {
+		next = envelope.Page.Next
+	}
+	return
+}
+
+// GetWebhookDelivery returns a delivery with its payload and every attempt at it
+func (c *Client) GetWebhookDelivery(ctx context.Context, deliveryID string) (delivery api.WebhookDelivery, err error) {
+	err = c.do(ctx, http.MethodGet, "/admin/webhook-deliveries/"+url.PathEscape(deliveryID), nil, &delivery)
+	return
+}
+
+// RedeliverWebhook queues a delivery to be sent again right away, ex: a dead letter once its receiver is fixed
+func (c *Client) RedeliverWebhook(ctx context.Context, deliveryID string) (delivery api.WebhookDelivery, err error) {
+	err = c.do(ctx, http.MethodPost, "/admin/webhook-deliveries/"+url.PathEscape(deliveryID)+"/redeliver", nil, &delivery)
+	return
+}
+
+// AuditFilter narrows down the entries GetAuditEntries lists, the zero value lists them all
+type AuditFilter struct {
+	OrganizationID string
+	// the table and primary key of a row, ex: reservations and its ID, for the row's history
+	EntityType string
+	EntityID   string
+	// ex: organization:<id>, api_key, admin, anonymous or system
+	Actor string
+}
+
+// GetAuditEntries returns a page of the audit log, newest first
+func (c *Client) GetAuditEntries(ctx context.Context, filter AuditFilter, page Page) (entries []api.AuditEntry, next string, err error) {
+	query := page.query(url.Values{})
+	for name, value := range map[string]string{
+		"organizationId": filter.OrganizationID,
+		"entityType":     filter.EntityType,
+		"entityId":       filter.EntityID,
+		"actor":          filter.Actor,
+	} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	envelope := &api.Envelope[any]{Data: &entries}
+	err = c.do(ctx, http.MethodGet, "/admin/audit-log?"+query.Encode(), nil, envelope)
+	if err == nil && envelope.Page != nil {
+		next = envelope.Page.Next
+	}
+	return
+}
+
+// VerifyAuditLog checks the organization's chain of audit entries for tampering, or every chain when organizationID
+// is empty
+func (c *Client) VerifyAuditLog(ctx context.Context, organizationID string) (verifications []api.AuditVerification, err error) {
+	query := url.Values{}
+	if organizationID != "" {
+		query.Set("organizationId", organizationID)
+	}
+	err = c.do(ctx, http.MethodGet, "/admin/audit-log/verify?"+query.Encode(), nil, &verifications)
+	return
+}
+
+// do sends the request, retrying on network errors, 409s from an in-progress idempotent request, 429s and 5xxs.
+// Every attempt of a mutating request carries the same Idempotency-Key, so a retry of a request that did reach
+// the server gets the original response instead of being applied twice.
+// out is what the data of the response envelope is decoded into, or the whole *api.Envelope[any] when the caller
+// needs more than the data. nil discards the body
+func (c *Client) do(ctx context.Context, method string, path string, in any, out any) (err error) {
+	var (
+		body           []byte
+		idempotencyKey string
+		backoff        = c.backoff
+	)
+	if in != nil {
+		body, err = json.Marshal(in)
+		if err != nil {
+			return
+		}
+	}
+	if method != http.MethodGet {
+		idempotencyKey = uuid.NewString()
+	}
+
+	for attempt := 0; ; attempt++ {
+		var retryable bool
+		retryable, err = c.attempt(ctx, method, path, body, idempotencyKey, out)
+		if err == nil || !retryable || attempt >= c.maxRetries {
+			return
+		}
+
+		// full jitter, so clients that failed together don't retry together
+		wait := time.Duration(rand.Int63n(int64(backoff) + 1))
+		backoff *= 2
+		// but never sooner than the server asked for
+		var apiErr *Error
+		if errors.As(err, &apiErr) && wait < apiErr.RetryAfter {
+			wait = apiErr.RetryAfter
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (c *Client) attempt(ctx context.Context, method string, path string, body []byte, idempotencyKey string, out any) (retryable bool, err error) {
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+	request, err := http.NewRequestWithContext(ctx, method, c.baseURL+apiPrefix+path, bodyReader)
+	if err != nil {
+		return
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if idempotencyKey != "" {
+		request.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+	if c.apiKey != "" {
+		request.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	if c.tenantID != "" {
+		request.Header.Set("X-Tenant-Id", c.tenantID)
+	}
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		// the request may or may not have made it, the idempotency key makes it safe to find out by retrying
+		retryable = ctx.Err() == nil
+		return
+	}
+	defer response.Body.Close()
+
+	responseBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		retryable = true
+		return
+	}
+
+	if response.StatusCode >= 300 {
+		err = newError(response, responseBody)
+		// a 409 only means "try again" when the server says so, it's sent while a request with the same
+		// idempotency key is still in progress. Any other 409 is a real conflict
+		retryable = response.StatusCode >= 500 ||
+			response.StatusCode == http.StatusTooManyRequests ||
+			response.StatusCode == http.StatusConflict && response.Header.Get("Retry-After") != ""
+		return
+	}
+
+	if out == nil {
+		return
+	}
+	envelope, ok := out.(*api.Envelope[any])
+	if !ok {
+		envelope = &api.Envelope[any]{Data: out}
+	}
+	err = json.Unmarshal(responseBody, envelope)
+	if err != nil {
+		err = fmt.Errorf("failed to decode %s %s response: %w", method, path, err)
+	}
+	return
+}
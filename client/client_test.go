@@ -0,0 +1,298 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"henrymeds-takehome/api"
+	"henrymeds-takehome/controller"
+	h "henrymeds-takehome/handler"
+	"henrymeds-takehome/logging"
+	"henrymeds-takehome/model"
+	"henrymeds-takehome/ratelimit"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+const (
+	providerID = "00000000-0000-4000-8000-0000000000a1"
+	clientID   = "00000000-0000-4000-8000-0000000000c1"
+)
+
+var start = time.Date(2030, 1, 7, 9, 0, 0, 0, time.UTC)
+
+// fakeController serves the handlers the client talks to, anything else panics on the nil Controller
+type fakeController struct {
+	controller.Controller
+
+	mu             sync.Mutex
+	availabilities []model.Availability
+	reservations   map[string]model.Reservation
+	// failures left before CreateReservation succeeds, and the idempotency keys of the attempts
+	failures        int
+	idempotencyKeys []string
+}
+
+func newFakeController() *fakeController {
+	f := &fakeController{reservations: map[string]model.Reservation{}}
+	for i := 0; i < 5; i++ {
+		availabilityStart := start.Add(time.Duration(i) * time.Hour)
+		f.availabilities = append(f.availabilities, model.Availability{
+			ID:         fmt.Sprintf("00000000-0000-4000-8000-00000000000%d", i),
+			ProviderID: providerID,
+			TimeRange:  model.TimeRange{Start: availabilityStart, End: availabilityStart.Add(time.Hour)},
+		})
+	}
+	return f
+}
+
+// GetAvailabilities pages through the availabilities in ascending order, like the dao does
+func (f *fakeController) GetAvailabilities(ctx context.Context, request model.GetAvailabilities) (availabilities []model.Availability, next *model.PageKey, err error) {
+	for _, availability := range f.availabilities {
+		if after := request.Page.After; after != nil && !availability.Start.After(after.Start) {
+			continue
+		}
+		if request.Page.Limit > 0 && len(availabilities) == request.Page.Limit {
+			last := availabilities[len(availabilities)-1]
+			next = &model.PageKey{Start: last.Start, ID: last.ID}
+			break
+		}
+		availabilities = append(availabilities, availability)
+	}
+	return
+}
+
+func (f *fakeController) CreateReservation(ctx context.Context, request model.CreateReservation) (reservation model.Reservation, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failures > 0 {
+		f.failures--
+		return reservation, errors.New("the database went away")
+	}
+	for _, existing := range f.reservations {
+		if existing.ProviderID == request.ProviderID && existing.Start.Equal(request.Start) {
+			return reservation, fmt.Errorf("%w: the slot is taken", controller.ErrConflict)
+		}
+	}
+	reservation = model.Reservation{
+		ID:         uuid.NewString(),
+		ClientID:   request.ClientID,
+		ProviderID: request.ProviderID,
+		ExpiresAt:  time.Now().Add(30 * time.Minute),
+		TimeRange:  request.TimeRange,
+	}
+	f.reservations[reservation.ID] = reservation
+	return
+}
+
+func (f *fakeController) GetReservation(ctx context.Context, id string) (reservation model.Reservation, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	reservation, ok := f.reservations[id]
+	if !ok {
+		return reservation, fmt.Errorf("%w: no reservation %s", controller.ErrNotFound, id)
+	}
+	return
+}
+
+// newServer serves the real handlers over the fake, with the error handler and request IDs of the real server
+func newServer(t *testing.T, f *fakeController) *httptest.Server {
+	var (
+		e       = echo.New()
+		handler = h.NewHandler(f)
+	)
+	e.HTTPErrorHandler = h.ErrorHandler(e)
+	e.Use(middleware.RequestIDWithConfig(middleware.RequestIDConfig{
+		RequestIDHandler: func(c echo.Context, requestID string) {
+			c.SetRequest(c.Request().WithContext(logging.WithRequestID(c.Request().Context(), requestID)))
+		},
+	}))
+	v1 := e.Group(h.V1Prefix)
+	v1.GET("/users/:"+h.ProviderIdParam+"/availabilities", handler.HandleV1GetAvailabilities)
+	v1.POST("/reservations", func(c echo.Context) error {
+		f.mu.Lock()
+		f.idempotencyKeys = append(f.idempotencyKeys, c.Request().Header.Get("Idempotency-Key"))
+		f.mu.Unlock()
+		return handler.HandleV1CreateReservation(c)
+	})
+	v1.GET("/reservations/:"+h.ReservationIdParam, handler.HandleV1GetReservation)
+	// a reservation a minute, the second request is turned away
+	v1.GET("/limited/reservations/:"+h.ReservationIdParam, handler.HandleV1GetReservation, ratelimit.Middleware(ratelimit.NewMemoryLimiter(), ratelimit.Rule{
+		Name:  "test",
+		Rate:  1.0 / 60,
+		Burst: 1,
+		Key:   ratelimit.ByIP,
+	}))
+
+	server := httptest.NewServer(e)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestCreateAndGetReservation(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		server = newServer(t, newFakeController())
+		c      = New(server.URL)
+	)
+	created, err := c.CreateReservation(ctx, api.CreateReservation{
+		ClientID:   clientID,
+		ProviderID: providerID,
+		Start:      start,
+		End:        start.Add(30 * time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("failed to create the reservation: %v", err)
+	}
+	if created.ID == "" || created.ProviderID != providerID || !created.Start.Equal(start) {
+		t.Fatalf("created %+v", created)
+	}
+
+	got, err := c.GetReservation(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("failed to get the reservation: %v", err)
+	}
+	if got.ID != created.ID || got.ClientID != clientID {
+		t.Fatalf("got %+v, want %+v", got, created)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		server = newServer(t, newFakeController())
+		c      = New(server.URL, WithRetries(0, 0))
+	)
+
+	t.Run("not found", func(t *testing.T) {
+		_, err := c.GetReservation(ctx, uuid.NewString())
+		var apiErr *Error
+		if !errors.As(err, &apiErr) {
+			t.Fatalf("got %v, want an *Error", err)
+		}
+		if !errors.Is(err, ErrNotFound) || apiErr.StatusCode != http.StatusNotFound || apiErr.Code != api.CodeNotFound {
+			t.Fatalf("got %+v", apiErr)
+		}
+		if apiErr.Message == "" || apiErr.RequestID == "" {
+			t.Fatalf("the message or request ID of %+v didn't come through", apiErr)
+		}
+	})
+
+	t.Run("conflict", func(t *testing.T) {
+		request := api.CreateReservation{ClientID: clientID, ProviderID: providerID, Start: start, End: start.Add(30 * time.Minute)}
+		if _, err := c.CreateReservation(ctx, request); err != nil {
+			t.Fatal(err)
+		}
+		_, err := c.CreateReservation(ctx, request)
+		if !errors.Is(err, ErrConflict) || errors.Is(err, ErrNotFound) {
+			t.Fatalf("got %v, want a conflict", err)
+		}
+	})
+
+	t.Run("rate limited", func(t *testing.T) {
+		if err := c.do(ctx, http.MethodGet, "/limited/reservations/"+uuid.NewString(), nil, nil); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("the first request got %v, want through to a not found", err)
+		}
+		err := c.do(ctx, http.MethodGet, "/limited/reservations/"+uuid.NewString(), nil, nil)
+		var apiErr *Error
+		if !errors.As(err, &apiErr) || !errors.Is(err, ErrRateLimited) {
+			t.Fatalf("got %v, want rate limited", err)
+		}
+		if apiErr.Code != api.CodeRateLimited || apiErr.RetryAfter <= 0 || apiErr.RetryAfter > time.Minute {
+			t.Fatalf("got %+v", apiErr)
+		}
+	})
+
+	t.Run("not the API", func(t *testing.T) {
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Request-Id", "from-the-proxy")
+			http.Error(w, "bad gateway", http.StatusBadGateway)
+		}))
+		defer proxy.Close()
+
+		_, err := New(proxy.URL, WithRetries(0, 0)).GetReservation(ctx, uuid.NewString())
+		var apiErr *Error
+		if !errors.As(err, &apiErr) || !errors.Is(err, ErrServer) {
+			t.Fatalf("got %v, want a server error", err)
+		}
+		if apiErr.Code != "" || apiErr.Message != "bad gateway" || apiErr.RequestID != "from-the-proxy" {
+			t.Fatalf("got %+v", apiErr)
+		}
+	})
+}
+
+func TestRetriesKeepTheIdempotencyKey(t *testing.T) {
+	var (
+		ctx = context.Background()
+		f   = newFakeController()
+		c   = New(newServer(t, f).URL, WithRetries(2, time.Millisecond))
+	)
+	f.failures = 2
+	_, err := c.CreateReservation(ctx, api.CreateReservation{ClientID: clientID, ProviderID: providerID, Start: start, End: start.Add(30 * time.Minute)})
+	if err != nil {
+		t.Fatalf("the retries didn't get through: %v", err)
+	}
+	if len(f.idempotencyKeys) != 3 {
+		t.Fatalf("made %d attempts, want 3", len(f.idempotencyKeys))
+	}
+	for _, key := range f.idempotencyKeys {
+		if key == "" || key != f.idempotencyKeys[0] {
+			t.Fatalf("the attempts were sent with keys %v, want the same one", f.idempotencyKeys)
+		}
+	}
+}
+
+func TestGetAvailabilitiesFollowsTheCursor(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		f       = newFakeController()
+		c       = New(newServer(t, f).URL)
+		seen    []string
+		page    = Page{Limit: 2}
+		fetched int
+	)
+	for {
+		availabilities, next, err := c.GetAvailabilities(ctx, providerID, start, start.Add(24*time.Hour), page)
+		if err != nil {
+			t.Fatalf("failed to get page %d: %v", fetched, err)
+		}
+		fetched++
+		if len(availabilities) > page.Limit {
+			t.Fatalf("page %d has %d availabilities, over the limit", fetched, len(availabilities))
+		}
+		for _, availability := range availabilities {
+			seen = append(seen, availability.ID)
+		}
+		if next == "" {
+			break
+		}
+		if fetched > len(f.availabilities) {
+			t.Fatal("the cursor never ran out")
+		}
+		page.Cursor = next
+	}
+
+	if fetched != 3 {
+		t.Fatalf("fetched %d pages, want 3", fetched)
+	}
+	if len(seen) != len(f.availabilities) {
+		t.Fatalf("saw %v, want every availability once", seen)
+	}
+	for i, availability := range f.availabilities {
+		if seen[i] != availability.ID {
+			t.Fatalf("saw %v, want them in order", seen)
+		}
+	}
+
+	_, _, err := c.GetAvailabilities(ctx, providerID, start, start.Add(24*time.Hour), Page{Cursor: "not a cursor"})
+	if !errors.Is(err, ErrBadRequest) {
+		t.Fatalf("a malformed cursor got %v, want a bad request", err)
+	}
+}
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"henrymeds-takehome/api"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errors.Is(err, client.ErrNotFound) and friends match an *Error with the corresponding status
+var (
+	ErrBadRequest   = errors.New("bad request")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+	ErrRateLimited  = errors.New("rate limited")
+	ErrServer       = errors.New("server error")
+)
+
+// Error is returned for every non-2xx response
+type Error struct {
+	StatusCode int
+	// machine readable, one of the api.Code* constants, empty when the server didn't send an error envelope
+	Code string
+	// the explanation the server sent back
+	Message string
+	// the server's request ID, quote it when reporting a problem
+	RequestID string
+	// how long the server asked to wait before retrying, sent with 429s and in-progress 409s
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrBadRequest:
+		return e.StatusCode == http.StatusBadRequest || e.StatusCode == http.StatusUnprocessableEntity
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrServer:
+		return e.StatusCode >= http.StatusInternalServerError
+	}
+	return false
+}
+
+func newError(response *http.Response, body []byte) *Error {
+	var (
+		envelope   api.ErrorEnvelope
+		retryAfter time.Duration
+	)
+	// only the seconds form, the server never sends a date
+	if seconds, err := strconv.Atoi(response.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		retryAfter = time.Duration(seconds) * time.Second
+	}
+	if json.Unmarshal(body, &envelope) == nil && envelope.Error.Message != "" {
+		return &Error{
+			StatusCode: response.StatusCode,
+			Code:       envelope.Error.Code,
+			Message:    envelope.Error.Message,
+			RequestID:  envelope.Error.RequestID,
+			RetryAfter: retryAfter,
+		}
+	}
+	// errors that never reached the API, ex: from a proxy in front of it
+	return &Error{
+		StatusCode: response.StatusCode,
+		Message:    strings.TrimSpace(string(body)),
+		RequestID:  response.Header.Get("X-Request-Id"),
+		RetryAfter: retryAfter,
+	}
+}
@@ -0,0 +1,885 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const envPrefix = "HENRY_"
+
+// Config holds every setting the service needs. Values are layered in this order, later layers win:
+// defaults, the config file, environment variables, flags.
+// Every leaf field is addressable by its yaml path, ex: db.poolSize can be set with
+// `db: {poolSize: 20}` in the file, HENRY_DB_POOLSIZE in the environment or -db.poolSize as a flag
+type Config struct {
+	Port         string       `yaml:"port"`
+	DB           DB           `yaml:"db"`
+	Server       Server       `yaml:"server"`
+	Booking      Booking      `yaml:"booking"`
+	Paging       Paging       `yaml:"paging"`
+	Calendar     Calendar     `yaml:"calendar"`
+	Outbox       Outbox       `yaml:"outbox"`
+	Webhooks     Webhooks     `yaml:"webhooks"`
+	Notify       Notify       `yaml:"notify"`
+	Reminders    Reminders    `yaml:"reminders"`
+	Confirmation Confirmation `yaml:"confirmation"`
+	Waitlist     Waitlist     `yaml:"waitlist"`
+	Video        Video        `yaml:"video"`
+	Encryption   Encryption   `yaml:"encryption"`
+	RateLimit    RateLimit    `yaml:"rateLimit"`
+	Log          Log          `yaml:"log"`
+	Tracing      Tracing      `yaml:"tracing"`
+	Auth         Auth         `yaml:"auth"`
+	Tenancy      Tenancy      `yaml:"tenancy"`
+}
+
+type DB struct {
+	URL          string        `yaml:"url" secret:"true"`
+	PoolSize     int           `yaml:"poolSize"`
+	DialTimeout  time.Duration `yaml:"dialTimeout"`
+	ReadTimeout  time.Duration `yaml:"readTimeout"`
+	WriteTimeout time.Duration `yaml:"writeTimeout"`
+	// how long the readiness probe waits on postgres
+	PingTimeout time.Duration `yaml:"pingTimeout"`
+}
+
+type Server struct {
+	ReadTimeout  time.Duration `yaml:"readTimeout"`
+	WriteTimeout time.Duration `yaml:"writeTimeout"`
+	// on shutdown, how long readiness fails before the listener closes so the orchestrator can route around us
+	DrainDelay time.Duration `yaml:"drainDelay"`
+	// how long in-flight requests get to finish once the listener is closed
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+	// log responses that don't match the OpenAPI spec, requests are always validated
+	ValidateResponses bool `yaml:"validateResponses"`
+	// how long a response is kept for replay to requests retried with the same Idempotency-Key
+	IdempotencyTTL time.Duration `yaml:"idempotencyTTL"`
+}
+
+type Booking struct {
+	// how far ahead of its start a reservation has to be made
+	LeadTime time.Duration `yaml:"leadTime"`
+	// how long an unconfirmed reservation holds its slot
+	HoldDuration time.Duration `yaml:"holdDuration"`
+	// reservations and availabilities have to start and end on multiples of this
+	SlotInterval time.Duration `yaml:"slotInterval"`
+	// how many unconfirmed reservations a client can hold at once, 0 for no cap
+	MaxHolds int `yaml:"maxHolds"`
+}
+
+type Paging struct {
+	// page size of list endpoints when the request doesn't set a limit
+	DefaultLimit int `yaml:"defaultLimit"`
+	// the largest limit a request can ask for, larger limits are capped to it
+	MaxLimit int `yaml:"maxLimit"`
+}
+
+type Calendar struct {
+	// how far back the calendar feeds go, past appointments drop off after this
+	Lookback time.Duration `yaml:"lookback"`
+	// how far ahead the calendar feeds go, and how far ahead busy time is imported from outside calendars
+	Horizon time.Duration `yaml:"horizon"`
+	// how long fetching an outside calendar from its URL may take
+	FetchTimeout time.Duration `yaml:"fetchTimeout"`
+	// outside calendars larger than this are refused
+	FetchMaxBytes int `yaml:"fetchMaxBytes"`
+	// let calendar URLs reach loopback and private addresses, only for local stand-ins
+	AllowPrivate bool `yaml:"allowPrivate"`
+}
+
+type Outbox struct {
+	// where domain events are published: log, webhook or nats
+	Sink string `yaml:"sink"`
+	// the webhook sink POSTs every event here
+	WebhookURL string `yaml:"webhookURL" secret:"true"`
+	// nats://[user:password@]host[:port] for the nats sink
+	NATSURL string `yaml:"natsURL" secret:"true"`
+	// the nats sink publishes to <natsSubject>.<event type>
+	NATSSubject string `yaml:"natsSubject"`
+	// how often the dispatcher looks for new events once the outbox is drained, and how often expired holds are swept
+	PollInterval time.Duration `yaml:"pollInterval"`
+	// how many events are claimed and published at a time
+	BatchSize int `yaml:"batchSize"`
+	// how long a claimed batch is reserved for one instance, a batch it doesn't finish is published by another after this
+	Lease time.Duration `yaml:"lease"`
+	// the wait before retrying a failed event, doubled with every failure up to maxBackoff
+	MinBackoff time.Duration `yaml:"minBackoff"`
+	MaxBackoff time.Duration `yaml:"maxBackoff"`
+	// how long a single publish may take
+	PublishTimeout time.Duration `yaml:"publishTimeout"`
+}
+
+type Webhooks struct {
+	// fan the events out to the webhook subscriptions, on top of outbox.sink
+	Enabled bool `yaml:"enabled"`
+	// how often the deliverer looks for deliveries that are due
+	PollInterval time.Duration `yaml:"pollInterval"`
+	// how many deliveries are claimed and sent at a time
+	BatchSize int `yaml:"batchSize"`
+	// how long a claimed batch is reserved for one instance
+	Lease time.Duration `yaml:"lease"`
+	// how long a receiver gets to answer
+	Timeout time.Duration `yaml:"timeout"`
+	// the wait before retrying a failed delivery, doubled with every failure up to maxBackoff
+	MinBackoff time.Duration `yaml:"minBackoff"`
+	MaxBackoff time.Duration `yaml:"maxBackoff"`
+	// a delivery that failed this many times goes to the dead letters
+	MaxAttempts int `yaml:"maxAttempts"`
+	// let subscriptions reach loopback and private addresses, only for local receivers
+	AllowPrivate bool `yaml:"allowPrivate"`
+}
+
+type Notify struct {
+	// how users are notified: log, email or sms
+	Channel string `yaml:"channel"`
+	// the SMTP server for email, host:port
+	SMTPAddr string `yaml:"smtpAddr"`
+	// SMTP PLAIN auth, none when the username is empty
+	SMTPUsername string `yaml:"smtpUsername"`
+	SMTPPassword string `yaml:"smtpPassword" secret:"true"`
+	// the From address of the emails
+	EmailFrom string `yaml:"emailFrom"`
+	// the SMS gateway for sms, only fake for now
+	SMSProvider string `yaml:"smsProvider"`
+	// how long sending one notification may take
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+type Reminders struct {
+	// remind clients of their confirmed appointments
+	Enabled bool `yaml:"enabled"`
+	// comma separated, how long before the appointment a reminder goes out, one reminder per lead
+	Leads string `yaml:"leads"`
+	// how often the sender looks for reminders that are due
+	PollInterval time.Duration `yaml:"pollInterval"`
+	// how many reminders are claimed and sent at a time
+	BatchSize int `yaml:"batchSize"`
+	// how long a claimed batch is reserved for one instance
+	Lease time.Duration `yaml:"lease"`
+	// the wait before retrying a failed reminder, doubled with every failure up to maxBackoff
+	MinBackoff time.Duration `yaml:"minBackoff"`
+	MaxBackoff time.Duration `yaml:"maxBackoff"`
+	// a reminder that failed this many times is given up on
+	MaxAttempts int `yaml:"maxAttempts"`
+}
+
+// LeadDurations parses Leads, ex: "24h,1h"
+func (r Reminders) LeadDurations() (leads []time.Duration, err error) {
+	for _, raw := range strings.Split(r.Leads, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		var lead time.Duration
+		lead, err = time.ParseDuration(raw)
+		if err != nil {
+			return nil, err
+		}
+		if lead < time.Second {
+			return nil, fmt.Errorf("%s is shorter than a second", raw)
+		}
+		leads = append(leads, lead)
+	}
+	return
+}
+
+type Waitlist struct {
+	// offer time that frees up to the waitlist
+	Enabled bool `yaml:"enabled"`
+	// fifo or priority, priority offers the highest priority entry first and falls back on first come first served
+	Order string `yaml:"order"`
+	// comma separated name=length pairs, the appointments an entry can wait for
+	AppointmentTypes string `yaml:"appointmentTypes"`
+	// comma separated name=modality|modality pairs, the modalities an appointment type can be booked as. Types that
+	// aren't listed take any
+	AppointmentModalities string `yaml:"appointmentModalities"`
+	// how long an offered slot is held for the client to confirm it
+	OfferHold time.Duration `yaml:"offerHold"`
+	// how many entries one freed up time range is offered to at most
+	BatchSize int `yaml:"batchSize"`
+}
+
+// the modalities appointment types can allow, see model.Modalities
+var modalities = []string{"video", "phone", "in_person"}
+
+// AppointmentTypeModalities parses AppointmentModalities, ex: "initial=video|in_person,follow-up=video|phone"
+func (w Waitlist) AppointmentTypeModalities() (allowed map[string][]string, err error) {
+	allowed = map[string][]string{}
+	for _, raw := range strings.Split(w.AppointmentModalities, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		name, rawModalities, ok := strings.Cut(raw, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%s isn't a name=modality|modality pair", raw)
+		}
+		if _, seen := allowed[name]; seen {
+			return nil, fmt.Errorf("%s is listed twice", name)
+		}
+		for _, modality := range strings.Split(rawModalities, "|") {
+			modality = strings.TrimSpace(modality)
+			if !slices.Contains(modalities, modality) {
+				return nil, fmt.Errorf("%s of %s isn't one of %s", modality, name, strings.Join(modalities, ", "))
+			}
+			allowed[name] = append(allowed[name], modality)
+		}
+	}
+	return
+}
+
+// AppointmentTypeLengths parses AppointmentTypes, ex: "initial=30m,follow-up=15m"
+func (w Waitlist) AppointmentTypeLengths() (lengths map[string]time.Duration, err error) {
+	lengths = map[string]time.Duration{}
+	for _, raw := range strings.Split(w.AppointmentTypes, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		name, rawLength, ok := strings.Cut(raw, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%s isn't a name=length pair", raw)
+		}
+		var length time.Duration
+		length, err = time.ParseDuration(strings.TrimSpace(rawLength))
+		if err != nil {
+			return nil, err
+		}
+		if length <= 0 {
+			return nil, fmt.Errorf("%s isn't a positive length", raw)
+		}
+		if _, seen := lengths[name]; seen {
+			return nil, fmt.Errorf("%s is listed twice", name)
+		}
+		lengths[name] = length
+	}
+	return
+}
+
+type Confirmation struct {
+	// email the client a confirmation link when a reservation is held, through notify.channel
+	SendLinks bool `yaml:"sendLinks"`
+	// where the links point, the public URL of GET /v1/reservations/confirm
+	LinkURL string `yaml:"linkURL"`
+	// the HMAC-SHA256 key the link tokens are signed with, shared by every instance
+	Secret string `yaml:"secret" secret:"true"`
+}
+
+type Log struct {
+	Level string `yaml:"level"`
+	// json or text
+	Format string `yaml:"format"`
+}
+
+type Tracing struct {
+	// none, stdout or otlp
+	Exporter string `yaml:"exporter"`
+	// collector host:port for the otlp exporter, the OTEL_EXPORTER_OTLP_* variables are used when empty
+	Endpoint string `yaml:"endpoint"`
+	// fraction of new traces that are sampled, traces started by a caller follow the caller's decision
+	SampleRatio float64 `yaml:"sampleRatio"`
+}
+
+type Auth struct {
+	Enabled bool   `yaml:"enabled"`
+	APIKey  string `yaml:"apiKey" secret:"true"`
+	// with auth enabled, the only key the /v1/admin routes accept, they're unreachable while it's empty. It works on
+	// every other route too
+	AdminAPIKey string `yaml:"adminAPIKey" secret:"true"`
+}
+
+type Video struct {
+	// where video rooms are opened, only fake for now, it makes up links under roomBaseURL
+	Provider    string `yaml:"provider"`
+	RoomBaseURL string `yaml:"roomBaseURL"`
+}
+
+type Encryption struct {
+	// where the master keys that patient details are encrypted under are kept: none or file. With none they're
+	// stored in plaintext
+	KMS string `yaml:"kms"`
+	// the file kms's keys, one `<key ID> <base64 256 bit key>` line each, the last one is current
+	KeyFile string `yaml:"keyFile"`
+	// how long a data key encrypts new values before a new one is made
+	DataKeyTTL time.Duration `yaml:"dataKeyTTL"`
+}
+
+// RateLimit limits how fast a caller can make requests, with token buckets: a caller can make burst requests at once
+// and perMinute a minute after that
+type RateLimit struct {
+	Enabled bool `yaml:"enabled"`
+	// where the buckets are kept: memory, each instance limits on its own, or postgres, shared by every instance
+	Backend string `yaml:"backend"`
+	// take the caller's IP from X-Forwarded-For, only behind a proxy that sets it, anyone can send one otherwise
+	TrustForwardedFor bool `yaml:"trustForwardedFor"`
+	// every request, by IP
+	IPPerMinute int `yaml:"ipPerMinute"`
+	IPBurst     int `yaml:"ipBurst"`
+	// booking requests, reservations and waitlist entries, by IP
+	BookingIPPerMinute int `yaml:"bookingIPPerMinute"`
+	BookingIPBurst     int `yaml:"bookingIPBurst"`
+	// booking requests by the client they're for
+	ClientPerMinute int `yaml:"clientPerMinute"`
+	ClientBurst     int `yaml:"clientBurst"`
+	// booking requests by the API key they're made with, a caller can't get around it with new clientIds
+	PrincipalPerMinute int `yaml:"principalPerMinute"`
+	PrincipalBurst     int `yaml:"principalBurst"`
+	// how often the postgres backend deletes the buckets that filled back up
+	PruneInterval time.Duration `yaml:"pruneInterval"`
+}
+
+type Tenancy struct {
+	// refuse requests that aren't scoped to an organization, by its API key or X-Tenant-Id. Without it they see
+	// every organization
+	Required bool `yaml:"required"`
+	// who may scope a request to any organization with X-Tenant-Id, comma separated, by the actor the audit log has
+	// for them: admin, the auth.adminAPIKey, api_key, the shared auth.apiKey, and anonymous, every caller while auth
+	// is disabled. Anyone else sending it is refused, organization keys are always scoped to their own
+	HeaderCallers string `yaml:"headerCallers"`
+}
+
+// HeaderActors parses HeaderCallers, ex: "admin,api_key"
+func (t Tenancy) HeaderActors() (actors map[string]bool, err error) {
+	actors = map[string]bool{}
+	for _, actor := range strings.Split(t.HeaderCallers, ",") {
+		actor = strings.TrimSpace(actor)
+		switch actor {
+		case "":
+		case "admin", "api_key", "anonymous":
+			actors[actor] = true
+		default:
+			return nil, fmt.Errorf("%q isn't one of admin, api_key, anonymous", actor)
+		}
+	}
+	return
+}
+
+func Default() Config {
+	return Config{
+		Port: "9001",
+		DB: DB{
+			PoolSize:     10,
+			DialTimeout:  20 * time.Second,
+			ReadTimeout:  10 * time.Second,
+			WriteTimeout: 10 * time.Second,
+			PingTimeout:  2 * time.Second,
+		},
+		Server: Server{
+			ReadTimeout:       15 * time.Second,
+			WriteTimeout:      15 * time.Second,
+			DrainDelay:        5 * time.Second,
+			ShutdownTimeout:   20 * time.Second,
+			ValidateResponses: true,
+			IdempotencyTTL:    24 * time.Hour,
+		},
+		Booking: Booking{
+			LeadTime:     24 * time.Hour,
+			HoldDuration: 30 * time.Minute,
+			SlotInterval: 15 * time.Minute,
+			MaxHolds:     3,
+		},
+		Paging: Paging{
+			DefaultLimit: 50,
+			MaxLimit:     500,
+		},
+		Calendar: Calendar{
+			Lookback:      30 * 24 * time.Hour,
+			Horizon:       365 * 24 * time.Hour,
+			FetchTimeout:  10 * time.Second,
+			FetchMaxBytes: 5 << 20,
+		},
+		Outbox: Outbox{
+			Sink:           "log",
+			NATSSubject:    "henrymeds",
+			PollInterval:   time.Second,
+			BatchSize:      100,
+			Lease:          time.Minute,
+			MinBackoff:     time.Second,
+			MaxBackoff:     10 * time.Minute,
+			PublishTimeout: 10 * time.Second,
+		},
+		Webhooks: Webhooks{
+			Enabled:      true,
+			PollInterval: time.Second,
+			BatchSize:    20,
+			Lease:        5 * time.Minute,
+			Timeout:      10 * time.Second,
+			MinBackoff:   10 * time.Second,
+			MaxBackoff:   time.Hour,
+			MaxAttempts:  10,
+		},
+		Notify: Notify{
+			Channel:     "log",
+			SMTPAddr:    "localhost:1025",
+			EmailFrom:   "Henry Meds <no-reply@henrymeds.example>",
+			SMSProvider: "fake",
+			Timeout:     10 * time.Second,
+		},
+		Reminders: Reminders{
+			Enabled:      true,
+			Leads:        "24h,1h",
+			PollInterval: 10 * time.Second,
+			BatchSize:    50,
+			Lease:        5 * time.Minute,
+			MinBackoff:   30 * time.Second,
+			MaxBackoff:   10 * time.Minute,
+			MaxAttempts:  5,
+		},
+		Confirmation: Confirmation{
+			SendLinks: true,
+			LinkURL:   "http://localhost:9001/v1/reservations/confirm",
+		},
+		Waitlist: Waitlist{
+			Enabled:          true,
+			Order:            "fifo",
+			AppointmentTypes: "initial=30m,follow-up=15m",
+			OfferHold:        2 * time.Hour,
+			BatchSize:        20,
+		},
+		Video: Video{
+			Provider:    "fake",
+			RoomBaseURL: "https://video.henrymeds.example/rooms",
+		},
+		Encryption: Encryption{
+			KMS:        "none",
+			DataKeyTTL: time.Hour,
+		},
+		RateLimit: RateLimit{
+			Enabled:            true,
+			Backend:            "memory",
+			IPPerMinute:        600,
+			IPBurst:            100,
+			BookingIPPerMinute: 30,
+			BookingIPBurst:     10,
+			ClientPerMinute:    6,
+			ClientBurst:        3,
+			PrincipalPerMinute: 120,
+			PrincipalBurst:     30,
+			PruneInterval:      time.Minute,
+		},
+		Log: Log{
+			Level:  "info",
+			Format: "text",
+		},
+		Tracing: Tracing{
+			Exporter:    "none",
+			SampleRatio: 1,
+		},
+		Tenancy: Tenancy{
+			Required:      true,
+			HeaderCallers: "admin",
+		},
+	}
+}
+
+// legacy flag names that predate the config subsystem, kept so the README instructions still work
+var flagAliases = map[string]string{
+	"db": "db.url",
+}
+
+// Load builds the effective config from the defaults, the file passed with -config (or HENRY_CONFIG),
+// the environment and the provided command line args. The file is read as TOML if its name ends in .toml, as YAML
+// otherwise. It does not validate, call Validate for that.
+func Load(args []string) (cfg Config, err error) {
+	var (
+		fs         = flag.NewFlagSet("henrymeds-takehome", flag.ContinueOnError)
+		configPath = fs.String("config", os.Getenv(envPrefix+"CONFIG"), "path to a YAML or TOML config file")
+		fields     []field
+		flagValues = map[string]*string{}
+	)
+	cfg = Default()
+	fields = walk(reflect.ValueOf(&cfg).Elem(), "")
+
+	for _, f := range fields {
+		flagValues[f.key] = fs.String(f.key, "", fmt.Sprintf("%s (env %s)", f.key, f.env))
+	}
+	for alias, key := range flagAliases {
+		flagValues[alias] = fs.String(alias, "", "alias for -"+key)
+	}
+	err = fs.Parse(args)
+	if err != nil {
+		return
+	}
+
+	// file
+	if *configPath != "" {
+		var raw []byte
+		raw, err = os.ReadFile(*configPath)
+		if err != nil {
+			err = fmt.Errorf("failed to read config file: %w", err)
+			return
+		}
+		if strings.EqualFold(filepath.Ext(*configPath), ".toml") {
+			err = decodeTOML(raw, fields)
+		} else {
+			decoder := yaml.NewDecoder(bytes.NewReader(raw))
+			decoder.KnownFields(true)
+			err = decoder.Decode(&cfg)
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			err = fmt.Errorf("failed to parse config file %s: %w", *configPath, err)
+			return
+		}
+		err = nil
+	}
+
+	// environment
+	for _, f := range fields {
+		if value, ok := os.LookupEnv(f.env); ok {
+			err = f.set(value)
+			if err != nil {
+				err = fmt.Errorf("invalid value for %s: %w", f.env, err)
+				return
+			}
+		}
+	}
+
+	// flags, only the ones that were actually passed so they don't clobber the other layers
+	byKey := map[string]field{}
+	for _, f := range fields {
+		byKey[f.key] = f
+	}
+	fs.Visit(func(fl *flag.Flag) {
+		if err != nil || fl.Name == "config" {
+			return
+		}
+		key := fl.Name
+		if alias, ok := flagAliases[key]; ok {
+			key = alias
+		}
+		if setErr := byKey[key].set(*flagValues[fl.Name]); setErr != nil {
+			err = fmt.Errorf("invalid value for -%s: %w", fl.Name, setErr)
+		}
+	})
+	return
+}
+
+// Validate checks every setting and reports all of the problems at once rather than just the first
+func (c Config) Validate() error {
+	var errs []error
+	add := func(key, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s (env %s): %s", key, envName(key), fmt.Sprintf(format, args...)))
+	}
+
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		add("port", "must be a number between 1 and 65535, got %q", c.Port)
+	}
+	if c.DB.URL == "" {
+		add("db.url", "must be set, see README for the connection string format")
+	}
+	if c.DB.PoolSize < 1 {
+		add("db.poolSize", "must be at least 1, got %d", c.DB.PoolSize)
+	}
+	for key, d := range map[string]time.Duration{
+		"db.dialTimeout":          c.DB.DialTimeout,
+		"db.readTimeout":          c.DB.ReadTimeout,
+		"db.writeTimeout":         c.DB.WriteTimeout,
+		"db.pingTimeout":          c.DB.PingTimeout,
+		"server.readTimeout":      c.Server.ReadTimeout,
+		"server.writeTimeout":     c.Server.WriteTimeout,
+		"server.shutdownTimeout":  c.Server.ShutdownTimeout,
+		"server.idempotencyTTL":   c.Server.IdempotencyTTL,
+		"booking.holdDuration":    c.Booking.HoldDuration,
+		"calendar.horizon":        c.Calendar.Horizon,
+		"calendar.fetchTimeout":   c.Calendar.FetchTimeout,
+		"outbox.pollInterval":     c.Outbox.PollInterval,
+		"outbox.lease":            c.Outbox.Lease,
+		"outbox.minBackoff":       c.Outbox.MinBackoff,
+		"outbox.publishTimeout":   c.Outbox.PublishTimeout,
+		"webhooks.pollInterval":   c.Webhooks.PollInterval,
+		"webhooks.lease":          c.Webhooks.Lease,
+		"webhooks.timeout":        c.Webhooks.Timeout,
+		"webhooks.minBackoff":     c.Webhooks.MinBackoff,
+		"notify.timeout":          c.Notify.Timeout,
+		"reminders.pollInterval":  c.Reminders.PollInterval,
+		"reminders.lease":         c.Reminders.Lease,
+		"reminders.minBackoff":    c.Reminders.MinBackoff,
+		"waitlist.offerHold":      c.Waitlist.OfferHold,
+		"rateLimit.pruneInterval": c.RateLimit.PruneInterval,
+	} {
+		if d <= 0 {
+			add(key, "must be a positive duration, got %s", d)
+		}
+	}
+	if c.Server.DrainDelay < 0 {
+		add("server.drainDelay", "must not be negative, got %s", c.Server.DrainDelay)
+	}
+	if c.Booking.LeadTime < 0 {
+		add("booking.leadTime", "must not be negative, got %s", c.Booking.LeadTime)
+	}
+	if c.Booking.SlotInterval < time.Minute || time.Hour%c.Booking.SlotInterval != 0 {
+		add("booking.slotInterval", "must be a whole number of minutes that divides an hour evenly, got %s", c.Booking.SlotInterval)
+	}
+	if c.Booking.MaxHolds < 0 {
+		add("booking.maxHolds", "must not be negative, got %d", c.Booking.MaxHolds)
+	}
+	if c.Paging.MaxLimit < 1 {
+		add("paging.maxLimit", "must be at least 1, got %d", c.Paging.MaxLimit)
+	}
+	if c.Paging.DefaultLimit < 1 || c.Paging.DefaultLimit > c.Paging.MaxLimit {
+		add("paging.defaultLimit", "must be between 1 and paging.maxLimit, got %d", c.Paging.DefaultLimit)
+	}
+	if c.Calendar.Lookback < 0 {
+		add("calendar.lookback", "must not be negative, got %s", c.Calendar.Lookback)
+	}
+	if c.Calendar.FetchMaxBytes < 1 {
+		add("calendar.fetchMaxBytes", "must be at least 1, got %d", c.Calendar.FetchMaxBytes)
+	}
+	switch strings.ToLower(c.Outbox.Sink) {
+	case "log":
+	case "webhook":
+		if u, err := url.Parse(c.Outbox.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("outbox.webhookURL", "must be an http or https URL when the sink is webhook")
+		}
+	case "nats":
+		if u, err := url.Parse(c.Outbox.NATSURL); err != nil || u.Scheme != "nats" || u.Host == "" {
+			add("outbox.natsURL", "must be a nats://host:port URL when the sink is nats")
+		}
+		if c.Outbox.NATSSubject == "" {
+			add("outbox.natsSubject", "must be set when the sink is nats")
+		}
+	default:
+		add("outbox.sink", "must be one of log, webhook, nats, got %q", c.Outbox.Sink)
+	}
+	if c.Outbox.BatchSize < 1 {
+		add("outbox.batchSize", "must be at least 1, got %d", c.Outbox.BatchSize)
+	}
+	if c.Outbox.MaxBackoff < c.Outbox.MinBackoff {
+		add("outbox.maxBackoff", "must be at least outbox.minBackoff, got %s", c.Outbox.MaxBackoff)
+	}
+	if c.Outbox.Lease > 0 && c.Outbox.Lease < c.Outbox.PublishTimeout {
+		add("outbox.lease", "must be at least outbox.publishTimeout, got %s", c.Outbox.Lease)
+	}
+	if c.Webhooks.BatchSize < 1 {
+		add("webhooks.batchSize", "must be at least 1, got %d", c.Webhooks.BatchSize)
+	}
+	if c.Webhooks.MaxBackoff < c.Webhooks.MinBackoff {
+		add("webhooks.maxBackoff", "must be at least webhooks.minBackoff, got %s", c.Webhooks.MaxBackoff)
+	}
+	if c.Webhooks.MaxAttempts < 1 {
+		add("webhooks.maxAttempts", "must be at least 1, got %d", c.Webhooks.MaxAttempts)
+	}
+	switch strings.ToLower(c.Notify.Channel) {
+	case "log":
+	case "email":
+		if _, _, err := net.SplitHostPort(c.Notify.SMTPAddr); err != nil {
+			add("notify.smtpAddr", "must be host:port when the channel is email, got %q", c.Notify.SMTPAddr)
+		}
+		if _, err := mail.ParseAddress(c.Notify.EmailFrom); err != nil {
+			add("notify.emailFrom", "must be an email address when the channel is email, got %q", c.Notify.EmailFrom)
+		}
+	case "sms":
+		if strings.ToLower(c.Notify.SMSProvider) != "fake" {
+			add("notify.smsProvider", "must be fake, got %q", c.Notify.SMSProvider)
+		}
+	default:
+		add("notify.channel", "must be one of log, email, sms, got %q", c.Notify.Channel)
+	}
+	if leads, err := c.Reminders.LeadDurations(); err != nil {
+		add("reminders.leads", "must be a comma separated list of durations of at least a second: %v", err)
+	} else if c.Reminders.Enabled && len(leads) == 0 {
+		add("reminders.leads", "must have at least one lead when reminders are enabled")
+	}
+	if c.Reminders.BatchSize < 1 {
+		add("reminders.batchSize", "must be at least 1, got %d", c.Reminders.BatchSize)
+	}
+	if c.Reminders.MaxBackoff < c.Reminders.MinBackoff {
+		add("reminders.maxBackoff", "must be at least reminders.minBackoff, got %s", c.Reminders.MaxBackoff)
+	}
+	if c.Reminders.MaxAttempts < 1 {
+		add("reminders.maxAttempts", "must be at least 1, got %d", c.Reminders.MaxAttempts)
+	}
+	if u, err := url.Parse(c.Confirmation.LinkURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		add("confirmation.linkURL", "must be an http or https URL, got %q", c.Confirmation.LinkURL)
+	}
+	if c.Confirmation.Secret != "" && len(c.Confirmation.Secret) < 32 {
+		add("confirmation.secret", "must be at least 32 characters")
+	}
+	switch strings.ToLower(c.Waitlist.Order) {
+	case "fifo", "priority":
+	default:
+		add("waitlist.order", "must be fifo or priority, got %q", c.Waitlist.Order)
+	}
+	if lengths, err := c.Waitlist.AppointmentTypeLengths(); err != nil {
+		add("waitlist.appointmentTypes", "must be a comma separated list of name=length pairs: %v", err)
+	} else {
+		if c.Waitlist.Enabled && len(lengths) == 0 {
+			add("waitlist.appointmentTypes", "must have at least one appointment type when the waitlist is enabled")
+		}
+		for name, length := range lengths {
+			if c.Booking.SlotInterval > 0 && length%c.Booking.SlotInterval != 0 {
+				add("waitlist.appointmentTypes", "%s must take a multiple of booking.slotInterval, got %s", name, length)
+			}
+		}
+	}
+	if _, err := c.Tenancy.HeaderActors(); err != nil {
+		add("tenancy.headerCallers", "must be a comma separated list of admin, api_key, anonymous: %v", err)
+	}
+	if allowed, err := c.Waitlist.AppointmentTypeModalities(); err != nil {
+		add("waitlist.appointmentModalities", "must be a comma separated list of name=modality|modality pairs: %v", err)
+	} else if lengths, err := c.Waitlist.AppointmentTypeLengths(); err == nil {
+		for name := range allowed {
+			if _, ok := lengths[name]; !ok {
+				add("waitlist.appointmentModalities", "%s isn't one of waitlist.appointmentTypes", name)
+			}
+		}
+	}
+	if c.Waitlist.BatchSize < 1 {
+		add("waitlist.batchSize", "must be at least 1, got %d", c.Waitlist.BatchSize)
+	}
+	if strings.ToLower(c.Video.Provider) != "fake" {
+		add("video.provider", "must be fake, got %q", c.Video.Provider)
+	}
+	if u, err := url.Parse(c.Video.RoomBaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		add("video.roomBaseURL", "must be an http or https URL, got %q", c.Video.RoomBaseURL)
+	}
+	switch strings.ToLower(c.Encryption.KMS) {
+	case "none":
+	case "file":
+		if c.Encryption.KeyFile == "" {
+			add("encryption.keyFile", "must be set when the kms is file")
+		}
+	default:
+		add("encryption.kms", "must be none or file, got %q", c.Encryption.KMS)
+	}
+	if c.Encryption.DataKeyTTL < time.Second {
+		add("encryption.dataKeyTTL", "must be at least 1s, got %s", c.Encryption.DataKeyTTL)
+	}
+	switch strings.ToLower(c.RateLimit.Backend) {
+	case "memory", "postgres":
+	default:
+		add("rateLimit.backend", "must be memory or postgres, got %q", c.RateLimit.Backend)
+	}
+	for key, n := range map[string]int{
+		"rateLimit.ipPerMinute":        c.RateLimit.IPPerMinute,
+		"rateLimit.ipBurst":            c.RateLimit.IPBurst,
+		"rateLimit.bookingIPPerMinute": c.RateLimit.BookingIPPerMinute,
+		"rateLimit.bookingIPBurst":     c.RateLimit.BookingIPBurst,
+		"rateLimit.clientPerMinute":    c.RateLimit.ClientPerMinute,
+		"rateLimit.clientBurst":        c.RateLimit.ClientBurst,
+		"rateLimit.principalPerMinute": c.RateLimit.PrincipalPerMinute,
+		"rateLimit.principalBurst":     c.RateLimit.PrincipalBurst,
+	} {
+		if n < 1 {
+			add(key, "must be at least 1, got %d", n)
+		}
+	}
+	switch strings.ToLower(c.Log.Level) {
+	case "debug", "info", "warn", "error":
+	default:
+		add("log.level", "must be one of debug, info, warn, error, got %q", c.Log.Level)
+	}
+	switch strings.ToLower(c.Log.Format) {
+	case "json", "text":
+	default:
+		add("log.format", "must be json or text, got %q", c.Log.Format)
+	}
+	switch strings.ToLower(c.Tracing.Exporter) {
+	case "none", "stdout", "otlp":
+	default:
+		add("tracing.exporter", "must be one of none, stdout, otlp, got %q", c.Tracing.Exporter)
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		add("tracing.sampleRatio", "must be between 0 and 1, got %v", c.Tracing.SampleRatio)
+	}
+	if c.Auth.Enabled && c.Auth.APIKey == "" {
+		add("auth.apiKey", "must be set when auth is enabled")
+	}
+	if c.Auth.AdminAPIKey != "" && c.Auth.AdminAPIKey == c.Auth.APIKey {
+		add("auth.adminAPIKey", "must be different from auth.apiKey")
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// Print writes the effective config, one setting per line, with secrets redacted
+func (c Config) Print(w io.Writer) {
+	for _, f := range walk(reflect.ValueOf(&c).Elem(), "") {
+		value := fmt.Sprint(f.value.Interface())
+		if f.secret && value != "" {
+			value = "[REDACTED]"
+		}
+		fmt.Fprintf(w, "%-22s %-26s %s\n", f.key, f.env, value)
+	}
+}
+
+type field struct {
+	key    string
+	env    string
+	secret bool
+	value  reflect.Value
+}
+
+// walk flattens the config struct into its leaf fields
+func walk(v reflect.Value, prefix string) (fields []field) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		key := prefix + sf.Tag.Get("yaml")
+		if sf.Type.Kind() == reflect.Struct {
+			fields = append(fields, walk(v.Field(i), key+".")...)
+			continue
+		}
+		fields = append(fields, field{
+			key:    key,
+			env:    envName(key),
+			secret: sf.Tag.Get("secret") == "true",
+			value:  v.Field(i),
+		})
+	}
+	return
+}
+
+func envName(key string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+func (f field) set(raw string) (err error) {
+	switch f.value.Interface().(type) {
+	case string:
+		f.value.SetString(raw)
+	case int:
+		var i int
+		if i, err = strconv.Atoi(raw); err == nil {
+			f.value.SetInt(int64(i))
+		}
+	case bool:
+		var b bool
+		if b, err = strconv.ParseBool(raw); err == nil {
+			f.value.SetBool(b)
+		}
+	case float64:
+		var fl float64
+		if fl, err = strconv.ParseFloat(raw, 64); err == nil {
+			f.value.SetFloat(fl)
+		}
+	case time.Duration:
+		var d time.Duration
+		if d, err = time.ParseDuration(raw); err == nil {
+			f.value.SetInt(int64(d))
+		}
+	default:
+		err = fmt.Errorf("unsupported config type %s", f.value.Type())
+	}
+	return
+}
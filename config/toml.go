@@ -0,0 +1,111 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// decodeTOML sets the fields named in a TOML config file. The config is a tree of tables of scalar settings, so
+// that's all of TOML that's read: [table] headers, key = value pairs with dotted keys, strings, numbers, booleans and
+// comments. Arrays, inline tables and multi-line strings are refused, like settings the config doesn't have
+func decodeTOML(raw []byte, fields []field) error {
+	var (
+		byKey = map[string]field{}
+		table string
+	)
+	for _, f := range fields {
+		byKey[f.key] = f
+	}
+	for n, line := range strings.Split(string(raw), "\n") {
+		line = strings.TrimSpace(stripTOMLComment(line))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if strings.HasPrefix(line, "[[") || !strings.HasSuffix(line, "]") {
+				return fmt.Errorf("line %d: only [table] headers are supported", n+1)
+			}
+			table = strings.TrimSpace(line[1:len(line)-1]) + "."
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("line %d: expected key = value", n+1)
+		}
+		key = table + strings.TrimSpace(key)
+		f, ok := byKey[key]
+		if !ok {
+			return fmt.Errorf("line %d: unknown setting %s", n+1, key)
+		}
+		value, err := tomlValue(strings.TrimSpace(value), f)
+		if err == nil {
+			err = f.set(value)
+		}
+		if err != nil {
+			return fmt.Errorf("line %d: invalid value for %s: %w", n+1, key, err)
+		}
+	}
+	return nil
+}
+
+// tomlValue unquotes a string and checks the value's TOML type is one the field takes. Durations are strings, ex:
+// "30s", like in the YAML file
+func tomlValue(value string, f field) (string, error) {
+	var quoted bool
+	switch {
+	case strings.HasPrefix(value, `"""`), strings.HasPrefix(value, "'''"), strings.HasPrefix(value, "["), strings.HasPrefix(value, "{"):
+		return "", fmt.Errorf("only strings, numbers and booleans are supported")
+	case strings.HasPrefix(value, `"`):
+		unquoted, err := strconv.Unquote(value)
+		if err != nil {
+			return "", fmt.Errorf("malformed string %s", value)
+		}
+		value, quoted = unquoted, true
+	case strings.HasPrefix(value, "'"):
+		if len(value) < 2 || !strings.HasSuffix(value, "'") {
+			return "", fmt.Errorf("malformed string %s", value)
+		}
+		value, quoted = value[1:len(value)-1], true
+	default:
+		// 1_000 is a number in TOML
+		value = strings.ReplaceAll(value, "_", "")
+	}
+	switch f.value.Interface().(type) {
+	case string, time.Duration:
+		if !quoted {
+			return "", fmt.Errorf("must be a string")
+		}
+	default:
+		if quoted {
+			return "", fmt.Errorf("must not be a string")
+		}
+	}
+	return value, nil
+}
+
+// stripTOMLComment cuts the line at the first # that isn't inside a string
+func stripTOMLComment(line string) string {
+	var quote rune
+	for i, r := range line {
+		switch {
+		case quote == 0 && r == '#':
+			return line[:i]
+		case quote == 0 && (r == '"' || r == '\''):
+			quote = r
+		case r == quote && (quote == '\'' || !escaped(line[:i])):
+			quote = 0
+		}
+	}
+	return line
+}
+
+// escaped reports whether the character after s is escaped in a basic string, by an odd run of backslashes
+func escaped(s string) bool {
+	var backslashes int
+	for i := len(s) - 1; i >= 0 && s[i] == '\\'; i-- {
+		backslashes++
+	}
+	return backslashes%2 == 1
+}
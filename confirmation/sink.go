@@ -0,0 +1,107 @@
+package confirmation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"henrymeds-takehome/api"
+	"henrymeds-takehome/model"
+	"henrymeds-takehome/notify"
+	"henrymeds-takehome/outbox"
+	"log/slog"
+	"net/url"
+	"time"
+)
+
+// Users looks up who a link goes to
+type Users interface {
+	GetUser(ctx context.Context, id string) (model.User, error)
+}
+
+// NewEmailSink is the outbox sink that sends the client a confirmation link for every reservation.held event, through
+// the notifier. Going through the outbox means the link only goes out for a hold that committed, and goes out again
+// if sending fails. confirmURL is where the link points, the token is added as its token query parameter
+func NewEmailSink(signer *Signer, notifier notify.Notifier, users Users, confirmURL string) *emailSink {
+	return &emailSink{
+		signer:     signer,
+		notifier:   notifier,
+		users:      users,
+		confirmURL: confirmURL,
+	}
+}
+
+type emailSink struct {
+	signer     *Signer
+	notifier   notify.Notifier
+	users      Users
+	confirmURL string
+}
+
+func (s *emailSink) Publish(ctx context.Context, event api.Event) (err error) {
+	if event.Type != outbox.ReservationHeld {
+		return
+	}
+	var (
+		reservation api.Reservation
+		client      model.User
+		provider    model.User
+		token       string
+	)
+	if err = json.Unmarshal(event.Data, &reservation); err != nil {
+		return
+	}
+	// published late, ex: after the sink was down a while, the link would be dead on arrival
+	if !time.Now().Before(reservation.ExpiresAt) {
+		return
+	}
+	client, err = s.users.GetUser(ctx, reservation.ClientID)
+	if err != nil {
+		return
+	}
+	provider, err = s.users.GetUser(ctx, reservation.ProviderID)
+	if err != nil {
+		return
+	}
+	token, err = s.signer.Sign(reservation.ID, reservation.ExpiresAt)
+	if err != nil {
+		return
+	}
+
+	err = s.notifier.Notify(ctx, notify.Message{
+		To:      client,
+		Subject: "Confirm your appointment with " + provider.Username,
+		Body: fmt.Sprintf("Hi %s,\n\nYour appointment with %s on %s is on hold until %s. Confirm it here:\n\n%s\n\nThe link works once. If you didn't book this, ignore this message and the hold will run out.",
+			client.Username,
+			provider.Username,
+			reservation.Start.UTC().Format("Monday, January 2 at 15:04 MST"),
+			reservation.ExpiresAt.UTC().Format("15:04 MST"),
+			s.link(token),
+		),
+	})
+	// nothing to send it to, retrying the event won't change that
+	if errors.Is(err, notify.ErrNoAddress) {
+		slog.WarnContext(ctx, "no address to send the confirmation link to",
+			"reservation_id", reservation.ID,
+			"channel", s.notifier.Channel(),
+		)
+		return nil
+	}
+	return
+}
+
+func (s *emailSink) link(token string) string {
+	u, err := url.Parse(s.confirmURL)
+	if err != nil {
+		// checked when the config is validated
+		return s.confirmURL + "?token=" + url.QueryEscape(token)
+	}
+	query := u.Query()
+	query.Set("token", token)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func (s *emailSink) Close() error {
+	return nil
+}
@@ -0,0 +1,105 @@
+package confirmation
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMalformedToken = errors.New("malformed confirmation token")
+	ErrInvalidToken   = errors.New("confirmation token signature doesn't match")
+	ErrExpiredToken   = errors.New("confirmation token expired")
+)
+
+// Claims is what a token carries
+type Claims struct {
+	ReservationID string
+	ExpiresAt     time.Time
+	// makes every token unique, a token is spent by recording its nonce
+	Nonce string
+}
+
+// the signed payload, short keys keep the links short
+type payload struct {
+	ReservationID string `json:"r"`
+	ExpiresAt     int64  `json:"e"`
+	Nonce         string `json:"n"`
+}
+
+// NewSigner builds the signer for confirmation tokens. A token is <base64url payload>.<base64url HMAC-SHA256 of the
+// payload>, so it's verified with the secret alone, no lookup. Every instance has to share the secret
+func NewSigner(secret []byte) *Signer {
+	return &Signer{
+		secret: secret,
+	}
+}
+
+type Signer struct {
+	secret []byte
+}
+
+// Sign returns a new token for the reservation that's good until expiresAt
+func (s *Signer) Sign(reservationID string, expiresAt time.Time) (token string, err error) {
+	nonce := make([]byte, 16)
+	if _, err = rand.Read(nonce); err != nil {
+		return
+	}
+	var raw []byte
+	raw, err = json.Marshal(payload{
+		ReservationID: reservationID,
+		ExpiresAt:     expiresAt.Unix(),
+		Nonce:         hex.EncodeToString(nonce),
+	})
+	if err != nil {
+		return
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(raw)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(s.mac(encoded)), nil
+}
+
+// Verify checks the token's signature and expiry and returns its claims. It doesn't know whether the token was
+// already used, that's up to the caller
+func (s *Signer) Verify(token string, now time.Time) (claims Claims, err error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return claims, ErrMalformedToken
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return claims, ErrMalformedToken
+	}
+	// the signature is checked before the payload is even decoded, nothing unsigned gets parsed
+	if !hmac.Equal(mac, s.mac(encoded)) {
+		return claims, ErrInvalidToken
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return claims, ErrMalformedToken
+	}
+	var p payload
+	if err = json.Unmarshal(raw, &p); err != nil || p.ReservationID == "" || p.Nonce == "" {
+		return claims, ErrMalformedToken
+	}
+	claims = Claims{
+		ReservationID: p.ReservationID,
+		ExpiresAt:     time.Unix(p.ExpiresAt, 0),
+		Nonce:         p.Nonce,
+	}
+	if !now.Before(claims.ExpiresAt) {
+		return claims, ErrExpiredToken
+	}
+	return claims, nil
+}
+
+func (s *Signer) mac(encoded string) []byte {
+	h := hmac.New(sha256.New, s.secret)
+	h.Write([]byte(encoded))
+	return h.Sum(nil)
+}
@@ -0,0 +1,99 @@
+package confirmation
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+const reservationID = "00000000-0000-4000-8000-000000000001"
+
+func TestSignAndVerify(t *testing.T) {
+	var (
+		signer    = NewSigner([]byte("a-secret-shared-by-every-instance"))
+		now       = time.Now()
+		expiresAt = now.Add(time.Hour)
+	)
+	token, err := signer.Sign(reservationID, expiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := signer.Verify(token, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.ReservationID != reservationID || !claims.ExpiresAt.Equal(expiresAt.Truncate(time.Second)) || claims.Nonce == "" {
+		t.Fatalf("got the claims %+v", claims)
+	}
+
+	// every token is its own, spending one doesn't spend the next
+	again, err := signer.Sign(reservationID, expiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if againClaims, err := signer.Verify(again, now); err != nil || again == token || againClaims.Nonce == claims.Nonce {
+		t.Fatalf("signed the same token twice: %s, err %v", again, err)
+	}
+}
+
+func TestVerifyRefuses(t *testing.T) {
+	var (
+		signer = NewSigner([]byte("a-secret-shared-by-every-instance"))
+		now    = time.Now()
+	)
+	token, err := signer.Sign(reservationID, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := signer.Sign(reservationID, now.Add(-time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, signature, _ := strings.Cut(token, ".")
+	// another reservation's payload under this token's signature
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPayload := base64.RawURLEncoding.EncodeToString([]byte(strings.Replace(string(raw), reservationID, "00000000-0000-4000-8000-000000000002", 1)))
+	flippedSignature, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flippedSignature[0] ^= 1
+	// a payload that isn't claims, signed with the secret
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"r":"","e":1}`))
+
+	for name, c := range map[string]struct {
+		token string
+		want  error
+	}{
+		"tampered payload":       {token: otherPayload + "." + signature, want: ErrInvalidToken},
+		"tampered signature":     {token: encoded + "." + base64.RawURLEncoding.EncodeToString(flippedSignature), want: ErrInvalidToken},
+		"another secret":         {token: mustSign(t, NewSigner([]byte("another-secret")), now.Add(time.Hour)), want: ErrInvalidToken},
+		"expired":                {token: expired, want: ErrExpiredToken},
+		"expires now":            {token: mustSign(t, signer, now), want: ErrExpiredToken},
+		"no signature":           {token: encoded, want: ErrMalformedToken},
+		"signature isn't base64": {token: encoded + ".!!", want: ErrMalformedToken},
+		"payload isn't base64":   {token: "!!." + base64.RawURLEncoding.EncodeToString(signer.mac("!!")), want: ErrMalformedToken},
+		"payload without claims": {token: unsigned + "." + base64.RawURLEncoding.EncodeToString(signer.mac(unsigned)), want: ErrMalformedToken},
+		"empty":                  {token: "", want: ErrMalformedToken},
+	} {
+		t.Run(name, func(t *testing.T) {
+			claims, err := signer.Verify(c.token, now)
+			if !errors.Is(err, c.want) {
+				t.Fatalf("got %+v, err %v, want %v", claims, err, c.want)
+			}
+		})
+	}
+}
+
+func mustSign(t *testing.T, signer *Signer, expiresAt time.Time) string {
+	token, err := signer.Sign(reservationID, expiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"context"
+	"henrymeds-takehome/audit"
+	"henrymeds-takehome/model"
+	"log/slog"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// the widths of the columns
+	maxAuditEntityTypeLength = 50
+	maxAuditEntityIDLength   = 100
+	maxAuditActorLength      = 100
+	// how many entries are checked at a time when walking a chain
+	auditVerifyBatchSize = 1000
+)
+
+// GetAuditEntries returns a page of the audit log newest first, filtered by any of organization, entity and actor
+func (c *controller) GetAuditEntries(ctx context.Context, request model.GetAuditEntries) (entries []model.AuditEntry, next *model.PageKey, err error) {
+	if request.OrganizationID != "" {
+		if _, err = uuid.Parse(request.OrganizationID); err != nil {
+			err = invalidf("invalid UUID provided")
+			return
+		}
+	}
+	switch {
+	case utf8.RuneCountInString(request.EntityType) > maxAuditEntityTypeLength:
+		err = invalidf("entityType must be at most %d characters", maxAuditEntityTypeLength)
+	case utf8.RuneCountInString(request.EntityID) > maxAuditEntityIDLength:
+		err = invalidf("entityId must be at most %d characters", maxAuditEntityIDLength)
+	case utf8.RuneCountInString(request.Actor) > maxAuditActorLength:
+		err = invalidf("actor must be at most %d characters", maxAuditActorLength)
+	}
+	if err != nil {
+		return
+	}
+	request.Page.Sort = model.SortStartDesc
+	request.Page, err = c.normalizePage(request.Page)
+	if err != nil {
+		return
+	}
+
+	// one row past the limit tells us if there's another page without a second query
+	limit := request.Page.Limit
+	request.Page.Limit++
+	entries, err = c.reservationDao.GetAuditEntries(ctx, request)
+	if err != nil {
+		return
+	}
+	if len(entries) > limit {
+		entries = entries[:limit]
+		last := entries[limit-1]
+		next = &model.PageKey{Start: last.OccurredAt, ID: last.ID}
+	}
+	return
+}
+
+// VerifyAuditLog walks the organization's chain from its first entry and reports the first entry that doesn't check
+// out. Without an organization every chain is walked, the organizations' and the one of rows that aren't any's
+func (c *controller) VerifyAuditLog(ctx context.Context, organizationID string) (verifications []model.AuditVerification, err error) {
+	var chains []string
+	if organizationID != "" {
+		if _, err = uuid.Parse(organizationID); err != nil {
+			err = invalidf("invalid UUID provided")
+			return
+		}
+		chains = []string{organizationID}
+	} else {
+		var organizations []model.Organization
+		if organizations, err = c.reservationDao.GetOrganizations(ctx, ""); err != nil {
+			return
+		}
+		chains = []string{""}
+		for _, organization := range organizations {
+			chains = append(chains, organization.ID)
+		}
+	}
+
+	for _, chain := range chains {
+		var verification model.AuditVerification
+		if verification, err = c.verifyChain(ctx, chain); err != nil {
+			return
+		}
+		if !verification.Valid {
+			slog.WarnContext(ctx, "audit log chain is broken", "organization_id", chain, "entry_id", verification.BrokenAt)
+		}
+		verifications = append(verifications, verification)
+	}
+	return
+}
+
+func (c *controller) verifyChain(ctx context.Context, organizationID string) (verification model.AuditVerification, err error) {
+	var (
+		previous []byte
+		afterSeq int64
+		entries  []model.AuditEntry
+	)
+	verification.OrganizationID = organizationID
+	for {
+		entries, err = c.reservationDao.GetAuditChain(ctx, organizationID, afterSeq, auditVerifyBatchSize)
+		if err != nil {
+			return
+		}
+		if broken := audit.Verify(previous, entries); broken >= 0 {
+			verification.Checked += broken + 1
+			verification.BrokenAt = entries[broken].ID
+			return
+		}
+		verification.Checked += len(entries)
+		if len(entries) < auditVerifyBatchSize {
+			verification.Valid = true
+			return
+		}
+		last := entries[len(entries)-1]
+		previous, afterSeq = last.Hash, last.Seq
+	}
+}
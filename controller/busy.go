@@ -0,0 +1,253 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"henrymeds-takehome/dao"
+	"henrymeds-takehome/ical"
+	"henrymeds-takehome/metrics"
+	"henrymeds-takehome/model"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func (c *controller) CreateBusySource(ctx context.Context, request model.CreateBusySource) (source model.BusySource, err error) {
+	var busy ical.Busy
+
+	if _, err = uuid.Parse(request.ProviderID); err != nil {
+		err = invalidf("invalid UUID provided")
+		return
+	}
+	if (request.URL == "") == (len(request.Calendar) == 0) {
+		err = invalidf("send either a calendar or the URL to fetch it from")
+		return
+	}
+	source = model.BusySource{
+		ProviderID: request.ProviderID,
+		Kind:       model.BusySourceUpload,
+		URL:        request.URL,
+		CreatedAt:  time.Now(),
+	}
+	if request.URL != "" {
+		source.Kind = model.BusySourceURL
+	}
+
+	// the fetch and the parse happen before the transaction, a slow calendar server shouldn't hold the provider's lock
+	busy, err = c.readBusy(ctx, source, request.Calendar)
+	if err != nil {
+		return
+	}
+	err = c.reservationDao.InTransaction(ctx, func(tx dao.ReservationDao) (err error) {
+		err = tx.LockUser(ctx, request.ProviderID)
+		if errors.Is(err, dao.ErrNotFound) {
+			return notFoundf("no provider with that ID")
+		}
+		if err != nil {
+			return
+		}
+		source, err = tx.InsertBusySource(ctx, source)
+		if err != nil {
+			return
+		}
+		source, err = c.storeBusy(ctx, tx, source, busy)
+		return
+	})
+	if err != nil {
+		return
+	}
+	slog.InfoContext(ctx, "busy source created", "provider_id", source.ProviderID, "source_id", source.ID, "blocks", source.Blocks)
+	return
+}
+
+func (c *controller) ImportBusySource(ctx context.Context, request model.ImportBusySource) (source model.BusySource, err error) {
+	var busy ical.Busy
+
+	source, err = c.GetBusySource(ctx, request.ProviderID, request.ID)
+	if err != nil {
+		return
+	}
+	switch {
+	case source.Kind == model.BusySourceURL && len(request.Calendar) > 0:
+		err = invalidf("the source is fetched from its URL, it doesn't take an uploaded calendar")
+	case source.Kind == model.BusySourceUpload && len(request.Calendar) == 0:
+		err = invalidf("the source was uploaded, send the new calendar to re-import it")
+	}
+	if err != nil {
+		return
+	}
+
+	busy, err = c.readBusy(ctx, source, request.Calendar)
+	if err != nil {
+		return
+	}
+	err = c.reservationDao.InTransaction(ctx, func(tx dao.ReservationDao) (err error) {
+		source, err = c.storeBusy(ctx, tx, source, busy)
+		return
+	})
+	if err != nil {
+		return
+	}
+	slog.InfoContext(ctx, "busy source imported", "provider_id", source.ProviderID, "source_id", source.ID, "blocks", source.Blocks)
+	return
+}
+
+func (c *controller) GetBusySources(ctx context.Context, providerID string) (sources []model.BusySource, err error) {
+	if _, err = uuid.Parse(providerID); err != nil {
+		err = invalidf("invalid UUID provided")
+		return
+	}
+	return c.reservationDao.GetBusySources(ctx, model.GetBusySources{ProviderID: providerID})
+}
+
+func (c *controller) DeleteBusySource(ctx context.Context, providerID string, id string) (err error) {
+	if _, err = c.GetBusySource(ctx, providerID, id); err != nil {
+		return
+	}
+	err = c.reservationDao.DeleteBusySource(ctx, id)
+	if errors.Is(err, dao.ErrNotFound) {
+		err = notFoundf("no busy source with that ID for that provider")
+	}
+	if err != nil {
+		return
+	}
+	slog.InfoContext(ctx, "busy source deleted", "provider_id", providerID, "source_id", id)
+	return
+}
+
+func (c *controller) GetBusySource(ctx context.Context, providerID string, id string) (source model.BusySource, err error) {
+	var sources []model.BusySource
+
+	// validate the UUIDs, don't want strings going directly to the DB
+	if _, err = uuid.Parse(id); err != nil {
+		err = invalidf("invalid UUID provided")
+		return
+	}
+	if _, err = uuid.Parse(providerID); err != nil {
+		err = invalidf("invalid UUID provided")
+		return
+	}
+
+	sources, err = c.reservationDao.GetBusySources(ctx, model.GetBusySources{ID: id, ProviderID: providerID})
+	if err != nil {
+		return
+	}
+	if len(sources) == 0 {
+		err = notFoundf("no busy source with that ID for that provider")
+		return
+	}
+	return sources[0], nil
+}
+
+// readBusy fetches the source's calendar if it has a URL and reads its busy time from now to the calendar horizon,
+// nothing before now can be booked anyway
+func (c *controller) readBusy(ctx context.Context, source model.BusySource, calendar []byte) (busy ical.Busy, err error) {
+	if source.Kind == model.BusySourceURL {
+		calendar, err = c.fetcher.Fetch(ctx, source.URL)
+		if err != nil {
+			err = invalidf("failed to fetch the calendar: %v", err)
+			return
+		}
+	}
+	now := time.Now()
+	busy, err = ical.ParseBusy(bytes.NewReader(calendar), model.TimeRange{Start: now, End: now.Add(c.policy.CalendarHorizon)})
+	if err != nil {
+		err = invalidf("failed to read the calendar: %v", err)
+	}
+	return
+}
+
+// storeBusy replaces the source's busy blocks with what was just read. The source row is updated first, its row lock
+// keeps a concurrent delete from pulling the source out from under the new blocks
+func (c *controller) storeBusy(ctx context.Context, tx dao.ReservationDao, source model.BusySource, busy ical.Busy) (model.BusySource, error) {
+	source.Blocks = len(busy.Intervals)
+	source.Skipped = busy.Skipped
+	source.ImportedAt = time.Now()
+	err := tx.UpdateBusySource(ctx, source)
+	if errors.Is(err, dao.ErrNotFound) {
+		return source, notFoundf("no busy source with that ID for that provider")
+	}
+	if err != nil {
+		return source, err
+	}
+
+	blocks := make([]model.BusyBlock, 0, len(busy.Intervals))
+	for _, interval := range busy.Intervals {
+		blocks = append(blocks, model.BusyBlock{SourceID: source.ID, ProviderID: source.ProviderID, TimeRange: interval})
+	}
+	return source, tx.ReplaceBusyBlocks(ctx, source.ID, blocks)
+}
+
+// checkBusy refuses a reservation over time the provider's outside calendars have them busy
+func (c *controller) checkBusy(ctx context.Context, providerID string, timeRange model.TimeRange) (err error) {
+	var blocks []model.BusyBlock
+	blocks, err = c.reservationDao.GetBusyBlocks(ctx, model.GetBusyBlocks{ProviderID: providerID, TimeRange: timeRange})
+	if err != nil {
+		return
+	}
+	if len(blocks) > 0 {
+		err = conflictf("the provider is busy during the requested time")
+		metrics.Reservations.WithLabelValues(metrics.ReservationConflicted).Inc()
+	}
+	return
+}
+
+// subtractBusy cuts the provider's busy time out of the availabilities. An availability with busy time in the middle
+// comes back as several pieces that share its ID, one entirely covered by busy time is left out
+func (c *controller) subtractBusy(ctx context.Context, providerID string, availabilities []model.Availability, descending bool) (free []model.Availability, err error) {
+	var (
+		blocks []model.BusyBlock
+		span   model.TimeRange
+	)
+	if len(availabilities) == 0 {
+		return availabilities, nil
+	}
+	for i, availability := range availabilities {
+		if i == 0 || availability.Start.Before(span.Start) {
+			span.Start = availability.Start
+		}
+		if i == 0 || availability.End.After(span.End) {
+			span.End = availability.End
+		}
+	}
+	blocks, err = c.reservationDao.GetBusyBlocks(ctx, model.GetBusyBlocks{ProviderID: providerID, TimeRange: span})
+	if err != nil || len(blocks) == 0 {
+		return availabilities, err
+	}
+
+	free = make([]model.Availability, 0, len(availabilities))
+	for _, availability := range availabilities {
+		pieces := subtractTimeRanges(availability.TimeRange, blocks)
+		if descending {
+			for i, j := 0, len(pieces)-1; i < j; i, j = i+1, j-1 {
+				pieces[i], pieces[j] = pieces[j], pieces[i]
+			}
+		}
+		for _, piece := range pieces {
+			availability.TimeRange = piece
+			free = append(free, availability)
+		}
+	}
+	return
+}
+
+// subtractTimeRanges returns what's left of from once the blocks are taken out, in order. The blocks are sorted by
+// start but may overlap each other, they come from different sources
+func subtractTimeRanges(from model.TimeRange, blocks []model.BusyBlock) (left []model.TimeRange) {
+	cursor := from.Start
+	for _, block := range blocks {
+		if !block.End.After(cursor) || !block.Start.Before(from.End) {
+			continue
+		}
+		if block.Start.After(cursor) {
+			left = append(left, model.TimeRange{Start: cursor, End: block.Start})
+		}
+		cursor = block.End
+		if !cursor.Before(from.End) {
+			return
+		}
+	}
+	return append(left, model.TimeRange{Start: cursor, End: from.End})
+}
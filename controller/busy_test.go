@@ -0,0 +1,170 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"henrymeds-takehome/dao"
+	"henrymeds-takehome/ical"
+	"henrymeds-takehome/model"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	busyProviderID = "00000000-0000-4000-8000-0000000000a1"
+	busySourceID   = "00000000-0000-4000-8000-0000000000b1"
+)
+
+// busyDao keeps one busy source and its blocks, anything else panics on the nil ReservationDao
+type busyDao struct {
+	dao.ReservationDao
+
+	source model.BusySource
+	blocks []model.BusyBlock
+}
+
+func (d *busyDao) InTransaction(ctx context.Context, fn func(dao.ReservationDao) error) error {
+	return fn(d)
+}
+
+func (d *busyDao) GetBusySources(ctx context.Context, request model.GetBusySources) ([]model.BusySource, error) {
+	if request.ID != d.source.ID || request.ProviderID != d.source.ProviderID {
+		return nil, nil
+	}
+	return []model.BusySource{d.source}, nil
+}
+
+func (d *busyDao) UpdateBusySource(ctx context.Context, source model.BusySource) error {
+	d.source = source
+	return nil
+}
+
+func (d *busyDao) ReplaceBusyBlocks(ctx context.Context, sourceID string, blocks []model.BusyBlock) error {
+	d.blocks = blocks
+	return nil
+}
+
+// calendarServer serves whatever calendar was set last, a calendar app's feed that changes between imports
+type calendarServer struct {
+	mu       sync.Mutex
+	calendar string
+}
+
+func (s *calendarServer) set(calendar string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calendar = calendar
+}
+
+func (s *calendarServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.calendar == "" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", ical.ContentType)
+	fmt.Fprint(w, s.calendar)
+}
+
+// daily is a calendar with a meeting at 09:00 in New York on count days from tomorrow
+func daily(tomorrow time.Time, count int) string {
+	return "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n" +
+		"BEGIN:VEVENT\r\nUID:meeting@example.com\r\n" +
+		"DTSTART;TZID=America/New_York:" + tomorrow.Format("20060102") + "T090000\r\n" +
+		"DURATION:PT1H\r\n" +
+		fmt.Sprintf("RRULE:FREQ=DAILY;COUNT=%d\r\n", count) +
+		"END:VEVENT\r\nEND:VCALENDAR\r\n"
+}
+
+func TestImportBusySource(t *testing.T) {
+	var (
+		ctx      = context.Background()
+		feed     = &calendarServer{}
+		server   = httptest.NewServer(feed)
+		newYork  = mustLoadLocation(t, "America/New_York")
+		tomorrow = time.Now().In(newYork).AddDate(0, 0, 1)
+		d        = &busyDao{source: model.BusySource{
+			ID:         busySourceID,
+			ProviderID: busyProviderID,
+			Kind:       model.BusySourceURL,
+		}}
+		c       = NewController(d, Policy{CalendarHorizon: 14 * 24 * time.Hour}, ical.NewHTTPFetcher(time.Second, 1<<20, true), nil, nil)
+		request = model.ImportBusySource{ID: busySourceID, ProviderID: busyProviderID}
+	)
+	defer server.Close()
+	d.source.URL = server.URL + "/calendar.ics"
+
+	t.Run("stores the busy time", func(t *testing.T) {
+		feed.set(daily(tomorrow, 3))
+		source, err := c.ImportBusySource(ctx, request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if source.Blocks != 3 || len(d.blocks) != 3 || source.ImportedAt.IsZero() {
+			t.Fatalf("imported %+v, stored %v", source, d.blocks)
+		}
+		for i, block := range d.blocks {
+			day := tomorrow.AddDate(0, 0, i)
+			want := time.Date(day.Year(), day.Month(), day.Day(), 9, 0, 0, 0, newYork)
+			if !block.Start.Equal(want) || block.End.Sub(block.Start) != time.Hour {
+				t.Fatalf("block %d is %v, want an hour from %v", i, block.TimeRange, want)
+			}
+			if block.SourceID != busySourceID || block.ProviderID != busyProviderID {
+				t.Fatalf("block %d belongs to %s/%s", i, block.ProviderID, block.SourceID)
+			}
+		}
+	})
+
+	t.Run("a re-import replaces the busy time", func(t *testing.T) {
+		feed.set(daily(tomorrow, 1))
+		source, err := c.ImportBusySource(ctx, request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if source.Blocks != 1 || len(d.blocks) != 1 {
+			t.Fatalf("imported %+v, stored %v", source, d.blocks)
+		}
+	})
+
+	t.Run("a malformed calendar stores nothing", func(t *testing.T) {
+		feed.set("BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nDTSTART:tomorrow\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n")
+		before := d.source
+		_, err := c.ImportBusySource(ctx, request)
+		if !errors.Is(err, ErrInvalid) {
+			t.Fatalf("got %v, want ErrInvalid", err)
+		}
+		if d.source != before || len(d.blocks) != 1 {
+			t.Fatalf("the failed import changed %+v, %v", d.source, d.blocks)
+		}
+	})
+
+	t.Run("a calendar that can't be fetched stores nothing", func(t *testing.T) {
+		feed.set("")
+		if _, err := c.ImportBusySource(ctx, request); !errors.Is(err, ErrInvalid) {
+			t.Fatalf("got %v, want ErrInvalid", err)
+		}
+		if len(d.blocks) != 1 {
+			t.Fatalf("the failed import changed the blocks to %v", d.blocks)
+		}
+	})
+
+	t.Run("an uploaded calendar is refused for a URL source", func(t *testing.T) {
+		_, err := c.ImportBusySource(ctx, model.ImportBusySource{ID: busySourceID, ProviderID: busyProviderID, Calendar: []byte(daily(tomorrow, 1))})
+		if !errors.Is(err, ErrInvalid) {
+			t.Fatalf("got %v, want ErrInvalid", err)
+		}
+	})
+}
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("failed to load %s: %v", name, err)
+	}
+	return location
+}
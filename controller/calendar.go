@@ -0,0 +1,108 @@
+package controller
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"henrymeds-takehome/dao"
+	"henrymeds-takehome/logging"
+	"henrymeds-takehome/model"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// 256 bits, the token is the only thing standing between the feed and anyone who guesses its URL
+const calendarTokenBytes = 32
+
+func (c *controller) RotateCalendarToken(ctx context.Context, userID string) (token string, err error) {
+	// validate the UUID, don't want strings going directly to the DB
+	if _, err = uuid.Parse(userID); err != nil {
+		err = invalidf("invalid UUID provided")
+		return
+	}
+
+	raw := make([]byte, calendarTokenBytes)
+	if _, err = rand.Read(raw); err != nil {
+		return
+	}
+	token = base64.RawURLEncoding.EncodeToString(raw)
+	err = c.reservationDao.SetCalendarToken(ctx, userID, hashCalendarToken(token))
+	if errors.Is(err, dao.ErrNotFound) {
+		err = notFoundf("no user with that ID")
+	}
+	if err != nil {
+		token = ""
+		return
+	}
+	slog.InfoContext(ctx, "calendar token rotated", "user_id", logging.Patient(userID))
+	return
+}
+
+func (c *controller) GetCalendar(ctx context.Context, userID string, token string) (reservations []model.Reservation, err error) {
+	var (
+		user     model.User
+		now      = time.Now()
+		window   = model.TimeRange{Start: now.Add(-c.policy.CalendarLookback), End: now.Add(c.policy.CalendarHorizon)}
+		asClient []model.Reservation
+	)
+	if _, err = uuid.Parse(userID); err != nil {
+		err = invalidf("invalid UUID provided")
+		return
+	}
+
+	// a wrong token looks the same as a missing user, the feed URL shouldn't confirm which user IDs exist
+	user, err = c.reservationDao.GetUser(ctx, userID)
+	if errors.Is(err, dao.ErrNotFound) || (err == nil && !calendarTokenMatches(user, token)) {
+		err = notFoundf("no calendar feed for that user and token")
+	}
+	if err != nil {
+		return
+	}
+
+	reservations, err = c.reservationDao.GetReservations(ctx, model.GetReservations{
+		ProviderID: userID,
+		TimeRange:  &window,
+	})
+	if err != nil {
+		return
+	}
+	asClient, err = c.reservationDao.GetReservations(ctx, model.GetReservations{
+		ClientID:  userID,
+		TimeRange: &window,
+	})
+	if err != nil {
+		return
+	}
+	// nobody books themselves, but if they did the reservation should still only show up once
+	seen := map[string]bool{}
+	for _, reservation := range reservations {
+		seen[reservation.ID] = true
+	}
+	for _, reservation := range asClient {
+		if !seen[reservation.ID] {
+			reservations = append(reservations, reservation)
+		}
+	}
+	sort.SliceStable(reservations, func(i, j int) bool {
+		return reservations[i].Start.Before(reservations[j].Start)
+	})
+	return
+}
+
+func hashCalendarToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+func calendarTokenMatches(user model.User, token string) bool {
+	if len(user.CalendarTokenHash) == 0 || token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare(user.CalendarTokenHash, hashCalendarToken(token)) == 1
+}
@@ -0,0 +1,141 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"henrymeds-takehome/confirmation"
+	"henrymeds-takehome/dao"
+	"henrymeds-takehome/model"
+	"testing"
+	"time"
+)
+
+const confirmReservationID = "00000000-0000-4000-8000-0000000000c1"
+
+// confirmDao keeps one held reservation and the tokens spent on it, anything else panics on the nil ReservationDao
+type confirmDao struct {
+	dao.ReservationDao
+
+	reservation model.Reservation
+	spent       map[string]bool
+}
+
+func (d *confirmDao) InTransaction(ctx context.Context, fn func(dao.ReservationDao) error) error {
+	return fn(d)
+}
+
+func (d *confirmDao) GetReservations(ctx context.Context, request model.GetReservations) ([]model.Reservation, error) {
+	if request.ID != d.reservation.ID {
+		return nil, nil
+	}
+	return []model.Reservation{d.reservation}, nil
+}
+
+func (d *confirmDao) SpendConfirmationToken(ctx context.Context, token model.SpentConfirmationToken) error {
+	if d.spent[token.Nonce] {
+		return dao.ErrConflict
+	}
+	d.spent[token.Nonce] = true
+	return nil
+}
+
+func (d *confirmDao) ConfirmReservation(ctx context.Context, id string) error {
+	d.reservation.Confirmed = true
+	return nil
+}
+
+func (d *confirmDao) SettleWaitlistOffers(ctx context.Context, reservationIDs []string, status string) error {
+	return nil
+}
+
+func (d *confirmDao) ReplaceReminders(ctx context.Context, reservationID string, reminders []model.Reminder) error {
+	return nil
+}
+
+func (d *confirmDao) InsertEvents(ctx context.Context, events []model.Event) error {
+	return nil
+}
+
+func TestConfirmReservationByToken(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		signer = confirmation.NewSigner([]byte("a-secret-shared-by-every-instance"))
+		start  = time.Now().Add(72 * time.Hour)
+		d      = &confirmDao{
+			reservation: model.Reservation{
+				ID:        confirmReservationID,
+				TimeRange: model.TimeRange{Start: start, End: start.Add(30 * time.Minute)},
+				ExpiresAt: time.Now().Add(10 * time.Minute),
+				Modality:  model.ModalityPhone,
+			},
+			spent: map[string]bool{},
+		}
+		c = NewController(d, Policy{}, nil, signer, nil)
+	)
+	token, err := signer.Sign(confirmReservationID, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reservation, err := c.ConfirmReservationByToken(ctx, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reservation.Confirmed || len(d.spent) != 1 {
+		t.Fatalf("confirmed %+v and spent %d tokens", reservation, len(d.spent))
+	}
+
+	// the link was used, even though there's nothing left to confirm it's refused
+	if _, err = c.ConfirmReservationByToken(ctx, token); !errors.Is(err, ErrConflict) {
+		t.Fatalf("got %v for a spent link, want ErrConflict", err)
+	}
+
+	// a new link for a confirmed reservation is spent like any other
+	another, err := signer.Sign(confirmReservationID, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.ConfirmReservationByToken(ctx, another); err != nil || len(d.spent) != 2 {
+		t.Fatalf("got %v and spent %d tokens", err, len(d.spent))
+	}
+}
+
+func TestConfirmReservationByTokenRefuses(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		signer = confirmation.NewSigner([]byte("a-secret-shared-by-every-instance"))
+		c      = NewController(&confirmDao{spent: map[string]bool{}}, Policy{}, nil, signer, nil)
+	)
+	expired, err := signer.Sign(confirmReservationID, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := confirmation.NewSigner([]byte("another-secret")).Sign(confirmReservationID, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	notAnID, err := signer.Sign("'; DROP TABLE reservations; --", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing, err := signer.Sign("00000000-0000-4000-8000-0000000000c2", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, link := range map[string]struct {
+		token string
+		want  error
+	}{
+		"expired":              {token: expired, want: ErrConflict},
+		"another secret":       {token: forged, want: ErrInvalid},
+		"malformed":            {token: "not-a-token", want: ErrInvalid},
+		"not a reservation ID": {token: notAnID, want: ErrInvalid},
+		"no such reservation":  {token: missing, want: ErrNotFound},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := c.ConfirmReservationByToken(ctx, link.token); !errors.Is(err, link.want) {
+				t.Fatalf("got %v, want %v", err, link.want)
+			}
+		})
+	}
+}
@@ -3,111 +3,282 @@ package controller
 import (
 	"context"
 	"errors"
-	"fmt"
+	"henrymeds-takehome/confirmation"
 	"henrymeds-takehome/dao"
+	"henrymeds-takehome/ical"
+	"henrymeds-takehome/metrics"
 	"henrymeds-takehome/model"
-	"log"
+	"henrymeds-takehome/outbox"
+	"henrymeds-takehome/video"
+	"log/slog"
+	"slices"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 )
 
-const (
-	reservationLeadTime       = time.Hour * 24
-	reservationExpirationTime = time.Minute * 30
-)
+// Policy holds the booking and paging rules the controller enforces
+type Policy struct {
+	// how far ahead of its start a reservation has to be made
+	LeadTime time.Duration
+	// how long an unconfirmed reservation holds its slot before it expires
+	HoldDuration time.Duration
+	// how many unconfirmed reservations a client can hold at once, 0 for no cap
+	MaxHolds int
+	// reservations and availabilities have to start and end on multiples of this
+	SlotInterval time.Duration
+	// page size of a list when the request doesn't set a limit
+	DefaultLimit int
+	// the largest page a list returns, larger limits are capped to it
+	MaxLimit int
+	// how far back and ahead of now the calendar feeds go
+	CalendarLookback time.Duration
+	CalendarHorizon  time.Duration
+	// how long before the start of a confirmed reservation its client is reminded, once per lead. None when empty
+	ReminderLeads []time.Duration
+	// the appointments a waitlist entry can wait for, by name, and how long each takes
+	AppointmentTypes map[string]time.Duration
+	// the modalities an appointment type can be booked as, by name. Types that aren't in it take any
+	AppointmentModalities map[string][]string
+	// how long a slot offered to the waitlist is held for the client to confirm it
+	WaitlistOfferHold time.Duration
+	// offer slots to the highest priority entry first instead of first come first served
+	WaitlistByPriority bool
+	// how many waitlist entries one freed up time range is offered to at most
+	WaitlistBatchSize int
+}
+
+func DefaultPolicy() Policy {
+	return Policy{
+		LeadTime:         time.Hour * 24,
+		HoldDuration:     time.Minute * 30,
+		MaxHolds:         3,
+		SlotInterval:     time.Minute * 15,
+		DefaultLimit:     50,
+		MaxLimit:         500,
+		CalendarLookback: 30 * 24 * time.Hour,
+		CalendarHorizon:  365 * 24 * time.Hour,
+		ReminderLeads:    []time.Duration{24 * time.Hour, time.Hour},
+		AppointmentTypes: map[string]time.Duration{
+			"initial":   30 * time.Minute,
+			"follow-up": 15 * time.Minute,
+		},
+		WaitlistOfferHold: 2 * time.Hour,
+		WaitlistBatchSize: 20,
+	}
+}
 
 type Controller interface {
-	CreateAvailability(ctx context.Context, request model.CreateAvailabilities) error
-	GetAvailabilities(ctx context.Context, request model.GetAvailabilities) (availabilities []model.TimeRange, err error)
-	CreateReservation(ctx context.Context, request model.CreateReservation) (confirmationID string, err error)
-	ConfirmReservation(ctx context.Context, confirmationId string) (err error)
+	CreateAvailability(ctx context.Context, request model.CreateAvailabilities) (availability model.Availability, err error)
+	// GetAvailabilities returns a page of availabilities, next is where the following page starts or nil on the last page
+	GetAvailabilities(ctx context.Context, request model.GetAvailabilities) (availabilities []model.Availability, next *model.PageKey, err error)
+	GetAvailability(ctx context.Context, providerID string, id string) (availability model.Availability, err error)
+	// GetSlots lists the provider's bookable slots inside the range with the seats each has left, full ones included.
+	// With a client set, only the slots the provider is licensed to see them for
+	GetSlots(ctx context.Context, request model.GetSlots) (slots []model.Slot, err error)
+	CreateReservation(ctx context.Context, request model.CreateReservation) (reservation model.Reservation, err error)
+	ConfirmReservation(ctx context.Context, confirmationId string) (reservation model.Reservation, err error)
+	// ConfirmReservationByToken confirms the reservation a confirmation link was sent for, each link works once
+	ConfirmReservationByToken(ctx context.Context, token string) (reservation model.Reservation, err error)
+	GetReservation(ctx context.Context, id string) (reservation model.Reservation, err error)
+	GetUser(ctx context.Context, id string) (user model.User, err error)
+	// UpdateUser records where the user is located, clients can only be booked with providers licensed there
+	UpdateUser(ctx context.Context, request model.UpdateUser) (user model.User, err error)
+	GetProviderLicenses(ctx context.Context, providerID string) (licenses []model.ProviderLicense, err error)
+	// PutProviderLicense adds the provider's license for the state, or renews the one they have
+	PutProviderLicense(ctx context.Context, license model.ProviderLicense) (stored model.ProviderLicense, err error)
+	DeleteProviderLicense(ctx context.Context, providerID string, state string) (err error)
+	ImportAvailabilities(ctx context.Context, request model.ImportAvailabilities) (report model.ImportReport, err error)
+	UpdateAvailability(ctx context.Context, request model.UpdateAvailability) (change model.AvailabilityChange, err error)
+	DeleteAvailability(ctx context.Context, request model.DeleteAvailability) (change model.AvailabilityChange, err error)
+	// RotateCalendarToken issues a new calendar feed token for the user, the previous one stops working
+	RotateCalendarToken(ctx context.Context, userID string) (token string, err error)
+	// GetCalendar returns the reservations in the user's calendar feed, as provider or client, if the token is theirs
+	GetCalendar(ctx context.Context, userID string, token string) (reservations []model.Reservation, err error)
+	// CreateBusySource registers an outside calendar for the provider and imports its busy time
+	CreateBusySource(ctx context.Context, request model.CreateBusySource) (source model.BusySource, err error)
+	// ImportBusySource replaces the source's busy time with what its calendar has now
+	ImportBusySource(ctx context.Context, request model.ImportBusySource) (source model.BusySource, err error)
+	GetBusySources(ctx context.Context, providerID string) (sources []model.BusySource, err error)
+	GetBusySource(ctx context.Context, providerID string, id string) (source model.BusySource, err error)
+	DeleteBusySource(ctx context.Context, providerID string, id string) (err error)
+	// CreateLocation adds a place in-person visits happen at, availabilities offer in_person visits at one
+	CreateLocation(ctx context.Context, request model.CreateLocation) (location model.Location, err error)
+	GetLocations(ctx context.Context) (locations []model.Location, err error)
+	GetLocation(ctx context.Context, id string) (location model.Location, err error)
+	// CreateOrganization adds a tenant along with its API key, the key is only ever returned here and on rotation
+	CreateOrganization(ctx context.Context, request model.CreateOrganization) (organization model.Organization, apiKey string, err error)
+	GetOrganizations(ctx context.Context) (organizations []model.Organization, err error)
+	GetOrganization(ctx context.Context, id string) (organization model.Organization, err error)
+	// RotateOrganizationKey issues a new API key for the organization, the previous one stops working
+	RotateOrganizationKey(ctx context.Context, id string) (apiKey string, err error)
+	CreateWebhookSubscription(ctx context.Context, request model.CreateWebhookSubscription) (subscription model.WebhookSubscription, err error)
+	GetWebhookSubscriptions(ctx context.Context) (subscriptions []model.WebhookSubscription, err error)
+	GetWebhookSubscription(ctx context.Context, id string) (subscription model.WebhookSubscription, err error)
+	UpdateWebhookSubscription(ctx context.Context, request model.UpdateWebhookSubscription) (subscription model.WebhookSubscription, err error)
+	DeleteWebhookSubscription(ctx context.Context, id string) (err error)
+	// GetWebhookDeliveries returns a page of the delivery log, newest first, next is where the following page starts
+	GetWebhookDeliveries(ctx context.Context, request model.GetWebhookDeliveries) (deliveries []model.WebhookDelivery, next *model.PageKey, err error)
+	GetWebhookDelivery(ctx context.Context, id string) (delivery model.WebhookDelivery, attempts []model.WebhookAttempt, err error)
+	// RedeliverWebhook queues the delivery to be sent again right away
+	RedeliverWebhook(ctx context.Context, id string) (delivery model.WebhookDelivery, err error)
+	// GetAuditEntries returns a page of the audit log, newest first, next is where the following page starts
+	GetAuditEntries(ctx context.Context, request model.GetAuditEntries) (entries []model.AuditEntry, next *model.PageKey, err error)
+	// VerifyAuditLog checks the organization's chain of audit entries, or every chain when organizationID is empty
+	VerifyAuditLog(ctx context.Context, organizationID string) (verifications []model.AuditVerification, err error)
+	// CreateWaitlistEntry puts the client in line for a slot, offered once time frees up
+	CreateWaitlistEntry(ctx context.Context, request model.CreateWaitlistEntry) (entry model.WaitlistEntry, err error)
+	// GetWaitlistEntry returns the entry and, while it has an open offer, the hold it was offered
+	GetWaitlistEntry(ctx context.Context, id string) (entry model.WaitlistEntry, offer model.Reservation, err error)
+	CancelWaitlistEntry(ctx context.Context, id string) (err error)
+	// OfferWaitlistSlots offers the provider's free time inside the range to the waitlist, offered is how many
+	// entries got a slot
+	OfferWaitlistSlots(ctx context.Context, providerID string, timeRange model.TimeRange) (offered int, err error)
+	// ExpireReservations records the expiry of up to limit holds that ran out, expired is how many it recorded
+	ExpireReservations(ctx context.Context, limit int) (expired int, err error)
 }
 
-func NewController(dao dao.ReservationDao) *controller {
+// NewController builds the controller, the fetcher downloads the outside calendars registered by URL, the signer
+// verifies the tokens of confirmation links and rooms opens the rooms of video visits
+func NewController(dao dao.ReservationDao, policy Policy, fetcher ical.Fetcher, signer *confirmation.Signer, rooms video.RoomProvider) *controller {
 	return &controller{
 		reservationDao: dao,
+		policy:         policy,
+		fetcher:        fetcher,
+		signer:         signer,
+		rooms:          rooms,
 	}
 }
 
 type controller struct {
 	reservationDao dao.ReservationDao
+	policy         Policy
+	fetcher        ical.Fetcher
+	signer         *confirmation.Signer
+	rooms          video.RoomProvider
 }
 
-func (c *controller) CreateAvailability(ctx context.Context, request model.CreateAvailabilities) (err error) {
+func (c *controller) CreateAvailability(ctx context.Context, request model.CreateAvailabilities) (availability model.Availability, err error) {
 	var (
 		existingAvailabilities []model.Availability
 		overlaps               []model.TimeRange
+		inserted               []model.Availability
 	)
 
-	err = validateCreateAvailability(request)
+	err = c.validateCreateAvailability(request)
 	if err != nil {
-		log.Println(err)
 		return
 	}
-
-	// check if there are any overlapping availabilities
-	existingAvailabilities, err = c.reservationDao.GetAvailabilities(ctx, model.GetAvailabilities{
-		ProviderID: request.ProviderID,
-		TimeRange:  request.TimeRange,
-	})
+	request.Modalities, err = normalizeModalities(request.Modalities, request.LocationID)
 	if err != nil {
 		return
 	}
 
-	overlaps = detectOverlap(append(extractTimeranges(existingAvailabilities), request.TimeRange))
-	if len(overlaps) > 0 {
-		// Ideally we don't throw an error here, we just extend the existing availability timerange to end when the submitted timerange ends
-		// however, I only have 2h, so this corner is getting cut
-		// TODO: give a better error here
-		err = errors.New("requested availability overlaps with existing availability")
-		log.Println(err)
-		return
-	}
+	// the provider is locked from the overlap check to the insert, so an import or another create can't slip in between
+	err = c.reservationDao.InTransaction(ctx, func(tx dao.ReservationDao) (err error) {
+		err = tx.LockUser(ctx, request.ProviderID)
+		if errors.Is(err, dao.ErrNotFound) {
+			return notFoundf("no provider with that ID")
+		}
+		if err != nil {
+			return
+		}
 
-	// insert
-	err = c.reservationDao.InsertAvailabilities(ctx, []model.Availability{
-		{
-			TimeRange:  request.TimeRange,
+		// check if there are any overlapping availabilities
+		existingAvailabilities, err = tx.GetAvailabilities(ctx, model.GetAvailabilities{
 			ProviderID: request.ProviderID,
-		},
+			TimeRange:  request.TimeRange,
+		})
+		if err != nil {
+			return
+		}
+
+		overlaps = detectOverlap(append(extractTimeranges(existingAvailabilities), request.TimeRange))
+		if len(overlaps) > 0 {
+			// Ideally we don't throw an error here, we just extend the existing availability timerange to end when the submitted timerange ends
+			// however, I only have 2h, so this corner is getting cut
+			// TODO: give a better error here
+			return conflictf("requested availability overlaps with existing availability")
+		}
+
+		// insert
+		inserted, err = tx.InsertAvailabilities(ctx, []model.Availability{
+			{
+				TimeRange:  request.TimeRange,
+				ProviderID: request.ProviderID,
+				Capacity:   max(request.Capacity, 1),
+				Modalities: request.Modalities,
+				LocationID: request.LocationID,
+			},
+		})
+		if errors.Is(err, dao.ErrNotFound) {
+			// the provider is locked, the location is what's left, either there's none or it's in another organization
+			return notFoundf("no location with that ID")
+		}
+		if err != nil {
+			return
+		}
+		return recordAvailabilityEvents(ctx, tx, outbox.AvailabilityCreated, time.Now(), inserted...)
 	})
 	// I prefer not to print every log on every layer unless it provides useful tracing context, this avoids log spam
 	// the error will get logged on the handler layer
+	if errors.Is(err, dao.ErrConflict) {
+		err = conflictf("requested availability overlaps with existing availability")
+	}
+	if err != nil {
+		return
+	}
+	availability = inserted[0]
+	slog.InfoContext(ctx, "availability created", "provider_id", request.ProviderID, "availability_id", availability.ID)
 	return
 }
 
-func validateCreateAvailability(request model.CreateAvailabilities) (err error) {
+func (c *controller) validateCreateAvailability(request model.CreateAvailabilities) (err error) {
 	if !request.Start.Before(request.End) {
-		err = errors.New("start time must be before end time")
-	} else if request.Start.Minute()%15 != 0 {
-		err = errors.New("start time minutes must be a multiple of 15")
-	} else if request.End.Minute()%15 != 0 {
-		err = errors.New("end time minutes must be a multiple of 15")
+		err = invalidf("start time must be before end time")
+	} else if !c.onSlotBoundary(request.Start) {
+		err = invalidf("start time must be on a %s boundary", c.policy.SlotInterval)
+	} else if !c.onSlotBoundary(request.End) {
+		err = invalidf("end time must be on a %s boundary", c.policy.SlotInterval)
 	} else if request.Start.Before(time.Now()) {
-		err = errors.New("start time must be in the future")
+		err = invalidf("start time must be in the future")
+	} else if request.Capacity < 0 {
+		err = invalidf("capacity must be at least 1")
 	}
 
 	return
 }
 
-func (c *controller) GetAvailabilities(ctx context.Context, request model.GetAvailabilities) (availableTimeranges []model.TimeRange, err error) {
-	var (
-		existingAvailabilities []model.Availability
-	)
-	err = validateGetAvailabilities(request)
+func (c *controller) GetAvailabilities(ctx context.Context, request model.GetAvailabilities) (availabilities []model.Availability, next *model.PageKey, err error) {
+	err = c.validateGetAvailabilities(request)
+	if err != nil {
+		return
+	}
+	request.Page, err = c.normalizePage(request.Page)
 	if err != nil {
-		log.Println(err)
 		return
 	}
 
 	// retrieve availabilities that overlap with request start-end
-	existingAvailabilities, err = c.reservationDao.GetAvailabilities(ctx, request)
+	// one row past the limit tells us if there's another page without a second query
+	limit := request.Page.Limit
+	request.Page.Limit++
+	availabilities, err = c.reservationDao.GetAvailabilities(ctx, request)
+	if err != nil {
+		return
+	}
+	if len(availabilities) > limit {
+		availabilities = availabilities[:limit]
+		last := availabilities[limit-1]
+		next = &model.PageKey{Start: last.Start, ID: last.ID}
+	}
+	// the page is cut on the stored rows, the next key has to come from them and not from the pieces below
+	availabilities, err = c.subtractBusy(ctx, request.ProviderID, availabilities, request.Page.Descending())
 	if err != nil {
-		log.Println("failed to retrieve availabilities: ", err)
 		return
 	}
-	availableTimeranges = extractTimeranges(existingAvailabilities)
 	// retrieve reservations that overlap with request start-end
 
 	// subtract reservations from retrieved availabilities
@@ -115,29 +286,48 @@ func (c *controller) GetAvailabilities(ctx context.Context, request model.GetAva
 	return
 }
 
-func validateGetAvailabilities(request model.GetAvailabilities) (err error) {
+func (c *controller) validateGetAvailabilities(request model.GetAvailabilities) (err error) {
 	if !request.Start.Before(request.End) {
-		err = errors.New("start time must be before end time")
-	} else if request.Start.Minute()%15 != 0 {
-		err = errors.New("start time minutes must be a multiple of 15")
-	} else if request.End.Minute()%15 != 0 {
-		err = errors.New("end time minutes must be a multiple of 15")
+		err = invalidf("start time must be before end time")
+	} else if !c.onSlotBoundary(request.Start) {
+		err = invalidf("start time must be on a %s boundary", c.policy.SlotInterval)
+	} else if !c.onSlotBoundary(request.End) {
+		err = invalidf("end time must be on a %s boundary", c.policy.SlotInterval)
 	}
 
 	return
 }
 
-func (c *controller) CreateReservation(ctx context.Context, request model.CreateReservation) (confirmationID string, err error) {
+// normalizePage fills in the defaults and caps the limit, a list never returns more than MaxLimit rows
+func (c *controller) normalizePage(page model.Page) (model.Page, error) {
+	switch page.Sort {
+	case "":
+		page.Sort = model.SortStartAsc
+	case model.SortStartAsc, model.SortStartDesc:
+	default:
+		return page, invalidf("sort must be %s or %s", model.SortStartAsc, model.SortStartDesc)
+	}
+	switch {
+	case page.Limit < 0:
+		return page, invalidf("limit must be positive")
+	case page.Limit == 0:
+		page.Limit = c.policy.DefaultLimit
+	case page.Limit > c.policy.MaxLimit:
+		page.Limit = c.policy.MaxLimit
+	}
+	return page, nil
+}
+
+func (c *controller) CreateReservation(ctx context.Context, request model.CreateReservation) (newReservation model.Reservation, err error) {
 	var (
 		availabilities []model.Availability
 		overlaps       []model.TimeRange
-		// reservations   []model.Reservation
-		newReservation model.Reservation
 	)
 
-	err = validateCreateReservation(request)
+	request.Reason = strings.TrimSpace(request.Reason)
+	err = c.validateCreateReservation(request)
 	if err != nil {
-		log.Println(err)
+		metrics.Reservations.WithLabelValues(metrics.ReservationInvalid).Inc()
 		return
 	}
 
@@ -149,7 +339,6 @@ func (c *controller) CreateReservation(ctx context.Context, request model.Create
 	})
 	if err != nil {
 		// TODO:error handling
-		log.Println("failed to check for existing availabilities: ", err)
 		return
 	}
 
@@ -157,8 +346,7 @@ func (c *controller) CreateReservation(ctx context.Context, request model.Create
 	overlaps = detectOverlap(append(extractTimeranges(availabilities), request.TimeRange))
 	// if there is absolutely no overlap, that means there are no availabilities
 	if len(overlaps) == 0 {
-		err = fmt.Errorf("no availability during the requested times: %v - %v", request.Start, request.End)
-		log.Println(err)
+		err = conflictf("no availability during the requested times: %v - %v", request.Start, request.End)
 		return
 	} else {
 		// figure out the total amount of overlap time
@@ -168,89 +356,230 @@ func (c *controller) CreateReservation(ctx context.Context, request model.Create
 			totalOverlapTime += overlap.End.Sub(overlap.Start)
 		}
 		if totalOverlapTime != request.End.Sub(request.Start) {
-			err = errors.New("insufficient availability during the requested time")
-			log.Println(err)
+			err = conflictf("insufficient availability during the requested time")
 			return
 		}
 	}
 
-	err = c.checkReservationAvailability(ctx, request.ClientID, request.ProviderID, request.TimeRange)
+	option, err := c.pickBookingOption(request, availabilities)
 	if err != nil {
 		return
 	}
-	newReservation, err = c.reservationDao.InsertReservation(ctx, model.Reservation{
-		ClientID:   request.ClientID,
-		ProviderID: request.ProviderID,
-		ExpiresAt:  time.Now().Add(reservationExpirationTime),
-		Confirmed:  false,
-		TimeRange:  request.TimeRange,
+
+	err = c.checkBusy(ctx, request.ProviderID, request.TimeRange)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	err = c.reservationDao.InTransaction(ctx, func(tx dao.ReservationDao) (err error) {
+		reservation := model.Reservation{
+			ClientID:   request.ClientID,
+			ProviderID: request.ProviderID,
+			ExpiresAt:  now.Add(c.policy.HoldDuration),
+			Confirmed:  false,
+			TimeRange:  request.TimeRange,
+			Modality:   option.modality,
+			LocationID: option.locationID,
+			Reason:     request.Reason,
+		}
+		err = c.checkReservationAvailability(ctx, tx, reservation)
+		if err != nil {
+			return
+		}
+		err = c.checkHolds(ctx, tx, reservation.ClientID, now)
+		if err != nil {
+			return
+		}
+		newReservation, err = tx.InsertReservation(ctx, reservation)
+		if err != nil {
+			return
+		}
+		return recordReservationEvents(ctx, tx, outbox.ReservationHeld, now, newReservation)
 	})
+	if errors.Is(err, dao.ErrConflict) {
+		// the provider is locked, only the client's unique constraint is left to trip over
+		metrics.Reservations.WithLabelValues(metrics.ReservationConflicted).Inc()
+		err = conflictf("the client has conflicting reservations during that time")
+	}
+	if errors.Is(err, dao.ErrNotFound) {
+		// likewise the client is what's left, either there's no such user or they're in another organization
+		err = notFoundf("no client with that ID")
+	}
 	if err != nil {
-		log.Println("failed to insert reservation")
 		return // TODO:error handling
 	}
 
-	confirmationID = newReservation.ConfirmationID
+	// counted once it's committed, a rolled back insert made no reservation
+	metrics.Reservations.WithLabelValues(metrics.ReservationCreated).Inc()
+	slog.InfoContext(ctx, "reservation created",
+		"reservation_id", newReservation.ID,
+		"provider_id", newReservation.ProviderID,
+		"client_id", newReservation.ClientID,
+		"modality", newReservation.Modality,
+	)
 
 	return
 }
 
-func (c *controller) checkReservationAvailability(ctx context.Context, clientId string, providerId string, timerange model.TimeRange) (err error) {
+// pickBookingOption works out how the visit happens: in the modality the client asked for, or else the first one the
+// availability under the start of the reservation lists that the appointment type allows. Every availability the
+// reservation covers has to offer it, in_person ones at the same location
+func (c *controller) pickBookingOption(request model.CreateReservation, availabilities []model.Availability) (option bookingOption, err error) {
+	modalities := c.allowedModalities(request.AppointmentType)
+	if request.Modality != "" {
+		modalities = []string{request.Modality}
+	} else {
+		for _, availability := range availabilities {
+			if covered(model.TimeRange{Start: request.Start, End: request.Start.Add(c.policy.SlotInterval)}, []model.TimeRange{availability.TimeRange}) {
+				modalities = slices.DeleteFunc(slices.Clone(availability.Modalities), func(modality string) bool {
+					return !slices.Contains(modalities, modality)
+				})
+				break
+			}
+		}
+	}
+	for _, option = range bookingOptions(availabilities, modalities, "") {
+		if capacity, _ := c.seats(request.TimeRange, option.availabilities, nil, nil); capacity > 0 {
+			return option, nil
+		}
+	}
+	if request.Modality != "" {
+		return option, conflictf("%s visits aren't offered during the requested time", request.Modality)
+	}
+	return option, conflictf("no modality the %s appointment type allows is offered during the requested time", request.AppointmentType)
+}
+
+// checkReservationAvailability makes sure the provider may see the client, has a seat left for the time range in the
+// reservation's modality and the client isn't booked for it already. It runs in the transaction that takes the seat
+// and locks the provider first, so two bookings can't both be handed the last seat
+func (c *controller) checkReservationAvailability(ctx context.Context, tx dao.ReservationDao, reservation model.Reservation) (err error) {
 	// TODO: check for collisions with self
 
-	var reservations []model.Reservation
-	reservations, err = c.reservationDao.GetReservations(ctx, model.GetReservations{
+	var (
+		availabilities []model.Availability
+		reservations   []model.Reservation
+		capacity       int
+		remaining      int
+		clientId       = reservation.ClientID
+		providerId     = reservation.ProviderID
+		timerange      = reservation.TimeRange
+	)
+	err = tx.LockUser(ctx, providerId)
+	if errors.Is(err, dao.ErrNotFound) {
+		return notFoundf("no provider with that ID")
+	}
+	if err != nil {
+		return
+	}
+	err = checkEligibility(ctx, tx, clientId, providerId, timerange)
+	if err != nil {
+		return
+	}
+	availabilities, err = tx.GetAvailabilities(ctx, model.GetAvailabilities{
+		ProviderID: providerId,
+		TimeRange:  timerange,
+	})
+	if err != nil {
+		return
+	}
+	reservations, err = tx.GetReservations(ctx, model.GetReservations{
 		ProviderID: providerId,
 		TimeRange:  &timerange,
 	})
 	if err != nil {
-		log.Println("failed to retrieve reservations")
 		return // TODO:error handling
 	}
-	// if there are any reservations that have been confirmed or haven't expired
-	// personally I would prefer to put this in the query, but that complicates the function
-	// significantly and adds significant devtime for this exercise
-	if len(reservations) > 0 {
-		for _, res := range reservations {
-			if res.Confirmed || time.Now().After(res.ExpiresAt) {
-				err = errors.New("the provider has conflicting reservations during that time")
-				log.Println(err)
-				return
-			}
+	// only reservations that have been confirmed or haven't expired take a seat, whatever their modality
+	capacity, remaining = c.seats(timerange, offering(availabilities, reservation.Modality, reservation.LocationID), nil, activeTimeRanges(reservations, time.Now()))
+	if capacity == 0 {
+		// the availability was removed or changed since it was checked
+		return conflictf("insufficient availability during the requested time")
+	}
+	if remaining == 0 {
+		if capacity == 1 {
+			err = conflictf("the provider has conflicting reservations during that time")
+		} else {
+			err = conflictf("all %d seats are taken during that time", capacity)
 		}
+		metrics.Reservations.WithLabelValues(metrics.ReservationConflicted).Inc()
+		return
 	}
 
 	// retrieve reservations that overlap with request timerange
-	reservations, err = c.reservationDao.GetReservations(ctx, model.GetReservations{
+	reservations, err = tx.GetReservations(ctx, model.GetReservations{
 		ClientID:  clientId,
 		TimeRange: &timerange,
 	})
 	if err != nil {
-		log.Println("failed to retrieve reservations")
 		return // TODO:error handling
 	}
 	// error if more than 1 are found, don't allow a client to double book even unconfirmed reservations.
 	if len(reservations) > 1 {
-		err = errors.New("the client has conflicting reservations during that time")
-		log.Println(err)
+		err = conflictf("the client has conflicting reservations during that time")
+		metrics.Reservations.WithLabelValues(metrics.ReservationConflicted).Inc()
 	}
 	return
 }
 
-func validateCreateReservation(request model.CreateReservation) (err error) {
-	if !request.Start.Before(request.End) {
-		err = errors.New("start time must be before end time")
-	} else if request.Start.Minute()%15 != 0 {
-		err = errors.New("start time minutes must be a multiple of 15")
-	} else if request.End.Minute()%15 != 0 {
-		err = errors.New("end time minutes must be a multiple of 15")
-	} else if request.Start.Before(time.Now().Add(reservationLeadTime)) {
-		err = errors.New("start time must be at least 24 hours before now")
+// checkHolds caps the client's unconfirmed reservations, so nobody can sit on every slot. The client is locked after
+// the provider, two of their requests can't both take the last hold
+func (c *controller) checkHolds(ctx context.Context, tx dao.ReservationDao, clientID string, now time.Time) (err error) {
+	if c.policy.MaxHolds == 0 {
+		return
+	}
+	err = tx.LockUser(ctx, clientID)
+	if errors.Is(err, dao.ErrNotFound) {
+		return notFoundf("no client with that ID")
+	}
+	if err != nil {
+		return
+	}
+	held, err := tx.CountHolds(ctx, clientID, now)
+	if err != nil {
+		return
+	}
+	if held >= c.policy.MaxHolds {
+		metrics.Reservations.WithLabelValues(metrics.ReservationTooManyHolds).Inc()
+		return conflictf("the client already holds %d unconfirmed reservations, confirm or cancel one first", held)
+	}
+	return
+}
+
+// a few paragraphs, the column takes any length but it's patient details nobody needs a novel of
+const maxReasonLength = 1000
+
+func (c *controller) validateCreateReservation(request model.CreateReservation) (err error) {
+	if utf8.RuneCountInString(request.Reason) > maxReasonLength {
+		err = invalidf("reason must be at most %d characters", maxReasonLength)
+	} else if !request.Start.Before(request.End) {
+		err = invalidf("start time must be before end time")
+	} else if !c.onSlotBoundary(request.Start) {
+		err = invalidf("start time must be on a %s boundary", c.policy.SlotInterval)
+	} else if !c.onSlotBoundary(request.End) {
+		err = invalidf("end time must be on a %s boundary", c.policy.SlotInterval)
+	} else if request.Start.Before(time.Now().Add(c.policy.LeadTime)) {
+		err = invalidf("start time must be at least %s from now", c.policy.LeadTime)
+	} else if request.AppointmentType != "" {
+		length, ok := c.policy.AppointmentTypes[request.AppointmentType]
+		if !ok {
+			err = invalidf("appointment type must be one of %s", strings.Join(c.appointmentTypes(), ", "))
+		} else if request.End.Sub(request.Start) != length {
+			err = invalidf("the %s appointment type takes %s", request.AppointmentType, length)
+		}
+	}
+	if err == nil {
+		err = c.validateModality(request.Modality, request.AppointmentType)
 	}
 
 	return
 }
 
+// the slot interval divides an hour evenly, so checking the offset into the hour is enough
+func (c *controller) onSlotBoundary(t time.Time) bool {
+	offset := time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second + time.Duration(t.Nanosecond())
+	return offset%c.policy.SlotInterval == 0
+}
+
 func detectOverlap(timeRanges []model.TimeRange) (overlappingRanges []model.TimeRange) {
 	var overlapStart, overlapEnd int64
 
@@ -272,17 +601,15 @@ func detectOverlap(timeRanges []model.TimeRange) (overlappingRanges []model.Time
 // I separated confirmations out into ReservationConfirmation to demonstrate some level of security competency
 // I realize I use UUIDs everywhere else, however generally you want to try to hide as many ID/UUIDs as possible from the
 // outside world. There wasn't time to do that however, and the user only uses their own UUID and their provider's UUID.
-func (c *controller) ConfirmReservation(ctx context.Context, confirmationId string) (err error) {
+func (c *controller) ConfirmReservation(ctx context.Context, confirmationId string) (reservation model.Reservation, err error) {
 	var (
 		reservations []model.Reservation
-		reservation  model.Reservation
 	)
 
 	// validate the UUID, don't want strings going directly to the DB
 	_, err = uuid.Parse(confirmationId)
 	if err != nil {
-		log.Println("invalid UUID provided")
-		err = errors.New("invalid UUID provided")
+		err = invalidf("invalid UUID provided")
 		return
 	}
 
@@ -291,42 +618,229 @@ func (c *controller) ConfirmReservation(ctx context.Context, confirmationId stri
 		ConfirmationID: confirmationId,
 	})
 	if err != nil {
-		log.Println("failed to retrieve reservations")
 		return // TODO:error handling
 	}
 	if len(reservations) == 0 {
-		err = errors.New("no reservation found for that confirmation Id")
-		log.Println(err.Error(), confirmationId)
+		err = notFoundf("no reservation found for that confirmation Id")
 		return
 	}
-	reservation = reservations[0]
+	return c.confirmReservation(ctx, reservations[0], nil)
+}
+
+// ConfirmReservationByToken checks the token by its signature, then confirms the reservation and spends the token in
+// the same transaction. A spent token is refused even when the reservation is already confirmed
+func (c *controller) ConfirmReservationByToken(ctx context.Context, token string) (reservation model.Reservation, err error) {
+	var (
+		claims       confirmation.Claims
+		reservations []model.Reservation
+	)
+
+	claims, err = c.signer.Verify(token, time.Now())
+	switch {
+	case errors.Is(err, confirmation.ErrExpiredToken):
+		err = conflictf("the confirmation link expired")
+		return
+	case err != nil:
+		err = invalidf("invalid confirmation token")
+		return
+	}
+	// signed by us, but the secret may have leaked, still don't let strings go directly to the DB
+	if _, err = uuid.Parse(claims.ReservationID); err != nil {
+		err = invalidf("invalid confirmation token")
+		return
+	}
+
+	reservations, err = c.reservationDao.GetReservations(ctx, model.GetReservations{
+		ID: claims.ReservationID,
+	})
+	if err != nil {
+		return
+	}
+	if len(reservations) == 0 {
+		err = notFoundf("no reservation found for that confirmation token")
+		return
+	}
+	return c.confirmReservation(ctx, reservations[0], &model.SpentConfirmationToken{
+		Nonce:         claims.Nonce,
+		ReservationID: claims.ReservationID,
+		ExpiresAt:     claims.ExpiresAt,
+	})
+}
+
+// confirmReservation confirms the reservation if it can still be confirmed. token is the confirmation link it's
+// confirmed with, spent along with the confirmation, nil when it's confirmed with its confirmation ID
+func (c *controller) confirmReservation(ctx context.Context, reservation model.Reservation, token *model.SpentConfirmationToken) (model.Reservation, error) {
+	var err error
+
+	// the availability under it was removed, there's nothing left to confirm
+	if !reservation.CancelledAt.IsZero() {
+		return reservation, conflictf("the reservation was cancelled")
+	}
 
 	// if already confirmed, just return
 	if reservation.Confirmed {
-		log.Println("reservation already confirmed")
-		return
+		slog.DebugContext(ctx, "reservation already confirmed", "reservation_id", reservation.ID)
+		if token != nil {
+			err = spendToken(ctx, c.reservationDao, *token)
+		}
+		return reservation, err
 	}
 
 	// if it has NOT expired, confirm it and return
-	if reservation.ExpiresAt.Before(time.Now()) {
-		err = c.reservationDao.ConfirmReservation(ctx, reservation.ID)
+	if time.Now().Before(reservation.ExpiresAt) {
+		reservation, err = c.confirm(ctx, reservation, token, false)
+		if err == nil {
+			slog.InfoContext(ctx, "reservation confirmed", "reservation_id", reservation.ID)
+		}
+		return reservation, err
+	}
+
+	// otherwise, it has expired, we need to check if other reservations have been booked in its place
+	err = c.checkBusy(ctx, reservation.ProviderID, reservation.TimeRange)
+	if err != nil {
+		return reservation, err
+	}
+	reservation, err = c.confirm(ctx, reservation, token, true)
+	if err == nil {
+		slog.InfoContext(ctx, "expired reservation confirmed", "reservation_id", reservation.ID)
+	}
+
+	return reservation, err
+}
+
+// confirm marks the reservation confirmed, spends the token it's confirmed with if there is one, books the waitlist
+// entry it was offered to, schedules its reminders and records the event for it. An expired hold gave up its seat, it
+// has to get one again first
+func (c *controller) confirm(ctx context.Context, reservation model.Reservation, token *model.SpentConfirmationToken, expired bool) (model.Reservation, error) {
+	var (
+		roomURL string
+		err     error
+	)
+	// a video visit gets its room, opened outside the transaction so it isn't held open on the video service. Rooms
+	// are opened once per reservation, a confirmation that fails below gets the same one when it's tried again
+	if reservation.Modality == model.ModalityVideo && reservation.VideoURL == "" {
+		roomURL, err = c.rooms.CreateRoom(ctx, reservation)
+		if err != nil {
+			return reservation, err
+		}
+	}
+	err = c.reservationDao.InTransaction(ctx, func(tx dao.ReservationDao) (err error) {
+		if expired {
+			err = c.checkReservationAvailability(ctx, tx, reservation)
+			if err != nil {
+				return
+			}
+		}
+		if roomURL != "" {
+			err = tx.SetReservationVideoURL(ctx, reservation.ID, roomURL)
+			if err != nil {
+				return
+			}
+			reservation.VideoURL = roomURL
+		}
+		if token != nil {
+			err = spendToken(ctx, tx, *token)
+			if err != nil {
+				return
+			}
+		}
+		err = tx.ConfirmReservation(ctx, reservation.ID)
 		if err != nil {
-			log.Println("failed to confirm reservation: ", err)
+			return
+		}
+		reservation.Confirmed = true
+		err = tx.SettleWaitlistOffers(ctx, []string{reservation.ID}, model.WaitlistBooked)
+		if err != nil {
+			return
+		}
+		err = c.scheduleReminders(ctx, tx, reservation)
+		if err != nil {
+			return
 		}
+		return recordReservationEvents(ctx, tx, outbox.ReservationConfirmed, time.Now(), reservation)
+	})
+	if err != nil {
+		reservation.Confirmed = false
+		reservation.VideoURL = ""
+		return reservation, err
+	}
+	metrics.Reservations.WithLabelValues(metrics.ReservationConfirmed).Inc()
+	return reservation, nil
+}
+
+// spendToken records the confirmation link as used, a conflict if it already was
+func spendToken(ctx context.Context, tx dao.ReservationDao, token model.SpentConfirmationToken) (err error) {
+	token.SpentAt = time.Now()
+	err = tx.SpendConfirmationToken(ctx, token)
+	if errors.Is(err, dao.ErrConflict) {
+		err = conflictf("the confirmation link was already used")
+	}
+	return
+}
+
+func (c *controller) GetAvailability(ctx context.Context, providerID string, id string) (availability model.Availability, err error) {
+	var availabilities []model.Availability
+
+	// validate the UUIDs, don't want strings going directly to the DB
+	if _, err = uuid.Parse(id); err != nil {
+		err = invalidf("invalid UUID provided")
+		return
+	}
+	if _, err = uuid.Parse(providerID); err != nil {
+		err = invalidf("invalid UUID provided")
 		return
 	}
 
-	// otherwise, it has expired, we need to check if other reservations have been booked in its place
-	err = c.checkReservationAvailability(ctx, reservation.ClientID, reservation.ProviderID, reservation.TimeRange)
+	availabilities, err = c.reservationDao.GetAvailabilities(ctx, model.GetAvailabilities{
+		ID:         id,
+		ProviderID: providerID,
+	})
 	if err != nil {
 		return
 	}
+	if len(availabilities) == 0 {
+		err = notFoundf("no availability with that ID for that provider")
+		return
+	}
+	availability = availabilities[0]
+	return
+}
+
+func (c *controller) GetReservation(ctx context.Context, id string) (reservation model.Reservation, err error) {
+	var reservations []model.Reservation
+
+	// validate the UUID, don't want strings going directly to the DB
+	if _, err = uuid.Parse(id); err != nil {
+		err = invalidf("invalid UUID provided")
+		return
+	}
 
-	err = c.reservationDao.ConfirmReservation(ctx, reservation.ID)
+	reservations, err = c.reservationDao.GetReservations(ctx, model.GetReservations{
+		ID: id,
+	})
 	if err != nil {
-		log.Println("failed to confirm reservation: ", err)
+		return
+	}
+	if len(reservations) == 0 {
+		err = notFoundf("no reservation with that ID")
+		return
 	}
+	reservation = reservations[0]
+	return
+}
 
+func (c *controller) GetUser(ctx context.Context, id string) (user model.User, err error) {
+	// validate the UUID, don't want strings going directly to the DB
+	_, err = uuid.Parse(id)
+	if err != nil {
+		err = invalidf("invalid UUID provided")
+		return
+	}
+
+	user, err = c.reservationDao.GetUser(ctx, id)
+	if errors.Is(err, dao.ErrNotFound) {
+		err = notFoundf("no user with that ID")
+	}
 	return
 }
 
@@ -0,0 +1,362 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"henrymeds-takehome/dao"
+	"henrymeds-takehome/metrics"
+	"henrymeds-takehome/model"
+	"henrymeds-takehome/outbox"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UpdateAvailability moves either end of an availability or changes its capacity. Reservations the new range no
+// longer covers are cancelled, held ones always and confirmed ones only with Force, without it a change that strands a
+// confirmed reservation is refused. A capacity too small for the reservations already booked is refused either way
+func (c *controller) UpdateAvailability(ctx context.Context, request model.UpdateAvailability) (change model.AvailabilityChange, err error) {
+	if err = validateAvailabilityIDs(request.ProviderID, request.ID); err != nil {
+		return
+	}
+	if request.Start == nil && request.End == nil && request.Capacity == nil {
+		err = invalidf("nothing to update, set start, end or capacity")
+		return
+	}
+
+	err = c.reservationDao.InTransaction(ctx, func(tx dao.ReservationDao) (err error) {
+		var (
+			current  model.Availability
+			existing []model.Availability
+		)
+		current, err = lockAvailability(ctx, tx, request.ProviderID, request.ID)
+		if err != nil {
+			return
+		}
+
+		updated := current
+		if request.Start != nil {
+			updated.Start = *request.Start
+		}
+		if request.End != nil {
+			updated.End = *request.End
+		}
+		if request.Capacity != nil {
+			updated.Capacity = *request.Capacity
+		}
+		err = c.validateUpdateAvailability(request, updated.TimeRange)
+		if err != nil {
+			return
+		}
+
+		existing, err = tx.GetAvailabilities(ctx, model.GetAvailabilities{
+			ProviderID: request.ProviderID,
+			TimeRange:  updated.TimeRange,
+		})
+		if err != nil {
+			return
+		}
+		for _, availability := range existing {
+			if availability.ID != updated.ID {
+				return conflictf("requested availability overlaps with existing availability")
+			}
+		}
+
+		change.Cancelled, err = c.orphanedReservations(ctx, tx, current, &updated.TimeRange, request.Force)
+		if err != nil {
+			return
+		}
+		if updated.Capacity < current.Capacity {
+			err = c.checkCapacity(ctx, tx, updated, change.Cancelled)
+			if err != nil {
+				return
+			}
+		}
+		err = tx.UpdateAvailability(ctx, updated)
+		if err != nil {
+			return
+		}
+		change.Availability = updated
+		err = c.cancelReservations(ctx, tx, change.Cancelled)
+		if err != nil {
+			return
+		}
+		now := time.Now()
+		err = recordAvailabilityEvents(ctx, tx, outbox.AvailabilityUpdated, now, updated)
+		if err != nil {
+			return
+		}
+		return recordReservationEvents(ctx, tx, outbox.ReservationCancelled, now, change.Cancelled...)
+	})
+	if errors.Is(err, dao.ErrConflict) {
+		err = conflictf("requested availability overlaps with existing availability")
+	}
+	if err != nil {
+		change = model.AvailabilityChange{}
+		return
+	}
+	countCancelled(change.Cancelled)
+	slog.InfoContext(ctx, "availability updated",
+		"provider_id", request.ProviderID,
+		"availability_id", request.ID,
+		"cancelled_reservations", len(change.Cancelled),
+	)
+	return
+}
+
+func (c *controller) validateUpdateAvailability(request model.UpdateAvailability, updated model.TimeRange) (err error) {
+	if !updated.Start.Before(updated.End) {
+		err = invalidf("start time must be before end time")
+	} else if !c.onSlotBoundary(updated.Start) {
+		err = invalidf("start time must be on a %s boundary", c.policy.SlotInterval)
+	} else if !c.onSlotBoundary(updated.End) {
+		err = invalidf("end time must be on a %s boundary", c.policy.SlotInterval)
+	} else if request.Start != nil && updated.Start.Before(time.Now()) {
+		// an availability that's already started can still be cut short, just not moved into the past
+		err = invalidf("start time must be in the future")
+	} else if request.End != nil && updated.End.Before(time.Now()) {
+		err = invalidf("end time must be in the future")
+	} else if request.Capacity != nil && *request.Capacity < 1 {
+		err = invalidf("capacity must be at least 1")
+	}
+
+	return
+}
+
+// DeleteAvailability removes an availability, cancelling the reservations it leaves uncovered under the same rules
+// as UpdateAvailability
+func (c *controller) DeleteAvailability(ctx context.Context, request model.DeleteAvailability) (change model.AvailabilityChange, err error) {
+	if err = validateAvailabilityIDs(request.ProviderID, request.ID); err != nil {
+		return
+	}
+
+	err = c.reservationDao.InTransaction(ctx, func(tx dao.ReservationDao) (err error) {
+		var current model.Availability
+		current, err = lockAvailability(ctx, tx, request.ProviderID, request.ID)
+		if err != nil {
+			return
+		}
+		change.Cancelled, err = c.orphanedReservations(ctx, tx, current, nil, request.Force)
+		if err != nil {
+			return
+		}
+		err = tx.DeleteAvailability(ctx, current.ID)
+		if err != nil {
+			return
+		}
+		err = c.cancelReservations(ctx, tx, change.Cancelled)
+		if err != nil {
+			return
+		}
+		now := time.Now()
+		err = recordAvailabilityEvents(ctx, tx, outbox.AvailabilityDeleted, now, current)
+		if err != nil {
+			return
+		}
+		return recordReservationEvents(ctx, tx, outbox.ReservationCancelled, now, change.Cancelled...)
+	})
+	if err != nil {
+		change = model.AvailabilityChange{}
+		return
+	}
+	countCancelled(change.Cancelled)
+	slog.InfoContext(ctx, "availability deleted",
+		"provider_id", request.ProviderID,
+		"availability_id", request.ID,
+		"cancelled_reservations", len(change.Cancelled),
+	)
+	return
+}
+
+// validate the UUIDs, don't want strings going directly to the DB
+func validateAvailabilityIDs(providerID string, id string) (err error) {
+	if _, err = uuid.Parse(providerID); err != nil {
+		return invalidf("invalid UUID provided")
+	}
+	if _, err = uuid.Parse(id); err != nil {
+		return invalidf("invalid UUID provided")
+	}
+	return
+}
+
+// lockAvailability locks the provider, so bookings and other edits wait for this one, and loads the availability
+func lockAvailability(ctx context.Context, tx dao.ReservationDao, providerID string, id string) (availability model.Availability, err error) {
+	var availabilities []model.Availability
+
+	err = tx.LockUser(ctx, providerID)
+	if errors.Is(err, dao.ErrNotFound) {
+		err = notFoundf("no provider with that ID")
+	}
+	if err != nil {
+		return
+	}
+	availabilities, err = tx.GetAvailabilities(ctx, model.GetAvailabilities{
+		ID:         id,
+		ProviderID: providerID,
+	})
+	if err != nil {
+		return
+	}
+	if len(availabilities) == 0 {
+		err = notFoundf("no availability with that ID for that provider")
+		return
+	}
+	availability = availabilities[0]
+	return
+}
+
+// orphanedReservations finds the active reservations that would no longer be covered by the provider's availability
+// once changed is replaced by replacement, or removed when replacement is nil. A reservation can span adjacent
+// availabilities, so coverage is checked against all of them and not just the one being changed
+func (c *controller) orphanedReservations(ctx context.Context, tx dao.ReservationDao, changed model.Availability, replacement *model.TimeRange, force bool) (orphaned []model.Reservation, err error) {
+	var (
+		reservations   []model.Reservation
+		active         []model.Reservation
+		availabilities []model.Availability
+		remaining      []model.TimeRange
+		span           model.TimeRange
+		now            = time.Now()
+	)
+	reservations, err = tx.GetReservations(ctx, model.GetReservations{
+		ProviderID: changed.ProviderID,
+		TimeRange:  &changed.TimeRange,
+	})
+	if err != nil {
+		return
+	}
+	for _, reservation := range reservations {
+		if !reservation.Active(now) {
+			continue
+		}
+		active = append(active, reservation)
+		if span.Start.IsZero() || reservation.Start.Before(span.Start) {
+			span.Start = reservation.Start
+		}
+		if reservation.End.After(span.End) {
+			span.End = reservation.End
+		}
+	}
+	if len(active) == 0 {
+		return
+	}
+
+	availabilities, err = tx.GetAvailabilities(ctx, model.GetAvailabilities{
+		ProviderID: changed.ProviderID,
+		TimeRange:  span,
+	})
+	if err != nil {
+		return
+	}
+	for _, availability := range availabilities {
+		if availability.ID != changed.ID {
+			remaining = append(remaining, availability.TimeRange)
+		}
+	}
+	if replacement != nil {
+		remaining = append(remaining, *replacement)
+	}
+
+	var confirmed []string
+	for _, reservation := range active {
+		if covered(reservation.TimeRange, remaining) {
+			continue
+		}
+		orphaned = append(orphaned, reservation)
+		if reservation.Confirmed {
+			confirmed = append(confirmed, reservation.ID)
+		}
+	}
+	if len(confirmed) > 0 && !force {
+		return nil, conflictf("the change would leave %d confirmed reservations without availability (%s), pass force=true to cancel them",
+			len(confirmed), strings.Join(confirmed, ", "))
+	}
+	return
+}
+
+// checkCapacity refuses a capacity the availability's active reservations don't fit in, there's no telling which of
+// them should make room. The ones the change cancels anyway don't count
+func (c *controller) checkCapacity(ctx context.Context, tx dao.ReservationDao, availability model.Availability, cancelled []model.Reservation) (err error) {
+	var (
+		reservations []model.Reservation
+		taken        []model.TimeRange
+		now          = time.Now()
+	)
+	reservations, err = tx.GetReservations(ctx, model.GetReservations{
+		ProviderID: availability.ProviderID,
+		TimeRange:  &availability.TimeRange,
+	})
+	if err != nil {
+		return
+	}
+	for _, reservation := range reservations {
+		if reservation.Active(now) && !slices.ContainsFunc(cancelled, func(other model.Reservation) bool { return other.ID == reservation.ID }) {
+			taken = append(taken, reservation.TimeRange)
+		}
+	}
+	for start := availability.Start; start.Before(availability.End); start = start.Add(c.policy.SlotInterval) {
+		var (
+			step   = model.TimeRange{Start: start, End: start.Add(c.policy.SlotInterval)}
+			booked int
+		)
+		for _, other := range taken {
+			if overlapsAny(step, []model.TimeRange{other}) {
+				booked++
+			}
+		}
+		if booked > availability.Capacity {
+			return conflictf("%d reservations are booked at %s, the capacity can't go below that", booked, start.Format(time.RFC3339))
+		}
+	}
+	return
+}
+
+// covered reports whether the time ranges, which don't overlap each other, cover all of timeRange between them
+func covered(timeRange model.TimeRange, timeRanges []model.TimeRange) bool {
+	var total time.Duration
+	for _, other := range timeRanges {
+		start, end := other.Start, other.End
+		if timeRange.Start.After(start) {
+			start = timeRange.Start
+		}
+		if timeRange.End.Before(end) {
+			end = timeRange.End
+		}
+		if start.Before(end) {
+			total += end.Sub(start)
+		}
+	}
+	return total == timeRange.End.Sub(timeRange.Start)
+}
+
+// cancelReservations cancels the reservations along with their reminders and marks the given copies to match. Waitlist
+// entries that were offered them go back in line, it's not on them that the slot went away
+func (c *controller) cancelReservations(ctx context.Context, tx dao.ReservationDao, reservations []model.Reservation) (err error) {
+	if len(reservations) == 0 {
+		return
+	}
+	var (
+		ids = make([]string, len(reservations))
+		now = time.Now()
+	)
+	for i := range reservations {
+		ids[i] = reservations[i].ID
+		reservations[i].CancelledAt = now
+	}
+	err = tx.CancelReservations(ctx, ids, now)
+	if err != nil {
+		return
+	}
+	err = tx.CancelReminders(ctx, ids)
+	if err != nil {
+		return
+	}
+	return tx.SettleWaitlistOffers(ctx, ids, model.WaitlistWaiting)
+}
+
+// countCancelled counts the reservations cancelReservations cancelled, once the transaction it ran in committed
+func countCancelled(reservations []model.Reservation) {
+	metrics.Reservations.WithLabelValues(metrics.ReservationCancelled).Add(float64(len(reservations)))
+}
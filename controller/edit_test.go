@@ -0,0 +1,290 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"henrymeds-takehome/dao"
+	"henrymeds-takehome/model"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	editedAvailabilityID = "00000000-0000-4000-8000-0000000000e1"
+	nextAvailabilityID   = "00000000-0000-4000-8000-0000000000e2"
+)
+
+// editDao looks availabilities up by ID too and keeps what the edit changed
+type editDao struct {
+	capacityDao
+
+	updated   *model.Availability
+	deleted   string
+	cancelled []string
+	reminders []string
+	// waitlist offers of the cancelled reservations, back in line
+	settled []string
+	events  []model.Event
+}
+
+func (d *editDao) InTransaction(ctx context.Context, fn func(dao.ReservationDao) error) error {
+	return fn(d)
+}
+
+func (d *editDao) GetAvailabilities(ctx context.Context, request model.GetAvailabilities) (availabilities []model.Availability, err error) {
+	if request.ID == "" {
+		return d.capacityDao.GetAvailabilities(ctx, request)
+	}
+	for _, availability := range d.availabilities {
+		if availability.ID == request.ID && availability.ProviderID == request.ProviderID {
+			availabilities = append(availabilities, availability)
+		}
+	}
+	return
+}
+
+func (d *editDao) UpdateAvailability(ctx context.Context, availability model.Availability) error {
+	d.updated = &availability
+	return nil
+}
+
+func (d *editDao) DeleteAvailability(ctx context.Context, id string) error {
+	d.deleted = id
+	return nil
+}
+
+func (d *editDao) CancelReservations(ctx context.Context, ids []string, at time.Time) error {
+	d.cancelled = append(d.cancelled, ids...)
+	return nil
+}
+
+func (d *editDao) CancelReminders(ctx context.Context, reservationIDs []string) error {
+	d.reminders = append(d.reminders, reservationIDs...)
+	return nil
+}
+
+func (d *editDao) SettleWaitlistOffers(ctx context.Context, reservationIDs []string, status string) error {
+	if status != model.WaitlistWaiting {
+		return errors.New("the offers should go back to waiting, not " + status)
+	}
+	d.settled = append(d.settled, reservationIDs...)
+	return nil
+}
+
+func (d *editDao) InsertEvents(ctx context.Context, events []model.Event) error {
+	d.events = append(d.events, events...)
+	return nil
+}
+
+// edited is the availability the tests change, from nine to eleven
+func edited() model.Availability {
+	edited := availability(at(0, 120), 1)
+	edited.ID = editedAvailabilityID
+	return edited
+}
+
+// confirmed is another client's confirmed reservation
+func confirmed(timeRange model.TimeRange) model.Reservation {
+	reservation := held(timeRange)
+	reservation.Confirmed, reservation.ExpiresAt = true, time.Time{}
+	return reservation
+}
+
+// ids are the IDs of the reservations, in order
+func ids(reservations []model.Reservation) (ids []string) {
+	for _, reservation := range reservations {
+		ids = append(ids, reservation.ID)
+	}
+	return
+}
+
+func TestOrphanedReservations(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		next    = availability(at(120, 180), 1)
+		expired = held(at(90, 120))
+		shorter = at(0, 60)
+		later   = at(60, 120)
+	)
+	next.ID = nextAvailabilityID
+	expired.ExpiresAt = time.Now().Add(-time.Minute)
+	for name, test := range map[string]struct {
+		reservations []model.Reservation
+		// nil deletes the availability
+		replacement *model.TimeRange
+		force       bool
+		want        []model.Reservation
+		conflict    string
+	}{
+		"still covered":         {reservations: []model.Reservation{held(at(0, 30)), confirmed(at(30, 60))}, replacement: &shorter},
+		"held are cancelled":    {reservations: []model.Reservation{held(at(0, 30)), held(at(90, 120))}, replacement: &shorter, want: []model.Reservation{held(at(90, 120))}},
+		"partly uncovered":      {reservations: []model.Reservation{held(at(45, 75))}, replacement: &shorter, want: []model.Reservation{held(at(45, 75))}},
+		"expired holds ignored": {reservations: []model.Reservation{expired}, replacement: &shorter},
+		"confirmed without force": {
+			reservations: []model.Reservation{held(at(60, 90)), confirmed(at(90, 120))},
+			replacement:  &shorter,
+			conflict:     "the change would leave 1 confirmed reservations without availability (" + at(90, 120).Start.String() + "), pass force=true",
+		},
+		"confirmed with force": {
+			reservations: []model.Reservation{held(at(60, 90)), confirmed(at(90, 120))},
+			replacement:  &shorter,
+			force:        true,
+			want:         []model.Reservation{held(at(60, 90)), confirmed(at(90, 120))},
+		},
+		"deleted":                   {reservations: []model.Reservation{held(at(0, 30)), held(at(90, 120))}, want: []model.Reservation{held(at(0, 30)), held(at(90, 120))}},
+		"deleted, confirmed":        {reservations: []model.Reservation{confirmed(at(0, 30))}, conflict: "pass force=true"},
+		"deleted, confirmed, force": {reservations: []model.Reservation{confirmed(at(0, 30))}, force: true, want: []model.Reservation{confirmed(at(0, 30))}},
+		// the rest of it is in the next availability, which is still there
+		"spans into the next availability":   {reservations: []model.Reservation{held(at(105, 135))}, replacement: &later},
+		"spans into the next one, cut short": {reservations: []model.Reservation{held(at(105, 135))}, replacement: &shorter, want: []model.Reservation{held(at(105, 135))}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var (
+				d = &editDao{capacityDao: capacityDao{availabilities: []model.Availability{edited(), next}, reservations: test.reservations}}
+				c = NewController(d, Policy{SlotInterval: 15 * time.Minute}, nil, nil, nil)
+			)
+			orphaned, err := c.orphanedReservations(ctx, d, edited(), test.replacement, test.force)
+			switch {
+			case test.conflict == "" && err != nil:
+				t.Fatal(err)
+			case test.conflict != "" && (!errors.Is(err, ErrConflict) || !strings.Contains(err.Error(), test.conflict)):
+				t.Fatalf("got %v, want a conflict about %q", err, test.conflict)
+			}
+			if !slices.Equal(ids(orphaned), ids(test.want)) {
+				t.Fatalf("orphaned %q, want %q", ids(orphaned), ids(test.want))
+			}
+		})
+	}
+}
+
+// the reservations the change strands are cancelled along with it, or none of it happens
+func TestUpdateAvailability(t *testing.T) {
+	var (
+		ctx = context.Background()
+		end = at(0, 60).End
+	)
+	for name, test := range map[string]struct {
+		reservations []model.Reservation
+		force        bool
+		want         []model.Reservation
+		conflict     string
+	}{
+		"nothing stranded": {reservations: []model.Reservation{held(at(0, 30))}},
+		"held cancelled":   {reservations: []model.Reservation{held(at(0, 30)), held(at(60, 90))}, want: []model.Reservation{held(at(60, 90))}},
+		"confirmed refused": {
+			reservations: []model.Reservation{held(at(60, 90)), confirmed(at(90, 120))},
+			conflict:     "pass force=true",
+		},
+		"confirmed with force": {
+			reservations: []model.Reservation{held(at(60, 90)), confirmed(at(90, 120))},
+			force:        true,
+			want:         []model.Reservation{held(at(60, 90)), confirmed(at(90, 120))},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var (
+				d = &editDao{capacityDao: capacityDao{availabilities: []model.Availability{edited()}, reservations: test.reservations}}
+				c = NewController(d, Policy{SlotInterval: 15 * time.Minute}, nil, nil, nil)
+			)
+			change, err := c.UpdateAvailability(ctx, model.UpdateAvailability{
+				ID:         editedAvailabilityID,
+				ProviderID: capacityProviderID,
+				End:        &end,
+				Force:      test.force,
+			})
+			if test.conflict != "" {
+				if !errors.Is(err, ErrConflict) || !strings.Contains(err.Error(), test.conflict) {
+					t.Fatalf("got %v, want a conflict about %q", err, test.conflict)
+				}
+				if d.updated != nil || d.cancelled != nil || d.events != nil {
+					t.Fatalf("refused, but updated %v, cancelled %q with %d events", d.updated, d.cancelled, len(d.events))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.updated == nil || d.updated.TimeRange != at(0, 60) || change.Availability.TimeRange != at(0, 60) {
+				t.Fatalf("updated to %v, the change has %v", d.updated, change.Availability)
+			}
+			want := ids(test.want)
+			if !slices.Equal(ids(change.Cancelled), want) || !slices.Equal(d.cancelled, want) || !slices.Equal(d.reminders, want) || !slices.Equal(d.settled, want) {
+				t.Fatalf("cancelled %q, %q in the DB with reminders %q and offers %q, want %q", ids(change.Cancelled), d.cancelled, d.reminders, d.settled, want)
+			}
+			for _, reservation := range change.Cancelled {
+				if reservation.CancelledAt.IsZero() {
+					t.Fatalf("%s isn't marked cancelled", reservation.ID)
+				}
+			}
+			// the availability's and one for each cancelled reservation
+			if len(d.events) != 1+len(want) {
+				t.Fatalf("recorded %d events", len(d.events))
+			}
+		})
+	}
+}
+
+func TestDeleteAvailability(t *testing.T) {
+	ctx := context.Background()
+	for name, test := range map[string]struct {
+		reservations []model.Reservation
+		force        bool
+		want         []model.Reservation
+		conflict     string
+	}{
+		"nothing booked":    {},
+		"held cancelled":    {reservations: []model.Reservation{held(at(0, 30)), held(at(60, 90))}, want: []model.Reservation{held(at(0, 30)), held(at(60, 90))}},
+		"confirmed refused": {reservations: []model.Reservation{held(at(0, 30)), confirmed(at(60, 90))}, conflict: "pass force=true"},
+		"confirmed with force": {
+			reservations: []model.Reservation{held(at(0, 30)), confirmed(at(60, 90))},
+			force:        true,
+			want:         []model.Reservation{held(at(0, 30)), confirmed(at(60, 90))},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var (
+				d = &editDao{capacityDao: capacityDao{availabilities: []model.Availability{edited()}, reservations: test.reservations}}
+				c = NewController(d, Policy{SlotInterval: 15 * time.Minute}, nil, nil, nil)
+			)
+			change, err := c.DeleteAvailability(ctx, model.DeleteAvailability{
+				ID:         editedAvailabilityID,
+				ProviderID: capacityProviderID,
+				Force:      test.force,
+			})
+			if test.conflict != "" {
+				if !errors.Is(err, ErrConflict) || !strings.Contains(err.Error(), test.conflict) {
+					t.Fatalf("got %v, want a conflict about %q", err, test.conflict)
+				}
+				if d.deleted != "" || d.cancelled != nil || d.events != nil {
+					t.Fatalf("refused, but deleted %q, cancelled %q with %d events", d.deleted, d.cancelled, len(d.events))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.deleted != editedAvailabilityID {
+				t.Fatalf("deleted %q", d.deleted)
+			}
+			want := ids(test.want)
+			if !slices.Equal(ids(change.Cancelled), want) || !slices.Equal(d.cancelled, want) || !slices.Equal(d.reminders, want) || !slices.Equal(d.settled, want) {
+				t.Fatalf("cancelled %q, %q in the DB with reminders %q and offers %q, want %q", ids(change.Cancelled), d.cancelled, d.reminders, d.settled, want)
+			}
+			if len(d.events) != 1+len(want) {
+				t.Fatalf("recorded %d events", len(d.events))
+			}
+		})
+	}
+
+	// another provider's availability isn't there to delete
+	d := &editDao{capacityDao: capacityDao{availabilities: []model.Availability{edited()}}}
+	_, err := NewController(d, Policy{SlotInterval: 15 * time.Minute}, nil, nil, nil).DeleteAvailability(ctx, model.DeleteAvailability{
+		ID:         editedAvailabilityID,
+		ProviderID: capacityClientID,
+	})
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("got %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,43 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+)
+
+// the kinds of failure callers need to tell apart, check with errors.Is.
+// Anything that isn't one of these is an internal failure
+var (
+	// ErrInvalid is returned when the request itself is malformed or breaks a booking rule
+	ErrInvalid = errors.New("invalid request")
+	// ErrNotFound is returned when the requested entity doesn't exist
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned when the request is valid but clashes with the current state, ex: a double booking
+	ErrConflict = errors.New("conflict")
+)
+
+// kindError tags an error with its kind without changing its message
+type kindError struct {
+	kind error
+	msg  string
+}
+
+func (e kindError) Error() string {
+	return e.msg
+}
+
+func (e kindError) Is(target error) bool {
+	return target == e.kind
+}
+
+func invalidf(format string, args ...any) error {
+	return kindError{kind: ErrInvalid, msg: fmt.Sprintf(format, args...)}
+}
+
+func notFoundf(format string, args ...any) error {
+	return kindError{kind: ErrNotFound, msg: fmt.Sprintf(format, args...)}
+}
+
+func conflictf(format string, args ...any) error {
+	return kindError{kind: ErrConflict, msg: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,68 @@
+package controller
+
+import (
+	"context"
+	"henrymeds-takehome/dao"
+	"henrymeds-takehome/metrics"
+	"henrymeds-takehome/model"
+	"henrymeds-takehome/outbox"
+	"log/slog"
+	"time"
+)
+
+// ExpireReservations records reservation.expired for up to limit holds that ran out without being confirmed.
+// Nothing happens to a hold when it expires, so this has to be called periodically for the event to be published
+func (c *controller) ExpireReservations(ctx context.Context, limit int) (expired int, err error) {
+	now := time.Now()
+	err = c.reservationDao.InTransaction(ctx, func(tx dao.ReservationDao) (err error) {
+		var reservations []model.Reservation
+		reservations, err = tx.MarkExpiriesPublished(ctx, now, limit)
+		if err != nil {
+			return
+		}
+		expired = len(reservations)
+		ids := make([]string, len(reservations))
+		for i, reservation := range reservations {
+			ids[i] = reservation.ID
+		}
+		// an offer the client let run out is theirs to lose, the slot goes to the next in line
+		err = tx.SettleWaitlistOffers(ctx, ids, model.WaitlistExpired)
+		if err != nil {
+			return
+		}
+		return recordReservationEvents(ctx, tx, outbox.ReservationExpired, now, reservations...)
+	})
+	if err != nil {
+		expired = 0
+		return
+	}
+	metrics.Reservations.WithLabelValues(metrics.ReservationExpired).Add(float64(expired))
+	if expired > 0 {
+		slog.InfoContext(ctx, "reservation expiries recorded", "count", expired)
+	}
+	return
+}
+
+// recordReservationEvents writes an event of the type for each of the reservations to the outbox. tx has to be the
+// transaction that made the change, so the events are published if and only if the change commits
+func recordReservationEvents(ctx context.Context, tx dao.ReservationDao, eventType string, at time.Time, reservations ...model.Reservation) (err error) {
+	events := make([]model.Event, len(reservations))
+	for i, reservation := range reservations {
+		events[i], err = outbox.ReservationEvent(eventType, reservation, at)
+		if err != nil {
+			return
+		}
+	}
+	return tx.InsertEvents(ctx, events)
+}
+
+func recordAvailabilityEvents(ctx context.Context, tx dao.ReservationDao, eventType string, at time.Time, availabilities ...model.Availability) (err error) {
+	events := make([]model.Event, len(availabilities))
+	for i, availability := range availabilities {
+		events[i], err = outbox.AvailabilityEvent(eventType, availability, at)
+		if err != nil {
+			return
+		}
+	}
+	return tx.InsertEvents(ctx, events)
+}
@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"henrymeds-takehome/dao"
+	"henrymeds-takehome/metrics"
+	"henrymeds-takehome/model"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// expiryDao has holds that ran out, and fails the commit of the transaction while commitErr is set
+type expiryDao struct {
+	dao.ReservationDao
+
+	expired   []model.Reservation
+	events    []model.Event
+	commitErr error
+}
+
+func (d *expiryDao) InTransaction(ctx context.Context, fn func(dao.ReservationDao) error) error {
+	if err := fn(d); err != nil {
+		return err
+	}
+	return d.commitErr
+}
+
+func (d *expiryDao) MarkExpiriesPublished(ctx context.Context, now time.Time, limit int) ([]model.Reservation, error) {
+	return d.expired, nil
+}
+
+func (d *expiryDao) SettleWaitlistOffers(ctx context.Context, reservationIDs []string, status string) error {
+	return nil
+}
+
+func (d *expiryDao) InsertEvents(ctx context.Context, events []model.Event) error {
+	d.events = append(d.events, events...)
+	return nil
+}
+
+func TestExpireReservationsCountsCommittedExpiries(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		counter = metrics.Reservations.WithLabelValues(metrics.ReservationExpired)
+		d       = &expiryDao{commitErr: errors.New("the connection went away")}
+		c       = NewController(d, Policy{}, nil, nil, nil)
+	)
+	for i := 0; i < 2; i++ {
+		d.expired = append(d.expired, model.Reservation{ID: uuid.NewString(), ExpiresAt: time.Now().Add(-time.Minute)})
+	}
+
+	before := testutil.ToFloat64(counter)
+	if _, err := c.ExpireReservations(ctx, 10); err == nil {
+		t.Fatal("the failed commit went unreported")
+	}
+	if got := testutil.ToFloat64(counter) - before; got != 0 {
+		t.Fatalf("counted %v expiries that rolled back", got)
+	}
+
+	d.commitErr = nil
+	expired, err := c.ExpireReservations(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := testutil.ToFloat64(counter) - before; expired != 2 || got != 2 {
+		t.Fatalf("expired %d and counted %v, want 2 of each", expired, got)
+	}
+}
@@ -0,0 +1,226 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"henrymeds-takehome/dao"
+	"henrymeds-takehome/model"
+	"henrymeds-takehome/outbox"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// a year of a provider's shifts is a few hundred rows, this leaves plenty of room while keeping one import's
+// transaction short
+const maxImportRows = 5000
+
+// ImportAvailabilities validates every row and checks them for overlaps with each other and with what the provider
+// already has. The rows are inserted together in one transaction, only if none of them were rejected and it's not
+// a dry run. Rejected rows are reported, not returned as an error, so the caller can fix them all in one go
+func (c *controller) ImportAvailabilities(ctx context.Context, request model.ImportAvailabilities) (report model.ImportReport, err error) {
+	if _, err = uuid.Parse(request.ProviderID); err != nil {
+		err = invalidf("invalid UUID provided")
+		return
+	}
+	if len(request.Rows) == 0 {
+		err = invalidf("there are no rows to import")
+		return
+	}
+	if len(request.Rows) > maxImportRows {
+		err = invalidf("at most %d rows can be imported at once, got %d", maxImportRows, len(request.Rows))
+		return
+	}
+
+	report = model.ImportReport{
+		DryRun:  request.DryRun,
+		Results: make([]model.ImportResult, len(request.Rows)),
+	}
+	for i, row := range request.Rows {
+		result := model.ImportResult{
+			Row:        row.Row,
+			Status:     model.ImportRowValid,
+			TimeRange:  row.TimeRange,
+			Capacity:   max(row.Capacity, 1),
+			LocationID: row.LocationID,
+		}
+		var validationErr error
+		if row.ParseError != "" {
+			result.Status = model.ImportRowInvalid
+			result.Error = row.ParseError
+		} else if validationErr = c.validateCreateAvailability(model.CreateAvailabilities{
+			ProviderID: request.ProviderID,
+			TimeRange:  row.TimeRange,
+			Capacity:   row.Capacity,
+		}); validationErr == nil {
+			result.Modalities, validationErr = normalizeModalities(row.Modalities, row.LocationID)
+		}
+		if validationErr != nil {
+			result.Status = model.ImportRowInvalid
+			result.Error = validationErr.Error()
+		}
+		report.Results[i] = result
+	}
+	markBatchOverlaps(report.Results)
+
+	err = c.reservationDao.InTransaction(ctx, func(tx dao.ReservationDao) (err error) {
+		// the provider stays locked until commit, so nothing can sneak in between the overlap check and the insert
+		err = tx.LockUser(ctx, request.ProviderID)
+		if errors.Is(err, dao.ErrNotFound) {
+			return notFoundf("no provider with that ID")
+		}
+		if err != nil {
+			return
+		}
+		err = markExistingOverlaps(ctx, tx, request.ProviderID, report.Results)
+		if err != nil {
+			return
+		}
+		err = markUnknownLocations(ctx, tx, report.Results)
+		if err != nil || request.DryRun || report.Rejected() > 0 {
+			return
+		}
+
+		var availabilities, inserted []model.Availability
+		for _, result := range report.Results {
+			availabilities = append(availabilities, model.Availability{
+				ProviderID: request.ProviderID,
+				TimeRange:  result.TimeRange,
+				Capacity:   result.Capacity,
+				Modalities: result.Modalities,
+				LocationID: result.LocationID,
+			})
+		}
+		inserted, err = tx.InsertAvailabilities(ctx, availabilities)
+		if errors.Is(err, dao.ErrConflict) {
+			err = conflictf("requested availability overlaps with existing availability")
+		}
+		if err != nil {
+			return
+		}
+		err = recordAvailabilityEvents(ctx, tx, outbox.AvailabilityCreated, time.Now(), inserted...)
+		if err != nil {
+			return
+		}
+		for i := range report.Results {
+			report.Results[i].Status = model.ImportRowCreated
+			report.Results[i].AvailabilityID = inserted[i].ID
+		}
+		report.Committed = true
+		return
+	})
+	if err != nil {
+		report = model.ImportReport{}
+		return
+	}
+
+	slog.InfoContext(ctx, "availabilities imported",
+		"provider_id", request.ProviderID,
+		"rows", len(report.Results),
+		"rejected", report.Rejected(),
+		"dry_run", report.DryRun,
+		"committed", report.Committed,
+	)
+	return
+}
+
+// markUnknownLocations rejects the valid rows whose location doesn't exist, or is in another organization
+func markUnknownLocations(ctx context.Context, tx dao.ReservationDao, results []model.ImportResult) (err error) {
+	known := map[string]bool{}
+	for i, result := range results {
+		if result.Status != model.ImportRowValid || result.LocationID == "" {
+			continue
+		}
+		found, looked := known[result.LocationID]
+		if !looked {
+			var locations []model.Location
+			locations, err = tx.GetLocations(ctx, result.LocationID)
+			if err != nil {
+				return
+			}
+			found = len(locations) > 0
+			known[result.LocationID] = found
+		}
+		if !found {
+			results[i].Status = model.ImportRowInvalid
+			results[i].Error = "no location with that ID"
+		}
+	}
+	return
+}
+
+// markBatchOverlaps rejects the valid rows that overlap another row of the same import. Sorted by start, a row
+// overlaps an earlier one exactly when it starts before the latest end seen so far
+func markBatchOverlaps(results []model.ImportResult) {
+	var valid []int
+	for i, result := range results {
+		if result.Status == model.ImportRowValid {
+			valid = append(valid, i)
+		}
+	}
+	sort.SliceStable(valid, func(a, b int) bool {
+		return results[valid[a]].Start.Before(results[valid[b]].Start)
+	})
+
+	latest := -1
+	for _, i := range valid {
+		if latest >= 0 && results[i].Start.Before(results[latest].End) {
+			results[i].Status = model.ImportRowConflict
+			results[i].Error = fmt.Sprintf("overlaps row %d", results[latest].Row)
+			if results[latest].Status == model.ImportRowValid {
+				results[latest].Status = model.ImportRowConflict
+				results[latest].Error = fmt.Sprintf("overlaps row %d", results[i].Row)
+			}
+		}
+		if latest < 0 || results[i].End.After(results[latest].End) {
+			latest = i
+		}
+	}
+}
+
+// markExistingOverlaps rejects the valid rows that overlap an availability the provider already has
+func markExistingOverlaps(ctx context.Context, tx dao.ReservationDao, providerID string, results []model.ImportResult) (err error) {
+	var (
+		span     model.TimeRange
+		existing []model.Availability
+	)
+	for _, result := range results {
+		if result.Status != model.ImportRowValid {
+			continue
+		}
+		if span.Start.IsZero() || result.Start.Before(span.Start) {
+			span.Start = result.Start
+		}
+		if result.End.After(span.End) {
+			span.End = result.End
+		}
+	}
+	if span.Start.IsZero() {
+		return
+	}
+
+	existing, err = tx.GetAvailabilities(ctx, model.GetAvailabilities{
+		ProviderID: providerID,
+		TimeRange:  span,
+	})
+	if err != nil {
+		return
+	}
+	for i, result := range results {
+		if result.Status != model.ImportRowValid {
+			continue
+		}
+		for _, availability := range existing {
+			if result.Start.Before(availability.End) && availability.Start.Before(result.End) {
+				results[i].Status = model.ImportRowConflict
+				results[i].Error = fmt.Sprintf("overlaps existing availability %s (%s - %s)",
+					availability.ID, availability.Start.Format(time.RFC3339), availability.End.Format(time.RFC3339))
+				break
+			}
+		}
+	}
+	return
+}
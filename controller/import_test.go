@@ -0,0 +1,162 @@
+package controller
+
+import (
+	"context"
+	"henrymeds-takehome/dao"
+	"henrymeds-takehome/model"
+	"strings"
+	"testing"
+	"time"
+)
+
+const importLocationID = "00000000-0000-4000-8000-0000000000f1"
+
+// importDao has the provider's availabilities and one location, and keeps what the import inserted
+type importDao struct {
+	capacityDao
+
+	inserted []model.Availability
+	events   []model.Event
+}
+
+func (d *importDao) InTransaction(ctx context.Context, fn func(dao.ReservationDao) error) error {
+	return fn(d)
+}
+
+func (d *importDao) GetLocations(ctx context.Context, id string) ([]model.Location, error) {
+	if id != importLocationID {
+		return nil, nil
+	}
+	return []model.Location{{ID: id}}, nil
+}
+
+func (d *importDao) InsertAvailabilities(ctx context.Context, availabilities []model.Availability) ([]model.Availability, error) {
+	for i := range availabilities {
+		availabilities[i].ID = availabilities[i].Start.String()
+	}
+	d.inserted = append(d.inserted, availabilities...)
+	return availabilities, nil
+}
+
+func (d *importDao) InsertEvents(ctx context.Context, events []model.Event) error {
+	d.events = append(d.events, events...)
+	return nil
+}
+
+// row is a row read from a file, its position in it is the next number
+func row(rows []model.ImportRow, timeRange model.TimeRange) []model.ImportRow {
+	return append(rows, model.ImportRow{Row: len(rows) + 1, TimeRange: timeRange})
+}
+
+func TestImportAvailabilities(t *testing.T) {
+	var (
+		ctx      = context.Background()
+		existing = availability(at(240, 300), 1)
+		past     = model.TimeRange{Start: nine.AddDate(0, 0, -14), End: nine.AddDate(0, 0, -14).Add(time.Hour)}
+	)
+	existing.ID = "existing"
+	type result struct {
+		status string
+		err    string
+	}
+	for name, test := range map[string]struct {
+		rows   []model.ImportRow
+		dryRun bool
+		want   []result
+	}{
+		"created": {
+			rows: append(row(row(nil, at(0, 60)), at(60, 120)), model.ImportRow{Row: 3, TimeRange: at(120, 180), Capacity: 3, Modalities: []string{model.ModalityInPerson}, LocationID: importLocationID}),
+			want: []result{{status: model.ImportRowCreated}, {status: model.ImportRowCreated}, {status: model.ImportRowCreated}},
+		},
+		"dry run": {
+			rows:   row(row(nil, at(0, 60)), at(60, 120)),
+			dryRun: true,
+			want:   []result{{status: model.ImportRowValid}, {status: model.ImportRowValid}},
+		},
+		// every row is checked, one bad row keeps the good ones out too
+		"invalid rows": {
+			rows: []model.ImportRow{
+				{Row: 1, TimeRange: at(0, 60)},
+				{Row: 2, ParseError: `invalid start "tomorrow", please use RFC3339`},
+				{Row: 3, TimeRange: at(120, 60)},
+				{Row: 4, TimeRange: at(125, 180)},
+				{Row: 5, TimeRange: past},
+				{Row: 6, TimeRange: at(180, 240), Modalities: []string{"carrier pigeon"}},
+				{Row: 7, TimeRange: at(300, 360), Modalities: []string{model.ModalityInPerson}},
+				{Row: 8, TimeRange: at(360, 420), Modalities: []string{model.ModalityInPerson}, LocationID: "00000000-0000-4000-8000-0000000000f9"},
+			},
+			want: []result{
+				{status: model.ImportRowValid},
+				{status: model.ImportRowInvalid, err: `invalid start "tomorrow"`},
+				{status: model.ImportRowInvalid, err: "start time must be before end time"},
+				{status: model.ImportRowInvalid, err: "start time must be on a 15m0s boundary"},
+				{status: model.ImportRowInvalid, err: "start time must be in the future"},
+				{status: model.ImportRowInvalid, err: "modality must be one of"},
+				{status: model.ImportRowInvalid, err: "in_person visits need a location"},
+				{status: model.ImportRowInvalid, err: "no location with that ID"},
+			},
+		},
+		"overlapping rows": {
+			rows: row(row(row(nil, at(30, 90)), at(0, 60)), at(120, 180)),
+			want: []result{
+				{status: model.ImportRowConflict, err: "overlaps row 2"},
+				{status: model.ImportRowConflict, err: "overlaps row 1"},
+				{status: model.ImportRowValid},
+			},
+		},
+		// the later rows are inside the first, each of them overlaps it even though they don't overlap each other
+		"rows inside another": {
+			rows: row(row(row(nil, at(0, 120)), at(30, 60)), at(60, 90)),
+			want: []result{
+				{status: model.ImportRowConflict, err: "overlaps row 2"},
+				{status: model.ImportRowConflict, err: "overlaps row 1"},
+				{status: model.ImportRowConflict, err: "overlaps row 1"},
+			},
+		},
+		// back to back isn't an overlap, with other rows or with what's there
+		"overlapping what's there": {
+			rows: row(row(row(nil, at(180, 240)), at(270, 300)), at(300, 360)),
+			want: []result{
+				{status: model.ImportRowValid},
+				{status: model.ImportRowConflict, err: "overlaps existing availability existing (" + at(240, 300).Start.Format(time.RFC3339)},
+				{status: model.ImportRowValid},
+			},
+		},
+		"dry run of rejected rows": {
+			rows:   row(row(nil, at(0, 60)), at(240, 300)),
+			dryRun: true,
+			want:   []result{{status: model.ImportRowValid}, {status: model.ImportRowConflict, err: "overlaps existing availability existing"}},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var (
+				d = &importDao{capacityDao: capacityDao{availabilities: []model.Availability{existing}}}
+				c = NewController(d, Policy{SlotInterval: 15 * time.Minute}, nil, nil, nil)
+			)
+			report, err := c.ImportAvailabilities(ctx, model.ImportAvailabilities{ProviderID: capacityProviderID, Rows: test.rows, DryRun: test.dryRun})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(report.Results) != len(test.want) {
+				t.Fatalf("got %d results, want %d", len(report.Results), len(test.want))
+			}
+			created := 0
+			for i, want := range test.want {
+				got := report.Results[i]
+				if got.Row != test.rows[i].Row || got.Status != want.status || (want.err == "") != (got.Error == "") || !strings.Contains(got.Error, want.err) {
+					t.Errorf("row %d is %s: %q, want %s: %q", got.Row, got.Status, got.Error, want.status, want.err)
+				}
+				if want.status == model.ImportRowCreated {
+					created++
+					if got.AvailabilityID == "" || d.inserted[i].TimeRange != test.rows[i].TimeRange || d.inserted[i].ProviderID != capacityProviderID {
+						t.Errorf("row %d was inserted as %+v", got.Row, d.inserted[i])
+					}
+				}
+			}
+			// all of them or none, and nothing at all for a dry run
+			if report.DryRun != test.dryRun || report.Committed != (created > 0) || len(d.inserted) != created || len(d.events) != created {
+				t.Fatalf("committed %v with %d of %d rows inserted and %d events", report.Committed, len(d.inserted), len(test.rows), len(d.events))
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"henrymeds-takehome/dao"
+	"henrymeds-takehome/logging"
+	"henrymeds-takehome/metrics"
+	"henrymeds-takehome/model"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// the width of the column
+const maxLicenseNumberLength = 50
+
+// the states, DC and the territories a provider can be licensed in and a client located in
+var states = map[string]bool{
+	"AL": true, "AK": true, "AZ": true, "AR": true, "CA": true, "CO": true, "CT": true, "DE": true, "FL": true,
+	"GA": true, "HI": true, "ID": true, "IL": true, "IN": true, "IA": true, "KS": true, "KY": true, "LA": true,
+	"ME": true, "MD": true, "MA": true, "MI": true, "MN": true, "MS": true, "MO": true, "MT": true, "NE": true,
+	"NV": true, "NH": true, "NJ": true, "NM": true, "NY": true, "NC": true, "ND": true, "OH": true, "OK": true,
+	"OR": true, "PA": true, "RI": true, "SC": true, "SD": true, "TN": true, "TX": true, "UT": true, "VT": true,
+	"VA": true, "WA": true, "WV": true, "WI": true, "WY": true, "DC": true, "AS": true, "GU": true, "MP": true,
+	"PR": true, "VI": true,
+}
+
+// UpdateUser records where the user is located, an empty state clears it
+func (c *controller) UpdateUser(ctx context.Context, request model.UpdateUser) (user model.User, err error) {
+	if _, err = uuid.Parse(request.ID); err != nil {
+		err = invalidf("invalid UUID provided")
+		return
+	}
+	request.State = strings.ToUpper(strings.TrimSpace(request.State))
+	if request.State != "" && !states[request.State] {
+		err = invalidf("state must be a two letter US state code")
+		return
+	}
+
+	err = c.reservationDao.SetUserState(ctx, request.ID, request.State)
+	if errors.Is(err, dao.ErrNotFound) {
+		err = notFoundf("no user with that ID")
+	}
+	if err != nil {
+		return
+	}
+	slog.InfoContext(ctx, "user updated", "user_id", logging.Patient(request.ID), "state", logging.Patient(request.State))
+	return c.GetUser(ctx, request.ID)
+}
+
+func (c *controller) GetProviderLicenses(ctx context.Context, providerID string) (licenses []model.ProviderLicense, err error) {
+	if _, err = uuid.Parse(providerID); err != nil {
+		err = invalidf("invalid UUID provided")
+		return
+	}
+	return c.reservationDao.GetProviderLicenses(ctx, providerID)
+}
+
+// PutProviderLicense adds the provider's license for the state, or renews the one they have
+func (c *controller) PutProviderLicense(ctx context.Context, license model.ProviderLicense) (stored model.ProviderLicense, err error) {
+	if _, err = uuid.Parse(license.ProviderID); err != nil {
+		err = invalidf("invalid UUID provided")
+		return
+	}
+	license.State = strings.ToUpper(license.State)
+	license.LicenseNumber = strings.TrimSpace(license.LicenseNumber)
+	if !states[license.State] {
+		err = invalidf("state must be a two letter US state code")
+		return
+	} else if license.LicenseNumber == "" {
+		err = invalidf("license number must be set")
+		return
+	} else if len(license.LicenseNumber) > maxLicenseNumberLength {
+		err = invalidf("license number must be at most %d characters", maxLicenseNumberLength)
+		return
+	} else if license.ExpiresOn.IsZero() {
+		err = invalidf("expiry date must be set")
+		return
+	}
+	license.CreatedAt = time.Now()
+
+	stored, err = c.reservationDao.UpsertProviderLicense(ctx, license)
+	if errors.Is(err, dao.ErrNotFound) {
+		err = notFoundf("no provider with that ID")
+	}
+	if err != nil {
+		return
+	}
+	slog.InfoContext(ctx, "provider license stored", "provider_id", stored.ProviderID, "state", stored.State, "expires_on", stored.ExpiresOn.Format(time.DateOnly))
+	return
+}
+
+func (c *controller) DeleteProviderLicense(ctx context.Context, providerID string, state string) (err error) {
+	if _, err = uuid.Parse(providerID); err != nil {
+		return invalidf("invalid UUID provided")
+	}
+	state = strings.ToUpper(state)
+	if !states[state] {
+		return invalidf("state must be a two letter US state code")
+	}
+	err = c.reservationDao.DeleteProviderLicense(ctx, providerID, state)
+	if errors.Is(err, dao.ErrNotFound) {
+		err = notFoundf("the provider has no license for that state")
+	}
+	if err != nil {
+		return
+	}
+	slog.InfoContext(ctx, "provider license deleted", "provider_id", providerID, "state", state)
+	return
+}
+
+// checkEligibility makes sure the provider may see the client for the time range, they need a license for the state
+// the client is located in that's still valid when the appointment ends
+func checkEligibility(ctx context.Context, reader dao.ReservationDao, clientID string, providerID string, timeRange model.TimeRange) (err error) {
+	var (
+		client   model.User
+		licenses []model.ProviderLicense
+	)
+	client, err = reader.GetUser(ctx, clientID)
+	if errors.Is(err, dao.ErrNotFound) {
+		return notFoundf("no client with that ID")
+	}
+	if err != nil {
+		return
+	}
+	licenses, err = reader.GetProviderLicenses(ctx, providerID)
+	if err != nil {
+		return
+	}
+	err = eligible(client, licenses, timeRange)
+	if err != nil {
+		metrics.Reservations.WithLabelValues(metrics.ReservationIneligible).Inc()
+	}
+	return
+}
+
+// eligible is checkEligibility once the client and the provider's licenses are looked up
+func eligible(client model.User, licenses []model.ProviderLicense, timeRange model.TimeRange) error {
+	if client.State == "" {
+		return conflictf("the client's state isn't on file, it's needed to match them with a licensed provider")
+	}
+	for _, license := range licenses {
+		if license.State != client.State {
+			continue
+		}
+		if !license.ValidUntil(timeRange.End) {
+			return conflictf("the provider's %s license expires on %s, before the appointment", license.State, license.ExpiresOn.Format(time.DateOnly))
+		}
+		return nil
+	}
+	return conflictf("the provider isn't licensed in %s, where the client is located", client.State)
+}
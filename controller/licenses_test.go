@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"henrymeds-takehome/dao"
+	"henrymeds-takehome/model"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEligible(t *testing.T) {
+	var (
+		// the appointment ends at 23:30 UTC on the 1st
+		appointment = model.TimeRange{
+			Start: time.Date(2026, 11, 1, 23, 0, 0, 0, time.UTC),
+			End:   time.Date(2026, 11, 1, 23, 30, 0, 0, time.UTC),
+		}
+		license = func(state string, expiresOn string) model.ProviderLicense {
+			day, err := time.Parse(time.DateOnly, expiresOn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return model.ProviderLicense{State: state, LicenseNumber: state + "-1", ExpiresOn: day}
+		}
+	)
+	for name, test := range map[string]struct {
+		client   model.User
+		licenses []model.ProviderLicense
+		want     string
+	}{
+		"licensed":                      {client: model.User{State: "CA"}, licenses: []model.ProviderLicense{license("NY", "2027-01-01"), license("CA", "2027-01-01")}},
+		"license lapses that day":       {client: model.User{State: "CA"}, licenses: []model.ProviderLicense{license("CA", "2026-11-01")}},
+		"license lapsed the day before": {client: model.User{State: "CA"}, licenses: []model.ProviderLicense{license("CA", "2026-10-31")}, want: "the provider's CA license expires on 2026-10-31, before the appointment"},
+		"no licenses":                   {client: model.User{State: "CA"}, want: "the provider isn't licensed in CA"},
+		"licensed elsewhere":            {client: model.User{State: "CA"}, licenses: []model.ProviderLicense{license("NY", "2027-01-01"), license("TX", "2027-01-01")}, want: "the provider isn't licensed in CA"},
+		"client without a state":        {licenses: []model.ProviderLicense{license("CA", "2027-01-01")}, want: "the client's state isn't on file"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := eligible(test.client, test.licenses, appointment)
+			switch {
+			case test.want == "" && err != nil:
+				t.Fatal(err)
+			case test.want != "" && (!errors.Is(err, ErrConflict) || !strings.Contains(err.Error(), test.want)):
+				t.Fatalf("got %v, want a conflict about %q", err, test.want)
+			}
+		})
+	}
+}
+
+// eligibilityDao only has the capacity tests' client
+type eligibilityDao struct {
+	capacityDao
+}
+
+func (d *eligibilityDao) GetUser(ctx context.Context, id string) (model.User, error) {
+	if id != capacityClientID {
+		return model.User{}, dao.ErrNotFound
+	}
+	return d.capacityDao.GetUser(ctx, id)
+}
+
+// the check looks the client and the licenses up, a client that isn't there isn't eligible
+func TestCheckEligibility(t *testing.T) {
+	var (
+		ctx = context.Background()
+		d   = &eligibilityDao{}
+	)
+	if err := checkEligibility(ctx, d, capacityClientID, capacityProviderID, at(0, 30)); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkEligibility(ctx, d, "00000000-0000-4000-8000-0000000000d9", capacityProviderID, at(0, 30)); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("got %v for a missing client, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,177 @@
+package controller
+
+import (
+	"context"
+	"henrymeds-takehome/model"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// the column sizes of the locations table
+const (
+	maxLocationNameLength    = 100
+	maxLocationAddressLength = 500
+)
+
+func (c *controller) CreateLocation(ctx context.Context, request model.CreateLocation) (location model.Location, err error) {
+	request.Name = strings.TrimSpace(request.Name)
+	request.Address = strings.TrimSpace(request.Address)
+	switch {
+	case request.Name == "":
+		err = invalidf("name is required")
+	case utf8.RuneCountInString(request.Name) > maxLocationNameLength:
+		err = invalidf("name must be at most %d characters", maxLocationNameLength)
+	case request.Address == "":
+		err = invalidf("address is required")
+	case utf8.RuneCountInString(request.Address) > maxLocationAddressLength:
+		err = invalidf("address must be at most %d characters", maxLocationAddressLength)
+	}
+	if err != nil {
+		return
+	}
+	// "Local" and "" load too, but they mean whatever the server runs in
+	if _, zoneErr := time.LoadLocation(request.TimeZone); zoneErr != nil || request.TimeZone == "" || request.TimeZone == "Local" {
+		err = invalidf("time zone must be an IANA time zone, ex: America/Los_Angeles")
+		return
+	}
+
+	location, err = c.reservationDao.InsertLocation(ctx, model.Location{
+		Name:      request.Name,
+		Address:   request.Address,
+		TimeZone:  request.TimeZone,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return
+	}
+	slog.InfoContext(ctx, "location created", "location_id", location.ID, "organization_id", location.OrganizationID)
+	return
+}
+
+func (c *controller) GetLocations(ctx context.Context) (locations []model.Location, err error) {
+	return c.reservationDao.GetLocations(ctx, "")
+}
+
+func (c *controller) GetLocation(ctx context.Context, id string) (location model.Location, err error) {
+	var locations []model.Location
+
+	// validate the UUID, don't want strings going directly to the DB
+	if _, err = uuid.Parse(id); err != nil {
+		err = invalidf("invalid UUID provided")
+		return
+	}
+	locations, err = c.reservationDao.GetLocations(ctx, id)
+	if err != nil {
+		return
+	}
+	if len(locations) == 0 {
+		err = notFoundf("no location with that ID")
+		return
+	}
+	return locations[0], nil
+}
+
+// normalizeModalities checks the modalities of an availability, none means video and phone. They're kept in the order
+// model.Modalities lists them, the first one is what a booking that doesn't pick gets. In-person visits need a
+// location, and a location is only for them
+func normalizeModalities(modalities []string, locationID string) (normalized []string, err error) {
+	if len(modalities) == 0 {
+		modalities = []string{model.ModalityVideo, model.ModalityPhone}
+	}
+	for _, modality := range modalities {
+		if !slices.Contains(model.Modalities, modality) {
+			return nil, invalidf("modality must be one of %s", strings.Join(model.Modalities, ", "))
+		}
+	}
+	for _, modality := range model.Modalities {
+		if slices.Contains(modalities, modality) {
+			normalized = append(normalized, modality)
+		}
+	}
+	inPerson := slices.Contains(normalized, model.ModalityInPerson)
+	switch {
+	case inPerson && locationID == "":
+		err = invalidf("in_person visits need a location")
+	case !inPerson && locationID != "":
+		err = invalidf("a location is only for in_person visits")
+	case locationID != "":
+		// validate the UUID, don't want strings going directly to the DB
+		if _, parseErr := uuid.Parse(locationID); parseErr != nil {
+			err = invalidf("invalid UUID provided")
+		}
+	}
+	return
+}
+
+// allowedModalities are the modalities the appointment type can be booked as, all of them for types the policy
+// doesn't restrict and for no type
+func (c *controller) allowedModalities(appointmentType string) []string {
+	if allowed, ok := c.policy.AppointmentModalities[appointmentType]; ok {
+		return allowed
+	}
+	return model.Modalities
+}
+
+// validateModality checks a modality the client asked for, empty is fine and leaves the pick to the availability
+func (c *controller) validateModality(modality string, appointmentType string) (err error) {
+	switch {
+	case modality == "":
+	case !slices.Contains(model.Modalities, modality):
+		err = invalidf("modality must be one of %s", strings.Join(model.Modalities, ", "))
+	case !slices.Contains(c.allowedModalities(appointmentType), modality):
+		err = invalidf("the %s appointment type can't be booked as %s, only as %s", appointmentType, modality, strings.Join(c.allowedModalities(appointmentType), ", "))
+	}
+	return
+}
+
+// bookingOption is one way a visit can happen, with the availabilities it can happen during
+type bookingOption struct {
+	modality       string
+	locationID     string
+	availabilities []model.Availability
+}
+
+// bookingOptions splits the availabilities by how visits can happen during them, in the order of modalities. In-person
+// visits make an option per location, locationID keeps only the one
+func bookingOptions(availabilities []model.Availability, modalities []string, locationID string) (options []bookingOption) {
+	for _, modality := range modalities {
+		if modality != model.ModalityInPerson {
+			if locationID == "" {
+				if offered := offering(availabilities, modality, ""); len(offered) > 0 {
+					options = append(options, bookingOption{modality: modality, availabilities: offered})
+				}
+			}
+			continue
+		}
+		var locations []string
+		for _, availability := range availabilities {
+			if availability.Offers(model.ModalityInPerson) && !slices.Contains(locations, availability.LocationID) &&
+				(locationID == "" || availability.LocationID == locationID) {
+				locations = append(locations, availability.LocationID)
+			}
+		}
+		for _, location := range locations {
+			options = append(options, bookingOption{
+				modality:       modality,
+				locationID:     location,
+				availabilities: offering(availabilities, modality, location),
+			})
+		}
+	}
+	return
+}
+
+// offering keeps the availabilities a visit of the modality can happen during, in_person ones at the location
+func offering(availabilities []model.Availability, modality string, locationID string) (offered []model.Availability) {
+	for _, availability := range availabilities {
+		if availability.Offers(modality) && (modality != model.ModalityInPerson || availability.LocationID == locationID) {
+			offered = append(offered, availability)
+		}
+	}
+	return
+}
@@ -0,0 +1,102 @@
+package controller
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"henrymeds-takehome/dao"
+	"henrymeds-takehome/model"
+	"henrymeds-takehome/tenant"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// 256 bits, like the calendar tokens
+	organizationKeyBytes = 32
+	// the width of the column
+	maxOrganizationNameLength = 100
+)
+
+func (c *controller) CreateOrganization(ctx context.Context, request model.CreateOrganization) (organization model.Organization, apiKey string, err error) {
+	name := strings.TrimSpace(request.Name)
+	if name == "" {
+		err = invalidf("name must be set")
+		return
+	} else if len(name) > maxOrganizationNameLength {
+		err = invalidf("name must be at most %d characters", maxOrganizationNameLength)
+		return
+	}
+	apiKey, err = newOrganizationKey()
+	if err != nil {
+		return
+	}
+
+	organization, err = c.reservationDao.InsertOrganization(ctx, model.Organization{
+		Name:       name,
+		APIKeyHash: tenant.HashKey(apiKey),
+		CreatedAt:  time.Now(),
+	})
+	if err != nil {
+		apiKey = ""
+		return
+	}
+	slog.InfoContext(ctx, "organization created", "organization_id", organization.ID)
+	return
+}
+
+func (c *controller) GetOrganizations(ctx context.Context) (organizations []model.Organization, err error) {
+	return c.reservationDao.GetOrganizations(ctx, "")
+}
+
+func (c *controller) GetOrganization(ctx context.Context, id string) (organization model.Organization, err error) {
+	var organizations []model.Organization
+
+	// validate the UUID, don't want strings going directly to the DB
+	if _, err = uuid.Parse(id); err != nil {
+		err = invalidf("invalid UUID provided")
+		return
+	}
+	organizations, err = c.reservationDao.GetOrganizations(ctx, id)
+	if err != nil {
+		return
+	}
+	if len(organizations) == 0 {
+		err = notFoundf("no organization with that ID")
+		return
+	}
+	return organizations[0], nil
+}
+
+func (c *controller) RotateOrganizationKey(ctx context.Context, id string) (apiKey string, err error) {
+	if _, err = uuid.Parse(id); err != nil {
+		err = invalidf("invalid UUID provided")
+		return
+	}
+	apiKey, err = newOrganizationKey()
+	if err != nil {
+		return
+	}
+	err = c.reservationDao.SetOrganizationKey(ctx, id, tenant.HashKey(apiKey))
+	if errors.Is(err, dao.ErrNotFound) {
+		err = notFoundf("no organization with that ID")
+	}
+	if err != nil {
+		apiKey = ""
+		return
+	}
+	slog.InfoContext(ctx, "organization key rotated", "organization_id", id)
+	return
+}
+
+func newOrganizationKey() (string, error) {
+	raw := make([]byte, organizationKeyBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
@@ -0,0 +1,33 @@
+package controller
+
+import (
+	"context"
+	"henrymeds-takehome/dao"
+	"henrymeds-takehome/model"
+	"time"
+)
+
+// scheduleReminders replaces the reservation's pending reminders with one for every lead in the policy, so calling it
+// again after a reservation moved moves its reminders too. Leads that are already past when the reservation is
+// confirmed are left out, a reminder a day ahead makes no sense an hour before the appointment
+func (c *controller) scheduleReminders(ctx context.Context, tx dao.ReservationDao, reservation model.Reservation) error {
+	var (
+		now       = time.Now()
+		reminders []model.Reminder
+	)
+	for _, lead := range c.policy.ReminderLeads {
+		dueAt := reservation.Start.Add(-lead)
+		if !dueAt.After(now) {
+			continue
+		}
+		reminders = append(reminders, model.Reminder{
+			ReservationID: reservation.ID,
+			LeadSeconds:   int(lead / time.Second),
+			DueAt:         dueAt,
+			Status:        model.ReminderPending,
+			NextAttemptAt: dueAt,
+			CreatedAt:     now,
+		})
+	}
+	return tx.ReplaceReminders(ctx, reservation.ID, reminders)
+}
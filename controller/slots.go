@@ -0,0 +1,192 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"henrymeds-takehome/dao"
+	"henrymeds-takehome/model"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// the widest range slots are listed for at once, a month of 15 minute slots is already a few thousand of them
+const maxSlotRange = 31 * 24 * time.Hour
+
+// GetSlots lists the slots of the provider inside the time range that are open for booking, with the seats each has
+// left. Slots start on every slot interval from the lead time on and are as long as the appointment type. Full slots
+// are listed with no seats remaining, busy time and time without availability are left out. With a client set, slots
+// the provider isn't licensed to see the client for are left out too, all of them when the provider has no license
+// for the client's state. Each slot lists the modalities it can be booked as, only the ones the appointment type
+// allows, and the request can keep to one modality or location
+func (c *controller) GetSlots(ctx context.Context, request model.GetSlots) (slots []model.Slot, err error) {
+	var (
+		availabilities []model.Availability
+		blocks         []model.BusyBlock
+		reservations   []model.Reservation
+		busy           []model.TimeRange
+		client         model.User
+		licenses       []model.ProviderLicense
+		now            = time.Now()
+	)
+
+	length, err := c.validateGetSlots(request)
+	if err != nil {
+		return
+	}
+	if request.ClientID != "" {
+		client, err = c.reservationDao.GetUser(ctx, request.ClientID)
+		if errors.Is(err, dao.ErrNotFound) {
+			err = notFoundf("no client with that ID")
+		}
+		if err != nil {
+			return
+		}
+		licenses, err = c.reservationDao.GetProviderLicenses(ctx, request.ProviderID)
+		if err != nil {
+			return
+		}
+	}
+	availabilities, err = c.reservationDao.GetAvailabilities(ctx, model.GetAvailabilities{
+		ProviderID: request.ProviderID,
+		TimeRange:  request.TimeRange,
+	})
+	if err != nil || len(availabilities) == 0 {
+		return
+	}
+	modalities := c.allowedModalities(request.AppointmentType)
+	if request.Modality != "" {
+		modalities = []string{request.Modality}
+	}
+	options := bookingOptions(availabilities, modalities, request.LocationID)
+	if len(options) == 0 {
+		return
+	}
+	blocks, err = c.reservationDao.GetBusyBlocks(ctx, model.GetBusyBlocks{ProviderID: request.ProviderID, TimeRange: request.TimeRange})
+	if err != nil {
+		return
+	}
+	for _, block := range blocks {
+		busy = append(busy, block.TimeRange)
+	}
+	reservations, err = c.reservationDao.GetReservations(ctx, model.GetReservations{
+		ProviderID: request.ProviderID,
+		TimeRange:  &request.TimeRange,
+	})
+	if err != nil {
+		return
+	}
+	taken := activeTimeRanges(reservations, now)
+
+	start := request.Start
+	// nothing inside the lead time can be booked
+	if earliest := c.nextSlotBoundary(now.Add(c.policy.LeadTime)); start.Before(earliest) {
+		start = earliest
+	}
+	for ; !start.Add(length).After(request.End); start = start.Add(c.policy.SlotInterval) {
+		slot := model.Slot{TimeRange: model.TimeRange{Start: start, End: start.Add(length)}}
+		if request.ClientID != "" && eligible(client, licenses, slot.TimeRange) != nil {
+			continue
+		}
+		// the options split the same availabilities, the ones that cover the slot agree on its seats
+		for _, option := range options {
+			capacity, remaining := c.seats(slot.TimeRange, option.availabilities, busy, taken)
+			if capacity == 0 {
+				continue
+			}
+			slot.Capacity, slot.Remaining = capacity, remaining
+			if !slices.Contains(slot.Modalities, option.modality) {
+				slot.Modalities = append(slot.Modalities, option.modality)
+			}
+			if option.locationID != "" {
+				slot.LocationID = option.locationID
+			}
+		}
+		if slot.Capacity > 0 {
+			slots = append(slots, slot)
+		}
+	}
+	return
+}
+
+func (c *controller) validateGetSlots(request model.GetSlots) (length time.Duration, err error) {
+	// validate the UUID, don't want strings going directly to the DB
+	if _, err = uuid.Parse(request.ProviderID); err != nil {
+		return 0, invalidf("invalid UUID provided")
+	}
+	if request.ClientID != "" {
+		if _, err = uuid.Parse(request.ClientID); err != nil {
+			return 0, invalidf("invalid UUID provided")
+		}
+	}
+	if request.LocationID != "" {
+		if _, err = uuid.Parse(request.LocationID); err != nil {
+			return 0, invalidf("invalid UUID provided")
+		}
+		// visits only happen at a location in person
+		if request.Modality != "" && request.Modality != model.ModalityInPerson {
+			return 0, invalidf("a location is only for in_person visits")
+		}
+	}
+	length = c.policy.SlotInterval
+	if request.AppointmentType != "" {
+		var ok bool
+		length, ok = c.policy.AppointmentTypes[request.AppointmentType]
+		if !ok {
+			return 0, invalidf("appointment type must be one of %s", strings.Join(c.appointmentTypes(), ", "))
+		}
+	}
+	if err = c.validateModality(request.Modality, request.AppointmentType); err != nil {
+		return 0, err
+	}
+	if !request.Start.Before(request.End) {
+		err = invalidf("start time must be before end time")
+	} else if !c.onSlotBoundary(request.Start) {
+		err = invalidf("start time must be on a %s boundary", c.policy.SlotInterval)
+	} else if !c.onSlotBoundary(request.End) {
+		err = invalidf("end time must be on a %s boundary", c.policy.SlotInterval)
+	} else if request.End.Sub(request.Start) > maxSlotRange {
+		err = invalidf("slots can be listed for at most %s at once", maxSlotRange)
+	}
+	return
+}
+
+// seats works out how many clients the time range takes and how many more fit. It's looked at one slot interval at a
+// time, each has the capacity of the availability under it less the taken time ranges overlapping it, and the range
+// gets the fewest of any of them. capacity is 0 when part of the range has no availability or overlaps the blocked
+// time ranges
+func (c *controller) seats(timeRange model.TimeRange, availabilities []model.Availability, blocked []model.TimeRange, taken []model.TimeRange) (capacity int, remaining int) {
+	if overlapsAny(timeRange, blocked) {
+		return 0, 0
+	}
+	for start := timeRange.Start; start.Before(timeRange.End); start = start.Add(c.policy.SlotInterval) {
+		var (
+			step = model.TimeRange{Start: start, End: start.Add(c.policy.SlotInterval)}
+			open int
+		)
+		for _, availability := range availabilities {
+			if covered(step, []model.TimeRange{availability.TimeRange}) {
+				open = availability.Capacity
+				break
+			}
+		}
+		if open == 0 {
+			return 0, 0
+		}
+		left := open
+		for _, other := range taken {
+			if overlapsAny(step, []model.TimeRange{other}) {
+				left--
+			}
+		}
+		if capacity == 0 || open < capacity {
+			capacity = open
+		}
+		if start.Equal(timeRange.Start) || left < remaining {
+			remaining = left
+		}
+	}
+	return capacity, max(remaining, 0)
+}
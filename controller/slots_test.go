@@ -0,0 +1,191 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"henrymeds-takehome/dao"
+	"henrymeds-takehome/model"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	capacityProviderID = "00000000-0000-4000-8000-0000000000d1"
+	capacityClientID   = "00000000-0000-4000-8000-0000000000d2"
+)
+
+// nine is 09:00 on a day well after any lead time, the tests book around it
+var nine = time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 7).Add(9 * time.Hour)
+
+// at is the time range from minutes after nine to minutes after nine
+func at(from int, to int) model.TimeRange {
+	return model.TimeRange{Start: nine.Add(time.Duration(from) * time.Minute), End: nine.Add(time.Duration(to) * time.Minute)}
+}
+
+// capacityDao has the provider's availabilities and reservations and a client licensed for them, anything else
+// panics on the nil ReservationDao
+type capacityDao struct {
+	dao.ReservationDao
+
+	availabilities []model.Availability
+	reservations   []model.Reservation
+}
+
+func (d *capacityDao) LockUser(ctx context.Context, id string) error {
+	return nil
+}
+
+func (d *capacityDao) GetUser(ctx context.Context, id string) (model.User, error) {
+	return model.User{ID: id, State: "CA"}, nil
+}
+
+func (d *capacityDao) GetProviderLicenses(ctx context.Context, providerID string) ([]model.ProviderLicense, error) {
+	return []model.ProviderLicense{{ProviderID: providerID, State: "CA", ExpiresOn: nine.AddDate(1, 0, 0)}}, nil
+}
+
+func (d *capacityDao) GetAvailabilities(ctx context.Context, request model.GetAvailabilities) (availabilities []model.Availability, err error) {
+	for _, availability := range d.availabilities {
+		if overlapsAny(availability.TimeRange, []model.TimeRange{request.TimeRange}) {
+			availabilities = append(availabilities, availability)
+		}
+	}
+	return
+}
+
+func (d *capacityDao) GetReservations(ctx context.Context, request model.GetReservations) (reservations []model.Reservation, err error) {
+	for _, reservation := range d.reservations {
+		if request.ClientID != "" && reservation.ClientID != request.ClientID {
+			continue
+		}
+		if overlapsAny(reservation.TimeRange, []model.TimeRange{*request.TimeRange}) {
+			reservations = append(reservations, reservation)
+		}
+	}
+	return
+}
+
+// availability is one offering video visits
+func availability(timeRange model.TimeRange, capacity int) model.Availability {
+	return model.Availability{ProviderID: capacityProviderID, TimeRange: timeRange, Capacity: capacity, Modalities: []string{model.ModalityVideo}}
+}
+
+// held is another client's reservation that's holding its seat
+func held(timeRange model.TimeRange) model.Reservation {
+	return model.Reservation{ID: timeRange.Start.String(), ClientID: "another client", ProviderID: capacityProviderID, TimeRange: timeRange, ExpiresAt: time.Now().Add(time.Hour), Modality: model.ModalityVideo}
+}
+
+func TestSeats(t *testing.T) {
+	c := NewController(nil, Policy{SlotInterval: 15 * time.Minute}, nil, nil, nil)
+	for name, test := range map[string]struct {
+		timeRange      model.TimeRange
+		availabilities []model.Availability
+		blocked        []model.TimeRange
+		taken          []model.TimeRange
+		capacity       int
+		remaining      int
+	}{
+		"one on one, open":  {timeRange: at(0, 30), availabilities: []model.Availability{availability(at(0, 60), 1)}, capacity: 1, remaining: 1},
+		"one on one, taken": {timeRange: at(0, 30), availabilities: []model.Availability{availability(at(0, 60), 1)}, taken: []model.TimeRange{at(0, 30)}, capacity: 1, remaining: 0},
+		"group, some taken": {timeRange: at(0, 30), availabilities: []model.Availability{availability(at(0, 60), 3)}, taken: []model.TimeRange{at(0, 30), at(0, 15)}, capacity: 3, remaining: 1},
+		"group, full":       {timeRange: at(0, 30), availabilities: []model.Availability{availability(at(0, 60), 2)}, taken: []model.TimeRange{at(0, 30), at(15, 45)}, capacity: 2, remaining: 0},
+		// back to back, no interval has more than one of them
+		"partial reservations that don't overlap": {timeRange: at(0, 60), availabilities: []model.Availability{availability(at(0, 60), 2)}, taken: []model.TimeRange{at(0, 30), at(30, 60)}, capacity: 2, remaining: 1},
+		// the range gets the fewest seats of any interval, 09:15 to 09:30 has both
+		"partial reservations that overlap": {timeRange: at(0, 60), availabilities: []model.Availability{availability(at(0, 60), 2)}, taken: []model.TimeRange{at(0, 30), at(15, 45)}, capacity: 2, remaining: 0},
+		"taken next to the range":           {timeRange: at(30, 60), availabilities: []model.Availability{availability(at(0, 60), 1)}, taken: []model.TimeRange{at(0, 30)}, capacity: 1, remaining: 1},
+		// adjacent availabilities cover the range between them, with the smaller capacity
+		"across availabilities": {timeRange: at(15, 45), availabilities: []model.Availability{availability(at(0, 30), 3), availability(at(30, 60), 2)}, taken: []model.TimeRange{at(30, 45)}, capacity: 2, remaining: 1},
+		"partly unavailable":    {timeRange: at(30, 90), availabilities: []model.Availability{availability(at(0, 60), 2)}, capacity: 0, remaining: 0},
+		"blocked":               {timeRange: at(0, 30), availabilities: []model.Availability{availability(at(0, 60), 2)}, blocked: []model.TimeRange{at(25, 35)}, capacity: 0, remaining: 0},
+		// more booked than there are seats, from before the capacity went down, is still just full
+		"overbooked": {timeRange: at(0, 30), availabilities: []model.Availability{availability(at(0, 60), 1)}, taken: []model.TimeRange{at(0, 30), at(0, 30)}, capacity: 1, remaining: 0},
+	} {
+		t.Run(name, func(t *testing.T) {
+			capacity, remaining := c.seats(test.timeRange, test.availabilities, test.blocked, test.taken)
+			if capacity != test.capacity || remaining != test.remaining {
+				t.Fatalf("got %d seats with %d left, want %d with %d left", capacity, remaining, test.capacity, test.remaining)
+			}
+		})
+	}
+}
+
+func TestCheckReservationAvailability(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		expired = held(at(0, 30))
+	)
+	expired.ExpiresAt = time.Now().Add(-time.Minute)
+	cancelled := held(at(0, 30))
+	cancelled.Confirmed, cancelled.CancelledAt = true, time.Now()
+	for name, test := range map[string]struct {
+		availabilities []model.Availability
+		reservations   []model.Reservation
+		want           string
+	}{
+		"open":              {availabilities: []model.Availability{availability(at(0, 60), 1)}},
+		"taken":             {availabilities: []model.Availability{availability(at(0, 60), 1)}, reservations: []model.Reservation{held(at(15, 45))}, want: "the provider has conflicting reservations"},
+		"a seat left":       {availabilities: []model.Availability{availability(at(0, 60), 2)}, reservations: []model.Reservation{held(at(0, 30))}},
+		"every seat taken":  {availabilities: []model.Availability{availability(at(0, 60), 2)}, reservations: []model.Reservation{held(at(0, 30)), held(at(15, 45))}, want: "all 2 seats are taken"},
+		"expired hold":      {availabilities: []model.Availability{availability(at(0, 60), 1)}, reservations: []model.Reservation{expired}},
+		"cancelled":         {availabilities: []model.Availability{availability(at(0, 60), 1)}, reservations: []model.Reservation{cancelled}},
+		"no availability":   {want: "insufficient availability"},
+		"availability ends": {availabilities: []model.Availability{availability(at(0, 15), 1)}, want: "insufficient availability"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var (
+				d = &capacityDao{availabilities: test.availabilities, reservations: test.reservations}
+				c = NewController(d, Policy{SlotInterval: 15 * time.Minute}, nil, nil, nil)
+			)
+			err := c.checkReservationAvailability(ctx, d, model.Reservation{
+				ClientID:   capacityClientID,
+				ProviderID: capacityProviderID,
+				TimeRange:  at(0, 30),
+				Modality:   model.ModalityVideo,
+			})
+			switch {
+			case test.want == "" && err != nil:
+				t.Fatal(err)
+			case test.want != "" && (!errors.Is(err, ErrConflict) || !strings.Contains(err.Error(), test.want)):
+				t.Fatalf("got %v, want a conflict about %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestCheckCapacity(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		expired = held(at(0, 30))
+	)
+	expired.ExpiresAt = time.Now().Add(-time.Minute)
+	for name, test := range map[string]struct {
+		capacity     int
+		reservations []model.Reservation
+		cancelled    []model.Reservation
+		want         string
+	}{
+		"above what's booked":   {capacity: 3, reservations: []model.Reservation{held(at(0, 30)), held(at(15, 45))}},
+		"down to what's booked": {capacity: 2, reservations: []model.Reservation{held(at(0, 30)), held(at(15, 45))}},
+		"below what's booked":   {capacity: 1, reservations: []model.Reservation{held(at(0, 30)), held(at(15, 45))}, want: "2 reservations are booked at " + nine.Add(15*time.Minute).Format(time.RFC3339)},
+		// never at the same time, one seat is enough
+		"below what's booked back to back": {capacity: 1, reservations: []model.Reservation{held(at(0, 30)), held(at(30, 60))}},
+		"expired holds don't count":        {capacity: 1, reservations: []model.Reservation{held(at(0, 30)), expired}},
+		// the change cancels one of them, it's no longer booked
+		"below what's booked, one cancelled": {capacity: 1, reservations: []model.Reservation{held(at(0, 30)), held(at(15, 45))}, cancelled: []model.Reservation{held(at(15, 45))}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var (
+				d = &capacityDao{reservations: test.reservations}
+				c = NewController(d, Policy{SlotInterval: 15 * time.Minute}, nil, nil, nil)
+			)
+			err := c.checkCapacity(ctx, d, availability(at(0, 60), test.capacity), test.cancelled)
+			switch {
+			case test.want == "" && err != nil:
+				t.Fatal(err)
+			case test.want != "" && (!errors.Is(err, ErrConflict) || !strings.Contains(err.Error(), test.want)):
+				t.Fatalf("got %v, want a conflict about %q", err, test.want)
+			}
+		})
+	}
+}
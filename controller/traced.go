@@ -0,0 +1,321 @@
+package controller
+
+import (
+	"context"
+	"henrymeds-takehome/model"
+	"henrymeds-takehome/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// NewTracedController wraps a Controller and puts every call in its own span
+func NewTracedController(next Controller) *tracedController {
+	return &tracedController{
+		next: next,
+	}
+}
+
+type tracedController struct {
+	next Controller
+}
+
+func (c *tracedController) CreateAvailability(ctx context.Context, request model.CreateAvailabilities) (availability model.Availability, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Controller.CreateAvailability")
+	defer func() { tracing.End(span, err) }()
+	return c.next.CreateAvailability(ctx, request)
+}
+
+func (c *tracedController) GetAvailabilities(ctx context.Context, request model.GetAvailabilities) (availabilities []model.Availability, next *model.PageKey, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Controller.GetAvailabilities")
+	defer func() { tracing.End(span, err) }()
+	return c.next.GetAvailabilities(ctx, request)
+}
+
+func (c *tracedController) GetAvailability(ctx context.Context, providerID string, id string) (availability model.Availability, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Controller.GetAvailability")
+	defer func() { tracing.End(span, err) }()
+	return c.next.GetAvailability(ctx, providerID, id)
+}
+
+func (c *tracedController) GetSlots(ctx context.Context, request model.GetSlots) (slots []model.Slot, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Controller.GetSlots")
+	defer func() { tracing.End(span, err) }()
+	return c.next.GetSlots(ctx, request)
+}
+
+func (c *tracedController) CreateReservation(ctx context.Context, request model.CreateReservation) (reservation model.Reservation, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Controller.CreateReservation")
+	defer func() { tracing.End(span, err) }()
+	return c.next.CreateReservation(ctx, request)
+}
+
+func (c *tracedController) ConfirmReservation(ctx context.Context, confirmationId string) (reservation model.Reservation, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Controller.ConfirmReservation")
+	defer func() { tracing.End(span, err) }()
+	return c.next.ConfirmReservation(ctx, confirmationId)
+}
+
+func (c *tracedController) ConfirmReservationByToken(ctx context.Context, token string) (reservation model.Reservation, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Controller.ConfirmReservationByToken")
+	defer func() { tracing.End(span, err) }()
+	return c.next.ConfirmReservationByToken(ctx, token)
+}
+
+func (c *tracedController) GetReservation(ctx context.Context, id string) (reservation model.Reservation, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Controller.GetReservation")
+	defer func() { tracing.End(span, err) }()
+	return c.next.GetReservation(ctx, id)
+}
+
+func (c *tracedController) GetUser(ctx context.Context, id string) (user model.User, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Controller.GetUser")
+	defer func() { tracing.End(span, err) }()
+	return c.next.GetUser(ctx, id)
+}
+
+func (c *tracedController) UpdateUser(ctx context.Context, request model.UpdateUser) (user model.User, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Controller.UpdateUser")
+	defer func() { tracing.End(span, err) }()
+	return c.next.UpdateUser(ctx, request)
+}
+
+func (c *tracedController) GetProviderLicenses(ctx context.Context, providerID string) (licenses []model.ProviderLicense, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Controller.GetProviderLicenses")
+	defer func() { tracing.End(span, err) }()
+	return c.next.GetProviderLicenses(ctx, providerID)
+}
+
+func (c *tracedController) PutProviderLicense(ctx context.Context, license model.ProviderLicense) (stored model.ProviderLicense, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Controller.PutProviderLicense")
+	defer func() { tracing.End(span, err) }()
+	return c.next.PutProviderLicense(ctx, license)
+}
+
+func (c *tracedController) DeleteProviderLicense(ctx context.Context, providerID string, state string) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Controller.DeleteProviderLicense")
+	defer func() { tracing.End(span, err) }()
+	return c.next.DeleteProviderLicense(ctx, providerID, state)
+}
+
+func (c *tracedController) ImportAvailabilities(ctx context.Context, request model.ImportAvailabilities) (report model.ImportReport, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Controller.ImportAvailabilities")
+	span.SetAttributes(attribute.Int("import.rows", len(request.Rows)), attribute.Bool("import.dry_run", request.DryRun))
+	defer func() { tracing.End(span, err) }()
+	return c.next.ImportAvailabilities(ctx, request)
+}
+
+func (c *tracedController) UpdateAvailability(ctx context.Context, request model.UpdateAvailability) (change model.AvailabilityChange, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Controller.UpdateAvailability")
+	span.SetAttributes(attribute.Bool("availability.force", request.Force))
+	defer func() {
+		span.SetAttributes(attribute.Int("reservation.cancelled", len(change.Cancelled)))
+		tracing.End(span, err)
+	}()
+	return c.next.UpdateAvailability(ctx, request)
+}
+
+func (c *tracedController) DeleteAvailability(ctx context.Context, request model.DeleteAvailability) (change model.AvailabilityChange, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Controller.DeleteAvailability")
+	span.SetAttributes(attribute.Bool("availability.force", request.Force))
+	defer func() {
+		span.SetAttributes(attribute.Int("reservation.cancelled", len(change.Cancelled)))
+		tracing.End(span, err)
+	}()
+	return c.next.DeleteAvailability(ctx, request)
+}
+
+func (c *tracedController) RotateCalendarToken(ctx context.Context, userID string) (token string, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Controller.RotateCalendarToken")
+	defer func() { tracing.End(span, err) }()
+	return c.next.RotateCalendarToken(ctx, userID)
+}
+
+func (c *tracedController) GetCalendar(ctx context.Context, userID string, token string) (reservations []model.Reservation, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Controller.GetCalendar")
+	defer func() {
+		span.SetAttributes(attribute.Int("reservation.count", len(reservations)))
+		tracing.End(span, err)
+	}()
+	return c.next.GetCalendar(ctx, userID, token)
+}
+
+func (c *tracedController) CreateBusySource(ctx context.Context, request model.CreateBusySource) (source model.BusySource, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Controller.CreateBusySource")
+	span.SetAttributes(attribute.Bool("busy.url", request.URL != ""))
+	defer func() {
+		span.SetAttributes(attribute.Int("busy.count", source.Blocks))
+		tracing.End(span, err)
+	}()
+	return c.next.CreateBusySource(ctx, request)
+}
+
+func (c *tracedController) ImportBusySource(ctx context.Context, request model.ImportBusySource) (source model.BusySource, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Controller.ImportBusySource")
+	defer func() {
+		span.SetAttributes(attribute.Int("busy.count", source.Blocks))
+		tracing.End(span, err)
+	}()
+	return c.next.ImportBusySource(ctx, request)
+}
+
+func (c *tracedController) GetBusySources(ctx context.Context, providerID string) (sources []model.BusySource, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Controller.GetBusySources")
+	defer func() { tracing.End(span, err) }()
+	return c.next.GetBusySources(ctx, providerID)
+}
+
+func (c *tracedController) GetBusySource(ctx context.Context, providerID string, id string) (source model.BusySource, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Controller.GetBusySource")
+	defer func() { tracing.End(span, err) }()
+	return c.next.GetBusySource(ctx, providerID, id)
+}
+
+func (c *tracedController) DeleteBusySource(ctx context.Context, providerID string, id string) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Controller.DeleteBusySource")
+	defer func() { tracing.End(span, err) }()
+	return c.next.DeleteBusySource(ctx, providerID, id)
+}
+
+func (c *tracedController) CreateWaitlistEntry(ctx context.Context, request model.CreateWaitlistEntry) (entry model.WaitlistEntry, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Controller.CreateWaitlistEntry")
+	defer func() { tracing.End(span, err) }()
+	return c.next.CreateWaitlistEntry(ctx, request)
+}
+
+func (c *tracedController) GetWaitlistEntry(ctx context.Context, id string) (entry model.WaitlistEntry, offer model.Reservation, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Controller.GetWaitlistEntry")
+	defer func() { tracing.End(span, err) }()
+	return c.next.GetWaitlistEntry(ctx, id)
+}
+
+func (c *tracedController) CancelWaitlistEntry(ctx context.Context, id string) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Controller.CancelWaitlistEntry")
+	defer func() { tracing.End(span, err) }()
+	return c.next.CancelWaitlistEntry(ctx, id)
+}
+
+func (c *tracedController) OfferWaitlistSlots(ctx context.Context, providerID string, timeRange model.TimeRange) (offered int, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Controller.OfferWaitlistSlots")
+	defer func() {
+		span.SetAttributes(attribute.Int("waitlist.offered", offered))
+		tracing.End(span, err)
+	}()
+	return c.next.OfferWaitlistSlots(ctx, providerID, timeRange)
+}
+
+func (c *tracedController) ExpireReservations(ctx context.Context, limit int) (expired int, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Controller.ExpireReservations")
+	defer func() {
+		span.SetAttributes(attribute.Int("reservation.count", expired))
+		tracing.End(span, err)
+	}()
+	return c.next.ExpireReservations(ctx, limit)
+}
+
+func (c *tracedController) CreateLocation(ctx context.Context, request model.CreateLocation) (location model.Location, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Controller.CreateLocation")
+	defer func() { tracing.End(span, err) }()
+	return c.next.CreateLocation(ctx, request)
+}
+
+func (c *tracedController) GetLocations(ctx context.Context) (locations []model.Location, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Controller.GetLocations")
+	defer func() { tracing.End(span, err) }()
+	return c.next.GetLocations(ctx)
+}
+
+func (c *tracedController) GetLocation(ctx context.Context, id string) (location model.Location, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Controller.GetLocation")
+	defer func() { tracing.End(span, err) }()
+	return c.next.GetLocation(ctx, id)
+}
+
+func (c *tracedController) CreateOrganization(ctx context.Context, request model.CreateOrganization) (organization model.Organization, apiKey string, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Controller.CreateOrganization")
+	defer func() { tracing.End(span, err) }()
+	return c.next.CreateOrganization(ctx, request)
+}
+
+func (c *tracedController) GetOrganizations(ctx context.Context) (organizations []model.Organization, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Controller.GetOrganizations")
+	defer func() { tracing.End(span, err) }()
+	return c.next.GetOrganizations(ctx)
+}
+
+func (c *tracedController) GetOrganization(ctx context.Context, id string) (organization model.Organization, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Controller.GetOrganization")
+	defer func() { tracing.End(span, err) }()
+	return c.next.GetOrganization(ctx, id)
+}
+
+func (c *tracedController) RotateOrganizationKey(ctx context.Context, id string) (apiKey string, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Controller.RotateOrganizationKey")
+	defer func() { tracing.End(span, err) }()
+	return c.next.RotateOrganizationKey(ctx, id)
+}
+
+func (c *tracedController) CreateWebhookSubscription(ctx context.Context, request model.CreateWebhookSubscription) (subscription model.WebhookSubscription, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Controller.CreateWebhookSubscription")
+	defer func() { tracing.End(span, err) }()
+	return c.next.CreateWebhookSubscription(ctx, request)
+}
+
+func (c *tracedController) GetWebhookSubscriptions(ctx context.Context) (subscriptions []model.WebhookSubscription, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Controller.GetWebhookSubscriptions")
+	defer func() { tracing.End(span, err) }()
+	return c.next.GetWebhookSubscriptions(ctx)
+}
+
+func (c *tracedController) GetWebhookSubscription(ctx context.Context, id string) (subscription model.WebhookSubscription, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Controller.GetWebhookSubscription")
+	defer func() { tracing.End(span, err) }()
+	return c.next.GetWebhookSubscription(ctx, id)
+}
+
+func (c *tracedController) UpdateWebhookSubscription(ctx context.Context, request model.UpdateWebhookSubscription) (subscription model.WebhookSubscription, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Controller.UpdateWebhookSubscription")
+	defer func() { tracing.End(span, err) }()
+	return c.next.UpdateWebhookSubscription(ctx, request)
+}
+
+func (c *tracedController) DeleteWebhookSubscription(ctx context.Context, id string) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Controller.DeleteWebhookSubscription")
+	defer func() { tracing.End(span, err) }()
+	return c.next.DeleteWebhookSubscription(ctx, id)
+}
+
+func (c *tracedController) GetWebhookDeliveries(ctx context.Context, request model.GetWebhookDeliveries) (deliveries []model.WebhookDelivery, next *model.PageKey, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Controller.GetWebhookDeliveries")
+	defer func() {
+		span.SetAttributes(attribute.Int("delivery.count", len(deliveries)))
+		tracing.End(span, err)
+	}()
+	return c.next.GetWebhookDeliveries(ctx, request)
+}
+
+func (c *tracedController) GetWebhookDelivery(ctx context.Context, id string) (delivery model.WebhookDelivery, attempts []model.WebhookAttempt, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Controller.GetWebhookDelivery")
+	defer func() { tracing.End(span, err) }()
+	return c.next.GetWebhookDelivery(ctx, id)
+}
+
+func (c *tracedController) RedeliverWebhook(ctx context.Context, id string) (delivery model.WebhookDelivery, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Controller.RedeliverWebhook")
+	defer func() { tracing.End(span, err) }()
+	return c.next.RedeliverWebhook(ctx, id)
+}
+
+func (c *tracedController) GetAuditEntries(ctx context.Context, request model.GetAuditEntries) (entries []model.AuditEntry, next *model.PageKey, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Controller.GetAuditEntries")
+	defer func() {
+		span.SetAttributes(attribute.Int("audit.count", len(entries)))
+		tracing.End(span, err)
+	}()
+	return c.next.GetAuditEntries(ctx, request)
+}
+
+func (c *tracedController) VerifyAuditLog(ctx context.Context, organizationID string) (verifications []model.AuditVerification, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Controller.VerifyAuditLog")
+	defer func() { tracing.End(span, err) }()
+	return c.next.VerifyAuditLog(ctx, organizationID)
+}
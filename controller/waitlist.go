@@ -0,0 +1,438 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"henrymeds-takehome/dao"
+	"henrymeds-takehome/metrics"
+	"henrymeds-takehome/model"
+	"henrymeds-takehome/outbox"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CreateWaitlistEntry puts the client in line for a slot of the appointment type inside the window, with the provider
+// or with any provider when none is given, in the modality or any the appointment type allows. Nothing is offered
+// until time frees up, see OfferWaitlistSlots. A provider has to be licensed to see the client for the first
+// appointment the window fits
+func (c *controller) CreateWaitlistEntry(ctx context.Context, request model.CreateWaitlistEntry) (entry model.WaitlistEntry, err error) {
+	var (
+		client   model.User
+		licenses []model.ProviderLicense
+	)
+	err = c.validateCreateWaitlistEntry(request)
+	if err != nil {
+		return
+	}
+	client, err = c.reservationDao.GetUser(ctx, request.ClientID)
+	if errors.Is(err, dao.ErrNotFound) {
+		err = notFoundf("no client with that ID")
+	}
+	if err != nil {
+		return
+	}
+	if request.ProviderID != "" {
+		_, err = c.reservationDao.GetUser(ctx, request.ProviderID)
+		if errors.Is(err, dao.ErrNotFound) {
+			err = notFoundf("no provider with that ID")
+		}
+		if err != nil {
+			return
+		}
+		licenses, err = c.reservationDao.GetProviderLicenses(ctx, request.ProviderID)
+		if err != nil {
+			return
+		}
+		first := model.TimeRange{Start: request.Start, End: request.Start.Add(c.policy.AppointmentTypes[request.AppointmentType])}
+		err = eligible(client, licenses, first)
+		if err != nil {
+			return
+		}
+	}
+
+	entry, err = c.reservationDao.InsertWaitlistEntry(ctx, model.WaitlistEntry{
+		ClientID:        request.ClientID,
+		ProviderID:      request.ProviderID,
+		AppointmentType: request.AppointmentType,
+		Modality:        request.Modality,
+		TimeRange:       request.TimeRange,
+		Priority:        request.Priority,
+		Status:          model.WaitlistWaiting,
+		CreatedAt:       time.Now(),
+	})
+	if err != nil {
+		return
+	}
+	slog.InfoContext(ctx, "waitlist entry created",
+		"waitlist_entry_id", entry.ID,
+		"client_id", entry.ClientID,
+		"provider_id", entry.ProviderID,
+	)
+	return
+}
+
+func (c *controller) validateCreateWaitlistEntry(request model.CreateWaitlistEntry) (err error) {
+	// validate the UUIDs, don't want strings going directly to the DB
+	if _, err = uuid.Parse(request.ClientID); err != nil {
+		return invalidf("invalid UUID provided")
+	}
+	if request.ProviderID != "" {
+		if _, err = uuid.Parse(request.ProviderID); err != nil {
+			return invalidf("invalid UUID provided")
+		}
+	}
+	length, ok := c.policy.AppointmentTypes[request.AppointmentType]
+	if !ok {
+		return invalidf("appointment type must be one of %s", strings.Join(c.appointmentTypes(), ", "))
+	}
+	if err = c.validateModality(request.Modality, request.AppointmentType); err != nil {
+		return
+	}
+	if !request.Start.Before(request.End) {
+		err = invalidf("start time must be before end time")
+	} else if !c.onSlotBoundary(request.Start) {
+		err = invalidf("start time must be on a %s boundary", c.policy.SlotInterval)
+	} else if !c.onSlotBoundary(request.End) {
+		err = invalidf("end time must be on a %s boundary", c.policy.SlotInterval)
+	} else if request.End.Sub(request.Start) < length {
+		err = invalidf("the window is too short for the %s appointment type, it takes %s", request.AppointmentType, length)
+	} else if request.End.Before(time.Now().Add(c.policy.LeadTime + length)) {
+		err = invalidf("the window has to leave room for the appointment at least %s from now", c.policy.LeadTime)
+	}
+	return
+}
+
+// appointmentTypes lists the names of the appointment types in the policy, sorted
+func (c *controller) appointmentTypes() (names []string) {
+	for name := range c.policy.AppointmentTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// GetWaitlistEntry returns the entry, and the hold it was offered while the offer is open
+func (c *controller) GetWaitlistEntry(ctx context.Context, id string) (entry model.WaitlistEntry, offer model.Reservation, err error) {
+	var reservations []model.Reservation
+
+	// validate the UUID, don't want strings going directly to the DB
+	if _, err = uuid.Parse(id); err != nil {
+		err = invalidf("invalid UUID provided")
+		return
+	}
+	entry, err = c.reservationDao.GetWaitlistEntry(ctx, id)
+	if errors.Is(err, dao.ErrNotFound) {
+		err = notFoundf("no waitlist entry with that ID")
+	}
+	if err != nil || entry.Status != model.WaitlistOffered {
+		return
+	}
+	reservations, err = c.reservationDao.GetReservations(ctx, model.GetReservations{
+		ID: entry.ReservationID,
+	})
+	if err != nil {
+		return
+	}
+	if len(reservations) > 0 {
+		offer = reservations[0]
+	}
+	return
+}
+
+// CancelWaitlistEntry takes the client out of line. A slot the entry is holding goes to the next one in line
+func (c *controller) CancelWaitlistEntry(ctx context.Context, id string) (err error) {
+	var entry model.WaitlistEntry
+
+	// validate the UUID, don't want strings going directly to the DB
+	if _, err = uuid.Parse(id); err != nil {
+		return invalidf("invalid UUID provided")
+	}
+	err = c.reservationDao.InTransaction(ctx, func(tx dao.ReservationDao) (err error) {
+		var (
+			reservations []model.Reservation
+			now          = time.Now()
+		)
+		entry, err = tx.CancelWaitlistEntry(ctx, id)
+		if errors.Is(err, dao.ErrNotFound) {
+			// either there's no such entry or it's past cancelling, tell them apart for the caller
+			entry, err = tx.GetWaitlistEntry(ctx, id)
+			if errors.Is(err, dao.ErrNotFound) {
+				return notFoundf("no waitlist entry with that ID")
+			}
+			if err != nil {
+				return
+			}
+			return conflictf("the waitlist entry is already %s", entry.StatusAt(now))
+		}
+		if err != nil || entry.Status != model.WaitlistOffered {
+			return
+		}
+
+		reservations, err = tx.GetReservations(ctx, model.GetReservations{
+			ID: entry.ReservationID,
+		})
+		if err != nil {
+			return
+		}
+		if len(reservations) == 0 || reservations[0].Status(now) != model.ReservationStatusHeld {
+			return
+		}
+		// cancelling the hold publishes reservation.cancelled, which offers the slot on
+		err = c.cancelReservations(ctx, tx, reservations)
+		if err != nil {
+			return
+		}
+		return recordReservationEvents(ctx, tx, outbox.ReservationCancelled, now, reservations...)
+	})
+	if err != nil {
+		return
+	}
+	slog.InfoContext(ctx, "waitlist entry cancelled", "waitlist_entry_id", id, "reservation_id", entry.ReservationID)
+	return
+}
+
+// OfferWaitlistSlots offers time that freed up with the provider, inside the time range, to the waitlist. Entries
+// are taken in line, each gets the earliest slot inside its window that's still free in a modality it takes and the
+// provider is licensed to see the client for, held for the offer hold. An entry nothing fits stays in line for the
+// next time. offered is how many entries got a slot
+func (c *controller) OfferWaitlistSlots(ctx context.Context, providerID string, timeRange model.TimeRange) (offered int, err error) {
+	now := time.Now()
+	// nothing inside the lead time can be booked
+	if earliest := c.nextSlotBoundary(now.Add(c.policy.LeadTime)); timeRange.Start.Before(earliest) {
+		timeRange.Start = earliest
+	}
+	if !timeRange.Start.Before(timeRange.End) {
+		return
+	}
+
+	// the provider is locked like any other write against their schedule, so offers for the same time can't interleave
+	err = c.reservationDao.InTransaction(ctx, func(tx dao.ReservationDao) (err error) {
+		var (
+			entries        []model.WaitlistEntry
+			availabilities []model.Availability
+			blocks         []model.BusyBlock
+			reservations   []model.Reservation
+			licenses       []model.ProviderLicense
+			busy           []model.TimeRange
+			taken          []model.TimeRange
+		)
+		offered = 0
+		err = tx.LockUser(ctx, providerID)
+		if errors.Is(err, dao.ErrNotFound) {
+			// the provider is gone, there's nothing to offer
+			return nil
+		}
+		if err != nil {
+			return
+		}
+		entries, err = tx.ClaimWaitlistEntries(ctx, model.GetWaitlistCandidates{
+			ProviderID: providerID,
+			TimeRange:  timeRange,
+			ByPriority: c.policy.WaitlistByPriority,
+			Limit:      c.policy.WaitlistBatchSize,
+		})
+		if err != nil || len(entries) == 0 {
+			return
+		}
+
+		availabilities, err = tx.GetAvailabilities(ctx, model.GetAvailabilities{
+			ProviderID: providerID,
+			TimeRange:  timeRange,
+		})
+		if err != nil || len(availabilities) == 0 {
+			return
+		}
+		blocks, err = tx.GetBusyBlocks(ctx, model.GetBusyBlocks{ProviderID: providerID, TimeRange: timeRange})
+		if err != nil {
+			return
+		}
+		for _, block := range blocks {
+			busy = append(busy, block.TimeRange)
+		}
+		reservations, err = tx.GetReservations(ctx, model.GetReservations{
+			ProviderID: providerID,
+			TimeRange:  &timeRange,
+		})
+		if err != nil {
+			return
+		}
+		taken = activeTimeRanges(reservations, now)
+		licenses, err = tx.GetProviderLicenses(ctx, providerID)
+		if err != nil {
+			return
+		}
+
+		for _, entry := range entries {
+			var (
+				client model.User
+				slot   model.TimeRange
+				option bookingOption
+				booked []model.Reservation
+				found  bool
+			)
+			length, ok := c.policy.AppointmentTypes[entry.AppointmentType]
+			if !ok {
+				// the type was dropped from the config after the entry joined, it can't be offered anything
+				continue
+			}
+			window := entry.TimeRange
+			if window.Start.Before(timeRange.Start) {
+				window.Start = timeRange.Start
+			}
+			if window.End.After(timeRange.End) {
+				window.End = timeRange.End
+			}
+			// the client can't be offered time they've already booked elsewhere
+			booked, err = tx.GetReservations(ctx, model.GetReservations{
+				ClientID:  entry.ClientID,
+				TimeRange: &window,
+			})
+			if err != nil {
+				return
+			}
+			modalities := c.allowedModalities(entry.AppointmentType)
+			if entry.Modality != "" {
+				modalities = []string{entry.Modality}
+			}
+			// the earliest slot of any modality, the one listed first when two start together
+			for _, candidate := range bookingOptions(availabilities, modalities, "") {
+				candidateSlot, ok := c.findSlot(window, length, candidate.availabilities, append(activeTimeRanges(booked, now), busy...), taken)
+				if ok && (!found || candidateSlot.Start.Before(slot.Start)) {
+					slot, option, found = candidateSlot, candidate, true
+				}
+			}
+			if !found {
+				continue
+			}
+			client, err = tx.GetUser(ctx, entry.ClientID)
+			if err != nil {
+				return
+			}
+			// an entry for any provider is only offered the slots of providers licensed where the client is located.
+			// Later slots can't be eligible when the earliest isn't, the license only runs out
+			if eligible(client, licenses, slot) != nil {
+				continue
+			}
+			err = c.offer(ctx, tx, entry, providerID, slot, option, now)
+			if err != nil {
+				return
+			}
+			taken = append(taken, slot)
+			offered++
+		}
+		return
+	})
+	if errors.Is(err, dao.ErrConflict) {
+		// the client booked the same time with another provider between the check and the insert, the event is
+		// retried and looks again
+		err = conflictf("the slot was booked while it was being offered")
+	}
+	if err != nil {
+		offered = 0
+		return
+	}
+	if offered > 0 {
+		metrics.WaitlistOffers.Add(float64(offered))
+		slog.InfoContext(ctx, "waitlist slots offered", "provider_id", providerID, "count", offered)
+	}
+	return
+}
+
+// findSlot returns the earliest slot of the length inside the window that has a seat left, see seats
+func (c *controller) findSlot(window model.TimeRange, length time.Duration, availabilities []model.Availability, blocked []model.TimeRange, taken []model.TimeRange) (slot model.TimeRange, found bool) {
+	for start := c.nextSlotBoundary(window.Start); !start.Add(length).After(window.End); start = start.Add(c.policy.SlotInterval) {
+		slot = model.TimeRange{Start: start, End: start.Add(length)}
+		if _, remaining := c.seats(slot, availabilities, blocked, taken); remaining > 0 {
+			return slot, true
+		}
+	}
+	return model.TimeRange{}, false
+}
+
+// offer holds the slot for the entry's client and records the offer. The client's expired holds on the exact slot
+// still count against their unique constraint, they're released first
+func (c *controller) offer(ctx context.Context, tx dao.ReservationDao, entry model.WaitlistEntry, providerID string, slot model.TimeRange, option bookingOption, now time.Time) (err error) {
+	var (
+		released    []model.Reservation
+		reservation model.Reservation
+	)
+	released, err = tx.ReleaseExpiredHolds(ctx, model.ReleaseExpiredHolds{
+		ClientID:  entry.ClientID,
+		TimeRange: slot,
+		Now:       now,
+	})
+	if err != nil {
+		return
+	}
+	if len(released) > 0 {
+		ids := make([]string, len(released))
+		for i, hold := range released {
+			ids[i] = hold.ID
+		}
+		err = tx.SettleWaitlistOffers(ctx, ids, model.WaitlistExpired)
+		if err != nil {
+			return
+		}
+		err = recordReservationEvents(ctx, tx, outbox.ReservationCancelled, now, released...)
+		if err != nil {
+			return
+		}
+	}
+
+	reservation, err = tx.InsertReservation(ctx, model.Reservation{
+		ClientID:   entry.ClientID,
+		ProviderID: providerID,
+		ExpiresAt:  now.Add(c.policy.WaitlistOfferHold),
+		TimeRange:  slot,
+		Modality:   option.modality,
+		LocationID: option.locationID,
+	})
+	if err != nil {
+		return
+	}
+	err = tx.OfferWaitlistEntry(ctx, entry.ID, reservation.ID, now)
+	if err != nil {
+		return
+	}
+	slog.InfoContext(ctx, "waitlist slot offered",
+		"waitlist_entry_id", entry.ID,
+		"reservation_id", reservation.ID,
+		"client_id", entry.ClientID,
+		"provider_id", providerID,
+		"modality", option.modality,
+	)
+	// the client hears about it like any other hold, ex: through the confirmation link
+	return recordReservationEvents(ctx, tx, outbox.ReservationHeld, now, reservation)
+}
+
+// nextSlotBoundary rounds t up to the slot interval
+func (c *controller) nextSlotBoundary(t time.Time) time.Time {
+	rounded := t.Truncate(c.policy.SlotInterval)
+	if rounded.Before(t) {
+		rounded = rounded.Add(c.policy.SlotInterval)
+	}
+	return rounded
+}
+
+// activeTimeRanges returns the time ranges of the reservations that are holding their slot
+func activeTimeRanges(reservations []model.Reservation, now time.Time) (timeRanges []model.TimeRange) {
+	for _, reservation := range reservations {
+		if reservation.Active(now) {
+			timeRanges = append(timeRanges, reservation.TimeRange)
+		}
+	}
+	return
+}
+
+func overlapsAny(timeRange model.TimeRange, timeRanges []model.TimeRange) bool {
+	for _, other := range timeRanges {
+		if timeRange.Start.Before(other.End) && other.Start.Before(timeRange.End) {
+			return true
+		}
+	}
+	return false
+}
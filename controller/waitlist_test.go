@@ -0,0 +1,188 @@
+package controller
+
+import (
+	"context"
+	"henrymeds-takehome/dao"
+	"henrymeds-takehome/model"
+	"testing"
+	"time"
+)
+
+// waitlistDao hands out its entries in the order they're listed, like the query does in the order asked for, and
+// keeps the holds offered to them
+type waitlistDao struct {
+	capacityDao
+
+	entries []model.WaitlistEntry
+	// what the entries were claimed with
+	claimed model.GetWaitlistCandidates
+	// by entry ID
+	offers map[string]model.TimeRange
+}
+
+func (d *waitlistDao) InTransaction(ctx context.Context, fn func(dao.ReservationDao) error) error {
+	return fn(d)
+}
+
+func (d *waitlistDao) ClaimWaitlistEntries(ctx context.Context, request model.GetWaitlistCandidates) ([]model.WaitlistEntry, error) {
+	d.claimed = request
+	return d.entries, nil
+}
+
+func (d *waitlistDao) GetBusyBlocks(ctx context.Context, request model.GetBusyBlocks) ([]model.BusyBlock, error) {
+	return nil, nil
+}
+
+func (d *waitlistDao) ReleaseExpiredHolds(ctx context.Context, request model.ReleaseExpiredHolds) ([]model.Reservation, error) {
+	return nil, nil
+}
+
+func (d *waitlistDao) InsertReservation(ctx context.Context, reservation model.Reservation) (model.Reservation, error) {
+	reservation.ID = reservation.ClientID + "@" + reservation.Start.String()
+	d.reservations = append(d.reservations, reservation)
+	return reservation, nil
+}
+
+func (d *waitlistDao) OfferWaitlistEntry(ctx context.Context, id string, reservationID string, at time.Time) error {
+	for _, reservation := range d.reservations {
+		if reservation.ID == reservationID {
+			d.offers[id] = reservation.TimeRange
+		}
+	}
+	return nil
+}
+
+func (d *waitlistDao) InsertEvents(ctx context.Context, events []model.Event) error {
+	return nil
+}
+
+// waiting is an entry of the client for a 30 minute follow up inside the window
+func waiting(id string, clientID string, window model.TimeRange) model.WaitlistEntry {
+	return model.WaitlistEntry{ID: id, ClientID: clientID, ProviderID: capacityProviderID, AppointmentType: "follow_up", TimeRange: window, Status: model.WaitlistWaiting}
+}
+
+func waitlistController(d *waitlistDao, byPriority bool) *controller {
+	return NewController(d, Policy{
+		SlotInterval:       15 * time.Minute,
+		AppointmentTypes:   map[string]time.Duration{"follow_up": 30 * time.Minute},
+		WaitlistOfferHold:  time.Hour,
+		WaitlistByPriority: byPriority,
+		WaitlistBatchSize:  10,
+	}, nil, nil, nil)
+}
+
+func TestOfferWaitlistSlots(t *testing.T) {
+	for name, test := range map[string]struct {
+		availabilities []model.Availability
+		reservations   []model.Reservation
+		entries        []model.WaitlistEntry
+		// what freed up
+		timeRange  model.TimeRange
+		byPriority bool
+		want       map[string]model.TimeRange
+	}{
+		// the entries come in line, the first gets the earliest slot and the last finds nothing left
+		"first come first served": {
+			availabilities: []model.Availability{availability(at(0, 60), 1)},
+			entries:        []model.WaitlistEntry{waiting("first", "a", at(0, 60)), waiting("second", "b", at(0, 60)), waiting("third", "c", at(0, 60))},
+			timeRange:      at(0, 60),
+			want:           map[string]model.TimeRange{"first": at(0, 30), "second": at(30, 60)},
+		},
+		"by priority": {
+			availabilities: []model.Availability{availability(at(0, 60), 1)},
+			entries:        []model.WaitlistEntry{waiting("urgent", "c", at(0, 60)), waiting("first", "a", at(0, 60))},
+			timeRange:      at(0, 60),
+			byPriority:     true,
+			want:           map[string]model.TimeRange{"urgent": at(0, 30), "first": at(30, 60)},
+		},
+		// only what freed up is offered, the rest of the window was there before
+		"window clipped to what freed up": {
+			availabilities: []model.Availability{availability(at(0, 120), 1)},
+			entries:        []model.WaitlistEntry{waiting("wide", "a", at(0, 120))},
+			timeRange:      at(60, 120),
+			want:           map[string]model.TimeRange{"wide": at(60, 90)},
+		},
+		"what freed up clipped to the window": {
+			availabilities: []model.Availability{availability(at(0, 120), 1)},
+			entries:        []model.WaitlistEntry{waiting("inside", "a", at(45, 90)), waiting("overhanging", "b", at(90, 135)), waiting("too short once clipped", "c", at(105, 150))},
+			timeRange:      at(0, 120),
+			want:           map[string]model.TimeRange{"inside": at(45, 75), "overhanging": at(90, 120)},
+		},
+		// the client has another appointment at the start of the window, the offer goes around it
+		"client booked elsewhere": {
+			availabilities: []model.Availability{availability(at(0, 60), 1)},
+			reservations:   []model.Reservation{{ID: "elsewhere", ClientID: "a", ProviderID: "another provider", TimeRange: at(0, 30), Confirmed: true}},
+			entries:        []model.WaitlistEntry{waiting("booked", "a", at(0, 60))},
+			timeRange:      at(0, 60),
+			want:           map[string]model.TimeRange{"booked": at(30, 60)},
+		},
+		"client booked the whole window": {
+			availabilities: []model.Availability{availability(at(0, 60), 2)},
+			reservations:   []model.Reservation{{ID: "elsewhere", ClientID: "a", ProviderID: "another provider", TimeRange: at(0, 60), Confirmed: true}},
+			entries:        []model.WaitlistEntry{waiting("booked", "a", at(0, 60)), waiting("free", "b", at(0, 60))},
+			timeRange:      at(0, 60),
+			want:           map[string]model.TimeRange{"free": at(0, 30)},
+		},
+		// someone else holds one of two seats, the other is offered, and the next entry goes after both
+		"a seat left": {
+			availabilities: []model.Availability{availability(at(0, 60), 2)},
+			reservations:   []model.Reservation{held(at(0, 30))},
+			entries:        []model.WaitlistEntry{waiting("first", "a", at(0, 60)), waiting("second", "b", at(0, 60))},
+			timeRange:      at(0, 60),
+			want:           map[string]model.TimeRange{"first": at(0, 30), "second": at(30, 60)},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			d := &waitlistDao{
+				capacityDao: capacityDao{availabilities: test.availabilities, reservations: test.reservations},
+				entries:     test.entries,
+				offers:      map[string]model.TimeRange{},
+			}
+			offered, err := waitlistController(d, test.byPriority).OfferWaitlistSlots(context.Background(), capacityProviderID, test.timeRange)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.claimed.ByPriority != test.byPriority || d.claimed.TimeRange != test.timeRange {
+				t.Fatalf("claimed the entries with %+v", d.claimed)
+			}
+			if offered != len(test.want) || len(d.offers) != len(test.want) {
+				t.Fatalf("offered %d slots: %v, want %v", offered, d.offers, test.want)
+			}
+			for id, slot := range test.want {
+				if d.offers[id] != slot {
+					t.Errorf("%s was offered %v, want %v", id, d.offers[id], slot)
+				}
+			}
+		})
+	}
+}
+
+func TestFindSlot(t *testing.T) {
+	var (
+		c              = waitlistController(&waitlistDao{}, false)
+		availabilities = []model.Availability{availability(at(0, 120), 1)}
+	)
+	for name, test := range map[string]struct {
+		window  model.TimeRange
+		blocked []model.TimeRange
+		taken   []model.TimeRange
+		want    model.TimeRange
+		found   bool
+	}{
+		"the earliest":                {window: at(0, 120), want: at(0, 30), found: true},
+		"from the next slot boundary": {window: at(5, 120), want: at(15, 45), found: true},
+		"after what's taken":          {window: at(0, 120), taken: []model.TimeRange{at(0, 45)}, want: at(45, 75), found: true},
+		"after what's blocked":        {window: at(0, 120), blocked: []model.TimeRange{at(15, 30)}, want: at(30, 60), found: true},
+		"ends with the window":        {window: at(90, 120), want: at(90, 120), found: true},
+		"window too short":            {window: at(0, 20)},
+		"nothing left":                {window: at(0, 120), taken: []model.TimeRange{at(0, 120)}},
+		"past the availability":       {window: at(105, 150)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			slot, found := c.findSlot(test.window, 30*time.Minute, availabilities, test.blocked, test.taken)
+			if found != test.found || slot != test.want {
+				t.Fatalf("got %v, found %v, want %v", slot, found, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package controller
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"henrymeds-takehome/dao"
+	"henrymeds-takehome/model"
+	"henrymeds-takehome/outbox"
+	"log/slog"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// shorter secrets are too easy to guess, the generated ones are 32 random bytes
+const minWebhookSecretLength = 16
+
+func (c *controller) CreateWebhookSubscription(ctx context.Context, request model.CreateWebhookSubscription) (subscription model.WebhookSubscription, err error) {
+	if _, err = uuid.Parse(request.OrganizationID); err != nil {
+		err = invalidf("organizationId must be the UUID of the organization whose events the subscription gets")
+		return
+	}
+	if err = validateWebhookURL(request.URL); err != nil {
+		return
+	}
+	if request.EventTypes, err = validateEventTypes(request.EventTypes); err != nil {
+		return
+	}
+	switch {
+	case request.Secret == "":
+		request.Secret, err = newWebhookSecret()
+		if err != nil {
+			return
+		}
+	case len(request.Secret) < minWebhookSecretLength:
+		err = invalidf("the secret must be at least %d characters, leave it out to have one generated", minWebhookSecretLength)
+		return
+	}
+
+	now := time.Now()
+	subscription, err = c.reservationDao.InsertWebhookSubscription(ctx, model.WebhookSubscription{
+		OrganizationID: request.OrganizationID,
+		URL:            request.URL,
+		EventTypes:     request.EventTypes,
+		Secret:         request.Secret,
+		Active:         true,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	})
+	if errors.Is(err, dao.ErrNotFound) {
+		err = notFoundf("no organization with that ID")
+	}
+	if err != nil {
+		return
+	}
+	slog.InfoContext(ctx, "webhook subscription created", "subscription_id", subscription.ID, "organization_id", subscription.OrganizationID, "event_types", subscription.EventTypes)
+	return
+}
+
+func (c *controller) GetWebhookSubscriptions(ctx context.Context) (subscriptions []model.WebhookSubscription, err error) {
+	return c.reservationDao.GetWebhookSubscriptions(ctx, "")
+}
+
+func (c *controller) GetWebhookSubscription(ctx context.Context, id string) (subscription model.WebhookSubscription, err error) {
+	var subscriptions []model.WebhookSubscription
+
+	// validate the UUID, don't want strings going directly to the DB
+	if _, err = uuid.Parse(id); err != nil {
+		err = invalidf("invalid UUID provided")
+		return
+	}
+	subscriptions, err = c.reservationDao.GetWebhookSubscriptions(ctx, id)
+	if err != nil {
+		return
+	}
+	if len(subscriptions) == 0 {
+		err = notFoundf("no webhook subscription with that ID")
+		return
+	}
+	return subscriptions[0], nil
+}
+
+func (c *controller) UpdateWebhookSubscription(ctx context.Context, request model.UpdateWebhookSubscription) (subscription model.WebhookSubscription, err error) {
+	if request.URL == nil && request.EventTypes == nil && request.Active == nil {
+		err = invalidf("nothing to update, set url, eventTypes or active")
+		return
+	}
+	subscription, err = c.GetWebhookSubscription(ctx, request.ID)
+	if err != nil {
+		return
+	}
+	if request.URL != nil {
+		if err = validateWebhookURL(*request.URL); err != nil {
+			return
+		}
+		subscription.URL = *request.URL
+	}
+	if request.EventTypes != nil {
+		if subscription.EventTypes, err = validateEventTypes(request.EventTypes); err != nil {
+			return
+		}
+	}
+	if request.Active != nil {
+		subscription.Active = *request.Active
+	}
+	subscription.UpdatedAt = time.Now()
+
+	err = c.reservationDao.UpdateWebhookSubscription(ctx, subscription)
+	if errors.Is(err, dao.ErrNotFound) {
+		err = notFoundf("no webhook subscription with that ID")
+	}
+	if err != nil {
+		return
+	}
+	slog.InfoContext(ctx, "webhook subscription updated", "subscription_id", subscription.ID, "active", subscription.Active)
+	return
+}
+
+func (c *controller) DeleteWebhookSubscription(ctx context.Context, id string) (err error) {
+	if _, err = uuid.Parse(id); err != nil {
+		return invalidf("invalid UUID provided")
+	}
+	err = c.reservationDao.DeleteWebhookSubscription(ctx, id)
+	if errors.Is(err, dao.ErrNotFound) {
+		err = notFoundf("no webhook subscription with that ID")
+	}
+	if err != nil {
+		return
+	}
+	slog.InfoContext(ctx, "webhook subscription deleted", "subscription_id", id)
+	return
+}
+
+// GetWebhookDeliveries returns a page of the delivery log, newest first. Filtered by the dead status it's the dead letters
+func (c *controller) GetWebhookDeliveries(ctx context.Context, request model.GetWebhookDeliveries) (deliveries []model.WebhookDelivery, next *model.PageKey, err error) {
+	if request.SubscriptionID != "" {
+		if _, err = uuid.Parse(request.SubscriptionID); err != nil {
+			err = invalidf("invalid UUID provided")
+			return
+		}
+	}
+	switch request.Status {
+	case "", model.WebhookPending, model.WebhookDelivered, model.WebhookDead:
+	default:
+		err = invalidf("status must be %s, %s or %s", model.WebhookPending, model.WebhookDelivered, model.WebhookDead)
+		return
+	}
+	request.Page.Sort = model.SortStartDesc
+	request.Page, err = c.normalizePage(request.Page)
+	if err != nil {
+		return
+	}
+
+	// one row past the limit tells us if there's another page without a second query
+	limit := request.Page.Limit
+	request.Page.Limit++
+	deliveries, err = c.reservationDao.GetWebhookDeliveries(ctx, request)
+	if err != nil {
+		return
+	}
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+		last := deliveries[limit-1]
+		next = &model.PageKey{Start: last.CreatedAt, ID: last.ID}
+	}
+	return
+}
+
+// GetWebhookDelivery returns the delivery along with every attempt at it
+func (c *controller) GetWebhookDelivery(ctx context.Context, id string) (delivery model.WebhookDelivery, attempts []model.WebhookAttempt, err error) {
+	var deliveries []model.WebhookDelivery
+
+	if _, err = uuid.Parse(id); err != nil {
+		err = invalidf("invalid UUID provided")
+		return
+	}
+	deliveries, err = c.reservationDao.GetWebhookDeliveries(ctx, model.GetWebhookDeliveries{ID: id})
+	if err != nil {
+		return
+	}
+	if len(deliveries) == 0 {
+		err = notFoundf("no webhook delivery with that ID")
+		return
+	}
+	delivery = deliveries[0]
+	attempts, err = c.reservationDao.GetWebhookAttempts(ctx, id)
+	return
+}
+
+// RedeliverWebhook sends the delivery again as soon as possible, ex: a dead letter once the receiver is fixed. It
+// gets the full number of attempts again
+func (c *controller) RedeliverWebhook(ctx context.Context, id string) (delivery model.WebhookDelivery, err error) {
+	delivery, _, err = c.GetWebhookDelivery(ctx, id)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	err = c.reservationDao.RequeueWebhookDelivery(ctx, id, now)
+	if errors.Is(err, dao.ErrNotFound) {
+		err = notFoundf("no webhook delivery with that ID")
+	}
+	if err != nil {
+		return
+	}
+	delivery.Status = model.WebhookPending
+	delivery.Attempts = 0
+	delivery.NextAttemptAt = now
+	slog.InfoContext(ctx, "webhook delivery requeued", "delivery_id", id, "subscription_id", delivery.SubscriptionID)
+	return
+}
+
+func validateWebhookURL(raw string) error {
+	parsed, err := url.Parse(raw)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return invalidf("the URL must be an absolute http or https URL")
+	}
+	return nil
+}
+
+// validateEventTypes checks the types are ones there are events for and drops duplicates
+func validateEventTypes(eventTypes []string) (valid []string, err error) {
+	if len(eventTypes) == 0 {
+		return nil, invalidf("subscribe to at least one event type")
+	}
+	for _, eventType := range eventTypes {
+		if !slices.Contains(outbox.Types, eventType) {
+			return nil, invalidf("unknown event type %q", eventType)
+		}
+		if !slices.Contains(valid, eventType) {
+			valid = append(valid, eventType)
+		}
+	}
+	return
+}
+
+func newWebhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(secret), nil
+}
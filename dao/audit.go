@@ -0,0 +1,223 @@
+package dao
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"henrymeds-takehome/audit"
+	"henrymeds-takehome/logging"
+	"henrymeds-takehome/model"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	gopg "github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+)
+
+// the columns audit entries leave out, hashes of credentials and signing secrets
+var redactedColumns = map[string]bool{
+	"calendar_token_hash": true,
+	"api_key_hash":        true,
+	"secret":              true,
+}
+
+// change is what an audit entry is made from. before and after are model rows, nil for none, and name the entity
+// unless entityType is set, for a change to many rows filed under the one they belong to. Those are slices of rows
+type change struct {
+	action string
+	before any
+	after  any
+
+	entityType     string
+	entityID       string
+	organizationID string
+}
+
+// audit appends an entry per change to the chains of the rows' organizations, call it in the transaction that makes
+// the changes
+func (d *dao) audit(ctx context.Context, changes ...change) (err error) {
+	var (
+		entries  = make([]model.AuditEntry, 0, len(changes))
+		now      = time.Now().UTC().Truncate(time.Microsecond)
+		previous []byte
+	)
+	for _, change := range changes {
+		entry := model.AuditEntry{
+			OrganizationID: change.organizationID,
+			OccurredAt:     now,
+			Actor:          audit.Actor(ctx),
+			Action:         change.action,
+			EntityType:     change.entityType,
+			EntityID:       change.entityID,
+			RequestID:      logging.RequestID(ctx),
+		}
+		if entry.Before, err = snapshot(change.before); err != nil {
+			return
+		}
+		if entry.After, err = snapshot(change.after); err != nil {
+			return
+		}
+		if entry.EntityType == "" {
+			row := change.after
+			if row == nil {
+				row = change.before
+			}
+			if entry.OrganizationID, err = d.organizationOfRow(ctx, row); err != nil {
+				return
+			}
+			table := orm.GetTable(reflect.TypeOf(row))
+			entry.EntityType = strings.Trim(string(table.SQLName), `"`)
+			entry.EntityID = fmt.Sprint(table.PKs[0].Value(reflect.ValueOf(row)).Interface())
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) == 0 {
+		return
+	}
+
+	// a chain at a time in a fixed order, two transactions appending to the same chains can't deadlock on them
+	slices.SortStableFunc(entries, func(a, b model.AuditEntry) int {
+		return strings.Compare(a.OrganizationID, b.OrganizationID)
+	})
+	for i := range entries {
+		if i == 0 || entries[i].OrganizationID != entries[i-1].OrganizationID {
+			if previous, err = d.lockChain(ctx, entries[i].OrganizationID); err != nil {
+				return
+			}
+		}
+		entries[i].PrevHash = previous
+		entries[i].Hash = audit.Hash(previous, entries[i])
+		previous = entries[i].Hash
+	}
+	_, err = d.db.ModelContext(ctx, &entries).Insert()
+	return
+}
+
+// lockChain holds the organization's chain until the transaction ends and returns the hash of its last entry, nil
+// for a chain without entries. Held, nothing else can append to the chain in between
+func (d *dao) lockChain(ctx context.Context, organizationID string) (last []byte, err error) {
+	key := sha256.Sum256([]byte("audit_log:" + organizationID))
+	_, err = d.db.ExecContext(ctx, "SELECT pg_advisory_xact_lock(?)", int64(binary.BigEndian.Uint64(key[:8])))
+	if err != nil {
+		return
+	}
+	var query = d.db.ModelContext(ctx, (*model.AuditEntry)(nil)).Column("hash").OrderExpr("seq DESC").Limit(1)
+	if organizationID == "" {
+		query.Where("organization_id IS NULL")
+	} else {
+		query.Where("organization_id = ?", organizationID)
+	}
+	err = query.Select(&last)
+	if errors.Is(err, gopg.ErrNoRows) {
+		err = nil
+	}
+	return
+}
+
+// organizationOfRow is the chain a row's entries go in: its organization's, the organization itself's for an
+// organization, the reservation's for rows about one, and the chain of no organization for the rest
+func (d *dao) organizationOfRow(ctx context.Context, row any) (id string, err error) {
+	if organization, ok := row.(model.Organization); ok {
+		return organization.ID, nil
+	}
+	var (
+		table = orm.GetTable(reflect.TypeOf(row))
+		value = reflect.ValueOf(row)
+	)
+	if field, ok := table.FieldsMap["organization_id"]; ok {
+		return field.Value(value).String(), nil
+	}
+	if field, ok := table.FieldsMap["reservation_id"]; ok {
+		err = d.db.ModelContext(ctx, (*model.Reservation)(nil)).Column("organization_id").Where("id = ?", field.Value(value).String()).Select(&id)
+		if errors.Is(err, gopg.ErrNoRows) {
+			err = ErrNotFound
+		}
+	}
+	return
+}
+
+// snapshot is the row as JSON by column, or an array of them for a slice of rows, nil for no row. Zero times are the
+// NULLs they're stored as
+func snapshot(rows any) (raw json.RawMessage, err error) {
+	if rows == nil {
+		return nil, nil
+	}
+	value := reflect.ValueOf(rows)
+	if value.Kind() != reflect.Slice {
+		return json.Marshal(columns(value))
+	}
+	all := make([]map[string]any, 0, value.Len())
+	for i := 0; i < value.Len(); i++ {
+		all = append(all, columns(value.Index(i)))
+	}
+	return json.Marshal(all)
+}
+
+func columns(row reflect.Value) map[string]any {
+	var (
+		table  = orm.GetTable(row.Type())
+		result = make(map[string]any, len(table.Fields))
+	)
+	for _, field := range table.Fields {
+		if redactedColumns[field.SQLName] {
+			continue
+		}
+		column := field.Value(row).Interface()
+		if t, ok := column.(time.Time); ok && t.IsZero() {
+			column = nil
+		}
+		result[field.SQLName] = column
+	}
+	return result
+}
+
+// lockRows reads the rows the condition picks and locks them until the transaction ends, the before of a change to
+// them. Tables with an organization are scoped like any other query on them
+func lockRows[T any](ctx context.Context, d *dao, condition string, params ...any) (rows []T, err error) {
+	var query = d.db.ModelContext(ctx, &rows).Where(condition, params...).For("UPDATE")
+	if _, ok := orm.GetTable(reflect.TypeOf(rows).Elem()).FieldsMap["organization_id"]; ok {
+		scoped(ctx, query)
+	}
+	err = query.Select()
+	return
+}
+
+// lockRow is lockRows for the row with the ID, ErrNotFound if there is no such row
+func lockRow[T any](ctx context.Context, d *dao, id string) (row T, err error) {
+	var rows []T
+	rows, err = lockRows[T](ctx, d, "id = ?", id)
+	if err == nil && len(rows) == 0 {
+		err = ErrNotFound
+	}
+	if err != nil {
+		return
+	}
+	return rows[0], nil
+}
+
+// changed is a change per row, the after of each is the row with apply's changes
+func changed[T any](action string, rows []T, apply func(*T)) []change {
+	changes := make([]change, 0, len(rows))
+	for _, before := range rows {
+		after := before
+		apply(&after)
+		changes = append(changes, change{action: action, before: before, after: after})
+	}
+	return changes
+}
+
+// reverted is changed for rows that already changed, the before of each is the row with undo's changes
+func reverted[T any](action string, rows []T, undo func(*T)) []change {
+	changes := make([]change, 0, len(rows))
+	for _, after := range rows {
+		before := after
+		undo(&before)
+		changes = append(changes, change{action: action, before: before, after: after})
+	}
+	return changes
+}
@@ -0,0 +1,172 @@
+package dao
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"henrymeds-takehome/audit"
+	"henrymeds-takehome/model"
+	"henrymeds-takehome/tenant"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestSnapshotRedacts(t *testing.T) {
+	for name, c := range map[string]struct {
+		row    any
+		secret string
+		// the secret's value, it mustn't be under another column either
+		value string
+		kept  []string
+	}{
+		"user's calendar token": {
+			row:    model.User{ID: uuid.NewString(), Username: "provider", CalendarTokenHash: []byte("calendar-token-hash")},
+			secret: "calendar_token_hash",
+			value:  "calendar-token-hash",
+			kept:   []string{"username", "email"},
+		},
+		"organization's API key": {
+			row:    model.Organization{ID: uuid.NewString(), Name: "clinic", APIKeyHash: []byte("api-key-hash")},
+			secret: "api_key_hash",
+			value:  "api-key-hash",
+			kept:   []string{"name"},
+		},
+		"webhook's signing secret": {
+			row:    model.WebhookSubscription{ID: uuid.NewString(), URL: "https://hooks.example.com", Secret: "a-secret-long-enough"},
+			secret: "secret",
+			value:  "a-secret-long-enough",
+			kept:   []string{"url", "active"},
+		},
+		"signing secrets of many webhooks": {
+			row: []model.WebhookSubscription{
+				{ID: uuid.NewString(), URL: "https://hooks.example.com/1", Secret: "a-secret-long-enough"},
+				{ID: uuid.NewString(), URL: "https://hooks.example.com/2", Secret: "another-secret-long-enough"},
+			},
+			secret: "secret",
+			value:  "secret-long-enough",
+			kept:   []string{"url"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			raw, err := snapshot(c.row)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var rows []map[string]any
+			if strings.HasPrefix(string(raw), "[") {
+				err = json.Unmarshal(raw, &rows)
+			} else {
+				rows = make([]map[string]any, 1)
+				err = json.Unmarshal(raw, &rows[0])
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, row := range rows {
+				if _, ok := row[c.secret]; ok {
+					t.Fatalf("the snapshot has %s: %s", c.secret, raw)
+				}
+				for _, column := range c.kept {
+					if _, ok := row[column]; !ok {
+						t.Fatalf("the snapshot is missing %s: %s", column, raw)
+					}
+				}
+			}
+			// bytes are base64 in JSON
+			for _, value := range []string{c.value, base64.StdEncoding.EncodeToString([]byte(c.value))} {
+				if strings.Contains(string(raw), value) {
+					t.Fatalf("the snapshot has %s: %s", value, raw)
+				}
+			}
+		})
+	}
+
+	if raw, err := snapshot(nil); err != nil || raw != nil {
+		t.Fatalf("no row is %s, err %v", raw, err)
+	}
+	// zero times are stored as NULLs
+	raw, err := snapshot(model.Organization{ID: uuid.NewString(), Name: "clinic"})
+	if err != nil || !strings.Contains(string(raw), `"created_at":null`) {
+		t.Fatalf("got %s, err %v", raw, err)
+	}
+}
+
+// changes made at once append to the chain one after the other, it still verifies from the start
+func TestAuditChain(t *testing.T) {
+	var (
+		ctx          = context.Background()
+		d            = NewReservationDao(testDB(t), nil)
+		organization = newTestOrganization(t, d)
+		start        = time.Now().UTC().Truncate(time.Hour).Add(72 * time.Hour)
+		changes      = 5
+		inserted     sync.WaitGroup
+	)
+	organizationID, _ := tenant.FromContext(organization.ctx)
+	errs := make(chan error, changes)
+	for i := 0; i < changes; i++ {
+		inserted.Add(1)
+		go func(i int) {
+			defer inserted.Done()
+			slot := start.Add(time.Duration(i) * time.Hour)
+			_, err := d.InsertAvailabilities(audit.WithActor(organization.ctx, audit.OrganizationActor(organizationID)), []model.Availability{{
+				ProviderID: organization.providerID,
+				TimeRange:  model.TimeRange{Start: slot, End: slot.Add(time.Hour)},
+				Capacity:   1,
+				Modalities: []string{model.ModalityVideo},
+			}})
+			errs <- err
+		}(i)
+	}
+	inserted.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := d.GetAuditChain(ctx, organizationID, 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the organization's creation, then the availabilities
+	if len(entries) != changes+1 || entries[0].Action != "create" || entries[0].EntityType != "organizations" {
+		t.Fatalf("the chain is %+v", entries)
+	}
+	if broken := audit.Verify(nil, entries); broken != -1 {
+		t.Fatalf("the chain is broken at %d", broken)
+	}
+	for _, entry := range entries[1:] {
+		if entry.Actor != audit.OrganizationActor(organizationID) || entry.EntityType != "availabilities" {
+			t.Fatalf("audited %+v", entry)
+		}
+	}
+
+	// the next entry chains to the last one
+	err = d.atomically(ctx, func(tx *dao) error {
+		last, err := tx.lockChain(ctx, organizationID)
+		if err == nil && !bytes.Equal(last, entries[len(entries)-1].Hash) {
+			t.Errorf("the chain ends at %x, want %x", last, entries[len(entries)-1].Hash)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a chain without entries starts from nothing
+	err = d.atomically(ctx, func(tx *dao) error {
+		last, err := tx.lockChain(ctx, uuid.NewString())
+		if err == nil && last != nil {
+			t.Errorf("a new chain ends at %x", last)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -2,106 +2,1049 @@ package dao
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"henrymeds-takehome/encryption"
 	"henrymeds-takehome/model"
-	"log"
+	"henrymeds-takehome/tenant"
+	"log/slog"
+	"time"
 
 	gopg "github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+)
+
+var (
+	// ErrNotFound is returned when a lookup by ID finds nothing
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned alongside the postgres error when a write breaks a unique constraint
+	ErrConflict = errors.New("conflict")
 )
 
 type ReservationDao interface {
-	InsertAvailabilities(context.Context, []model.Availability) error
+	InsertAvailabilities(context.Context, []model.Availability) ([]model.Availability, error)
 	GetAvailabilities(context.Context, model.GetAvailabilities) ([]model.Availability, error)
+	// UpdateAvailability moves the availability to its new time range and capacity, ErrNotFound if there is no such
+	// availability
+	UpdateAvailability(ctx context.Context, availability model.Availability) error
+	DeleteAvailability(ctx context.Context, id string) error
 	InsertReservation(context.Context, model.Reservation) (model.Reservation, error)
 	GetReservations(context.Context, model.GetReservations) ([]model.Reservation, error)
+	// CountHolds counts the client's unconfirmed reservations that are still holding their slot as of now
+	CountHolds(ctx context.Context, clientID string, now time.Time) (count int, err error)
 	GetUser(ctx context.Context, id string) (user model.User, err error)
+	// SetCalendarToken replaces the user's calendar feed token, ErrNotFound if there is no such user
+	SetCalendarToken(ctx context.Context, userID string, tokenHash []byte) error
+	// SetUserState records where the user is located, an empty state clears it. ErrNotFound if there is no such user
+	SetUserState(ctx context.Context, userID string, state string) error
 	// UpdateReservation would be a more generalized way to do this, but I took a shortcut
 	ConfirmReservation(ctx context.Context, reservationId string) error
+	// CancelReservations marks the reservations cancelled as of at, their slots are free to book again
+	CancelReservations(ctx context.Context, ids []string, at time.Time) error
+	InsertBusySource(ctx context.Context, source model.BusySource) (model.BusySource, error)
+	GetBusySources(ctx context.Context, request model.GetBusySources) ([]model.BusySource, error)
+	// UpdateBusySource records the outcome of an import, ErrNotFound if there is no such source
+	UpdateBusySource(ctx context.Context, source model.BusySource) error
+	// DeleteBusySource removes the source along with its busy blocks, ErrNotFound if there is no such source
+	DeleteBusySource(ctx context.Context, id string) error
+	// ReplaceBusyBlocks swaps the source's busy blocks for blocks, run it in a transaction so readers never see
+	// the source empty
+	ReplaceBusyBlocks(ctx context.Context, sourceID string, blocks []model.BusyBlock) error
+	// GetBusyBlocks returns the provider's busy blocks, from every source, that overlap the range, ordered by start
+	GetBusyBlocks(ctx context.Context, request model.GetBusyBlocks) ([]model.BusyBlock, error)
+	// UpsertProviderLicense adds the license, or replaces the number and expiry of the provider's license for the state
+	UpsertProviderLicense(ctx context.Context, license model.ProviderLicense) (model.ProviderLicense, error)
+	// GetProviderLicenses returns the provider's licenses ordered by state
+	GetProviderLicenses(ctx context.Context, providerID string) ([]model.ProviderLicense, error)
+	// DeleteProviderLicense removes the provider's license for the state, ErrNotFound if there is none
+	DeleteProviderLicense(ctx context.Context, providerID string, state string) error
+	// InsertLocation adds the location to ctx's tenant, or to the first organization for work without one
+	InsertLocation(ctx context.Context, location model.Location) (model.Location, error)
+	// GetLocations returns the location with the ID, or all of them when id is empty, by name
+	GetLocations(ctx context.Context, id string) ([]model.Location, error)
+	// SetReservationVideoURL records the room of the reservation's video visit, ErrNotFound if there is no such reservation
+	SetReservationVideoURL(ctx context.Context, reservationID string, url string) error
+	InsertOrganization(ctx context.Context, organization model.Organization) (model.Organization, error)
+	// GetOrganizations returns the organization with the ID, or all of them when id is empty, oldest first
+	GetOrganizations(ctx context.Context, id string) ([]model.Organization, error)
+	// SetOrganizationKey replaces the organization's API key, ErrNotFound if there is no such organization
+	SetOrganizationKey(ctx context.Context, id string, keyHash []byte) error
+	InsertWebhookSubscription(ctx context.Context, subscription model.WebhookSubscription) (model.WebhookSubscription, error)
+	// GetWebhookSubscriptions returns the subscription with the ID, or all of them when id is empty, oldest first
+	GetWebhookSubscriptions(ctx context.Context, id string) ([]model.WebhookSubscription, error)
+	// UpdateWebhookSubscription saves the URL, event types and active flag, ErrNotFound if there is no such subscription
+	UpdateWebhookSubscription(ctx context.Context, subscription model.WebhookSubscription) error
+	// DeleteWebhookSubscription removes the subscription with its delivery log, ErrNotFound if there is no such subscription
+	DeleteWebhookSubscription(ctx context.Context, id string) error
+	GetWebhookDeliveries(ctx context.Context, request model.GetWebhookDeliveries) ([]model.WebhookDelivery, error)
+	// GetWebhookAttempts returns the delivery's attempts in the order they were made
+	GetWebhookAttempts(ctx context.Context, deliveryID string) ([]model.WebhookAttempt, error)
+	// RequeueWebhookDelivery makes the delivery pending again with no attempts counted and its next attempt at at,
+	// ErrNotFound if there is no such delivery
+	RequeueWebhookDelivery(ctx context.Context, id string, at time.Time) error
+	// GetAuditEntries returns a page of the audit log ordered by when the changes were made
+	GetAuditEntries(ctx context.Context, request model.GetAuditEntries) ([]model.AuditEntry, error)
+	// GetAuditChain returns up to limit entries of the organization's chain that come after seq, in chain order. An
+	// empty organizationID is the chain of the rows that aren't any organization's
+	GetAuditChain(ctx context.Context, organizationID string, afterSeq int64, limit int) ([]model.AuditEntry, error)
+	// InsertEvents adds domain events to the outbox, call it in the transaction that makes the change they describe
+	InsertEvents(ctx context.Context, events []model.Event) error
+	// MarkExpiriesPublished picks up to limit holds that ran out before now and hasn't had their expiry published,
+	// and marks it published. The caller writes the events in the same transaction
+	MarkExpiriesPublished(ctx context.Context, now time.Time, limit int) ([]model.Reservation, error)
+	// ReplaceReminders cancels the reservation's pending reminders and schedules reminders in their place, it's how
+	// reminders are moved along with a reservation
+	ReplaceReminders(ctx context.Context, reservationID string, reminders []model.Reminder) error
+	// CancelReminders cancels the pending reminders of the reservations
+	CancelReminders(ctx context.Context, reservationIDs []string) error
+	// SpendConfirmationToken records the token as used, ErrConflict if it already was
+	SpendConfirmationToken(ctx context.Context, token model.SpentConfirmationToken) error
+	InsertWaitlistEntry(ctx context.Context, entry model.WaitlistEntry) (model.WaitlistEntry, error)
+	// GetWaitlistEntry returns the entry with the ID, ErrNotFound if there is no such entry
+	GetWaitlistEntry(ctx context.Context, id string) (model.WaitlistEntry, error)
+	// CancelWaitlistEntry cancels the entry if it's waiting or offered and returns it as it was before, ErrNotFound if
+	// there is no such entry in either status
+	CancelWaitlistEntry(ctx context.Context, id string) (model.WaitlistEntry, error)
+	// ClaimWaitlistEntries locks the waiting entries that could take a slot until the transaction ends, in the order
+	// they're offered slots. Entries another transaction has locked are skipped
+	ClaimWaitlistEntries(ctx context.Context, request model.GetWaitlistCandidates) ([]model.WaitlistEntry, error)
+	// OfferWaitlistEntry records that the entry was offered the reservation
+	OfferWaitlistEntry(ctx context.Context, id string, reservationID string, at time.Time) error
+	// SettleWaitlistOffers moves the open offers, offered or expired, of the reservations to status. An entry moved
+	// back to waiting loses its offer
+	SettleWaitlistOffers(ctx context.Context, reservationIDs []string, status string) error
+	// ReleaseExpiredHolds cancels the client's expired holds in the way of the slot and returns them
+	ReleaseExpiredHolds(ctx context.Context, request model.ReleaseExpiredHolds) ([]model.Reservation, error)
+	// LockUser takes a row lock on the user until the transaction ends, writes that check then insert
+	// (ex: overlap detection) lock the provider first so two of them can't interleave. ErrNotFound if there is no such user
+	LockUser(ctx context.Context, id string) error
+	// InTransaction runs fn with a ReservationDao bound to a single transaction, committed if fn returns nil
+	// and rolled back otherwise. Calls from inside fn join the transaction that's already open
+	InTransaction(ctx context.Context, fn func(ReservationDao) error) error
 }
 
-func NewReservationDao(db *gopg.DB) *dao {
+// NewReservationDao builds the DAO over db. The fields tagged encrypted are encrypted with encryptor, nil stores
+// them in plaintext
+func NewReservationDao(db *gopg.DB, encryptor *encryption.Encryptor) *dao {
 	return &dao{
-		db: db,
+		db:        db,
+		encryptor: encryptor,
 	}
 }
 
 type dao struct {
-	db *gopg.DB
+	// a *gopg.DB, or a *gopg.Tx for the dao handed to InTransaction's fn
+	db        orm.DB
+	encryptor *encryption.Encryptor
 }
 
-func (d *dao) InsertAvailabilities(ctx context.Context, request []model.Availability) (err error) {
-	_, err = d.db.Model(&request).Insert()
-	if err != nil {
-		log.Println("failed to insert availabilities: ", err)
-	}
-	return
+func (d *dao) InsertAvailabilities(ctx context.Context, request []model.Availability) ([]model.Availability, error) {
+	err := d.atomically(ctx, func(tx *dao) (err error) {
+		for i := range request {
+			if request[i].OrganizationID, err = tx.organizationOf(ctx, request[i].ProviderID); err != nil {
+				return
+			}
+		}
+		if _, err = tx.db.ModelContext(ctx, &request).Insert(); err != nil {
+			return translateError(err)
+		}
+		changes := make([]change, 0, len(request))
+		for _, availability := range request {
+			changes = append(changes, change{action: "create", after: availability})
+		}
+		return tx.audit(ctx, changes...)
+	})
+	return request, err
 }
 
 func (d *dao) GetAvailabilities(ctx context.Context, request model.GetAvailabilities) (availabilities []model.Availability, err error) {
 
 	// SELECT * FROM availabilities WHERE
-	// [ provider_id = ? | (?,?) OVERLAPS (start,end) ]
+	// [ provider_id = ? | (?,?) OVERLAPS (start,end) | (start,id) > (?,?) ]
+	// ORDER BY start_time, id LIMIT ?
 
-	var query = d.db.Model(&availabilities)
-	// if provider ID was set, add it to the query
-	if request.ProviderID != "" {
-		query.Where("provider_id = ?", request.ProviderID)
-	}
-	if request.TimeRange.Start.Unix() != 0 {
-		query.Where("(?,?) OVERLAPS (start_time,end_time)", request.Start, request.End)
+	err = d.reading(ctx, func(tx *dao) error {
+		var query = scoped(ctx, tx.db.ModelContext(ctx, &availabilities))
+		if request.ID != "" {
+			query.Where("id = ?", request.ID)
+		}
+		// if provider ID was set, add it to the query
+		if request.ProviderID != "" {
+			query.Where("provider_id = ?", request.ProviderID)
+		}
+		// a lookup by ID leaves the range unset
+		if !request.TimeRange.Start.IsZero() {
+			query.Where("(?,?) OVERLAPS (start_time,end_time)", request.Start, request.End)
+		}
+		paginate(query, request.Page)
+		return query.Select()
+	})
+	slog.DebugContext(ctx, "retrieved availabilities", "count", len(availabilities), "provider_id", request.ProviderID)
+	return
+}
+
+func (d *dao) UpdateAvailability(ctx context.Context, availability model.Availability) (err error) {
+	return d.atomically(ctx, func(tx *dao) (err error) {
+		var before, after model.Availability
+		if before, err = lockRow[model.Availability](ctx, tx, availability.ID); err != nil {
+			return
+		}
+		_, err = scoped(ctx, tx.db.ModelContext(ctx, &availability)).Column("start_time", "end_time", "capacity").WherePK().Update()
+		if err != nil {
+			return translateError(err)
+		}
+		after = before
+		after.TimeRange, after.Capacity = availability.TimeRange, availability.Capacity
+		return tx.audit(ctx, change{action: "update", before: before, after: after})
+	})
+}
+
+func (d *dao) DeleteAvailability(ctx context.Context, id string) (err error) {
+	return d.atomically(ctx, func(tx *dao) (err error) {
+		var before model.Availability
+		if before, err = lockRow[model.Availability](ctx, tx, id); err != nil {
+			return
+		}
+		if _, err = scoped(ctx, tx.db.ModelContext(ctx, &model.Availability{})).Where("id = ?", id).Delete(); err != nil {
+			return
+		}
+		return tx.audit(ctx, change{action: "delete", before: before})
+	})
+}
+
+func (d *dao) InsertReservation(ctx context.Context, reservation model.Reservation) (model.Reservation, error) {
+	err := d.atomically(ctx, func(tx *dao) (err error) {
+		if reservation.OrganizationID, err = tx.organizationOf(ctx, reservation.ProviderID); err != nil {
+			return
+		}
+		if err = sealRows(ctx, tx.encryptor, &reservation); err != nil {
+			return
+		}
+		if _, err = tx.db.ModelContext(ctx, &reservation).Insert(); err != nil {
+			return translateError(err)
+		}
+		return tx.audit(ctx, change{action: "create", after: reservation})
+	})
+	if err == nil {
+		err = openRows(ctx, d.encryptor, &reservation)
 	}
+	return reservation, err
+}
 
-	err = query.Select()
+func (d *dao) GetReservations(ctx context.Context, request model.GetReservations) (reservations []model.Reservation, err error) {
+	err = d.reading(ctx, func(tx *dao) error {
+		var query = scoped(ctx, tx.db.ModelContext(ctx, &reservations))
+
+		// if ID was set, add it to the query
+		if request.ID != "" {
+			query.Where("id = ?", request.ID)
+		}
+		if request.ProviderID != "" {
+			query.Where("provider_id = ?", request.ProviderID)
+		}
+		if request.ClientID != "" {
+			query.Where("client_id = ?", request.ClientID)
+		}
+		if request.ConfirmationID != "" {
+			query.Where("confirmation_id = ?", request.ConfirmationID)
+		}
+		if request.TimeRange != nil {
+			query.Where("(?,?) OVERLAPS (start_time,end_time)", request.Start, request.End)
+		}
+		paginate(query, request.Page)
+		return query.Select()
+	})
 	if err != nil {
-		log.Println("failed to retrieve availabilities: ", err)
+		return
 	}
+	slog.DebugContext(ctx, "retrieved reservations", "count", len(reservations), "range", request.TimeRange)
+	err = openRows(ctx, d.encryptor, &reservations)
 	return
 }
 
-func (d *dao) InsertReservation(ctx context.Context, reservation model.Reservation) (model.Reservation, error) {
-	var err error
+func (d *dao) CountHolds(ctx context.Context, clientID string, now time.Time) (count int, err error) {
+	err = d.reading(ctx, func(tx *dao) (err error) {
+		count, err = scoped(ctx, tx.db.ModelContext(ctx, (*model.Reservation)(nil))).
+			Where("client_id = ?", clientID).
+			Where("confirmed = false AND cancelled_at IS NULL AND expires_at > ?", now).
+			Count()
+		return
+	})
+	return
+}
 
-	_, err = d.db.Model(&reservation).Insert()
+func (d *dao) GetUser(ctx context.Context, id string) (user model.User, err error) {
+	err = d.reading(ctx, func(tx *dao) error {
+		return scoped(ctx, tx.db.ModelContext(ctx, &user)).Where("id = ?", id).Select()
+	})
+	if errors.Is(err, gopg.ErrNoRows) {
+		err = ErrNotFound
+	}
 	if err != nil {
-		log.Println("failed to insert reservation: ", err)
+		return
 	}
-	return reservation, err
+	err = openRows(ctx, d.encryptor, &user)
+	return
 }
 
-func (d *dao) GetReservations(ctx context.Context, request model.GetReservations) (reservations []model.Reservation, err error) {
-	var query = d.db.Model(&reservations)
+func (d *dao) SetCalendarToken(ctx context.Context, userID string, tokenHash []byte) (err error) {
+	return d.atomically(ctx, func(tx *dao) (err error) {
+		var before, after model.User
+		if before, err = lockRow[model.User](ctx, tx, userID); err != nil {
+			return
+		}
+		_, err = scoped(ctx, tx.db.ModelContext(ctx, &model.User{})).Where("id = ?", userID).Set("calendar_token_hash = ?", tokenHash).Update()
+		if err != nil {
+			return
+		}
+		// the hash itself is left out of the entry, it's the rotation that's recorded
+		after = before
+		after.CalendarTokenHash = tokenHash
+		return tx.audit(ctx, change{action: "rotate_calendar_token", before: before, after: after})
+	})
+}
+
+func (d *dao) SetUserState(ctx context.Context, userID string, state string) (err error) {
+	return d.atomically(ctx, func(tx *dao) (err error) {
+		var before, after model.User
+		if before, err = lockRow[model.User](ctx, tx, userID); err != nil {
+			return
+		}
+		_, err = scoped(ctx, tx.db.ModelContext(ctx, &model.User{})).Where("id = ?", userID).Set("state = NULLIF(?, '')", state).Update()
+		if err != nil {
+			return
+		}
+		after = before
+		after.State = state
+		return tx.audit(ctx, change{action: "update", before: before, after: after})
+	})
+}
 
-	// if ID was set, add it to the query
+func (d *dao) ConfirmReservation(ctx context.Context, reservationId string) (err error) {
+	return d.atomically(ctx, func(tx *dao) (err error) {
+		var reservations []model.Reservation
+		if reservations, err = lockRows[model.Reservation](ctx, tx, "id = ?", reservationId); err != nil {
+			return
+		}
+		_, err = scoped(ctx, tx.db.ModelContext(ctx, &model.Reservation{})).Where("id = ?", reservationId).Set("confirmed = ?", true).Update()
+		if err != nil {
+			return
+		}
+		return tx.audit(ctx, changed("confirm", reservations, func(reservation *model.Reservation) {
+			reservation.Confirmed = true
+		})...)
+	})
+}
+
+func (d *dao) CancelReservations(ctx context.Context, ids []string, at time.Time) (err error) {
+	if len(ids) == 0 {
+		return
+	}
+	return d.atomically(ctx, func(tx *dao) (err error) {
+		var reservations []model.Reservation
+		reservations, err = lockRows[model.Reservation](ctx, tx, "id IN (?) AND cancelled_at IS NULL", gopg.In(ids))
+		if err != nil {
+			return
+		}
+		_, err = scoped(ctx, tx.db.ModelContext(ctx, &model.Reservation{})).
+			Where("id IN (?)", gopg.In(ids)).
+			Where("cancelled_at IS NULL").
+			Set("cancelled_at = ?", at).
+			Update()
+		if err != nil {
+			return translateError(err)
+		}
+		return tx.audit(ctx, changed("cancel", reservations, func(reservation *model.Reservation) {
+			reservation.CancelledAt = at
+		})...)
+	})
+}
+
+func (d *dao) InsertBusySource(ctx context.Context, source model.BusySource) (model.BusySource, error) {
+	err := d.atomically(ctx, func(tx *dao) (err error) {
+		if source.OrganizationID, err = tx.organizationOf(ctx, source.ProviderID); err != nil {
+			return
+		}
+		if _, err = tx.db.ModelContext(ctx, &source).Insert(); err != nil {
+			return translateError(err)
+		}
+		return tx.audit(ctx, change{action: "create", after: source})
+	})
+	return source, err
+}
+
+func (d *dao) GetBusySources(ctx context.Context, request model.GetBusySources) (sources []model.BusySource, err error) {
+	err = d.reading(ctx, func(tx *dao) error {
+		var query = scoped(ctx, tx.db.ModelContext(ctx, &sources))
+		if request.ID != "" {
+			query.Where("id = ?", request.ID)
+		}
+		if request.ProviderID != "" {
+			query.Where("provider_id = ?", request.ProviderID)
+		}
+		return query.OrderExpr("created_at, id").Select()
+	})
+	return
+}
+
+func (d *dao) UpdateBusySource(ctx context.Context, source model.BusySource) (err error) {
+	return d.atomically(ctx, func(tx *dao) (err error) {
+		var before, after model.BusySource
+		if before, err = lockRow[model.BusySource](ctx, tx, source.ID); err != nil {
+			return
+		}
+		_, err = scoped(ctx, tx.db.ModelContext(ctx, &source)).Column("blocks", "skipped", "imported_at").WherePK().Update()
+		if err != nil {
+			return
+		}
+		after = before
+		after.Blocks, after.Skipped, after.ImportedAt = source.Blocks, source.Skipped, source.ImportedAt
+		return tx.audit(ctx, change{action: "import", before: before, after: after})
+	})
+}
+
+func (d *dao) DeleteBusySource(ctx context.Context, id string) (err error) {
+	return d.atomically(ctx, func(tx *dao) (err error) {
+		var before model.BusySource
+		if before, err = lockRow[model.BusySource](ctx, tx, id); err != nil {
+			return
+		}
+		// its busy blocks go with it, on delete cascade
+		if _, err = scoped(ctx, tx.db.ModelContext(ctx, &model.BusySource{})).Where("id = ?", id).Delete(); err != nil {
+			return
+		}
+		return tx.audit(ctx, change{action: "delete", before: before})
+	})
+}
+
+func (d *dao) ReplaceBusyBlocks(ctx context.Context, sourceID string, blocks []model.BusyBlock) (err error) {
+	return d.atomically(ctx, func(tx *dao) (err error) {
+		var replaced []model.BusyBlock
+		if replaced, err = lockRows[model.BusyBlock](ctx, tx, "source_id = ?", sourceID); err != nil {
+			return
+		}
+		_, err = scoped(ctx, tx.db.ModelContext(ctx, &model.BusyBlock{})).Where("source_id = ?", sourceID).Delete()
+		if err != nil {
+			return
+		}
+		if len(blocks) > 0 {
+			// every block of a source is the same provider's
+			var organizationID string
+			if organizationID, err = tx.organizationOf(ctx, blocks[0].ProviderID); err != nil {
+				return
+			}
+			for i := range blocks {
+				blocks[i].OrganizationID = organizationID
+			}
+			if _, err = tx.db.ModelContext(ctx, &blocks).Insert(); err != nil {
+				return translateError(err)
+			}
+		}
+		slog.DebugContext(ctx, "replaced busy blocks", "count", len(blocks), "source_id", sourceID)
+		if len(replaced) == 0 && len(blocks) == 0 {
+			return
+		}
+		// an import can swap thousands of blocks, they're one entry under their source
+		replacement := change{action: "replace_blocks", before: replaced, after: blocks, entityType: "busy_sources", entityID: sourceID}
+		if len(blocks) > 0 {
+			replacement.organizationID = blocks[0].OrganizationID
+		} else {
+			replacement.organizationID = replaced[0].OrganizationID
+		}
+		return tx.audit(ctx, replacement)
+	})
+}
+
+func (d *dao) GetBusyBlocks(ctx context.Context, request model.GetBusyBlocks) (blocks []model.BusyBlock, err error) {
+	err = d.reading(ctx, func(tx *dao) error {
+		return scoped(ctx, tx.db.ModelContext(ctx, &blocks)).
+			Where("provider_id = ?", request.ProviderID).
+			Where("(?,?) OVERLAPS (start_time,end_time)", request.Start, request.End).
+			OrderExpr("start_time, id").
+			Select()
+	})
+	return
+}
+
+func (d *dao) UpsertProviderLicense(ctx context.Context, license model.ProviderLicense) (model.ProviderLicense, error) {
+	err := d.atomically(ctx, func(tx *dao) (err error) {
+		var renewed []model.ProviderLicense
+		if license.OrganizationID, err = tx.organizationOf(ctx, license.ProviderID); err != nil {
+			return
+		}
+		renewed, err = lockRows[model.ProviderLicense](ctx, tx, "provider_id = ? AND state = ?", license.ProviderID, license.State)
+		if err != nil {
+			return
+		}
+		// a renewal keeps the ID and created_at of the license it replaces
+		_, err = tx.db.ModelContext(ctx, &license).
+			OnConflict("(provider_id, state) DO UPDATE").
+			Set("license_number = EXCLUDED.license_number, expires_on = EXCLUDED.expires_on").
+			Returning("*").
+			Insert()
+		if err != nil {
+			return translateError(err)
+		}
+		renewal := change{action: "create", after: license}
+		if len(renewed) > 0 {
+			renewal.action, renewal.before = "renew", renewed[0]
+		}
+		return tx.audit(ctx, renewal)
+	})
+	return license, err
+}
+
+func (d *dao) GetProviderLicenses(ctx context.Context, providerID string) (licenses []model.ProviderLicense, err error) {
+	err = d.reading(ctx, func(tx *dao) error {
+		return scoped(ctx, tx.db.ModelContext(ctx, &licenses)).Where("provider_id = ?", providerID).Order("state").Select()
+	})
+	return
+}
+
+func (d *dao) DeleteProviderLicense(ctx context.Context, providerID string, state string) (err error) {
+	return d.atomically(ctx, func(tx *dao) (err error) {
+		var licenses []model.ProviderLicense
+		if licenses, err = lockRows[model.ProviderLicense](ctx, tx, "provider_id = ? AND state = ?", providerID, state); err != nil {
+			return
+		}
+		if len(licenses) == 0 {
+			return ErrNotFound
+		}
+		_, err = scoped(ctx, tx.db.ModelContext(ctx, &model.ProviderLicense{})).
+			Where("provider_id = ?", providerID).
+			Where("state = ?", state).
+			Delete()
+		if err != nil {
+			return
+		}
+		return tx.audit(ctx, change{action: "delete", before: licenses[0]})
+	})
+}
+
+func (d *dao) InsertLocation(ctx context.Context, location model.Location) (model.Location, error) {
+	err := d.atomically(ctx, func(tx *dao) (err error) {
+		if id, ok := tenant.FromContext(ctx); ok {
+			location.OrganizationID = id
+		} else {
+			// the one the rows from before organizations went to
+			err = tx.db.ModelContext(ctx, &model.Organization{}).Column("id").Order("created_at", "id").Limit(1).Select(&location.OrganizationID)
+			if err != nil {
+				return
+			}
+		}
+		if _, err = tx.db.ModelContext(ctx, &location).Insert(); err != nil {
+			return translateError(err)
+		}
+		return tx.audit(ctx, change{action: "create", after: location})
+	})
+	return location, err
+}
+
+func (d *dao) GetLocations(ctx context.Context, id string) (locations []model.Location, err error) {
+	err = d.reading(ctx, func(tx *dao) error {
+		var query = scoped(ctx, tx.db.ModelContext(ctx, &locations))
+		if id != "" {
+			query.Where("id = ?", id)
+		}
+		return query.Order("name", "id").Select()
+	})
+	return
+}
+
+func (d *dao) SetReservationVideoURL(ctx context.Context, reservationID string, url string) (err error) {
+	return d.atomically(ctx, func(tx *dao) (err error) {
+		var before, after model.Reservation
+		if before, err = lockRow[model.Reservation](ctx, tx, reservationID); err != nil {
+			return
+		}
+		_, err = scoped(ctx, tx.db.ModelContext(ctx, &model.Reservation{})).Where("id = ?", reservationID).Set("video_url = ?", url).Update()
+		if err != nil {
+			return
+		}
+		after = before
+		after.VideoURL = url
+		return tx.audit(ctx, change{action: "open_video_room", before: before, after: after})
+	})
+}
+
+func (d *dao) InsertOrganization(ctx context.Context, organization model.Organization) (model.Organization, error) {
+	err := d.atomically(ctx, func(tx *dao) (err error) {
+		if _, err = tx.db.ModelContext(ctx, &organization).Insert(); err != nil {
+			return translateError(err)
+		}
+		return tx.audit(ctx, change{action: "create", after: organization})
+	})
+	return organization, err
+}
+
+func (d *dao) GetOrganizations(ctx context.Context, id string) (organizations []model.Organization, err error) {
+	var query = d.db.ModelContext(ctx, &organizations)
+	if id != "" {
+		query.Where("id = ?", id)
+	}
+	err = query.Order("created_at", "id").Select()
+	return
+}
+
+func (d *dao) SetOrganizationKey(ctx context.Context, id string, keyHash []byte) (err error) {
+	return d.atomically(ctx, func(tx *dao) (err error) {
+		var before, after model.Organization
+		if before, err = lockRow[model.Organization](ctx, tx, id); err != nil {
+			return
+		}
+		_, err = tx.db.ModelContext(ctx, &model.Organization{}).Where("id = ?", id).Set("api_key_hash = ?", keyHash).Update()
+		if err != nil {
+			return translateError(err)
+		}
+		// likewise the key's hash is left out
+		after = before
+		after.APIKeyHash = keyHash
+		return tx.audit(ctx, change{action: "rotate_key", before: before, after: after})
+	})
+}
+
+func (d *dao) InsertWebhookSubscription(ctx context.Context, subscription model.WebhookSubscription) (model.WebhookSubscription, error) {
+	err := d.atomically(ctx, func(tx *dao) (err error) {
+		if _, err = tx.db.ModelContext(ctx, &subscription).Insert(); err != nil {
+			return translateError(err)
+		}
+		return tx.audit(ctx, change{action: "create", after: subscription})
+	})
+	return subscription, err
+}
+
+func (d *dao) GetWebhookSubscriptions(ctx context.Context, id string) (subscriptions []model.WebhookSubscription, err error) {
+	var query = d.db.ModelContext(ctx, &subscriptions)
+	if id != "" {
+		query.Where("id = ?", id)
+	}
+	err = query.Order("created_at", "id").Select()
+	return
+}
+
+func (d *dao) UpdateWebhookSubscription(ctx context.Context, subscription model.WebhookSubscription) (err error) {
+	return d.atomically(ctx, func(tx *dao) (err error) {
+		var before, after model.WebhookSubscription
+		if before, err = lockRow[model.WebhookSubscription](ctx, tx, subscription.ID); err != nil {
+			return
+		}
+		_, err = tx.db.ModelContext(ctx, &subscription).Column("url", "event_types", "active", "updated_at").WherePK().Update()
+		if err != nil {
+			return
+		}
+		after = before
+		after.URL, after.EventTypes, after.Active, after.UpdatedAt = subscription.URL, subscription.EventTypes, subscription.Active, subscription.UpdatedAt
+		return tx.audit(ctx, change{action: "update", before: before, after: after})
+	})
+}
+
+func (d *dao) DeleteWebhookSubscription(ctx context.Context, id string) (err error) {
+	return d.atomically(ctx, func(tx *dao) (err error) {
+		var before model.WebhookSubscription
+		if before, err = lockRow[model.WebhookSubscription](ctx, tx, id); err != nil {
+			return
+		}
+		if _, err = tx.db.ModelContext(ctx, &model.WebhookSubscription{}).Where("id = ?", id).Delete(); err != nil {
+			return
+		}
+		return tx.audit(ctx, change{action: "delete", before: before})
+	})
+}
+
+func (d *dao) GetWebhookDeliveries(ctx context.Context, request model.GetWebhookDeliveries) (deliveries []model.WebhookDelivery, err error) {
+	var query = d.db.ModelContext(ctx, &deliveries)
 	if request.ID != "" {
 		query.Where("id = ?", request.ID)
 	}
-	if request.ProviderID != "" {
-		query.Where("provider_id = ?", request.ProviderID)
-	}
-	if request.ClientID != "" {
-		query.Where("client_id = ?", request.ClientID)
+	if request.SubscriptionID != "" {
+		query.Where("subscription_id = ?", request.SubscriptionID)
 	}
-	if request.TimeRange != nil {
-		fmt.Println(request.TimeRange.Start)
-		query.Where("(?,?) OVERLAPS (start_time,end_time)", request.Start, request.End)
+	if request.Status != "" {
+		query.Where("status = ?", request.Status)
 	}
+	paginateBy(query, request.Page, "created_at")
+	err = query.Select()
+	return
+}
+
+func (d *dao) GetWebhookAttempts(ctx context.Context, deliveryID string) (attempts []model.WebhookAttempt, err error) {
+	err = d.db.ModelContext(ctx, &attempts).Where("delivery_id = ?", deliveryID).Order("attempted_at", "id").Select()
+	return
+}
 
+func (d *dao) RequeueWebhookDelivery(ctx context.Context, id string, at time.Time) (err error) {
+	return d.atomically(ctx, func(tx *dao) (err error) {
+		var before, after model.WebhookDelivery
+		if before, err = lockRow[model.WebhookDelivery](ctx, tx, id); err != nil {
+			return
+		}
+		_, err = tx.db.ModelContext(ctx, &model.WebhookDelivery{}).
+			Where("id = ?", id).
+			Set("status = ?", model.WebhookPending).
+			Set("attempts = 0").
+			Set("next_attempt_at = ?", at).
+			Update()
+		if err != nil {
+			return
+		}
+		after = before
+		after.Status, after.Attempts, after.NextAttemptAt = model.WebhookPending, 0, at
+		return tx.audit(ctx, change{action: "requeue", before: before, after: after})
+	})
+}
+
+func (d *dao) GetAuditEntries(ctx context.Context, request model.GetAuditEntries) (entries []model.AuditEntry, err error) {
+	var query = scoped(ctx, d.db.ModelContext(ctx, &entries))
+	if request.OrganizationID != "" {
+		query.Where("organization_id = ?", request.OrganizationID)
+	}
+	if request.EntityType != "" {
+		query.Where("entity_type = ?", request.EntityType)
+	}
+	if request.EntityID != "" {
+		query.Where("entity_id = ?", request.EntityID)
+	}
+	if request.Actor != "" {
+		query.Where("actor = ?", request.Actor)
+	}
+	paginateBy(query, request.Page, "occurred_at")
 	err = query.Select()
 	return
 }
 
-func (d *dao) GetUser(ctx context.Context, id string) (user model.User, err error) {
-	err = d.db.Model(&user).Where("id = ?", id).Select()
-	if err != nil {
-		log.Println("failed to retrieve user: ", err)
+func (d *dao) GetAuditChain(ctx context.Context, organizationID string, afterSeq int64, limit int) (entries []model.AuditEntry, err error) {
+	var query = d.db.ModelContext(ctx, &entries).Where("seq > ?", afterSeq)
+	if organizationID == "" {
+		query.Where("organization_id IS NULL")
+	} else {
+		query.Where("organization_id = ?", organizationID)
 	}
+	err = query.Order("seq").Limit(limit).Select()
 	return
 }
 
-func (d *dao) ConfirmReservation(ctx context.Context, reservationId string) (err error) {
-	_, err = d.db.Model(&model.Reservation{}).Where("id = ?", reservationId).Set("confirmed = ?", true).Update()
-	if err != nil {
-		log.Println("failed to confirm reservation: ", err)
+// InsertEvents isn't audited, an event is itself the record of a change and goes in with it, audit entry and all
+func (d *dao) InsertEvents(ctx context.Context, events []model.Event) (err error) {
+	if len(events) == 0 {
+		return
+	}
+	_, err = d.db.ModelContext(ctx, &events).Insert()
+	return
+}
+
+func (d *dao) ReplaceReminders(ctx context.Context, reservationID string, reminders []model.Reminder) (err error) {
+	return d.atomically(ctx, func(tx *dao) (err error) {
+		err = tx.CancelReminders(ctx, []string{reservationID})
+		if err != nil || len(reminders) == 0 {
+			return
+		}
+		if _, err = tx.db.ModelContext(ctx, &reminders).Insert(); err != nil {
+			return translateError(err)
+		}
+		changes := make([]change, 0, len(reminders))
+		for _, reminder := range reminders {
+			changes = append(changes, change{action: "create", after: reminder})
+		}
+		return tx.audit(ctx, changes...)
+	})
+}
+
+func (d *dao) CancelReminders(ctx context.Context, reservationIDs []string) (err error) {
+	if len(reservationIDs) == 0 {
+		return
 	}
+	return d.atomically(ctx, func(tx *dao) (err error) {
+		var reminders []model.Reminder
+		reminders, err = lockRows[model.Reminder](ctx, tx, "reservation_id IN (?) AND status = ?", gopg.In(reservationIDs), model.ReminderPending)
+		if err != nil {
+			return
+		}
+		_, err = tx.db.ModelContext(ctx, &model.Reminder{}).
+			Where("reservation_id IN (?)", gopg.In(reservationIDs)).
+			Where("status = ?", model.ReminderPending).
+			Set("status = ?", model.ReminderCancelled).
+			Update()
+		if err != nil {
+			return
+		}
+		return tx.audit(ctx, changed("cancel", reminders, func(reminder *model.Reminder) {
+			reminder.Status = model.ReminderCancelled
+		})...)
+	})
+}
+
+func (d *dao) SpendConfirmationToken(ctx context.Context, token model.SpentConfirmationToken) (err error) {
+	return d.atomically(ctx, func(tx *dao) (err error) {
+		if _, err = tx.db.ModelContext(ctx, &token).Insert(); err != nil {
+			return translateError(err)
+		}
+		return tx.audit(ctx, change{action: "spend", after: token})
+	})
+}
+
+func (d *dao) InsertWaitlistEntry(ctx context.Context, entry model.WaitlistEntry) (model.WaitlistEntry, error) {
+	err := d.atomically(ctx, func(tx *dao) (err error) {
+		if entry.OrganizationID, err = tx.organizationOf(ctx, entry.ClientID); err != nil {
+			return
+		}
+		if _, err = tx.db.ModelContext(ctx, &entry).Insert(); err != nil {
+			return translateError(err)
+		}
+		return tx.audit(ctx, change{action: "create", after: entry})
+	})
+	return entry, err
+}
+
+func (d *dao) GetWaitlistEntry(ctx context.Context, id string) (entry model.WaitlistEntry, err error) {
+	err = d.reading(ctx, func(tx *dao) error {
+		return scoped(ctx, tx.db.ModelContext(ctx, &entry)).Where("id = ?", id).Select()
+	})
+	if errors.Is(err, gopg.ErrNoRows) {
+		err = ErrNotFound
+	}
+	return
+}
+
+func (d *dao) CancelWaitlistEntry(ctx context.Context, id string) (entry model.WaitlistEntry, err error) {
+	err = d.atomically(ctx, func(tx *dao) (err error) {
+		// the old row comes from the FROM, RETURNING only sees the new one
+		_, err = tx.db.QueryContext(ctx, &entry, `
+			UPDATE waitlist_entries AS entry SET status = ?
+			FROM waitlist_entries AS old
+			WHERE entry.id = old.id AND entry.id = ? AND entry.status IN (?, ?) AND ?
+			RETURNING old.*`, model.WaitlistCancelled, id, model.WaitlistWaiting, model.WaitlistOffered, tenantCondition(ctx, "entry.organization_id"))
+		if errors.Is(err, gopg.ErrNoRows) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return
+		}
+		after := entry
+		after.Status = model.WaitlistCancelled
+		return tx.audit(ctx, change{action: "cancel", before: entry, after: after})
+	})
 	return
 }
+
+func (d *dao) ClaimWaitlistEntries(ctx context.Context, request model.GetWaitlistCandidates) (entries []model.WaitlistEntry, err error) {
+	var query = scoped(ctx, d.db.ModelContext(ctx, &entries)).
+		Where("status = ?", model.WaitlistWaiting).
+		WhereGroup(func(query *orm.Query) (*orm.Query, error) {
+			return query.WhereOr("provider_id = ?", request.ProviderID).WhereOr("provider_id IS NULL"), nil
+		}).
+		// an entry for any provider only means any provider of the client's own organization
+		Where("organization_id = (SELECT organization_id FROM users WHERE id = ?)", request.ProviderID).
+		Where("(?,?) OVERLAPS (start_time,end_time)", request.Start, request.End)
+	if request.ByPriority {
+		query.OrderExpr("priority DESC")
+	}
+	err = query.OrderExpr("created_at, id").Limit(request.Limit).For("UPDATE SKIP LOCKED").Select()
+	return
+}
+
+func (d *dao) OfferWaitlistEntry(ctx context.Context, id string, reservationID string, at time.Time) (err error) {
+	return d.atomically(ctx, func(tx *dao) (err error) {
+		var entries []model.WaitlistEntry
+		if entries, err = lockRows[model.WaitlistEntry](ctx, tx, "id = ?", id); err != nil {
+			return
+		}
+		_, err = scoped(ctx, tx.db.ModelContext(ctx, &model.WaitlistEntry{})).
+			Where("id = ?", id).
+			Set("status = ?", model.WaitlistOffered).
+			Set("reservation_id = ?", reservationID).
+			Set("offered_at = ?", at).
+			Update()
+		if err != nil {
+			return
+		}
+		return tx.audit(ctx, changed("offer", entries, func(entry *model.WaitlistEntry) {
+			entry.Status, entry.ReservationID, entry.OfferedAt = model.WaitlistOffered, reservationID, at
+		})...)
+	})
+}
+
+func (d *dao) SettleWaitlistOffers(ctx context.Context, reservationIDs []string, status string) (err error) {
+	if len(reservationIDs) == 0 {
+		return
+	}
+	return d.atomically(ctx, func(tx *dao) (err error) {
+		var entries []model.WaitlistEntry
+		entries, err = lockRows[model.WaitlistEntry](ctx, tx, "reservation_id IN (?) AND status IN (?, ?)",
+			gopg.In(reservationIDs), model.WaitlistOffered, model.WaitlistExpired)
+		if err != nil {
+			return
+		}
+		var query = scoped(ctx, tx.db.ModelContext(ctx, &model.WaitlistEntry{})).
+			Where("reservation_id IN (?)", gopg.In(reservationIDs)).
+			Where("status IN (?, ?)", model.WaitlistOffered, model.WaitlistExpired).
+			Set("status = ?", status)
+		if status == model.WaitlistWaiting {
+			query.Set("reservation_id = NULL").Set("offered_at = NULL")
+		}
+		if _, err = query.Update(); err != nil {
+			return
+		}
+		return tx.audit(ctx, changed("settle", entries, func(entry *model.WaitlistEntry) {
+			entry.Status = status
+			if status == model.WaitlistWaiting {
+				entry.ReservationID, entry.OfferedAt = "", time.Time{}
+			}
+		})...)
+	})
+}
+
+func (d *dao) ReleaseExpiredHolds(ctx context.Context, request model.ReleaseExpiredHolds) (reservations []model.Reservation, err error) {
+	err = d.atomically(ctx, func(tx *dao) (err error) {
+		_, err = tx.db.QueryContext(ctx, &reservations, `
+			UPDATE reservations SET cancelled_at = ?
+			WHERE confirmed = false AND cancelled_at IS NULL AND expires_at <= ?
+				AND start_time = ? AND end_time = ? AND client_id = ? AND ?
+			RETURNING *`, request.Now, request.Now, request.Start, request.End, request.ClientID, tenantCondition(ctx, "organization_id"))
+		if err != nil {
+			return
+		}
+		// RETURNING has the rows as they are now, before they were the same less the cancellation
+		return tx.audit(ctx, reverted("release", reservations, func(reservation *model.Reservation) {
+			reservation.CancelledAt = time.Time{}
+		})...)
+	})
+	if err == nil {
+		err = openRows(ctx, d.encryptor, &reservations)
+	}
+	return
+}
+
+func (d *dao) MarkExpiriesPublished(ctx context.Context, now time.Time, limit int) (reservations []model.Reservation, err error) {
+	err = d.atomically(ctx, func(tx *dao) (err error) {
+		_, err = tx.db.QueryContext(ctx, &reservations, `
+			UPDATE reservations SET expiry_published_at = ?
+			WHERE id IN (
+				SELECT id FROM reservations
+				WHERE confirmed = false AND cancelled_at IS NULL AND expiry_published_at IS NULL AND expires_at <= ? AND ?
+				ORDER BY expires_at
+				LIMIT ?
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING *`, now, now, tenantCondition(ctx, "organization_id"), limit)
+		if err != nil {
+			return
+		}
+		return tx.audit(ctx, reverted("publish_expiry", reservations, func(reservation *model.Reservation) {
+			reservation.ExpiryPublishedAt = time.Time{}
+		})...)
+	})
+	if err == nil {
+		err = openRows(ctx, d.encryptor, &reservations)
+	}
+	return
+}
+
+func (d *dao) LockUser(ctx context.Context, id string) (err error) {
+	err = scoped(ctx, d.db.ModelContext(ctx, &model.User{})).Column("id").Where("id = ?", id).For("UPDATE").Select()
+	if errors.Is(err, gopg.ErrNoRows) {
+		err = ErrNotFound
+	}
+	return
+}
+
+func (d *dao) InTransaction(ctx context.Context, fn func(ReservationDao) error) error {
+	return d.atomically(ctx, func(tx *dao) error {
+		return fn(tx)
+	})
+}
+
+// atomically runs fn in a transaction, or in the one d is already bound to. Every write goes through it, a change and
+// its audit entries are written together or not at all
+func (d *dao) atomically(ctx context.Context, fn func(tx *dao) error) error {
+	db, ok := d.db.(*gopg.DB)
+	if !ok {
+		return fn(d)
+	}
+	return db.RunInTransaction(ctx, func(tx *gopg.Tx) (err error) {
+		// the row level security policies only let the transaction at the tenant's rows, a backstop for a query
+		// that isn't scoped
+		if id, ok := tenant.FromContext(ctx); ok {
+			_, err = tx.ExecContext(ctx, "SELECT set_config('app.tenant_id', ?, true)", id)
+			if err != nil {
+				return
+			}
+		}
+		return fn(&dao{db: tx, encryptor: d.encryptor})
+	})
+}
+
+// reading runs the reads of a scoped request in a transaction, so app.tenant_id is set for them too and the row level
+// security policies hold them to the tenant like they do writes. Work without a tenant reads as it is
+func (d *dao) reading(ctx context.Context, fn func(tx *dao) error) error {
+	if _, ok := tenant.FromContext(ctx); !ok {
+		return fn(d)
+	}
+	return d.atomically(ctx, fn)
+}
+
+// scoped keeps the query to the rows of ctx's tenant, see the tenant package. Work without one, the workers and the
+// CLI, sees every organization
+func scoped(ctx context.Context, query *orm.Query) *orm.Query {
+	if id, ok := tenant.FromContext(ctx); ok {
+		query.Where("?TableAlias.organization_id = ?", id)
+	}
+	return query
+}
+
+// tenantCondition is scoped for raw SQL, a condition on the column that holds for every row without a tenant
+func tenantCondition(ctx context.Context, column string) *orm.SafeQueryAppender {
+	if id, ok := tenant.FromContext(ctx); ok {
+		return gopg.SafeQuery("? = ?", gopg.Ident(column), id)
+	}
+	return gopg.SafeQuery("TRUE")
+}
+
+// organizationOf is the organization new rows about the user go in, ctx's tenant or else the user's own. The
+// foreign keys refuse a row whose users are in another organization than the row
+func (d *dao) organizationOf(ctx context.Context, userID string) (id string, err error) {
+	if id, ok := tenant.FromContext(ctx); ok {
+		return id, nil
+	}
+	err = d.db.ModelContext(ctx, &model.User{}).Column("organization_id").Where("id = ?", userID).Select(&id)
+	if errors.Is(err, gopg.ErrNoRows) {
+		err = ErrNotFound
+	}
+	return
+}
+
+// paginate orders the query by (start_time, id) and picks up after the page's key. The row comparison is
+// what makes it a keyset, postgres walks the index from the key instead of counting past an offset
+func paginate(query *orm.Query, page model.Page) {
+	paginateBy(query, page, "start_time")
+}
+
+// paginateBy pages on (column, id), the page key's start is the row's column
+func paginateBy(query *orm.Query, page model.Page, column string) {
+	direction, comparison := "ASC", ">"
+	if page.Descending() {
+		direction, comparison = "DESC", "<"
+	}
+	if page.After != nil {
+		query.Where("("+column+",id) "+comparison+" (?,?)", page.After.Start, page.After.ID)
+	}
+	query.OrderExpr(column + " " + direction + ", id " + direction)
+	if page.Limit > 0 {
+		query.Limit(page.Limit)
+	}
+}
+
+// translateError tags the postgres errors the layers above care about, the original error stays in the chain
+func translateError(err error) error {
+	var pgErr gopg.Error
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+	switch pgErr.Field('C') {
+	case "23505":
+		// unique_violation, the schema's last line of defense against double booking
+		return errors.Join(ErrConflict, err)
+	case "23503":
+		// foreign_key_violation, the row points at a user that doesn't exist or is in another organization
+		return errors.Join(ErrNotFound, err)
+	}
+	return err
+}
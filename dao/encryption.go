@@ -0,0 +1,189 @@
+package dao
+
+import (
+	"context"
+	"errors"
+	"henrymeds-takehome/encryption"
+	"henrymeds-takehome/model"
+	"reflect"
+	"strings"
+
+	"github.com/go-pg/pg/v10/orm"
+)
+
+// the tag that has a model field encrypted at rest, ex: `encrypted:"true"`. Only string fields can have it
+const encryptedTag = "encrypted"
+
+// the lowest UUID, where a walk over a table by ID starts
+const firstID = "00000000-0000-0000-0000-000000000000"
+
+var errNoEncryptor = errors.New("found an encrypted value but encryption.kms is none")
+
+// KeyRotationDao moves the encrypted columns onto the current master key, see the reencrypt command
+type KeyRotationDao interface {
+	// ReEncrypt encrypts every value of the encrypted columns that isn't under the current master key again, values
+	// stored before encryption was turned on included. It goes batchSize rows at a time, a transaction each, and
+	// returns how many rows of each table it rewrote
+	ReEncrypt(ctx context.Context, batchSize int) (rewritten map[string]int, err error)
+}
+
+func (d *dao) ReEncrypt(ctx context.Context, batchSize int) (rewritten map[string]int, err error) {
+	if d.encryptor == nil {
+		return nil, errors.New("encryption.kms is none, there's no key to encrypt with")
+	}
+	rewritten = map[string]int{}
+	if rewritten["users"], err = reEncrypt[model.User](ctx, d, batchSize); err != nil {
+		return
+	}
+	rewritten["reservations"], err = reEncrypt[model.Reservation](ctx, d, batchSize)
+	return
+}
+
+// reEncrypt walks the table by ID. It isn't scoped, it's for the CLI, which sees every organization anyway
+func reEncrypt[T any](ctx context.Context, d *dao, batchSize int) (rewritten int, err error) {
+	var (
+		table   = orm.GetTable(reflect.TypeOf((*T)(nil)).Elem())
+		columns []string
+		afterID = firstID
+	)
+	for _, field := range encryptedFields(table) {
+		columns = append(columns, field.SQLName)
+	}
+	for {
+		var rows []T
+		err = d.atomically(ctx, func(tx *dao) (err error) {
+			err = tx.db.ModelContext(ctx, &rows).Where("id > ?", afterID).Order("id").Limit(batchSize).For("UPDATE").Select()
+			if err != nil {
+				return
+			}
+			var changes []change
+			for _, before := range rows {
+				after := before
+				var stale bool
+				if stale, err = tx.rotate(ctx, &after); err != nil {
+					return
+				}
+				if !stale {
+					continue
+				}
+				if _, err = tx.db.ModelContext(ctx, &after).Column(columns...).WherePK().Update(); err != nil {
+					return
+				}
+				if err = tx.sealPlaintext(ctx, &before); err != nil {
+					return
+				}
+				changes = append(changes, change{action: "reencrypt", before: before, after: after})
+			}
+			rewritten += len(changes)
+			return tx.audit(ctx, changes...)
+		})
+		if err != nil || len(rows) < batchSize {
+			return
+		}
+		afterID = table.PKs[0].Value(reflect.ValueOf(rows[len(rows)-1])).String()
+	}
+}
+
+// rotate encrypts the row's values that aren't under the current master key again, stale reports whether there were any
+func (d *dao) rotate(ctx context.Context, row any) (stale bool, err error) {
+	err = eachEncrypted(row, func(value reflect.Value, label string) (err error) {
+		if d.encryptor.Current(value.String()) {
+			return
+		}
+		stale = true
+		var plaintext, envelope string
+		if plaintext, err = d.encryptor.Decrypt(ctx, value.String(), label); err != nil {
+			return
+		}
+		if envelope, err = d.encryptor.Encrypt(ctx, plaintext, label); err != nil {
+			return
+		}
+		value.SetString(envelope)
+		return
+	})
+	return
+}
+
+// sealPlaintext encrypts the row's values that were stored before encryption was turned on, the before of their
+// rewrite is audited encrypted like the after
+func (d *dao) sealPlaintext(ctx context.Context, row any) error {
+	return eachEncrypted(row, func(value reflect.Value, label string) (err error) {
+		if encryption.IsEnvelope(value.String()) {
+			return
+		}
+		var envelope string
+		if envelope, err = d.encryptor.Encrypt(ctx, value.String(), label); err != nil {
+			return
+		}
+		value.SetString(envelope)
+		return
+	})
+}
+
+// sealRows encrypts the encrypted fields of rows, a pointer to a model or to a slice of them, in place. Without an
+// encryptor they're stored as they are
+func sealRows(ctx context.Context, encryptor *encryption.Encryptor, rows any) error {
+	if encryptor == nil {
+		return nil
+	}
+	return eachEncrypted(rows, func(value reflect.Value, label string) (err error) {
+		var envelope string
+		if envelope, err = encryptor.Encrypt(ctx, value.String(), label); err != nil {
+			return
+		}
+		value.SetString(envelope)
+		return
+	})
+}
+
+// openRows decrypts what sealRows encrypted, values stored before encryption was turned on are left as they are
+func openRows(ctx context.Context, encryptor *encryption.Encryptor, rows any) error {
+	return eachEncrypted(rows, func(value reflect.Value, label string) (err error) {
+		if encryptor == nil {
+			if encryption.IsEnvelope(value.String()) {
+				err = errNoEncryptor
+			}
+			return
+		}
+		var plaintext string
+		if plaintext, err = encryptor.Decrypt(ctx, value.String(), label); err != nil {
+			return
+		}
+		value.SetString(plaintext)
+		return
+	})
+}
+
+// eachEncrypted calls fn with every encrypted field of rows, a pointer to a model or to a slice of them, and the
+// label it's encrypted under, <table>.<column>
+func eachEncrypted(rows any, fn func(value reflect.Value, label string) error) error {
+	value := reflect.ValueOf(rows).Elem()
+	if value.Kind() != reflect.Slice {
+		return eachEncryptedField(value, fn)
+	}
+	for i := 0; i < value.Len(); i++ {
+		if err := eachEncryptedField(value.Index(i), fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func eachEncryptedField(row reflect.Value, fn func(value reflect.Value, label string) error) error {
+	table := orm.GetTable(row.Type())
+	for _, field := range encryptedFields(table) {
+		if err := fn(field.Value(row), strings.Trim(string(table.SQLName), `"`)+"."+field.SQLName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func encryptedFields(table *orm.Table) (fields []*orm.Field) {
+	for _, field := range table.Fields {
+		if field.Field.Tag.Get(encryptedTag) == "true" {
+			fields = append(fields, field)
+		}
+	}
+	return
+}
@@ -0,0 +1,187 @@
+package dao
+
+import (
+	"context"
+	"errors"
+	"henrymeds-takehome/encryption"
+	"henrymeds-takehome/model"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// the test databases are shared, rows encrypted by earlier runs have to decrypt in later ones, so the keys are fixed.
+// Everything ends up under testKey, the key the other packages' tests encrypt with too
+const (
+	testOldKey = "test-old Tshkpfmx1CPqXE8VRSHi/TgN2Bb8/x20OZn3znrwfac="
+	testKey    = "test Y4t461jz2tc7wBI3xl/JW8CrlAuwPE0P36QG7MqnsAA="
+)
+
+// testEncryptor encrypts under the last of the key file lines
+func testEncryptor(t *testing.T, lines ...string) *encryption.Encryptor {
+	path := filepath.Join(t.TempDir(), "keys.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	kms, err := encryption.NewFileKMS(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encryption.NewEncryptor(kms, time.Hour)
+}
+
+func TestSealAndOpenRows(t *testing.T) {
+	var (
+		ctx       = context.Background()
+		encryptor = testEncryptor(t, testKey)
+		users     = []model.User{
+			{ID: uuid.NewString(), Username: "client", Email: "client@example.com", Phone: "555-0100", State: "CA"},
+			{ID: uuid.NewString(), Username: "provider"},
+		}
+		sealed = append([]model.User(nil), users...)
+	)
+	if err := sealRows(ctx, encryptor, &sealed); err != nil {
+		t.Fatal(err)
+	}
+	if !encryption.IsEnvelope(sealed[0].Email) || !encryption.IsEnvelope(sealed[0].Phone) {
+		t.Fatalf("sealed to %+v", sealed[0])
+	}
+	// only the tagged fields are encrypted, and no value stays no value
+	if sealed[0].Username != "client" || sealed[0].State != "CA" || sealed[1].Email != "" || sealed[1].Phone != "" {
+		t.Fatalf("sealed to %+v", sealed)
+	}
+
+	// the label is the table and column, an email doesn't open as a phone number
+	swapped := sealed[0]
+	swapped.Email, swapped.Phone = swapped.Phone, swapped.Email
+	if err := openRows(ctx, encryptor, &swapped); err == nil {
+		t.Fatalf("opened a phone number as an email: %+v", swapped)
+	}
+
+	if err := openRows(ctx, encryptor, &sealed); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(sealed, users) {
+		t.Fatalf("opened to %+v, want %+v", sealed, users)
+	}
+
+	reservation := model.Reservation{ID: uuid.NewString(), Reason: "follow up on my prescription"}
+	if err := sealRows(ctx, encryptor, &reservation); err != nil {
+		t.Fatal(err)
+	}
+	if !encryption.IsEnvelope(reservation.Reason) {
+		t.Fatalf("the reason was sealed to %s", reservation.Reason)
+	}
+	// a model without the encryptor reads plaintext, and refuses to pass an envelope off as a value
+	plaintext := model.Reservation{ID: uuid.NewString(), Reason: "written before encryption was turned on"}
+	if err := openRows(ctx, nil, &plaintext); err != nil || plaintext.Reason != "written before encryption was turned on" {
+		t.Fatalf("got %+v, err %v", plaintext, err)
+	}
+	if err := openRows(ctx, nil, &reservation); !errors.Is(err, errNoEncryptor) {
+		t.Fatalf("got %v, want errNoEncryptor", err)
+	}
+}
+
+func TestReEncrypt(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		db    = testDB(t)
+		old   = NewReservationDao(db, testEncryptor(t, testOldKey))
+		start = time.Now().UTC().Truncate(time.Hour).Add(72 * time.Hour)
+	)
+	organization := newTestOrganization(t, old)
+	// stored before encryption was turned on
+	_, err := db.ModelContext(ctx, &model.User{}).
+		Set("email = ?", "client@example.com").
+		Set("phone = ?", "555-0100").
+		Where("id = ?", organization.client).
+		Update()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// and under the old key
+	if _, err = old.InsertAvailabilities(organization.ctx, []model.Availability{{
+		ProviderID: organization.providerID,
+		TimeRange:  model.TimeRange{Start: start, End: start.Add(time.Hour)},
+		Capacity:   1,
+		Modalities: []string{model.ModalityVideo},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	reservation, err := old.InsertReservation(organization.ctx, model.Reservation{
+		ClientID:   organization.client,
+		ProviderID: organization.providerID,
+		TimeRange:  model.TimeRange{Start: start, End: start.Add(30 * time.Minute)},
+		ExpiresAt:  time.Now().Add(time.Hour),
+		Modality:   model.ModalityVideo,
+		Reason:     "follow up on my prescription",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the new key is added to the file, the batches are small so the walk goes over several
+	var (
+		encryptor = testEncryptor(t, testOldKey, testKey)
+		current   = NewReservationDao(db, encryptor)
+	)
+	rewritten, err := current.ReEncrypt(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rewritten["users"] < 1 || rewritten["reservations"] < 1 {
+		t.Fatalf("rewrote %v", rewritten)
+	}
+
+	var (
+		user   model.User
+		stored model.Reservation
+	)
+	if err = db.ModelContext(ctx, &user).Where("id = ?", organization.client).Select(); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.ModelContext(ctx, &stored).Where("id = ?", reservation.ID).Select(); err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range []string{user.Email, user.Phone, stored.Reason} {
+		if !encryption.IsEnvelope(value) || !encryptor.Current(value) {
+			t.Fatalf("%s isn't under the current key", value)
+		}
+	}
+	if user, err = current.GetUser(organization.ctx, organization.client); err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "client@example.com" || user.Phone != "555-0100" {
+		t.Fatalf("the user decrypts to %+v", user)
+	}
+	reservations, err := current.GetReservations(organization.ctx, model.GetReservations{ID: reservation.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reservations) != 1 || reservations[0].Reason != "follow up on my prescription" {
+		t.Fatalf("the reservation decrypts to %+v", reservations)
+	}
+
+	// the rewrites are audited with the envelopes, never the plaintext
+	var snapshots []string
+	_, err = db.QueryContext(ctx, &snapshots, `SELECT coalesce(before::text, '') || coalesce(after::text, '') FROM audit_log WHERE action = 'reencrypt' AND entity_id IN (?, ?)`,
+		organization.client, reservation.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 {
+		t.Fatalf("audited %d rewrites, want the user's and the reservation's", len(snapshots))
+	}
+	for _, snapshot := range snapshots {
+		for _, plaintext := range []string{"client@example.com", "555-0100", "follow up on my prescription"} {
+			if strings.Contains(snapshot, plaintext) {
+				t.Fatalf("the audit log has %s: %s", plaintext, snapshot)
+			}
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package dao
+
+import (
+	"context"
+	"henrymeds-takehome/encryption"
+	"henrymeds-takehome/model"
+	"time"
+
+	gopg "github.com/go-pg/pg/v10"
+)
+
+// the label the stored responses are encrypted under
+const idempotencyBodyLabel = "idempotency_keys.body"
+
+// IdempotencyDao stores the responses to requests sent with an Idempotency-Key
+type IdempotencyDao interface {
+	// ClaimIdempotencyKey records the key as in progress. If the key was already claimed, and hasn't expired,
+	// claimed is false and existing holds what was stored for it
+	ClaimIdempotencyKey(ctx context.Context, key string, fingerprint string) (existing model.IdempotencyRecord, claimed bool, err error)
+	CompleteIdempotencyKey(ctx context.Context, record model.IdempotencyRecord) error
+	// ReleaseIdempotencyKey forgets the key so the request can be retried
+	ReleaseIdempotencyKey(ctx context.Context, key string) error
+}
+
+// NewIdempotencyDao builds the DAO over db. A response can have patient details in it, ex: the reason of a
+// reservation, so the bodies are encrypted with encryptor, nil stores them as they are
+func NewIdempotencyDao(db *gopg.DB, ttl time.Duration, encryptor *encryption.Encryptor) *idempotencyDao {
+	return &idempotencyDao{
+		db:        db,
+		ttl:       ttl,
+		encryptor: encryptor,
+	}
+}
+
+type idempotencyDao struct {
+	db        *gopg.DB
+	ttl       time.Duration
+	encryptor *encryption.Encryptor
+}
+
+func (d *idempotencyDao) ClaimIdempotencyKey(ctx context.Context, key string, fingerprint string) (existing model.IdempotencyRecord, claimed bool, err error) {
+	// an expired key is free to be claimed again
+	_, err = d.db.ModelContext(ctx, &model.IdempotencyRecord{}).
+		Where("key = ?", key).
+		Where("created_at < ?", time.Now().Add(-d.ttl)).
+		Delete()
+	if err != nil {
+		return
+	}
+
+	result, err := d.db.ModelContext(ctx, &model.IdempotencyRecord{
+		Key:         key,
+		Fingerprint: fingerprint,
+		CreatedAt:   time.Now(),
+	}).OnConflict("DO NOTHING").Insert()
+	if err != nil {
+		return
+	}
+	if result.RowsAffected() == 1 {
+		claimed = true
+		return
+	}
+
+	err = d.db.ModelContext(ctx, &existing).Where("key = ?", key).Select()
+	if err != nil || !encryption.IsEnvelope(string(existing.Body)) {
+		return
+	}
+	if d.encryptor == nil {
+		return existing, false, errNoEncryptor
+	}
+	body, err := d.encryptor.Decrypt(ctx, string(existing.Body), idempotencyBodyLabel)
+	existing.Body = []byte(body)
+	return
+}
+
+func (d *idempotencyDao) CompleteIdempotencyKey(ctx context.Context, record model.IdempotencyRecord) (err error) {
+	record.Completed = true
+	if d.encryptor != nil {
+		var body string
+		if body, err = d.encryptor.Encrypt(ctx, string(record.Body), idempotencyBodyLabel); err != nil {
+			return
+		}
+		record.Body = []byte(body)
+	}
+	_, err = d.db.ModelContext(ctx, &record).
+		Column("completed", "status", "content_type", "body").
+		WherePK().
+		Update()
+	return
+}
+
+func (d *idempotencyDao) ReleaseIdempotencyKey(ctx context.Context, key string) (err error) {
+	_, err = d.db.ModelContext(ctx, &model.IdempotencyRecord{}).Where("key = ?", key).Delete()
+	return
+}
@@ -0,0 +1,281 @@
+package dao
+
+import (
+	"context"
+	"henrymeds-takehome/metrics"
+	"henrymeds-takehome/model"
+	"time"
+)
+
+// NewInstrumentedDao wraps a ReservationDao and records the duration and outcome of every call. The reservation
+// lifecycle counters are the controller's, a call made in a transaction that rolls back changed nothing
+func NewInstrumentedDao(next ReservationDao) *instrumentedDao {
+	return &instrumentedDao{
+		next: next,
+	}
+}
+
+type instrumentedDao struct {
+	next ReservationDao
+}
+
+func observe(operation string, start time.Time, err error) {
+	outcome := "ok"
+	if err != nil {
+		outcome = "error"
+	}
+	metrics.DaoQueryDuration.WithLabelValues(operation, outcome).Observe(time.Since(start).Seconds())
+}
+
+func (d *instrumentedDao) InsertAvailabilities(ctx context.Context, availabilities []model.Availability) (inserted []model.Availability, err error) {
+	defer func(start time.Time) { observe("InsertAvailabilities", start, err) }(time.Now())
+	return d.next.InsertAvailabilities(ctx, availabilities)
+}
+
+func (d *instrumentedDao) GetAvailabilities(ctx context.Context, request model.GetAvailabilities) (availabilities []model.Availability, err error) {
+	defer func(start time.Time) { observe("GetAvailabilities", start, err) }(time.Now())
+	return d.next.GetAvailabilities(ctx, request)
+}
+
+func (d *instrumentedDao) UpdateAvailability(ctx context.Context, availability model.Availability) (err error) {
+	defer func(start time.Time) { observe("UpdateAvailability", start, err) }(time.Now())
+	return d.next.UpdateAvailability(ctx, availability)
+}
+
+func (d *instrumentedDao) DeleteAvailability(ctx context.Context, id string) (err error) {
+	defer func(start time.Time) { observe("DeleteAvailability", start, err) }(time.Now())
+	return d.next.DeleteAvailability(ctx, id)
+}
+
+func (d *instrumentedDao) InsertReservation(ctx context.Context, reservation model.Reservation) (inserted model.Reservation, err error) {
+	defer func(start time.Time) { observe("InsertReservation", start, err) }(time.Now())
+	return d.next.InsertReservation(ctx, reservation)
+}
+
+func (d *instrumentedDao) GetReservations(ctx context.Context, request model.GetReservations) (reservations []model.Reservation, err error) {
+	defer func(start time.Time) { observe("GetReservations", start, err) }(time.Now())
+	return d.next.GetReservations(ctx, request)
+}
+
+func (d *instrumentedDao) CountHolds(ctx context.Context, clientID string, now time.Time) (count int, err error) {
+	defer func(start time.Time) { observe("CountHolds", start, err) }(time.Now())
+	return d.next.CountHolds(ctx, clientID, now)
+}
+
+func (d *instrumentedDao) GetUser(ctx context.Context, id string) (user model.User, err error) {
+	defer func(start time.Time) { observe("GetUser", start, err) }(time.Now())
+	return d.next.GetUser(ctx, id)
+}
+
+func (d *instrumentedDao) SetCalendarToken(ctx context.Context, userID string, tokenHash []byte) (err error) {
+	defer func(start time.Time) { observe("SetCalendarToken", start, err) }(time.Now())
+	return d.next.SetCalendarToken(ctx, userID, tokenHash)
+}
+
+func (d *instrumentedDao) SetUserState(ctx context.Context, userID string, state string) (err error) {
+	defer func(start time.Time) { observe("SetUserState", start, err) }(time.Now())
+	return d.next.SetUserState(ctx, userID, state)
+}
+
+func (d *instrumentedDao) ConfirmReservation(ctx context.Context, reservationId string) (err error) {
+	defer func(start time.Time) { observe("ConfirmReservation", start, err) }(time.Now())
+	return d.next.ConfirmReservation(ctx, reservationId)
+}
+
+func (d *instrumentedDao) CancelReservations(ctx context.Context, ids []string, at time.Time) (err error) {
+	defer func(start time.Time) { observe("CancelReservations", start, err) }(time.Now())
+	return d.next.CancelReservations(ctx, ids, at)
+}
+
+func (d *instrumentedDao) InsertBusySource(ctx context.Context, source model.BusySource) (inserted model.BusySource, err error) {
+	defer func(start time.Time) { observe("InsertBusySource", start, err) }(time.Now())
+	return d.next.InsertBusySource(ctx, source)
+}
+
+func (d *instrumentedDao) GetBusySources(ctx context.Context, request model.GetBusySources) (sources []model.BusySource, err error) {
+	defer func(start time.Time) { observe("GetBusySources", start, err) }(time.Now())
+	return d.next.GetBusySources(ctx, request)
+}
+
+func (d *instrumentedDao) UpdateBusySource(ctx context.Context, source model.BusySource) (err error) {
+	defer func(start time.Time) { observe("UpdateBusySource", start, err) }(time.Now())
+	return d.next.UpdateBusySource(ctx, source)
+}
+
+func (d *instrumentedDao) DeleteBusySource(ctx context.Context, id string) (err error) {
+	defer func(start time.Time) { observe("DeleteBusySource", start, err) }(time.Now())
+	return d.next.DeleteBusySource(ctx, id)
+}
+
+func (d *instrumentedDao) ReplaceBusyBlocks(ctx context.Context, sourceID string, blocks []model.BusyBlock) (err error) {
+	defer func(start time.Time) { observe("ReplaceBusyBlocks", start, err) }(time.Now())
+	return d.next.ReplaceBusyBlocks(ctx, sourceID, blocks)
+}
+
+func (d *instrumentedDao) GetBusyBlocks(ctx context.Context, request model.GetBusyBlocks) (blocks []model.BusyBlock, err error) {
+	defer func(start time.Time) { observe("GetBusyBlocks", start, err) }(time.Now())
+	return d.next.GetBusyBlocks(ctx, request)
+}
+
+func (d *instrumentedDao) UpsertProviderLicense(ctx context.Context, license model.ProviderLicense) (upserted model.ProviderLicense, err error) {
+	defer func(start time.Time) { observe("UpsertProviderLicense", start, err) }(time.Now())
+	return d.next.UpsertProviderLicense(ctx, license)
+}
+
+func (d *instrumentedDao) GetProviderLicenses(ctx context.Context, providerID string) (licenses []model.ProviderLicense, err error) {
+	defer func(start time.Time) { observe("GetProviderLicenses", start, err) }(time.Now())
+	return d.next.GetProviderLicenses(ctx, providerID)
+}
+
+func (d *instrumentedDao) DeleteProviderLicense(ctx context.Context, providerID string, state string) (err error) {
+	defer func(start time.Time) { observe("DeleteProviderLicense", start, err) }(time.Now())
+	return d.next.DeleteProviderLicense(ctx, providerID, state)
+}
+
+func (d *instrumentedDao) InsertLocation(ctx context.Context, location model.Location) (inserted model.Location, err error) {
+	defer func(start time.Time) { observe("InsertLocation", start, err) }(time.Now())
+	return d.next.InsertLocation(ctx, location)
+}
+
+func (d *instrumentedDao) GetLocations(ctx context.Context, id string) (locations []model.Location, err error) {
+	defer func(start time.Time) { observe("GetLocations", start, err) }(time.Now())
+	return d.next.GetLocations(ctx, id)
+}
+
+func (d *instrumentedDao) SetReservationVideoURL(ctx context.Context, reservationID string, url string) (err error) {
+	defer func(start time.Time) { observe("SetReservationVideoURL", start, err) }(time.Now())
+	return d.next.SetReservationVideoURL(ctx, reservationID, url)
+}
+
+func (d *instrumentedDao) InsertOrganization(ctx context.Context, organization model.Organization) (inserted model.Organization, err error) {
+	defer func(start time.Time) { observe("InsertOrganization", start, err) }(time.Now())
+	return d.next.InsertOrganization(ctx, organization)
+}
+
+func (d *instrumentedDao) GetOrganizations(ctx context.Context, id string) (organizations []model.Organization, err error) {
+	defer func(start time.Time) { observe("GetOrganizations", start, err) }(time.Now())
+	return d.next.GetOrganizations(ctx, id)
+}
+
+func (d *instrumentedDao) SetOrganizationKey(ctx context.Context, id string, keyHash []byte) (err error) {
+	defer func(start time.Time) { observe("SetOrganizationKey", start, err) }(time.Now())
+	return d.next.SetOrganizationKey(ctx, id, keyHash)
+}
+
+func (d *instrumentedDao) InsertWebhookSubscription(ctx context.Context, subscription model.WebhookSubscription) (inserted model.WebhookSubscription, err error) {
+	defer func(start time.Time) { observe("InsertWebhookSubscription", start, err) }(time.Now())
+	return d.next.InsertWebhookSubscription(ctx, subscription)
+}
+
+func (d *instrumentedDao) GetWebhookSubscriptions(ctx context.Context, id string) (subscriptions []model.WebhookSubscription, err error) {
+	defer func(start time.Time) { observe("GetWebhookSubscriptions", start, err) }(time.Now())
+	return d.next.GetWebhookSubscriptions(ctx, id)
+}
+
+func (d *instrumentedDao) UpdateWebhookSubscription(ctx context.Context, subscription model.WebhookSubscription) (err error) {
+	defer func(start time.Time) { observe("UpdateWebhookSubscription", start, err) }(time.Now())
+	return d.next.UpdateWebhookSubscription(ctx, subscription)
+}
+
+func (d *instrumentedDao) DeleteWebhookSubscription(ctx context.Context, id string) (err error) {
+	defer func(start time.Time) { observe("DeleteWebhookSubscription", start, err) }(time.Now())
+	return d.next.DeleteWebhookSubscription(ctx, id)
+}
+
+func (d *instrumentedDao) GetWebhookDeliveries(ctx context.Context, request model.GetWebhookDeliveries) (deliveries []model.WebhookDelivery, err error) {
+	defer func(start time.Time) { observe("GetWebhookDeliveries", start, err) }(time.Now())
+	return d.next.GetWebhookDeliveries(ctx, request)
+}
+
+func (d *instrumentedDao) GetWebhookAttempts(ctx context.Context, deliveryID string) (attempts []model.WebhookAttempt, err error) {
+	defer func(start time.Time) { observe("GetWebhookAttempts", start, err) }(time.Now())
+	return d.next.GetWebhookAttempts(ctx, deliveryID)
+}
+
+func (d *instrumentedDao) RequeueWebhookDelivery(ctx context.Context, id string, at time.Time) (err error) {
+	defer func(start time.Time) { observe("RequeueWebhookDelivery", start, err) }(time.Now())
+	return d.next.RequeueWebhookDelivery(ctx, id, at)
+}
+
+func (d *instrumentedDao) GetAuditEntries(ctx context.Context, request model.GetAuditEntries) (entries []model.AuditEntry, err error) {
+	defer func(start time.Time) { observe("GetAuditEntries", start, err) }(time.Now())
+	return d.next.GetAuditEntries(ctx, request)
+}
+
+func (d *instrumentedDao) GetAuditChain(ctx context.Context, organizationID string, afterSeq int64, limit int) (entries []model.AuditEntry, err error) {
+	defer func(start time.Time) { observe("GetAuditChain", start, err) }(time.Now())
+	return d.next.GetAuditChain(ctx, organizationID, afterSeq, limit)
+}
+
+func (d *instrumentedDao) InsertEvents(ctx context.Context, events []model.Event) (err error) {
+	defer func(start time.Time) { observe("InsertEvents", start, err) }(time.Now())
+	return d.next.InsertEvents(ctx, events)
+}
+
+func (d *instrumentedDao) MarkExpiriesPublished(ctx context.Context, now time.Time, limit int) (reservations []model.Reservation, err error) {
+	defer func(start time.Time) { observe("MarkExpiriesPublished", start, err) }(time.Now())
+	return d.next.MarkExpiriesPublished(ctx, now, limit)
+}
+
+func (d *instrumentedDao) ReplaceReminders(ctx context.Context, reservationID string, reminders []model.Reminder) (err error) {
+	defer func(start time.Time) { observe("ReplaceReminders", start, err) }(time.Now())
+	return d.next.ReplaceReminders(ctx, reservationID, reminders)
+}
+
+func (d *instrumentedDao) CancelReminders(ctx context.Context, reservationIDs []string) (err error) {
+	defer func(start time.Time) { observe("CancelReminders", start, err) }(time.Now())
+	return d.next.CancelReminders(ctx, reservationIDs)
+}
+
+func (d *instrumentedDao) SpendConfirmationToken(ctx context.Context, token model.SpentConfirmationToken) (err error) {
+	defer func(start time.Time) { observe("SpendConfirmationToken", start, err) }(time.Now())
+	return d.next.SpendConfirmationToken(ctx, token)
+}
+
+func (d *instrumentedDao) InsertWaitlistEntry(ctx context.Context, entry model.WaitlistEntry) (inserted model.WaitlistEntry, err error) {
+	defer func(start time.Time) { observe("InsertWaitlistEntry", start, err) }(time.Now())
+	return d.next.InsertWaitlistEntry(ctx, entry)
+}
+
+func (d *instrumentedDao) GetWaitlistEntry(ctx context.Context, id string) (entry model.WaitlistEntry, err error) {
+	defer func(start time.Time) { observe("GetWaitlistEntry", start, err) }(time.Now())
+	return d.next.GetWaitlistEntry(ctx, id)
+}
+
+func (d *instrumentedDao) CancelWaitlistEntry(ctx context.Context, id string) (entry model.WaitlistEntry, err error) {
+	defer func(start time.Time) { observe("CancelWaitlistEntry", start, err) }(time.Now())
+	return d.next.CancelWaitlistEntry(ctx, id)
+}
+
+func (d *instrumentedDao) ClaimWaitlistEntries(ctx context.Context, request model.GetWaitlistCandidates) (entries []model.WaitlistEntry, err error) {
+	defer func(start time.Time) { observe("ClaimWaitlistEntries", start, err) }(time.Now())
+	return d.next.ClaimWaitlistEntries(ctx, request)
+}
+
+func (d *instrumentedDao) OfferWaitlistEntry(ctx context.Context, id string, reservationID string, at time.Time) (err error) {
+	defer func(start time.Time) { observe("OfferWaitlistEntry", start, err) }(time.Now())
+	return d.next.OfferWaitlistEntry(ctx, id, reservationID, at)
+}
+
+func (d *instrumentedDao) SettleWaitlistOffers(ctx context.Context, reservationIDs []string, status string) (err error) {
+	defer func(start time.Time) { observe("SettleWaitlistOffers", start, err) }(time.Now())
+	return d.next.SettleWaitlistOffers(ctx, reservationIDs, status)
+}
+
+func (d *instrumentedDao) ReleaseExpiredHolds(ctx context.Context, request model.ReleaseExpiredHolds) (reservations []model.Reservation, err error) {
+	defer func(start time.Time) { observe("ReleaseExpiredHolds", start, err) }(time.Now())
+	return d.next.ReleaseExpiredHolds(ctx, request)
+}
+
+func (d *instrumentedDao) LockUser(ctx context.Context, id string) (err error) {
+	defer func(start time.Time) { observe("LockUser", start, err) }(time.Now())
+	return d.next.LockUser(ctx, id)
+}
+
+// the transaction's duration covers everything fn does, the calls inside it are observed individually as well
+func (d *instrumentedDao) InTransaction(ctx context.Context, fn func(ReservationDao) error) (err error) {
+	defer func(start time.Time) { observe("InTransaction", start, err) }(time.Now())
+	return d.next.InTransaction(ctx, func(tx ReservationDao) error {
+		return fn(NewInstrumentedDao(tx))
+	})
+}
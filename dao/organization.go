@@ -0,0 +1,34 @@
+package dao
+
+import (
+	"context"
+	"errors"
+	"henrymeds-takehome/model"
+
+	gopg "github.com/go-pg/pg/v10"
+)
+
+// OrganizationDao is how the API key check finds the organization a key belongs to, the organizations themselves
+// are managed through ReservationDao
+type OrganizationDao interface {
+	// GetOrganizationByKeyHash returns the organization whose API key hashes to keyHash, ErrNotFound if there's none
+	GetOrganizationByKeyHash(ctx context.Context, keyHash []byte) (model.Organization, error)
+}
+
+func NewOrganizationDao(db *gopg.DB) *organizationDao {
+	return &organizationDao{
+		db: db,
+	}
+}
+
+type organizationDao struct {
+	db *gopg.DB
+}
+
+func (d *organizationDao) GetOrganizationByKeyHash(ctx context.Context, keyHash []byte) (organization model.Organization, err error) {
+	err = d.db.ModelContext(ctx, &organization).Where("api_key_hash = ?", keyHash).Select()
+	if errors.Is(err, gopg.ErrNoRows) {
+		err = ErrNotFound
+	}
+	return
+}
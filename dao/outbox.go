@@ -0,0 +1,61 @@
+package dao
+
+import (
+	"context"
+	"henrymeds-takehome/model"
+	"sort"
+	"time"
+
+	gopg "github.com/go-pg/pg/v10"
+)
+
+// OutboxDao is the dispatcher's side of the outbox, the events themselves are written through ReservationDao so they
+// commit with the change they describe
+type OutboxDao interface {
+	// ClaimEvents takes up to limit unpublished events that are due, oldest first, and pushes their next attempt out
+	// by lease. Another dispatcher won't get them until the lease runs out, which is also how an event claimed by a
+	// dispatcher that died gets published after all
+	ClaimEvents(ctx context.Context, limit int, lease time.Duration) ([]model.Event, error)
+	MarkEventPublished(ctx context.Context, id string, at time.Time) error
+	// MarkEventFailed records a failed attempt, event carries the attempt count, next attempt, error and the sinks that
+	// took it anyway to store
+	MarkEventFailed(ctx context.Context, event model.Event) error
+}
+
+func NewOutboxDao(db *gopg.DB) *outboxDao {
+	return &outboxDao{
+		db: db,
+	}
+}
+
+type outboxDao struct {
+	db *gopg.DB
+}
+
+func (d *outboxDao) ClaimEvents(ctx context.Context, limit int, lease time.Duration) (events []model.Event, err error) {
+	now := time.Now()
+	// SKIP LOCKED lets dispatchers on other instances claim the next events instead of queueing behind this one
+	_, err = d.db.QueryContext(ctx, &events, `
+		UPDATE outbox SET next_attempt_at = ?
+		WHERE id IN (
+			SELECT id FROM outbox
+			WHERE published_at IS NULL AND next_attempt_at <= ?
+			ORDER BY created_at, id
+			LIMIT ?
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`, now.Add(lease), now, limit)
+	// RETURNING doesn't keep the subquery's order
+	sort.Slice(events, func(i, j int) bool { return events[i].CreatedAt.Before(events[j].CreatedAt) })
+	return
+}
+
+func (d *outboxDao) MarkEventPublished(ctx context.Context, id string, at time.Time) (err error) {
+	_, err = d.db.ModelContext(ctx, &model.Event{}).Where("id = ?", id).Set("published_at = ?", at).Update()
+	return
+}
+
+func (d *outboxDao) MarkEventFailed(ctx context.Context, event model.Event) (err error) {
+	_, err = d.db.ModelContext(ctx, &event).Column("attempts", "next_attempt_at", "last_error", "delivered_to").WherePK().Update()
+	return
+}
@@ -0,0 +1,91 @@
+package dao
+
+import (
+	"henrymeds-takehome/model"
+	"henrymeds-takehome/tenant"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// rows that start at the same time are told apart by their ID, paging one row at a time through them in either
+// order still returns each of them exactly once
+func TestPaginateSharedStart(t *testing.T) {
+	var (
+		d            = NewReservationDao(testDB(t), nil)
+		organization = newTestOrganization(t, d)
+		start        = time.Now().UTC().Truncate(time.Hour).Add(72 * time.Hour)
+		providers    = []string{organization.providerID}
+		inserted     []model.Availability
+	)
+	organizationID, _ := tenant.FromContext(organization.ctx)
+	// availabilities of the same provider can't overlap, the ones starting together are other providers'
+	for i := 0; i < 3; i++ {
+		provider := model.User{ID: uuid.NewString(), Username: "provider", OrganizationID: organizationID}
+		if _, err := d.db.ModelContext(organization.ctx, &provider).Insert(); err != nil {
+			t.Fatal(err)
+		}
+		providers = append(providers, provider.ID)
+	}
+	for i, provider := range providers {
+		timeRanges := []model.TimeRange{{Start: start, End: start.Add(time.Hour)}}
+		if i == 0 {
+			timeRanges = append(timeRanges, model.TimeRange{Start: start.Add(-time.Hour), End: start}, model.TimeRange{Start: start.Add(time.Hour), End: start.Add(2 * time.Hour)})
+		}
+		for _, timeRange := range timeRanges {
+			availabilities, err := d.InsertAvailabilities(organization.ctx, []model.Availability{{
+				ProviderID: provider,
+				TimeRange:  timeRange,
+				Capacity:   1,
+				Modalities: []string{model.ModalityVideo},
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			inserted = append(inserted, availabilities...)
+		}
+	}
+	ascending := slices.Clone(inserted)
+	slices.SortFunc(ascending, func(a, b model.Availability) int {
+		if !a.Start.Equal(b.Start) {
+			return a.Start.Compare(b.Start)
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	descending := slices.Clone(ascending)
+	slices.Reverse(descending)
+
+	for _, test := range []struct {
+		sort string
+		want []model.Availability
+	}{
+		{sort: model.SortStartAsc, want: ascending},
+		{sort: model.SortStartDesc, want: descending},
+	} {
+		var (
+			got   []string
+			after *model.PageKey
+		)
+		for pages := 0; pages <= len(inserted); pages++ {
+			page, err := d.GetAvailabilities(organization.ctx, model.GetAvailabilities{Page: model.Page{Sort: test.sort, Limit: 1, After: after}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page) == 0 {
+				break
+			}
+			got = append(got, page[0].ID)
+			after = &model.PageKey{Start: page[0].Start, ID: page[0].ID}
+		}
+		var want []string
+		for _, availability := range test.want {
+			want = append(want, availability.ID)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("sorted by %s, the pages are %q, want %q", test.sort, got, want)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package dao
+
+import (
+	"context"
+	"time"
+
+	gopg "github.com/go-pg/pg/v10"
+)
+
+// RateLimitDao keeps the token buckets of the rate limits in Postgres, so every instance draws from the same ones.
+// It's a ratelimit.Limiter
+type RateLimitDao interface {
+	// TakeToken takes a token from the key's bucket, see ratelimit.Limiter
+	TakeToken(ctx context.Context, key string, rate float64, burst int) (allowed bool, retryAfter time.Duration, err error)
+	// PruneRateLimitBuckets deletes the buckets that filled back up, they're the same as none
+	PruneRateLimitBuckets(ctx context.Context) (pruned int, err error)
+}
+
+func NewRateLimitDao(db *gopg.DB) *rateLimitDao {
+	return &rateLimitDao{
+		db: db,
+	}
+}
+
+type rateLimitDao struct {
+	db *gopg.DB
+}
+
+// the bucket is refilled for the time since it was last touched and a token is taken if there's one, in a single
+// statement so concurrent requests for the same key queue on the row instead of both taking the last token.
+// ?0 is the key, ?1 the tokens after the refill, ?2 the burst and ?3 the rate
+const takeTokenQuery = `
+INSERT INTO rate_limit_buckets AS b (key, tokens, allowed, updated_at, full_at)
+VALUES (?0, ?2 - 1, true, now(), now() + make_interval(secs => 1 / ?3::float8))
+ON CONFLICT (key) DO UPDATE SET
+  tokens = CASE WHEN ?1 >= 1 THEN ?1 - 1 ELSE ?1 END,
+  allowed = ?1 >= 1,
+  updated_at = now(),
+  full_at = now() + make_interval(secs => (?2 - CASE WHEN ?1 >= 1 THEN ?1 - 1 ELSE ?1 END) / ?3::float8)
+RETURNING tokens, allowed`
+
+func (d *rateLimitDao) TakeToken(ctx context.Context, key string, rate float64, burst int) (allowed bool, retryAfter time.Duration, err error) {
+	var (
+		refilled = gopg.SafeQuery("LEAST(?::float8, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at) * ?::float8)", burst, rate)
+		tokens   float64
+	)
+	_, err = d.db.QueryOneContext(ctx, gopg.Scan(&tokens, &allowed), takeTokenQuery, key, refilled, float64(burst), rate)
+	if err != nil || allowed {
+		return
+	}
+	retryAfter = time.Duration((1 - tokens) / rate * float64(time.Second))
+	return
+}
+
+func (d *rateLimitDao) PruneRateLimitBuckets(ctx context.Context) (pruned int, err error) {
+	result, err := d.db.ExecContext(ctx, "DELETE FROM rate_limit_buckets WHERE full_at <= now()")
+	if err != nil {
+		return
+	}
+	pruned = result.RowsAffected()
+	return
+}
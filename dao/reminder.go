@@ -0,0 +1,92 @@
+package dao
+
+import (
+	"context"
+	"henrymeds-takehome/encryption"
+	"henrymeds-takehome/model"
+	"sort"
+	"time"
+
+	gopg "github.com/go-pg/pg/v10"
+)
+
+// ReminderDao is the reminder worker's side of the reminders, they're scheduled and cancelled through ReservationDao
+// so that commits with the reservation change
+type ReminderDao interface {
+	// ClaimReminders takes up to limit pending reminders that are due, soonest first, and pushes their next attempt out
+	// by lease so another worker won't send them too
+	ClaimReminders(ctx context.Context, limit int, lease time.Duration) ([]model.Reminder, error)
+	GetReservationsByID(ctx context.Context, ids []string) ([]model.Reservation, error)
+	GetUsersByID(ctx context.Context, ids []string) ([]model.User, error)
+	// GetLocationsByID returns the locations of in_person visits, the reminder says where to go
+	GetLocationsByID(ctx context.Context, ids []string) ([]model.Location, error)
+	// UpdateReminder saves the reminder's status, attempts, due time, next attempt, error and send time
+	UpdateReminder(ctx context.Context, reminder model.Reminder) error
+}
+
+func NewReminderDao(db *gopg.DB, encryptor *encryption.Encryptor) *reminderDao {
+	return &reminderDao{
+		db:        db,
+		encryptor: encryptor,
+	}
+}
+
+type reminderDao struct {
+	db        *gopg.DB
+	encryptor *encryption.Encryptor
+}
+
+func (d *reminderDao) ClaimReminders(ctx context.Context, limit int, lease time.Duration) (reminders []model.Reminder, err error) {
+	now := time.Now()
+	_, err = d.db.QueryContext(ctx, &reminders, `
+		UPDATE reminders SET next_attempt_at = ?
+		WHERE id IN (
+			SELECT id FROM reminders
+			WHERE status = ? AND next_attempt_at <= ?
+			ORDER BY due_at, id
+			LIMIT ?
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`, now.Add(lease), model.ReminderPending, now, limit)
+	// RETURNING doesn't keep the subquery's order
+	sort.Slice(reminders, func(i, j int) bool { return reminders[i].DueAt.Before(reminders[j].DueAt) })
+	return
+}
+
+func (d *reminderDao) GetReservationsByID(ctx context.Context, ids []string) (reservations []model.Reservation, err error) {
+	if len(ids) == 0 {
+		return
+	}
+	if err = d.db.ModelContext(ctx, &reservations).Where("id IN (?)", gopg.In(ids)).Select(); err != nil {
+		return
+	}
+	err = openRows(ctx, d.encryptor, &reservations)
+	return
+}
+
+func (d *reminderDao) GetUsersByID(ctx context.Context, ids []string) (users []model.User, err error) {
+	if len(ids) == 0 {
+		return
+	}
+	if err = d.db.ModelContext(ctx, &users).Where("id IN (?)", gopg.In(ids)).Select(); err != nil {
+		return
+	}
+	err = openRows(ctx, d.encryptor, &users)
+	return
+}
+
+func (d *reminderDao) GetLocationsByID(ctx context.Context, ids []string) (locations []model.Location, err error) {
+	if len(ids) == 0 {
+		return
+	}
+	err = d.db.ModelContext(ctx, &locations).Where("id IN (?)", gopg.In(ids)).Select()
+	return
+}
+
+func (d *reminderDao) UpdateReminder(ctx context.Context, reminder model.Reminder) (err error) {
+	_, err = d.db.ModelContext(ctx, &reminder).
+		Column("status", "attempts", "due_at", "next_attempt_at", "last_error", "sent_at").
+		WherePK().
+		Update()
+	return
+}
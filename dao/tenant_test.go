@@ -0,0 +1,195 @@
+package dao
+
+import (
+	"context"
+	"errors"
+	"henrymeds-takehome/model"
+	"henrymeds-takehome/tenant"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	gopg "github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+	"github.com/google/uuid"
+)
+
+func TestScopedFiltersOnTheTenant(t *testing.T) {
+	var (
+		db           = gopg.Connect(&gopg.Options{})
+		organization = uuid.NewString()
+	)
+	defer db.Close()
+
+	scopedQuery := selectSQL(t, scoped(tenant.WithID(context.Background(), organization), db.Model(&model.User{})))
+	if !strings.Contains(scopedQuery, `"user".organization_id = '`+organization+`'`) {
+		t.Fatalf("a scoped query doesn't filter on the tenant: %s", scopedQuery)
+	}
+	unscopedQuery := selectSQL(t, scoped(context.Background(), db.Model(&model.User{})))
+	if strings.Contains(unscopedQuery, "organization_id =") {
+		t.Fatalf("a query without a tenant filters on one: %s", unscopedQuery)
+	}
+	if condition := db.Formatter().FormatQuery(nil, "?", tenantCondition(tenant.WithID(context.Background(), organization), "organization_id")); string(condition) != `"organization_id" = '`+organization+`'` {
+		t.Fatalf("tenantCondition is %s", condition)
+	}
+}
+
+// selectSQL is the SELECT query runs, with the model's placeholders, ex: ?TableAlias, filled in like they are when
+// it's sent
+func selectSQL(t *testing.T, query *orm.Query) string {
+	b, err := orm.NewSelectQuery(query).AppendQuery(orm.NewFormatter().WithModel(query.TableModel()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// testDB connects to the database in HENRY_TEST_DB_URL, migrated with `make migrate`, and skips the test without
+// one. The rows the tests make are left behind, the audit log can't be deleted from, so point it at a throwaway
+// database
+func testDB(t *testing.T) *gopg.DB {
+	url := os.Getenv("HENRY_TEST_DB_URL")
+	if url == "" {
+		t.Skip("HENRY_TEST_DB_URL is not set")
+	}
+	options, err := gopg.ParseURL(url)
+	if err != nil {
+		t.Fatalf("HENRY_TEST_DB_URL: %v", err)
+	}
+	db := gopg.Connect(options)
+	t.Cleanup(func() {
+		db.Close()
+	})
+	return db
+}
+
+type testOrganization struct {
+	ctx                context.Context
+	providerID, client string
+}
+
+// newTestOrganization makes an organization with a provider and a client, and a context scoped to it
+func newTestOrganization(t *testing.T, d *dao) testOrganization {
+	ctx := context.Background()
+	organization, err := d.InsertOrganization(ctx, model.Organization{Name: "test " + uuid.NewString()})
+	if err != nil {
+		t.Fatalf("failed to insert the organization: %v", err)
+	}
+	users := []model.User{
+		{ID: uuid.NewString(), Username: "provider", OrganizationID: organization.ID},
+		{ID: uuid.NewString(), Username: "client", OrganizationID: organization.ID},
+	}
+	if _, err = d.db.ModelContext(ctx, &users).Insert(); err != nil {
+		t.Fatalf("failed to insert the users: %v", err)
+	}
+	return testOrganization{
+		ctx:        tenant.WithID(ctx, organization.ID),
+		providerID: users[0].ID,
+		client:     users[1].ID,
+	}
+}
+
+func TestTenantIsolation(t *testing.T) {
+	var (
+		d     = NewReservationDao(testDB(t), nil)
+		a     = newTestOrganization(t, d)
+		b     = newTestOrganization(t, d)
+		start = time.Now().UTC().Truncate(time.Hour).Add(72 * time.Hour)
+		slot  = model.TimeRange{Start: start, End: start.Add(30 * time.Minute)}
+	)
+	_, err := d.InsertAvailabilities(b.ctx, []model.Availability{{
+		ProviderID: b.providerID,
+		TimeRange:  model.TimeRange{Start: start, End: start.Add(2 * time.Hour)},
+		Capacity:   1,
+		Modalities: []string{model.ModalityVideo},
+	}})
+	if err != nil {
+		t.Fatalf("failed to insert b's availability: %v", err)
+	}
+	reservation, err := d.InsertReservation(b.ctx, model.Reservation{
+		ClientID:   b.client,
+		ProviderID: b.providerID,
+		TimeRange:  slot,
+		ExpiresAt:  time.Now().Add(time.Hour),
+		Modality:   model.ModalityVideo,
+	})
+	if err != nil {
+		t.Fatalf("failed to insert b's reservation: %v", err)
+	}
+
+	t.Run("can't read another tenant's provider", func(t *testing.T) {
+		if _, err := d.GetUser(a.ctx, b.providerID); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("a got b's provider, err %v", err)
+		}
+		if _, err := d.GetUser(b.ctx, b.providerID); err != nil {
+			t.Fatalf("b can't get its own provider: %v", err)
+		}
+	})
+
+	t.Run("can't read another tenant's availabilities", func(t *testing.T) {
+		availabilities, err := d.GetAvailabilities(a.ctx, model.GetAvailabilities{ProviderID: b.providerID, TimeRange: slot})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(availabilities) != 0 {
+			t.Fatalf("a got b's availabilities: %v", availabilities)
+		}
+	})
+
+	t.Run("can't read another tenant's reservations", func(t *testing.T) {
+		reservations, err := d.GetReservations(a.ctx, model.GetReservations{ID: reservation.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(reservations) != 0 {
+			t.Fatalf("a got b's reservation: %v", reservations)
+		}
+		if holds, err := d.CountHolds(a.ctx, b.client, time.Now()); err != nil || holds != 0 {
+			t.Fatalf("a counted %d of b's holds, err %v", holds, err)
+		}
+	})
+
+	t.Run("can't book another tenant's provider", func(t *testing.T) {
+		later := model.TimeRange{Start: slot.End, End: slot.End.Add(30 * time.Minute)}
+		_, err := d.InsertReservation(a.ctx, model.Reservation{
+			ClientID:   a.client,
+			ProviderID: b.providerID,
+			TimeRange:  later,
+			ExpiresAt:  time.Now().Add(time.Hour),
+			Modality:   model.ModalityVideo,
+		})
+		if err == nil {
+			t.Fatal("a booked b's provider")
+		}
+		err = d.InTransaction(a.ctx, func(tx ReservationDao) error {
+			return tx.LockUser(a.ctx, b.providerID)
+		})
+		if !errors.Is(err, ErrNotFound) {
+			t.Fatalf("a locked b's provider, err %v", err)
+		}
+	})
+
+	t.Run("row level security holds unscoped reads to the tenant", func(t *testing.T) {
+		var exempt bool
+		_, err := d.db.QueryOne(gopg.Scan(&exempt), "SELECT rolsuper OR rolbypassrls FROM pg_roles WHERE rolname = current_user")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exempt {
+			t.Skip("the test role skips row level security, connect as an ordinary role")
+		}
+		var count int
+		err = d.reading(a.ctx, func(tx *dao) (err error) {
+			// no scoped, only the policies stand between a and b's rows
+			count, err = tx.db.ModelContext(a.ctx, (*model.User)(nil)).Where("id = ?", b.providerID).Count()
+			return
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 0 {
+			t.Fatal("a read b's provider through a query that isn't scoped")
+		}
+	})
+}
@@ -0,0 +1,366 @@
+package dao
+
+import (
+	"context"
+	"henrymeds-takehome/model"
+	"henrymeds-takehome/tracing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// NewTracedDao wraps a ReservationDao and puts every call in its own span,
+// the individual queries show up underneath it through the go-pg query hook
+func NewTracedDao(next ReservationDao) *tracedDao {
+	return &tracedDao{
+		next: next,
+	}
+}
+
+type tracedDao struct {
+	next ReservationDao
+}
+
+func (d *tracedDao) InsertAvailabilities(ctx context.Context, availabilities []model.Availability) (inserted []model.Availability, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.InsertAvailabilities")
+	span.SetAttributes(attribute.Int("availability.count", len(availabilities)))
+	defer func() { tracing.End(span, err) }()
+	return d.next.InsertAvailabilities(ctx, availabilities)
+}
+
+func (d *tracedDao) GetAvailabilities(ctx context.Context, request model.GetAvailabilities) (availabilities []model.Availability, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.GetAvailabilities")
+	defer func() {
+		span.SetAttributes(attribute.Int("availability.count", len(availabilities)))
+		tracing.End(span, err)
+	}()
+	return d.next.GetAvailabilities(ctx, request)
+}
+
+func (d *tracedDao) UpdateAvailability(ctx context.Context, availability model.Availability) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.UpdateAvailability")
+	defer func() { tracing.End(span, err) }()
+	return d.next.UpdateAvailability(ctx, availability)
+}
+
+func (d *tracedDao) DeleteAvailability(ctx context.Context, id string) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.DeleteAvailability")
+	defer func() { tracing.End(span, err) }()
+	return d.next.DeleteAvailability(ctx, id)
+}
+
+func (d *tracedDao) InsertReservation(ctx context.Context, reservation model.Reservation) (inserted model.Reservation, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.InsertReservation")
+	defer func() { tracing.End(span, err) }()
+	return d.next.InsertReservation(ctx, reservation)
+}
+
+func (d *tracedDao) GetReservations(ctx context.Context, request model.GetReservations) (reservations []model.Reservation, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.GetReservations")
+	defer func() {
+		span.SetAttributes(attribute.Int("reservation.count", len(reservations)))
+		tracing.End(span, err)
+	}()
+	return d.next.GetReservations(ctx, request)
+}
+
+func (d *tracedDao) CountHolds(ctx context.Context, clientID string, now time.Time) (count int, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.CountHolds")
+	defer func() {
+		span.SetAttributes(attribute.Int("reservation.count", count))
+		tracing.End(span, err)
+	}()
+	return d.next.CountHolds(ctx, clientID, now)
+}
+
+func (d *tracedDao) GetUser(ctx context.Context, id string) (user model.User, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.GetUser")
+	defer func() { tracing.End(span, err) }()
+	return d.next.GetUser(ctx, id)
+}
+
+func (d *tracedDao) SetCalendarToken(ctx context.Context, userID string, tokenHash []byte) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.SetCalendarToken")
+	defer func() { tracing.End(span, err) }()
+	return d.next.SetCalendarToken(ctx, userID, tokenHash)
+}
+
+func (d *tracedDao) SetUserState(ctx context.Context, userID string, state string) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.SetUserState")
+	defer func() { tracing.End(span, err) }()
+	return d.next.SetUserState(ctx, userID, state)
+}
+
+func (d *tracedDao) ConfirmReservation(ctx context.Context, reservationId string) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.ConfirmReservation")
+	defer func() { tracing.End(span, err) }()
+	return d.next.ConfirmReservation(ctx, reservationId)
+}
+
+func (d *tracedDao) CancelReservations(ctx context.Context, ids []string, at time.Time) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.CancelReservations")
+	span.SetAttributes(attribute.Int("reservation.count", len(ids)))
+	defer func() { tracing.End(span, err) }()
+	return d.next.CancelReservations(ctx, ids, at)
+}
+
+func (d *tracedDao) InsertBusySource(ctx context.Context, source model.BusySource) (inserted model.BusySource, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.InsertBusySource")
+	defer func() { tracing.End(span, err) }()
+	return d.next.InsertBusySource(ctx, source)
+}
+
+func (d *tracedDao) GetBusySources(ctx context.Context, request model.GetBusySources) (sources []model.BusySource, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.GetBusySources")
+	defer func() { tracing.End(span, err) }()
+	return d.next.GetBusySources(ctx, request)
+}
+
+func (d *tracedDao) UpdateBusySource(ctx context.Context, source model.BusySource) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.UpdateBusySource")
+	defer func() { tracing.End(span, err) }()
+	return d.next.UpdateBusySource(ctx, source)
+}
+
+func (d *tracedDao) DeleteBusySource(ctx context.Context, id string) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.DeleteBusySource")
+	defer func() { tracing.End(span, err) }()
+	return d.next.DeleteBusySource(ctx, id)
+}
+
+func (d *tracedDao) ReplaceBusyBlocks(ctx context.Context, sourceID string, blocks []model.BusyBlock) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.ReplaceBusyBlocks")
+	span.SetAttributes(attribute.Int("busy.count", len(blocks)))
+	defer func() { tracing.End(span, err) }()
+	return d.next.ReplaceBusyBlocks(ctx, sourceID, blocks)
+}
+
+func (d *tracedDao) GetBusyBlocks(ctx context.Context, request model.GetBusyBlocks) (blocks []model.BusyBlock, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.GetBusyBlocks")
+	defer func() {
+		span.SetAttributes(attribute.Int("busy.count", len(blocks)))
+		tracing.End(span, err)
+	}()
+	return d.next.GetBusyBlocks(ctx, request)
+}
+
+func (d *tracedDao) UpsertProviderLicense(ctx context.Context, license model.ProviderLicense) (upserted model.ProviderLicense, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.UpsertProviderLicense")
+	defer func() { tracing.End(span, err) }()
+	return d.next.UpsertProviderLicense(ctx, license)
+}
+
+func (d *tracedDao) GetProviderLicenses(ctx context.Context, providerID string) (licenses []model.ProviderLicense, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.GetProviderLicenses")
+	defer func() {
+		span.SetAttributes(attribute.Int("license.count", len(licenses)))
+		tracing.End(span, err)
+	}()
+	return d.next.GetProviderLicenses(ctx, providerID)
+}
+
+func (d *tracedDao) DeleteProviderLicense(ctx context.Context, providerID string, state string) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.DeleteProviderLicense")
+	defer func() { tracing.End(span, err) }()
+	return d.next.DeleteProviderLicense(ctx, providerID, state)
+}
+
+func (d *tracedDao) InsertLocation(ctx context.Context, location model.Location) (inserted model.Location, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.InsertLocation")
+	defer func() { tracing.End(span, err) }()
+	return d.next.InsertLocation(ctx, location)
+}
+
+func (d *tracedDao) GetLocations(ctx context.Context, id string) (locations []model.Location, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.GetLocations")
+	defer func() { tracing.End(span, err) }()
+	return d.next.GetLocations(ctx, id)
+}
+
+func (d *tracedDao) SetReservationVideoURL(ctx context.Context, reservationID string, url string) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.SetReservationVideoURL")
+	defer func() {
+		span.SetAttributes(attribute.String("reservation.id", reservationID))
+		tracing.End(span, err)
+	}()
+	return d.next.SetReservationVideoURL(ctx, reservationID, url)
+}
+
+func (d *tracedDao) InsertOrganization(ctx context.Context, organization model.Organization) (inserted model.Organization, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.InsertOrganization")
+	defer func() { tracing.End(span, err) }()
+	return d.next.InsertOrganization(ctx, organization)
+}
+
+func (d *tracedDao) GetOrganizations(ctx context.Context, id string) (organizations []model.Organization, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.GetOrganizations")
+	defer func() { tracing.End(span, err) }()
+	return d.next.GetOrganizations(ctx, id)
+}
+
+func (d *tracedDao) SetOrganizationKey(ctx context.Context, id string, keyHash []byte) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.SetOrganizationKey")
+	defer func() { tracing.End(span, err) }()
+	return d.next.SetOrganizationKey(ctx, id, keyHash)
+}
+
+func (d *tracedDao) InsertWebhookSubscription(ctx context.Context, subscription model.WebhookSubscription) (inserted model.WebhookSubscription, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.InsertWebhookSubscription")
+	defer func() { tracing.End(span, err) }()
+	return d.next.InsertWebhookSubscription(ctx, subscription)
+}
+
+func (d *tracedDao) GetWebhookSubscriptions(ctx context.Context, id string) (subscriptions []model.WebhookSubscription, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.GetWebhookSubscriptions")
+	defer func() { tracing.End(span, err) }()
+	return d.next.GetWebhookSubscriptions(ctx, id)
+}
+
+func (d *tracedDao) UpdateWebhookSubscription(ctx context.Context, subscription model.WebhookSubscription) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.UpdateWebhookSubscription")
+	defer func() { tracing.End(span, err) }()
+	return d.next.UpdateWebhookSubscription(ctx, subscription)
+}
+
+func (d *tracedDao) DeleteWebhookSubscription(ctx context.Context, id string) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.DeleteWebhookSubscription")
+	defer func() { tracing.End(span, err) }()
+	return d.next.DeleteWebhookSubscription(ctx, id)
+}
+
+func (d *tracedDao) GetWebhookDeliveries(ctx context.Context, request model.GetWebhookDeliveries) (deliveries []model.WebhookDelivery, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.GetWebhookDeliveries")
+	defer func() {
+		span.SetAttributes(attribute.Int("delivery.count", len(deliveries)))
+		tracing.End(span, err)
+	}()
+	return d.next.GetWebhookDeliveries(ctx, request)
+}
+
+func (d *tracedDao) GetWebhookAttempts(ctx context.Context, deliveryID string) (attempts []model.WebhookAttempt, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.GetWebhookAttempts")
+	defer func() { tracing.End(span, err) }()
+	return d.next.GetWebhookAttempts(ctx, deliveryID)
+}
+
+func (d *tracedDao) RequeueWebhookDelivery(ctx context.Context, id string, at time.Time) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.RequeueWebhookDelivery")
+	defer func() { tracing.End(span, err) }()
+	return d.next.RequeueWebhookDelivery(ctx, id, at)
+}
+
+func (d *tracedDao) GetAuditEntries(ctx context.Context, request model.GetAuditEntries) (entries []model.AuditEntry, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.GetAuditEntries")
+	defer func() { tracing.End(span, err) }()
+	return d.next.GetAuditEntries(ctx, request)
+}
+
+func (d *tracedDao) GetAuditChain(ctx context.Context, organizationID string, afterSeq int64, limit int) (entries []model.AuditEntry, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.GetAuditChain")
+	defer func() {
+		span.SetAttributes(attribute.String("organization.id", organizationID))
+		tracing.End(span, err)
+	}()
+	return d.next.GetAuditChain(ctx, organizationID, afterSeq, limit)
+}
+
+func (d *tracedDao) InsertEvents(ctx context.Context, events []model.Event) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.InsertEvents")
+	span.SetAttributes(attribute.Int("event.count", len(events)))
+	defer func() { tracing.End(span, err) }()
+	return d.next.InsertEvents(ctx, events)
+}
+
+func (d *tracedDao) MarkExpiriesPublished(ctx context.Context, now time.Time, limit int) (reservations []model.Reservation, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.MarkExpiriesPublished")
+	defer func() {
+		span.SetAttributes(attribute.Int("reservation.count", len(reservations)))
+		tracing.End(span, err)
+	}()
+	return d.next.MarkExpiriesPublished(ctx, now, limit)
+}
+
+func (d *tracedDao) ReplaceReminders(ctx context.Context, reservationID string, reminders []model.Reminder) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.ReplaceReminders")
+	span.SetAttributes(attribute.String("reservation.id", reservationID), attribute.Int("reminder.count", len(reminders)))
+	defer func() { tracing.End(span, err) }()
+	return d.next.ReplaceReminders(ctx, reservationID, reminders)
+}
+
+func (d *tracedDao) CancelReminders(ctx context.Context, reservationIDs []string) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.CancelReminders")
+	span.SetAttributes(attribute.Int("reservation.count", len(reservationIDs)))
+	defer func() { tracing.End(span, err) }()
+	return d.next.CancelReminders(ctx, reservationIDs)
+}
+
+func (d *tracedDao) SpendConfirmationToken(ctx context.Context, token model.SpentConfirmationToken) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.SpendConfirmationToken")
+	span.SetAttributes(attribute.String("reservation.id", token.ReservationID))
+	defer func() { tracing.End(span, err) }()
+	return d.next.SpendConfirmationToken(ctx, token)
+}
+
+func (d *tracedDao) InsertWaitlistEntry(ctx context.Context, entry model.WaitlistEntry) (inserted model.WaitlistEntry, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.InsertWaitlistEntry")
+	defer func() { tracing.End(span, err) }()
+	return d.next.InsertWaitlistEntry(ctx, entry)
+}
+
+func (d *tracedDao) GetWaitlistEntry(ctx context.Context, id string) (entry model.WaitlistEntry, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.GetWaitlistEntry")
+	defer func() { tracing.End(span, err) }()
+	return d.next.GetWaitlistEntry(ctx, id)
+}
+
+func (d *tracedDao) CancelWaitlistEntry(ctx context.Context, id string) (entry model.WaitlistEntry, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.CancelWaitlistEntry")
+	defer func() { tracing.End(span, err) }()
+	return d.next.CancelWaitlistEntry(ctx, id)
+}
+
+func (d *tracedDao) ClaimWaitlistEntries(ctx context.Context, request model.GetWaitlistCandidates) (entries []model.WaitlistEntry, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.ClaimWaitlistEntries")
+	defer func() {
+		span.SetAttributes(attribute.Int("waitlist.count", len(entries)))
+		tracing.End(span, err)
+	}()
+	return d.next.ClaimWaitlistEntries(ctx, request)
+}
+
+func (d *tracedDao) OfferWaitlistEntry(ctx context.Context, id string, reservationID string, at time.Time) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.OfferWaitlistEntry")
+	span.SetAttributes(attribute.String("reservation.id", reservationID))
+	defer func() { tracing.End(span, err) }()
+	return d.next.OfferWaitlistEntry(ctx, id, reservationID, at)
+}
+
+func (d *tracedDao) SettleWaitlistOffers(ctx context.Context, reservationIDs []string, status string) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.SettleWaitlistOffers")
+	span.SetAttributes(attribute.Int("reservation.count", len(reservationIDs)), attribute.String("waitlist.status", status))
+	defer func() { tracing.End(span, err) }()
+	return d.next.SettleWaitlistOffers(ctx, reservationIDs, status)
+}
+
+func (d *tracedDao) ReleaseExpiredHolds(ctx context.Context, request model.ReleaseExpiredHolds) (reservations []model.Reservation, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.ReleaseExpiredHolds")
+	defer func() {
+		span.SetAttributes(attribute.Int("reservation.count", len(reservations)))
+		tracing.End(span, err)
+	}()
+	return d.next.ReleaseExpiredHolds(ctx, request)
+}
+
+func (d *tracedDao) LockUser(ctx context.Context, id string) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.LockUser")
+	defer func() { tracing.End(span, err) }()
+	return d.next.LockUser(ctx, id)
+}
+
+func (d *tracedDao) InTransaction(ctx context.Context, fn func(ReservationDao) error) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ReservationDao.InTransaction")
+	defer func() { tracing.End(span, err) }()
+	return d.next.InTransaction(ctx, func(tx ReservationDao) error {
+		return fn(NewTracedDao(tx))
+	})
+}
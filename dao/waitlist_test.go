@@ -0,0 +1,75 @@
+package dao
+
+import (
+	"henrymeds-takehome/model"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestClaimWaitlistEntriesInLine(t *testing.T) {
+	var (
+		d            = NewReservationDao(testDB(t), nil)
+		organization = newTestOrganization(t, d)
+		start        = time.Now().UTC().Truncate(time.Hour).Add(72 * time.Hour)
+		joined       = time.Now().UTC().Truncate(time.Second)
+		window       = model.TimeRange{Start: start, End: start.Add(2 * time.Hour)}
+		ids          = map[string]string{}
+	)
+	for _, entry := range []struct {
+		name     string
+		provider string
+		priority int
+		joined   time.Duration
+		window   model.TimeRange
+	}{
+		{name: "first", provider: organization.providerID, joined: 0},
+		{name: "urgent", provider: organization.providerID, priority: 10, joined: 2 * time.Minute},
+		// any provider of the organization
+		{name: "second, any provider", joined: time.Minute},
+		{name: "important", provider: organization.providerID, priority: 5, joined: 3 * time.Minute},
+		{name: "too late", provider: organization.providerID, joined: -time.Minute, window: model.TimeRange{Start: window.End, End: window.End.Add(time.Hour)}},
+	} {
+		if entry.window.Start.IsZero() {
+			entry.window = window
+		}
+		inserted, err := d.InsertWaitlistEntry(organization.ctx, model.WaitlistEntry{
+			ClientID:        organization.client,
+			ProviderID:      entry.provider,
+			AppointmentType: "follow_up",
+			TimeRange:       entry.window,
+			Priority:        entry.priority,
+			Status:          model.WaitlistWaiting,
+			CreatedAt:       joined.Add(entry.joined),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[inserted.ID] = entry.name
+	}
+
+	for _, test := range []struct {
+		byPriority bool
+		want       []string
+	}{
+		{want: []string{"first", "second, any provider", "urgent", "important"}},
+		{byPriority: true, want: []string{"urgent", "important", "first", "second, any provider"}},
+	} {
+		entries, err := d.ClaimWaitlistEntries(organization.ctx, model.GetWaitlistCandidates{
+			ProviderID: organization.providerID,
+			TimeRange:  window,
+			ByPriority: test.byPriority,
+			Limit:      10,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, entry := range entries {
+			got = append(got, ids[entry.ID])
+		}
+		if !slices.Equal(got, test.want) {
+			t.Errorf("by priority %v, the line is %q, want %q", test.byPriority, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package dao
+
+import (
+	"context"
+	"encoding/json"
+	"henrymeds-takehome/model"
+	"sort"
+	"time"
+
+	gopg "github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+)
+
+// WebhookDao is the delivery side of the webhooks, the subscriptions and the delivery log are managed through
+// ReservationDao
+type WebhookDao interface {
+	// EnqueueWebhookDeliveries adds a delivery of the event for every active subscription of the organization to its
+	// type, it returns how many were added. An event that was already enqueued isn't enqueued again
+	EnqueueWebhookDeliveries(ctx context.Context, organizationID string, eventID string, eventType string, payload json.RawMessage, at time.Time) (int, error)
+	// ClaimWebhookDeliveries takes up to limit pending deliveries that are due, oldest first, and pushes their next
+	// attempt out by lease so no one else takes them in the meantime
+	ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]model.WebhookDelivery, error)
+	GetWebhookSubscriptionsByID(ctx context.Context, ids []string) ([]model.WebhookSubscription, error)
+	// RecordWebhookAttempt logs the attempt and saves the delivery's status, attempts, next attempt and last response
+	RecordWebhookAttempt(ctx context.Context, delivery model.WebhookDelivery, attempt model.WebhookAttempt) error
+}
+
+func NewWebhookDao(db *gopg.DB) *webhookDao {
+	return &webhookDao{
+		db: db,
+	}
+}
+
+type webhookDao struct {
+	db *gopg.DB
+}
+
+func (d *webhookDao) EnqueueWebhookDeliveries(ctx context.Context, organizationID string, eventID string, eventType string, payload json.RawMessage, at time.Time) (enqueued int, err error) {
+	var result orm.Result
+	result, err = d.db.ExecContext(ctx, `
+		INSERT INTO webhook_deliveries (subscription_id, event_id, event_type, payload, status, next_attempt_at, created_at)
+		SELECT id, ?, ?, ?::jsonb, ?, ?, ? FROM webhook_subscriptions
+		WHERE active AND organization_id = ? AND ? = ANY(event_types)
+		ON CONFLICT (subscription_id, event_id) DO NOTHING`,
+		eventID, eventType, payload, model.WebhookPending, at, at, organizationID, eventType)
+	if err == nil {
+		enqueued = result.RowsAffected()
+	}
+	return
+}
+
+func (d *webhookDao) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) (deliveries []model.WebhookDelivery, err error) {
+	now := time.Now()
+	_, err = d.db.QueryContext(ctx, &deliveries, `
+		UPDATE webhook_deliveries SET next_attempt_at = ?
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = ? AND next_attempt_at <= ?
+			ORDER BY next_attempt_at, id
+			LIMIT ?
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`, now.Add(lease), model.WebhookPending, now, limit)
+	// RETURNING doesn't keep the subquery's order
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].CreatedAt.Before(deliveries[j].CreatedAt) })
+	return
+}
+
+func (d *webhookDao) GetWebhookSubscriptionsByID(ctx context.Context, ids []string) (subscriptions []model.WebhookSubscription, err error) {
+	if len(ids) == 0 {
+		return
+	}
+	err = d.db.ModelContext(ctx, &subscriptions).Where("id IN (?)", gopg.In(ids)).Select()
+	return
+}
+
+func (d *webhookDao) RecordWebhookAttempt(ctx context.Context, delivery model.WebhookDelivery, attempt model.WebhookAttempt) error {
+	return d.db.RunInTransaction(ctx, func(tx *gopg.Tx) (err error) {
+		_, err = tx.ModelContext(ctx, &attempt).Insert()
+		if err != nil {
+			return
+		}
+		_, err = tx.ModelContext(ctx, &delivery).
+			Column("status", "attempts", "next_attempt_at", "last_status_code", "last_error", "delivered_at").
+			WherePK().
+			Update()
+		return
+	})
+}
@@ -0,0 +1,72 @@
+package dao
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"henrymeds-takehome/model"
+	"henrymeds-takehome/tenant"
+	"testing"
+	"time"
+
+	gopg "github.com/go-pg/pg/v10"
+	"github.com/google/uuid"
+)
+
+func TestWebhookDeliveriesStayInTheOrganization(t *testing.T) {
+	var (
+		ctx        = context.Background()
+		db         = testDB(t)
+		d          = NewReservationDao(db, nil)
+		webhookDao = NewWebhookDao(db)
+		a          = newTestOrganization(t, d)
+		b          = newTestOrganization(t, d)
+		// a type no other test subscribes to, so subscriptions left behind by earlier runs don't count
+		eventType = "test." + uuid.NewString()
+	)
+	organizationA, _ := tenant.FromContext(a.ctx)
+	organizationB, _ := tenant.FromContext(b.ctx)
+	for _, organizationID := range []string{organizationA, organizationB} {
+		_, err := d.InsertWebhookSubscription(ctx, model.WebhookSubscription{
+			OrganizationID: organizationID,
+			URL:            "https://hooks.example.com/" + organizationID,
+			EventTypes:     []string{eventType},
+			Secret:         "a-secret-long-enough",
+			Active:         true,
+			CreatedAt:      time.Now(),
+			UpdatedAt:      time.Now(),
+		})
+		if err != nil {
+			t.Fatalf("failed to insert the subscription: %v", err)
+		}
+	}
+
+	eventID := uuid.NewString()
+	enqueued, err := webhookDao.EnqueueWebhookDeliveries(ctx, organizationA, eventID, eventType, json.RawMessage(`{}`), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enqueued != 1 {
+		t.Fatalf("enqueued %d deliveries of a's event, want only a's subscription", enqueued)
+	}
+	var url string
+	_, err = db.QueryOne(gopg.Scan(&url), `SELECT s.url FROM webhook_deliveries d JOIN webhook_subscriptions s ON s.id = d.subscription_id WHERE d.event_id = ?`, eventID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url != "https://hooks.example.com/"+organizationA {
+		t.Fatalf("a's event went to %s", url)
+	}
+
+	_, err = d.InsertWebhookSubscription(ctx, model.WebhookSubscription{
+		OrganizationID: uuid.NewString(),
+		URL:            "https://hooks.example.com/nobody",
+		EventTypes:     []string{eventType},
+		Secret:         "a-secret-long-enough",
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+	})
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("a subscription of an organization that doesn't exist got %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,173 @@
+package encryption
+
+import (
+	"context"
+	"crypto/cipher"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the start of every envelope, values without it were written before encryption was turned on and are read as is
+const envelopePrefix = "enc:v1:"
+
+// how many unwrapped data keys are kept, there's one per instance per data key TTL so it takes a while to fill up
+const maxOpenedKeys = 1024
+
+var ErrMalformedEnvelope = errors.New("malformed envelope")
+
+// NewEncryptor builds the envelope encryption of values. A value is encrypted with AES-256-GCM under a data key, and
+// stored as enc:v1:<master key ID>:<base64 wrapped data key>:<base64 nonce and ciphertext>. A data key encrypts new
+// values for dataKeyTTL, then a new one is made, so the KMS is called once per TTL and not per value. Unwrapped data
+// keys are cached for decryption
+func NewEncryptor(kms KMS, dataKeyTTL time.Duration) *Encryptor {
+	return &Encryptor{
+		kms:        kms,
+		dataKeyTTL: dataKeyTTL,
+		opened:     map[string]cipher.AEAD{},
+	}
+}
+
+type Encryptor struct {
+	kms        KMS
+	dataKeyTTL time.Duration
+
+	mu      sync.Mutex
+	current *dataKey
+	// by master key ID and wrapped data key
+	opened map[string]cipher.AEAD
+}
+
+// the data key new values are encrypted with
+type dataKey struct {
+	masterKeyID string
+	wrapped     []byte
+	aead        cipher.AEAD
+	expiresAt   time.Time
+}
+
+// Encrypt seals the plaintext in an envelope. The label, ex: the table and column, has to be the same to decrypt
+// it, so an envelope moved to another column doesn't decrypt there. The row isn't part of the label, an envelope
+// copied to another row of the same column decrypts fine. Empty stays empty, it's no value
+func (e *Encryptor) Encrypt(ctx context.Context, plaintext string, label string) (envelope string, err error) {
+	if plaintext == "" {
+		return "", nil
+	}
+	key, err := e.dataKey(ctx)
+	if err != nil {
+		return
+	}
+	sealed, err := seal(key.aead, []byte(plaintext), []byte(label))
+	if err != nil {
+		return
+	}
+	return envelopePrefix + key.masterKeyID + ":" + base64.RawStdEncoding.EncodeToString(key.wrapped) + ":" +
+		base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens an envelope made by Encrypt with the same label. A value that isn't an envelope is returned as is
+func (e *Encryptor) Decrypt(ctx context.Context, envelope string, label string) (plaintext string, err error) {
+	if !IsEnvelope(envelope) {
+		return envelope, nil
+	}
+	masterKeyID, wrapped, sealed, err := parse(envelope)
+	if err != nil {
+		return
+	}
+	aead, err := e.openDataKey(ctx, masterKeyID, wrapped)
+	if err != nil {
+		return
+	}
+	raw, err := open(aead, sealed, []byte(label))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt %s: %w", label, err)
+	}
+	return string(raw), nil
+}
+
+// Current reports whether the value is an envelope under the current master key, anything else is re-encrypted by
+// the reencrypt command
+func (e *Encryptor) Current(value string) bool {
+	if value == "" {
+		return true
+	}
+	if !IsEnvelope(value) {
+		return false
+	}
+	masterKeyID, _, _, err := parse(value)
+	return err == nil && masterKeyID == e.kms.CurrentKeyID()
+}
+
+// IsEnvelope reports whether the value was made by Encrypt
+func IsEnvelope(value string) bool {
+	return strings.HasPrefix(value, envelopePrefix)
+}
+
+func parse(envelope string) (masterKeyID string, wrapped []byte, sealed []byte, err error) {
+	parts := strings.Split(strings.TrimPrefix(envelope, envelopePrefix), ":")
+	if len(parts) != 3 {
+		return "", nil, nil, ErrMalformedEnvelope
+	}
+	if wrapped, err = base64.RawStdEncoding.DecodeString(parts[1]); err != nil {
+		return "", nil, nil, ErrMalformedEnvelope
+	}
+	if sealed, err = base64.RawStdEncoding.DecodeString(parts[2]); err != nil {
+		return "", nil, nil, ErrMalformedEnvelope
+	}
+	return parts[0], wrapped, sealed, nil
+}
+
+// dataKey is the current data key, a new one once it's expired or the master key changed
+func (e *Encryptor) dataKey(ctx context.Context) (key *dataKey, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := time.Now()
+	if e.current != nil && now.Before(e.current.expiresAt) && e.current.masterKeyID == e.kms.CurrentKeyID() {
+		return e.current, nil
+	}
+	generated, err := e.kms.GenerateDataKey(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate a data key: %w", err)
+	}
+	aead, err := newGCM(generated.Plaintext)
+	if err != nil {
+		return
+	}
+	e.current = &dataKey{
+		masterKeyID: generated.MasterKeyID,
+		wrapped:     generated.Wrapped,
+		aead:        aead,
+		expiresAt:   now.Add(e.dataKeyTTL),
+	}
+	e.remember(generated.MasterKeyID, generated.Wrapped, aead)
+	return e.current, nil
+}
+
+// openDataKey unwraps a data key through the KMS, or takes it from the cache
+func (e *Encryptor) openDataKey(ctx context.Context, masterKeyID string, wrapped []byte) (aead cipher.AEAD, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if aead, ok := e.opened[masterKeyID+":"+string(wrapped)]; ok {
+		return aead, nil
+	}
+	plaintext, err := e.kms.DecryptDataKey(ctx, masterKeyID, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt a data key: %w", err)
+	}
+	if aead, err = newGCM(plaintext); err != nil {
+		return
+	}
+	e.remember(masterKeyID, wrapped, aead)
+	return aead, nil
+}
+
+func (e *Encryptor) remember(masterKeyID string, wrapped []byte, aead cipher.AEAD) {
+	// crude, but the keys in use are unwrapped again on their next read
+	if len(e.opened) >= maxOpenedKeys {
+		clear(e.opened)
+	}
+	e.opened[masterKeyID+":"+string(wrapped)] = aead
+}
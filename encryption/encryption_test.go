@@ -0,0 +1,211 @@
+package encryption
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newKeyLine is a key file line with a new random key
+func newKeyLine(t *testing.T, id string) string {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return id + " " + base64.StdEncoding.EncodeToString(key)
+}
+
+// writeKeyFile writes the lines to a key file that's removed with the test
+func writeKeyFile(t *testing.T, lines ...string) string {
+	path := filepath.Join(t.TempDir(), "keys.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newTestEncryptor(t *testing.T, lines ...string) *Encryptor {
+	kms, err := NewFileKMS(writeKeyFile(t, lines...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewEncryptor(kms, time.Hour)
+}
+
+func TestRoundTrip(t *testing.T) {
+	var (
+		ctx       = context.Background()
+		encryptor = newTestEncryptor(t, newKeyLine(t, "2026-10"))
+		plaintext = "follow up on my prescription"
+	)
+	envelope, err := encryptor.Encrypt(ctx, plaintext, "reservations.reason")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEnvelope(envelope) || !strings.HasPrefix(envelope, envelopePrefix+"2026-10:") || strings.Contains(envelope, plaintext) {
+		t.Fatalf("got the envelope %s", envelope)
+	}
+	decrypted, err := encryptor.Decrypt(ctx, envelope, "reservations.reason")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted != plaintext {
+		t.Fatalf("decrypted %q, want %q", decrypted, plaintext)
+	}
+
+	// the nonce is random, the same value doesn't encrypt to the same envelope
+	again, err := encryptor.Encrypt(ctx, plaintext, "reservations.reason")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == envelope {
+		t.Fatal("encrypted the same value to the same envelope twice")
+	}
+
+	// a new encryptor over the same keys unwraps the data key through the KMS, not from its cache
+	kms := encryptor.kms
+	if decrypted, err = NewEncryptor(kms, time.Hour).Decrypt(ctx, envelope, "reservations.reason"); err != nil || decrypted != plaintext {
+		t.Fatalf("decrypted %q, err %v", decrypted, err)
+	}
+
+	if empty, err := encryptor.Encrypt(ctx, "", "reservations.reason"); err != nil || empty != "" {
+		t.Fatalf("encrypted no value to %q, err %v", empty, err)
+	}
+}
+
+func TestDecryptRefuses(t *testing.T) {
+	var (
+		ctx       = context.Background()
+		encryptor = newTestEncryptor(t, newKeyLine(t, "2026-10"))
+	)
+	envelope, err := encryptor.Encrypt(ctx, "555-0100", "users.phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(strings.TrimPrefix(envelope, envelopePrefix), ":")
+	// flipped flips a bit of a base64 part of the envelope
+	flipped := func(part string) string {
+		raw, err := base64.RawStdEncoding.DecodeString(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw[len(raw)-1] ^= 1
+		return base64.RawStdEncoding.EncodeToString(raw)
+	}
+
+	for name, c := range map[string]struct {
+		envelope, label string
+		want            error
+	}{
+		"another column":          {envelope: envelope, label: "users.email"},
+		"tampered ciphertext":     {envelope: envelopePrefix + parts[0] + ":" + parts[1] + ":" + flipped(parts[2]), label: "users.phone"},
+		"tampered data key":       {envelope: envelopePrefix + parts[0] + ":" + flipped(parts[1]) + ":" + parts[2], label: "users.phone"},
+		"another master key":      {envelope: envelopePrefix + "2026-09:" + parts[1] + ":" + parts[2], label: "users.phone", want: ErrUnknownKey},
+		"missing part":            {envelope: envelopePrefix + parts[0] + ":" + parts[2], label: "users.phone", want: ErrMalformedEnvelope},
+		"ciphertext isn't base64": {envelope: envelopePrefix + parts[0] + ":" + parts[1] + ":!!", label: "users.phone", want: ErrMalformedEnvelope},
+	} {
+		t.Run(name, func(t *testing.T) {
+			plaintext, err := NewEncryptor(encryptor.kms, time.Hour).Decrypt(ctx, c.envelope, c.label)
+			if err == nil {
+				t.Fatalf("decrypted %q", plaintext)
+			}
+			if c.want != nil && !errors.Is(err, c.want) {
+				t.Fatalf("got %v, want %v", err, c.want)
+			}
+		})
+	}
+}
+
+// values written before encryption was turned on are read as they are, and are due for re-encryption
+func TestLegacyPlaintext(t *testing.T) {
+	encryptor := newTestEncryptor(t, newKeyLine(t, "2026-10"))
+	plaintext, err := encryptor.Decrypt(context.Background(), "client@example.com", "users.email")
+	if err != nil || plaintext != "client@example.com" {
+		t.Fatalf("got %q, err %v", plaintext, err)
+	}
+	if encryptor.Current("client@example.com") {
+		t.Fatal("plaintext counts as current")
+	}
+	if !encryptor.Current("") {
+		t.Fatal("no value isn't current, it has nothing to re-encrypt")
+	}
+}
+
+func TestCurrentAfterAddingAKey(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		old   = newKeyLine(t, "2026-09")
+		newer = newKeyLine(t, "2026-10")
+	)
+	before := newTestEncryptor(t, old)
+	envelope, err := before.Encrypt(ctx, "client@example.com", "users.email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !before.Current(envelope) {
+		t.Fatal("a value under the only key isn't current")
+	}
+
+	// the new key is added to the end of the file, and the instances restart
+	after := newTestEncryptor(t, old, newer)
+	if after.Current(envelope) {
+		t.Fatal("a value under the old key is still current")
+	}
+	plaintext, err := after.Decrypt(ctx, envelope, "users.email")
+	if err != nil || plaintext != "client@example.com" {
+		t.Fatalf("the old key no longer decrypts: %q, err %v", plaintext, err)
+	}
+	reencrypted, err := after.Encrypt(ctx, plaintext, "users.email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !after.Current(reencrypted) || !strings.HasPrefix(reencrypted, envelopePrefix+"2026-10:") {
+		t.Fatalf("a new value went under the old key: %s", reencrypted)
+	}
+}
+
+func TestNewFileKMSRejects(t *testing.T) {
+	var (
+		key   = newKeyLine(t, "2026-10")
+		short = "2026-10 " + base64.StdEncoding.EncodeToString(make([]byte, 16))
+	)
+	for name, c := range map[string]struct {
+		lines []string
+		want  string
+	}{
+		"no keys":          {lines: []string{"# only a comment", ""}, want: "no keys"},
+		"no key":           {lines: []string{"2026-10"}, want: ":1: want a key ID and a base64 key"},
+		"too many fields":  {lines: []string{key + " extra"}, want: ":1: want a key ID and a base64 key"},
+		"bad key ID":       {lines: []string{"# the : would break the envelope", strings.Replace(key, "2026-10", "2026:10", 1)}, want: ":2: key ID must be"},
+		"key listed twice": {lines: []string{key, key}, want: ":2: key 2026-10 is listed twice"},
+		"key isn't base64": {lines: []string{"2026-10 not-base64!"}, want: ":1: key must be 32 bytes of base64"},
+		"key too short":    {lines: []string{short}, want: ":1: key must be 32 bytes of base64"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewFileKMS(writeKeyFile(t, c.lines...))
+			if err == nil || !strings.Contains(err.Error(), c.want) {
+				t.Fatalf("got %v, want an error about %q", err, c.want)
+			}
+		})
+	}
+
+	if _, err := NewFileKMS(filepath.Join(t.TempDir(), "missing.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got %v for a missing file", err)
+	}
+}
+
+func TestNewFileKMSTheLastKeyIsCurrent(t *testing.T) {
+	kms, err := NewFileKMS(writeKeyFile(t, "# rotated monthly", newKeyLine(t, "2026-09"), "", newKeyLine(t, "2026-10")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kms.CurrentKeyID() != "2026-10" {
+		t.Fatalf("the current key is %s", kms.CurrentKeyID())
+	}
+}
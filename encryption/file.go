@@ -0,0 +1,123 @@
+package encryption
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// what a key ID can be made of, it's stored in every envelope so it can't have the separator in it
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// NewFileKMS reads the master keys from a file, for development and for deployments that mount the keys as a
+// secret. Each line is a key ID and a base64 256 bit key separated by whitespace, blank lines and lines starting
+// with # are skipped, ex:
+//
+//	2026-10 Q0FGRUJBQkVDQUZFQkFCRUNBRkVCQUJFQ0FGRUJBQkU=
+//
+// The last key is the current one. To rotate, add a line with a new key, restart, run reencrypt and then remove the
+// old line
+func NewFileKMS(path string) (*fileKMS, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	kms := &fileKMS{keys: map[string]cipher.AEAD{}}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: want a key ID and a base64 key", path, line)
+		}
+		id := fields[0]
+		if !keyIDPattern.MatchString(id) {
+			return nil, fmt.Errorf("%s:%d: key ID must be 1 to 64 letters, digits, dots, dashes or underscores", path, line)
+		}
+		if _, ok := kms.keys[id]; ok {
+			return nil, fmt.Errorf("%s:%d: key %s is listed twice", path, line, id)
+		}
+		key, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("%s:%d: key must be 32 bytes of base64", path, line)
+		}
+		if kms.keys[id], err = newGCM(key); err != nil {
+			return nil, err
+		}
+		kms.current = id
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	if kms.current == "" {
+		return nil, fmt.Errorf("%s: no keys", path)
+	}
+	return kms, nil
+}
+
+type fileKMS struct {
+	// key ID to the master key
+	keys    map[string]cipher.AEAD
+	current string
+}
+
+func (k *fileKMS) GenerateDataKey(ctx context.Context) (key DataKey, err error) {
+	key = DataKey{
+		MasterKeyID: k.current,
+		Plaintext:   make([]byte, 32),
+	}
+	if _, err = rand.Read(key.Plaintext); err != nil {
+		return
+	}
+	key.Wrapped, err = seal(k.keys[k.current], key.Plaintext, []byte(k.current))
+	return
+}
+
+func (k *fileKMS) DecryptDataKey(ctx context.Context, masterKeyID string, wrapped []byte) (plaintext []byte, err error) {
+	master, ok := k.keys[masterKeyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, masterKeyID)
+	}
+	return open(master, wrapped, []byte(masterKeyID))
+}
+
+func (k *fileKMS) CurrentKeyID() string {
+	return k.current
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts with a random nonce, which goes in front of the ciphertext
+func seal(aead cipher.AEAD, plaintext []byte, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(aead cipher.AEAD, sealed []byte, additionalData []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additionalData)
+}
@@ -0,0 +1,28 @@
+package encryption
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrUnknownKey is returned for a data key wrapped under a master key the KMS doesn't have, ex: one retired before
+// everything under it was re-encrypted
+var ErrUnknownKey = errors.New("unknown master key")
+
+// KMS keeps the master keys. They never leave it, values are encrypted with data keys that are stored wrapped under
+// a master key next to what they encrypted
+type KMS interface {
+	// GenerateDataKey makes a new data key wrapped under the current master key
+	GenerateDataKey(ctx context.Context) (key DataKey, err error)
+	// DecryptDataKey unwraps a data key that was wrapped under the master key with the ID
+	DecryptDataKey(ctx context.Context, masterKeyID string, wrapped []byte) (plaintext []byte, err error)
+	// CurrentKeyID is the master key new data keys are wrapped under, values under any other are due for re-encryption
+	CurrentKeyID() string
+}
+
+// DataKey is a 256 bit AES key, in plaintext to encrypt with and wrapped to store
+type DataKey struct {
+	MasterKeyID string
+	Plaintext   []byte
+	Wrapped     []byte
+}
@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"henrymeds-takehome/api"
+	"henrymeds-takehome/model"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// the query parameters that filter the audit log, along with OrganizationIdParam
+const (
+	EntityTypeParam = "entityType"
+	EntityIdParam   = "entityId"
+	ActorParam      = "actor"
+)
+
+// HandleV1GetAuditEntries pages through the audit log newest first, optionally for one organization, one entity or
+// one actor
+func (h *Handler) HandleV1GetAuditEntries(c echo.Context) (err error) {
+	var (
+		page    model.Page
+		entries []model.AuditEntry
+		next    *model.PageKey
+	)
+	page, err = parseSortedPage(c, model.SortStartDesc)
+	if err != nil {
+		return respondError(c, "failed to get audit log", err)
+	}
+
+	entries, next, err = h.controller.GetAuditEntries(c.Request().Context(), model.GetAuditEntries{
+		OrganizationID: c.QueryParam(OrganizationIdParam),
+		EntityType:     c.QueryParam(EntityTypeParam),
+		EntityID:       c.QueryParam(EntityIdParam),
+		Actor:          c.QueryParam(ActorParam),
+		Page:           page,
+	})
+	if err != nil {
+		return respondError(c, "failed to get audit log", err)
+	}
+	return c.JSON(http.StatusOK, api.Envelope[[]api.AuditEntry]{
+		Data: api.FromAuditEntries(entries),
+		Page: &api.Page{Next: encodeCursor(next, page.Sort)},
+	})
+}
+
+// HandleV1VerifyAuditLog walks the hash chains of the audit log, the organization's or all of them, and reports where
+// they break
+func (h *Handler) HandleV1VerifyAuditLog(c echo.Context) (err error) {
+	var verifications []model.AuditVerification
+
+	verifications, err = h.controller.VerifyAuditLog(c.Request().Context(), c.QueryParam(OrganizationIdParam))
+	if err != nil {
+		return respondError(c, "failed to verify audit log", err)
+	}
+	return c.JSON(http.StatusOK, api.Envelope[[]api.AuditVerification]{Data: api.FromAuditVerifications(verifications)})
+}
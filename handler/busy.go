@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"fmt"
+	"henrymeds-takehome/api"
+	"henrymeds-takehome/controller"
+	"henrymeds-takehome/model"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+const BusySourceIdParam = "sourceId"
+
+// HandleV1CreateBusySource registers an outside calendar for the provider, sent as text/calendar to upload it or as
+// JSON with the URL it's served at, and imports its busy time
+func (h *Handler) HandleV1CreateBusySource(c echo.Context) (err error) {
+	var (
+		request = model.CreateBusySource{ProviderID: c.Param(ProviderIdParam)}
+		source  model.BusySource
+	)
+	if isCalendar(c) {
+		request.Calendar, err = io.ReadAll(c.Request().Body)
+		if err != nil {
+			return respondError(c, "failed to read calendar", err)
+		}
+	} else {
+		body := api.CreateBusySource{}
+		err = c.Bind(&body)
+		if err != nil {
+			return respondError(c, "failed to parse create busy source request", fmt.Errorf("%w: %s", controller.ErrInvalid, err.Error()))
+		}
+		request.URL = body.URL
+	}
+
+	source, err = h.controller.CreateBusySource(c.Request().Context(), request)
+	if err != nil {
+		return respondError(c, "failed to create busy source", err)
+	}
+	c.Response().Header().Set(echo.HeaderLocation, fmt.Sprintf("%s/users/%s/busy-sources/%s", V1Prefix, source.ProviderID, source.ID))
+	return c.JSON(http.StatusCreated, api.Envelope[api.BusySource]{Data: api.FromBusySource(source)})
+}
+
+// HandleV1ImportBusySource replaces the source's busy time, a URL source is fetched again and an uploaded one takes
+// the new calendar as text/calendar
+func (h *Handler) HandleV1ImportBusySource(c echo.Context) (err error) {
+	var (
+		request = model.ImportBusySource{ID: c.Param(BusySourceIdParam), ProviderID: c.Param(ProviderIdParam)}
+		source  model.BusySource
+	)
+	if isCalendar(c) {
+		request.Calendar, err = io.ReadAll(c.Request().Body)
+		if err != nil {
+			return respondError(c, "failed to read calendar", err)
+		}
+	}
+
+	source, err = h.controller.ImportBusySource(c.Request().Context(), request)
+	if err != nil {
+		return respondError(c, "failed to import busy source", err)
+	}
+	return c.JSON(http.StatusOK, api.Envelope[api.BusySource]{Data: api.FromBusySource(source)})
+}
+
+func (h *Handler) HandleV1GetBusySources(c echo.Context) (err error) {
+	var sources []model.BusySource
+
+	sources, err = h.controller.GetBusySources(c.Request().Context(), c.Param(ProviderIdParam))
+	if err != nil {
+		return respondError(c, "failed to get busy sources", err)
+	}
+	return c.JSON(http.StatusOK, api.Envelope[[]api.BusySource]{Data: api.FromBusySources(sources)})
+}
+
+func (h *Handler) HandleV1GetBusySource(c echo.Context) (err error) {
+	var source model.BusySource
+
+	source, err = h.controller.GetBusySource(c.Request().Context(), c.Param(ProviderIdParam), c.Param(BusySourceIdParam))
+	if err != nil {
+		return respondError(c, "failed to get busy source", err)
+	}
+	return c.JSON(http.StatusOK, api.Envelope[api.BusySource]{Data: api.FromBusySource(source)})
+}
+
+// HandleV1DeleteBusySource removes the source, its busy time stops blocking the provider's availability
+func (h *Handler) HandleV1DeleteBusySource(c echo.Context) (err error) {
+	err = h.controller.DeleteBusySource(c.Request().Context(), c.Param(ProviderIdParam), c.Param(BusySourceIdParam))
+	if err != nil {
+		return respondError(c, "failed to delete busy source", err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func isCalendar(c echo.Context) bool {
+	mediaType, _, _ := mime.ParseMediaType(c.Request().Header.Get(echo.HeaderContentType))
+	return mediaType == "text/calendar"
+}
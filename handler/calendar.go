@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"henrymeds-takehome/api"
+	"henrymeds-takehome/controller"
+	"henrymeds-takehome/ical"
+	"henrymeds-takehome/model"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// TokenParam carries the calendar feed token, calendar apps subscribe to a bare URL and can't send an API key
+const TokenParam = "token"
+
+// HandleV1RotateCalendarToken issues a new feed token for the user and returns the feed URL built from it.
+// The token is only ever shown here, the previous one stops working
+func (h *Handler) HandleV1RotateCalendarToken(c echo.Context) (err error) {
+	var (
+		userID = c.Param(UserIdParam)
+		token  string
+	)
+	token, err = h.controller.RotateCalendarToken(c.Request().Context(), userID)
+	if err != nil {
+		return respondError(c, "failed to issue calendar token", err)
+	}
+	feed := url.URL{
+		Scheme:   c.Scheme(),
+		Host:     c.Request().Host,
+		Path:     fmt.Sprintf("%s/users/%s/calendar.ics", V1Prefix, userID),
+		RawQuery: url.Values{TokenParam: {token}}.Encode(),
+	}
+	return c.JSON(http.StatusOK, api.Envelope[api.CalendarToken]{Data: api.CalendarToken{Token: token, URL: feed.String()}})
+}
+
+// HandleV1GetCalendar serves the user's reservations, as provider and as client, as an iCalendar feed
+func (h *Handler) HandleV1GetCalendar(c echo.Context) (err error) {
+	var (
+		reservations []model.Reservation
+		now          = time.Now()
+		calendar     = ical.Calendar{Name: "Appointments"}
+	)
+	reservations, err = h.controller.GetCalendar(c.Request().Context(), c.Param(UserIdParam), c.QueryParam(TokenParam))
+	if err != nil {
+		return respondError(c, "failed to get calendar", err)
+	}
+	for _, reservation := range reservations {
+		if event, ok := ical.ReservationEvent(reservation, now); ok {
+			calendar.Events = append(calendar.Events, event)
+		}
+	}
+	// the token is in the URL, keep shared caches from holding on to the feed
+	c.Response().Header().Set(echo.HeaderCacheControl, "private, no-cache")
+	return writeCalendar(c, calendar)
+}
+
+// HandleV1GetReservationCalendar serves a single reservation as an .ics attachment, to be added to a calendar as an invite
+func (h *Handler) HandleV1GetReservationCalendar(c echo.Context) (err error) {
+	var reservation model.Reservation
+
+	reservation, err = h.controller.GetReservation(c.Request().Context(), c.Param(ReservationIdParam))
+	if err != nil {
+		return respondError(c, "failed to get reservation calendar", err)
+	}
+	event, ok := ical.ReservationEvent(reservation, time.Now())
+	if !ok {
+		return respondError(c, "failed to get reservation calendar", fmt.Errorf("%w: the hold expired, there is no appointment", controller.ErrNotFound))
+	}
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=\"reservation-%s.ics\"", reservation.ID))
+	return writeCalendar(c, ical.Calendar{Events: []ical.Event{event}})
+}
+
+func writeCalendar(c echo.Context, calendar ical.Calendar) error {
+	var body bytes.Buffer
+	if err := ical.Encode(&body, calendar); err != nil {
+		return respondError(c, "failed to encode calendar", err)
+	}
+	return c.Blob(http.StatusOK, ical.ContentType, body.Bytes())
+}
@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"henrymeds-takehome/controller"
+	"henrymeds-takehome/model"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	LimitParam  = "limit"
+	SortParam   = "sort"
+	CursorParam = "cursor"
+)
+
+// cursor is what a page's opaque cursor decodes to. Callers shouldn't build or pick these apart,
+// the base64 is there so they don't, the format can change under them
+type cursor struct {
+	Start time.Time `json:"s"`
+	ID    string    `json:"i"`
+	// the sort the cursor was issued for, a key is only a position in one order
+	Sort string `json:"o"`
+}
+
+func encodeCursor(key *model.PageKey, sort string) string {
+	if key == nil {
+		return ""
+	}
+	raw, _ := json.Marshal(cursor{Start: key.Start, ID: key.ID, Sort: effectiveSort(sort)})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(encoded string, sort string) (key *model.PageKey, err error) {
+	var (
+		raw    []byte
+		decode cursor
+	)
+	raw, err = base64.RawURLEncoding.DecodeString(encoded)
+	if err == nil {
+		err = json.Unmarshal(raw, &decode)
+	}
+	if err == nil {
+		_, err = uuid.Parse(decode.ID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", controller.ErrInvalid)
+	}
+	if decode.Sort != effectiveSort(sort) {
+		return nil, fmt.Errorf("%w: the cursor belongs to a list sorted by %s", controller.ErrInvalid, decode.Sort)
+	}
+	return &model.PageKey{Start: decode.Start, ID: decode.ID}, nil
+}
+
+// the controller defaults an empty sort to ascending, the cursor has to agree with it
+func effectiveSort(sort string) string {
+	if sort == "" {
+		return model.SortStartAsc
+	}
+	return sort
+}
+
+// parsePage reads the limit, sort and cursor query parameters, limits and defaults are up to the controller
+func parsePage(c echo.Context) (page model.Page, err error) {
+	return parseSortedPage(c, c.QueryParam(SortParam))
+}
+
+// parseSortedPage is parsePage for lists that only come in one order
+func parseSortedPage(c echo.Context, sort string) (page model.Page, err error) {
+	page.Sort = sort
+	if limit := c.QueryParam(LimitParam); limit != "" {
+		page.Limit, err = strconv.Atoi(limit)
+		if err != nil || page.Limit < 1 {
+			return page, fmt.Errorf("%w: limit must be a positive integer", controller.ErrInvalid)
+		}
+	}
+	if encoded := c.QueryParam(CursorParam); encoded != "" {
+		page.After, err = decodeCursor(encoded, page.Sort)
+	}
+	return
+}
+
+// nextPageURL is the request's own URL pointing at the page after this one
+func nextPageURL(c echo.Context, next string) string {
+	query := c.Request().URL.Query()
+	query.Set(CursorParam, next)
+	return (&url.URL{Path: c.Request().URL.Path, RawQuery: query.Encode()}).String()
+}
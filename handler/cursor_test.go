@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"encoding/base64"
+	"errors"
+	"henrymeds-takehome/controller"
+	"henrymeds-takehome/model"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const cursorID = "00000000-0000-4000-8000-0000000000b1"
+
+// the key comes back exactly, a cursor that lost the nanoseconds or the ID would skip or repeat rows that share a
+// start time
+func TestCursor(t *testing.T) {
+	var (
+		start   = time.Date(2030, 1, 7, 9, 0, 0, 123456789, time.UTC)
+		newYork = time.FixedZone("EST", -5*60*60)
+	)
+	for name, test := range map[string]struct {
+		key *model.PageKey
+		// what it's issued and read back for
+		issued, read string
+	}{
+		"ascending":              {key: &model.PageKey{Start: start, ID: cursorID}, issued: model.SortStartAsc, read: model.SortStartAsc},
+		"descending":             {key: &model.PageKey{Start: start, ID: cursorID}, issued: model.SortStartDesc, read: model.SortStartDesc},
+		"default sort":           {key: &model.PageKey{Start: start, ID: cursorID}, read: model.SortStartAsc},
+		"read with default sort": {key: &model.PageKey{Start: start, ID: cursorID}, issued: model.SortStartAsc},
+		"another time zone":      {key: &model.PageKey{Start: start.In(newYork), ID: cursorID}},
+		"before the unix epoch":  {key: &model.PageKey{Start: time.Date(1969, 12, 31, 23, 59, 59, 1, time.UTC), ID: cursorID}},
+		"uppercase ID":           {key: &model.PageKey{Start: start, ID: strings.ToUpper("0000000a-0000-4000-8000-0000000000b1")}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			encoded := encodeCursor(test.key, test.issued)
+			if strings.ContainsAny(encoded, "+/=") {
+				t.Fatalf("%s isn't safe in a query", encoded)
+			}
+			key, err := decodeCursor(encoded, test.read)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !key.Start.Equal(test.key.Start) || key.ID != test.key.ID {
+				t.Fatalf("got %+v, want %+v", key, test.key)
+			}
+		})
+	}
+
+	// the last page has no next one
+	if encoded := encodeCursor(nil, model.SortStartAsc); encoded != "" {
+		t.Fatalf("no key is %q", encoded)
+	}
+}
+
+func TestDecodeCursorRejects(t *testing.T) {
+	var (
+		start = time.Date(2030, 1, 7, 9, 0, 0, 0, time.UTC)
+		raw   = func(json string) string {
+			return base64.RawURLEncoding.EncodeToString([]byte(json))
+		}
+	)
+	for name, test := range map[string]struct {
+		encoded string
+		sort    string
+		want    string
+	}{
+		"not base64":       {encoded: "not a cursor!", want: "malformed cursor"},
+		"padded":           {encoded: base64.URLEncoding.EncodeToString([]byte(`{"s":"2030-01-07T09:00:00Z","i":"` + cursorID + `","o":"start"}`)), want: "malformed cursor"},
+		"not JSON":         {encoded: raw("start=2030-01-07"), want: "malformed cursor"},
+		"not a time":       {encoded: raw(`{"s":"tomorrow","i":"` + cursorID + `","o":"start"}`), want: "malformed cursor"},
+		"no ID":            {encoded: raw(`{"s":"2030-01-07T09:00:00Z","o":"start"}`), want: "malformed cursor"},
+		"ID isn't a UUID":  {encoded: raw(`{"s":"2030-01-07T09:00:00Z","i":"1 OR 1=1","o":"start"}`), want: "malformed cursor"},
+		"another sort":     {encoded: encodeCursor(&model.PageKey{Start: start, ID: cursorID}, model.SortStartAsc), sort: model.SortStartDesc, want: "the cursor belongs to a list sorted by start"},
+		"the default sort": {encoded: encodeCursor(&model.PageKey{Start: start, ID: cursorID}, model.SortStartDesc), want: "the cursor belongs to a list sorted by -start"},
+		"no sort":          {encoded: raw(`{"s":"2030-01-07T09:00:00Z","i":"` + cursorID + `"}`), want: "the cursor belongs to a list sorted by "},
+	} {
+		t.Run(name, func(t *testing.T) {
+			key, err := decodeCursor(test.encoded, test.sort)
+			if !errors.Is(err, controller.ErrInvalid) || !strings.Contains(err.Error(), test.want) || key != nil {
+				t.Fatalf("got %+v, err %v, want an invalid cursor about %q", key, err, test.want)
+			}
+		})
+	}
+}
+
+func TestParsePage(t *testing.T) {
+	var (
+		start  = time.Date(2030, 1, 7, 9, 0, 0, 0, time.UTC)
+		cursor = encodeCursor(&model.PageKey{Start: start, ID: cursorID}, model.SortStartDesc)
+	)
+	for name, test := range map[string]struct {
+		query string
+		want  model.Page
+		err   string
+	}{
+		"defaults":                   {},
+		"limit":                      {query: "limit=10", want: model.Page{Limit: 10}},
+		"zero limit":                 {query: "limit=0", err: "limit must be a positive integer"},
+		"negative limit":             {query: "limit=-1", err: "limit must be a positive integer"},
+		"limit isn't a number":       {query: "limit=ten", err: "limit must be a positive integer"},
+		"next page":                  {query: "sort=-start&limit=10&cursor=" + cursor, want: model.Page{Sort: model.SortStartDesc, Limit: 10, After: &model.PageKey{Start: start, ID: cursorID}}},
+		"next page in another order": {query: "cursor=" + cursor, err: "the cursor belongs to a list sorted by -start"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/v1/availabilities?"+test.query, nil), httptest.NewRecorder())
+			page, err := parsePage(c)
+			if test.err != "" {
+				if !errors.Is(err, controller.ErrInvalid) || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("got %v, want an invalid page about %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if page.Sort != test.want.Sort || page.Limit != test.want.Limit || (page.After == nil) != (test.want.After == nil) {
+				t.Fatalf("got %+v, want %+v", page, test.want)
+			}
+			if page.After != nil && (!page.After.Start.Equal(test.want.After.Start) || page.After.ID != test.want.After.ID) {
+				t.Fatalf("picks up after %+v, want %+v", page.After, test.want.After)
+			}
+		})
+	}
+}
+
+// the next page's URL keeps the rest of the query, with the cursor replaced
+func TestNextPageURL(t *testing.T) {
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/v1/availabilities?providerId=a&cursor=old&limit=2", nil), httptest.NewRecorder())
+	if got, want := nextPageURL(c, "new"), "/v1/availabilities?cursor=new&limit=2&providerId=a"; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
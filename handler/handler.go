@@ -1,10 +1,11 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"henrymeds-takehome/controller"
 	"henrymeds-takehome/model"
-	"log"
+	"log/slog"
 	"net/http"
 	"time"
 
@@ -18,6 +19,7 @@ func NewHandler(controller controller.Controller) *Handler {
 }
 
 const (
+	UserIdParam     = "userId"
 	ProviderIdParam = "providerId"
 	StartParam      = "start"
 	EndParam        = "end"
@@ -32,8 +34,10 @@ type Handler struct {
 
 func (h *Handler) HandleGetAvailabilitiesRequest(c echo.Context) (err error) {
 	var (
-		availabilities []model.TimeRange
+		availabilities []model.Availability
 		times          []time.Time
+		page           model.Page
+		next           *model.PageKey
 	)
 	// parse the JSON into a timerange
 	times, err = parseTimes([]string{
@@ -41,24 +45,37 @@ func (h *Handler) HandleGetAvailabilitiesRequest(c echo.Context) (err error) {
 		c.QueryParam(EndParam),
 	})
 	if err != nil {
+		logFailure(c, errInvalidTimeFormat, err)
 		_ = c.String(http.StatusBadRequest, errInvalidTimeFormat)
 		return
 	}
+	page, err = parsePage(c)
+	if err != nil {
+		logFailure(c, "failed to get availabilities", err)
+		_ = c.String(http.StatusBadRequest, err.Error())
+		return
+	}
 
-	availabilities, err = h.controller.GetAvailabilities(c.Request().Context(), model.GetAvailabilities{
+	availabilities, next, err = h.controller.GetAvailabilities(c.Request().Context(), model.GetAvailabilities{
 		TimeRange: model.TimeRange{
 			Start: times[0],
 			End:   times[1],
 		},
 		ProviderID: c.Param(ProviderIdParam),
+		Page:       page,
 	})
 	if err != nil {
 		// TODO: status handler
 		msg := fmt.Sprintf("failed to get availabilities: %s", err.Error())
+		logFailure(c, "failed to get availabilities", err)
 		_ = c.String(http.StatusBadRequest, msg)
 		return
 	}
-	_ = c.JSON(http.StatusOK, availabilities)
+	// the body is a bare list, so the next page goes in a header
+	if next != nil {
+		c.Response().Header().Add("Link", fmt.Sprintf("<%s>; rel=\"next\"", nextPageURL(c, encodeCursor(next, page.Sort))))
+	}
+	_ = c.JSON(http.StatusOK, extractTimeranges(availabilities))
 	return
 }
 
@@ -69,18 +86,18 @@ func (h *Handler) HandleCreateAvailabilityRequest(c echo.Context) (err error) {
 	err = c.Bind(&request)
 	if err != nil {
 		msg := fmt.Sprintf("failed to parse create availabilities request: %s", err.Error())
-		log.Println(msg)
+		logFailure(c, "failed to parse create availabilities request", err)
 		_ = c.String(http.StatusBadRequest, msg)
 		return
 	}
-	fmt.Println(c.Param(ProviderIdParam))
-	err = h.controller.CreateAvailability(c.Request().Context(), model.CreateAvailabilities{
+	_, err = h.controller.CreateAvailability(c.Request().Context(), model.CreateAvailabilities{
 		TimeRange:  request,
 		ProviderID: c.Param(ProviderIdParam),
 	})
 	if err != nil {
 		// TODO: status handler
 		msg := fmt.Sprintf("failed to create availability: %s", err.Error())
+		logFailure(c, "failed to create availability", err)
 		_ = c.String(http.StatusBadRequest, msg)
 		return
 	}
@@ -90,25 +107,26 @@ func (h *Handler) HandleCreateAvailabilityRequest(c echo.Context) (err error) {
 
 func (h *Handler) HandleCreateReservationRequest(c echo.Context) (err error) {
 	var (
-		request        = model.CreateReservation{}
-		confirmationID string
+		request     = model.CreateReservation{}
+		reservation model.Reservation
 	)
 	err = c.Bind(&request)
 	if err != nil {
 		msg := fmt.Sprintf("failed to parse create reservations request: %s", err.Error())
-		log.Println(msg)
+		logFailure(c, "failed to parse create reservations request", err)
 		_ = c.String(http.StatusBadRequest, msg)
 		return
 	}
 
-	confirmationID, err = h.controller.CreateReservation(c.Request().Context(), request)
+	reservation, err = h.controller.CreateReservation(c.Request().Context(), request)
 	if err != nil {
 		// TODO: status handler
 		msg := fmt.Sprintf("failed to create reservation: %s", err.Error())
+		logFailure(c, "failed to create reservation", err)
 		_ = c.String(http.StatusBadRequest, msg)
 		return
 	}
-	_ = c.String(http.StatusOK, confirmationID)
+	_ = c.String(http.StatusOK, reservation.ConfirmationID)
 	return
 }
 
@@ -118,14 +136,15 @@ func (h *Handler) HandleConfirmReservationRequest(c echo.Context) (err error) {
 	confirmationId = c.Param("confirmationId")
 	if err != nil {
 		msg := fmt.Sprintf("failed to parse create reservations request: %s", err.Error())
-		log.Println(msg)
+		logFailure(c, "failed to parse create reservations request", err)
 		_ = c.String(http.StatusBadRequest, msg)
 		return
 	}
-	err = h.controller.ConfirmReservation(c.Request().Context(), confirmationId)
+	_, err = h.controller.ConfirmReservation(c.Request().Context(), confirmationId)
 	if err != nil {
 		// TODO: status handler
 		msg := fmt.Sprintf("failed to confirm reservation: %s", err.Error())
+		logFailure(c, "failed to confirm reservation", err)
 		_ = c.String(http.StatusBadRequest, msg)
 		return
 	}
@@ -133,15 +152,41 @@ func (h *Handler) HandleConfirmReservationRequest(c echo.Context) (err error) {
 	return
 }
 
+func (h *Handler) HandleGetUserRequest(c echo.Context) (err error) {
+	var user model.User
+
+	user, err = h.controller.GetUser(c.Request().Context(), c.Param(UserIdParam))
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, controller.ErrNotFound) {
+			status = http.StatusNotFound
+		}
+		msg := fmt.Sprintf("failed to get user: %s", err.Error())
+		logFailure(c, "failed to get user", err)
+		_ = c.String(status, msg)
+		return
+	}
+	_ = c.JSON(http.StatusOK, user)
+	return
+}
+
 // everything below here would go into a util package
 
+// errors are logged once, here at the edge, rather than on every layer they pass through
+func logFailure(c echo.Context, msg string, err error) {
+	slog.WarnContext(c.Request().Context(), msg,
+		"error", err,
+		"method", c.Request().Method,
+		"path", c.Path(),
+	)
+}
+
 // parses list of times
 func parseTimes(timeStrs []string) (times []time.Time, err error) {
 	for _, ts := range timeStrs {
 		var t time.Time
 		t, err = time.Parse(time.RFC3339, ts)
 		if err != nil {
-			log.Println("invalid time provided: ", err)
 			return
 		}
 		times = append(times, t)
@@ -149,3 +194,10 @@ func parseTimes(timeStrs []string) (times []time.Time, err error) {
 
 	return
 }
+
+func extractTimeranges(availabilities []model.Availability) (timeranges []model.TimeRange) {
+	for _, avail := range availabilities {
+		timeranges = append(timeranges, avail.TimeRange)
+	}
+	return
+}
@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"fmt"
+	"henrymeds-takehome/api"
+	"henrymeds-takehome/controller"
+	"henrymeds-takehome/model"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const LicenseStateParam = "state"
+
+func (h *Handler) HandleV1GetProviderLicenses(c echo.Context) (err error) {
+	var licenses []model.ProviderLicense
+
+	licenses, err = h.controller.GetProviderLicenses(c.Request().Context(), c.Param(ProviderIdParam))
+	if err != nil {
+		return respondError(c, "failed to get provider licenses", err)
+	}
+	return c.JSON(http.StatusOK, api.Envelope[[]api.ProviderLicense]{Data: api.FromProviderLicenses(licenses)})
+}
+
+// HandleV1PutProviderLicense adds the provider's license for the state in the path, or renews the one they have
+func (h *Handler) HandleV1PutProviderLicense(c echo.Context) (err error) {
+	var (
+		body    = api.PutProviderLicense{}
+		license model.ProviderLicense
+	)
+	err = c.Bind(&body)
+	if err != nil {
+		return respondError(c, "failed to parse provider license request", fmt.Errorf("%w: %s", controller.ErrInvalid, err.Error()))
+	}
+	expiresOn, err := time.Parse(time.DateOnly, body.ExpiresOn)
+	if err != nil {
+		return respondError(c, "failed to parse provider license request", fmt.Errorf("%w: expiresOn must be a YYYY-MM-DD date", controller.ErrInvalid))
+	}
+
+	license, err = h.controller.PutProviderLicense(c.Request().Context(), model.ProviderLicense{
+		ProviderID:    c.Param(ProviderIdParam),
+		State:         c.Param(LicenseStateParam),
+		LicenseNumber: body.LicenseNumber,
+		ExpiresOn:     expiresOn,
+	})
+	if err != nil {
+		return respondError(c, "failed to store provider license", err)
+	}
+	return c.JSON(http.StatusOK, api.Envelope[api.ProviderLicense]{Data: api.FromProviderLicense(license)})
+}
+
+// HandleV1DeleteProviderLicense removes the provider's license for the state, they can't be booked by clients there
+// anymore. Reservations already made are kept
+func (h *Handler) HandleV1DeleteProviderLicense(c echo.Context) (err error) {
+	err = h.controller.DeleteProviderLicense(c.Request().Context(), c.Param(ProviderIdParam), c.Param(LicenseStateParam))
+	if err != nil {
+		return respondError(c, "failed to delete provider license", err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
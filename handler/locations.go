@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"fmt"
+	"henrymeds-takehome/api"
+	"henrymeds-takehome/controller"
+	"henrymeds-takehome/model"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// HandleV1CreateLocation adds a place in-person visits happen at, availabilities point at it to offer them
+func (h *Handler) HandleV1CreateLocation(c echo.Context) (err error) {
+	var (
+		body     = api.CreateLocation{}
+		location model.Location
+	)
+	err = c.Bind(&body)
+	if err != nil {
+		return respondError(c, "failed to parse create location request", fmt.Errorf("%w: %s", controller.ErrInvalid, err.Error()))
+	}
+
+	location, err = h.controller.CreateLocation(c.Request().Context(), model.CreateLocation{
+		Name:     body.Name,
+		Address:  body.Address,
+		TimeZone: body.TimeZone,
+	})
+	if err != nil {
+		return respondError(c, "failed to create location", err)
+	}
+	c.Response().Header().Set(echo.HeaderLocation, fmt.Sprintf("%s/locations/%s", V1Prefix, location.ID))
+	return c.JSON(http.StatusCreated, api.Envelope[api.Location]{Data: api.FromLocation(location)})
+}
+
+func (h *Handler) HandleV1GetLocations(c echo.Context) (err error) {
+	var locations []model.Location
+
+	locations, err = h.controller.GetLocations(c.Request().Context())
+	if err != nil {
+		return respondError(c, "failed to get locations", err)
+	}
+	return c.JSON(http.StatusOK, api.Envelope[[]api.Location]{Data: api.FromLocations(locations)})
+}
+
+func (h *Handler) HandleV1GetLocation(c echo.Context) (err error) {
+	var location model.Location
+
+	location, err = h.controller.GetLocation(c.Request().Context(), c.Param(LocationIdParam))
+	if err != nil {
+		return respondError(c, "failed to get location", err)
+	}
+	return c.JSON(http.StatusOK, api.Envelope[api.Location]{Data: api.FromLocation(location)})
+}
@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"fmt"
+	"henrymeds-takehome/api"
+	"henrymeds-takehome/controller"
+	"henrymeds-takehome/model"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+const OrganizationIdParam = "organizationId"
+
+// HandleV1CreateOrganization adds a tenant, the response is the only time its API key is shown
+func (h *Handler) HandleV1CreateOrganization(c echo.Context) (err error) {
+	var (
+		body         = api.CreateOrganization{}
+		organization model.Organization
+		apiKey       string
+	)
+	err = c.Bind(&body)
+	if err != nil {
+		return respondError(c, "failed to parse create organization request", fmt.Errorf("%w: %s", controller.ErrInvalid, err.Error()))
+	}
+
+	organization, apiKey, err = h.controller.CreateOrganization(c.Request().Context(), model.CreateOrganization{Name: body.Name})
+	if err != nil {
+		return respondError(c, "failed to create organization", err)
+	}
+	created := api.FromOrganization(organization)
+	created.APIKey = apiKey
+	c.Response().Header().Set(echo.HeaderLocation, fmt.Sprintf("%s/admin/organizations/%s", V1Prefix, organization.ID))
+	return c.JSON(http.StatusCreated, api.Envelope[api.Organization]{Data: created})
+}
+
+func (h *Handler) HandleV1GetOrganizations(c echo.Context) (err error) {
+	var organizations []model.Organization
+
+	organizations, err = h.controller.GetOrganizations(c.Request().Context())
+	if err != nil {
+		return respondError(c, "failed to get organizations", err)
+	}
+	return c.JSON(http.StatusOK, api.Envelope[[]api.Organization]{Data: api.FromOrganizations(organizations)})
+}
+
+func (h *Handler) HandleV1GetOrganization(c echo.Context) (err error) {
+	var organization model.Organization
+
+	organization, err = h.controller.GetOrganization(c.Request().Context(), c.Param(OrganizationIdParam))
+	if err != nil {
+		return respondError(c, "failed to get organization", err)
+	}
+	return c.JSON(http.StatusOK, api.Envelope[api.Organization]{Data: api.FromOrganization(organization)})
+}
+
+// HandleV1RotateOrganizationKey issues the organization a new API key, the old one stops working right away
+func (h *Handler) HandleV1RotateOrganizationKey(c echo.Context) (err error) {
+	var apiKey string
+
+	apiKey, err = h.controller.RotateOrganizationKey(c.Request().Context(), c.Param(OrganizationIdParam))
+	if err != nil {
+		return respondError(c, "failed to issue organization key", err)
+	}
+	return c.JSON(http.StatusOK, api.Envelope[api.OrganizationKey]{Data: api.OrganizationKey{APIKey: apiKey}})
+}
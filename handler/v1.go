@@ -0,0 +1,430 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"henrymeds-takehome/api"
+	"henrymeds-takehome/bulk"
+	"henrymeds-takehome/controller"
+	"henrymeds-takehome/logging"
+	"henrymeds-takehome/model"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	AvailabilityIdParam  = "availabilityId"
+	ReservationIdParam   = "reservationId"
+	ConfirmationIdParam  = "confirmationId"
+	DryRunParam          = "dryRun"
+	ForceParam           = "force"
+	AppointmentTypeParam = "appointmentType"
+	ClientIdParam        = "clientId"
+	ModalityParam        = "modality"
+	LocationIdParam      = "locationId"
+
+	// V1Prefix is where the current version of the API is mounted
+	V1Prefix = "/v1"
+)
+
+// the /v1 handlers return JSON resources wrapped in an api.Envelope, errors in an api.ErrorEnvelope
+
+func (h *Handler) HandleV1GetAvailabilities(c echo.Context) (err error) {
+	var (
+		availabilities []model.Availability
+		times          []time.Time
+		page           model.Page
+		next           *model.PageKey
+	)
+	times, err = parseTimes([]string{
+		c.QueryParam(StartParam),
+		c.QueryParam(EndParam),
+	})
+	if err != nil {
+		return respondError(c, "failed to get availabilities", fmt.Errorf("%w: %s", controller.ErrInvalid, errInvalidTimeFormat))
+	}
+	page, err = parsePage(c)
+	if err != nil {
+		return respondError(c, "failed to get availabilities", err)
+	}
+
+	availabilities, next, err = h.controller.GetAvailabilities(c.Request().Context(), model.GetAvailabilities{
+		TimeRange: model.TimeRange{
+			Start: times[0],
+			End:   times[1],
+		},
+		ProviderID: c.Param(ProviderIdParam),
+		Page:       page,
+	})
+	if err != nil {
+		return respondError(c, "failed to get availabilities", err)
+	}
+	return c.JSON(http.StatusOK, api.Envelope[[]api.Availability]{
+		Data: api.FromAvailabilities(availabilities),
+		Page: &api.Page{Next: encodeCursor(next, page.Sort)},
+	})
+}
+
+func (h *Handler) HandleV1GetAvailability(c echo.Context) (err error) {
+	var availability model.Availability
+
+	availability, err = h.controller.GetAvailability(c.Request().Context(), c.Param(ProviderIdParam), c.Param(AvailabilityIdParam))
+	if err != nil {
+		return respondError(c, "failed to get availability", err)
+	}
+	return c.JSON(http.StatusOK, api.Envelope[api.Availability]{Data: api.FromAvailability(availability)})
+}
+
+// HandleV1GetSlots lists the provider's bookable slots with the seats they have left, one slot interval long or as
+// long as the appointment type. With a clientId, only the slots the provider is licensed to see the client for, and
+// with a modality or locationId only the slots that can be booked that way
+func (h *Handler) HandleV1GetSlots(c echo.Context) (err error) {
+	var (
+		slots []model.Slot
+		times []time.Time
+	)
+	times, err = parseTimes([]string{
+		c.QueryParam(StartParam),
+		c.QueryParam(EndParam),
+	})
+	if err != nil {
+		return respondError(c, "failed to get slots", fmt.Errorf("%w: %s", controller.ErrInvalid, errInvalidTimeFormat))
+	}
+
+	slots, err = h.controller.GetSlots(c.Request().Context(), model.GetSlots{
+		ProviderID: c.Param(ProviderIdParam),
+		TimeRange: model.TimeRange{
+			Start: times[0],
+			End:   times[1],
+		},
+		AppointmentType: c.QueryParam(AppointmentTypeParam),
+		ClientID:        c.QueryParam(ClientIdParam),
+		Modality:        c.QueryParam(ModalityParam),
+		LocationID:      c.QueryParam(LocationIdParam),
+	})
+	if err != nil {
+		return respondError(c, "failed to get slots", err)
+	}
+	return c.JSON(http.StatusOK, api.Envelope[[]api.Slot]{Data: api.FromSlots(slots)})
+}
+
+func (h *Handler) HandleV1CreateAvailability(c echo.Context) (err error) {
+	var (
+		request      = api.CreateAvailability{}
+		availability model.Availability
+	)
+	err = c.Bind(&request)
+	if err != nil {
+		return respondError(c, "failed to parse create availability request", fmt.Errorf("%w: %s", controller.ErrInvalid, err.Error()))
+	}
+
+	availability, err = h.controller.CreateAvailability(c.Request().Context(), model.CreateAvailabilities{
+		TimeRange: model.TimeRange{
+			Start: request.Start,
+			End:   request.End,
+		},
+		ProviderID: c.Param(ProviderIdParam),
+		Capacity:   request.Capacity,
+		Modalities: request.Modalities,
+		LocationID: request.LocationID,
+	})
+	if err != nil {
+		return respondError(c, "failed to create availability", err)
+	}
+	c.Response().Header().Set(echo.HeaderLocation, fmt.Sprintf("%s/users/%s/availabilities/%s", V1Prefix, availability.ProviderID, availability.ID))
+	return c.JSON(http.StatusCreated, api.Envelope[api.Availability]{Data: api.FromAvailability(availability)})
+}
+
+// HandleV1UpdateAvailability moves either end of an availability or changes its capacity. A change that would leave a confirmed reservation
+// without availability is refused with a 409 unless force=true, then those reservations are cancelled and returned
+func (h *Handler) HandleV1UpdateAvailability(c echo.Context) (err error) {
+	var (
+		request = api.UpdateAvailability{}
+		force   bool
+		change  model.AvailabilityChange
+	)
+	force, err = parseForce(c)
+	if err != nil {
+		return respondError(c, "failed to update availability", err)
+	}
+	err = c.Bind(&request)
+	if err != nil {
+		return respondError(c, "failed to parse update availability request", fmt.Errorf("%w: %s", controller.ErrInvalid, err.Error()))
+	}
+
+	change, err = h.controller.UpdateAvailability(c.Request().Context(), model.UpdateAvailability{
+		ID:         c.Param(AvailabilityIdParam),
+		ProviderID: c.Param(ProviderIdParam),
+		Start:      request.Start,
+		End:        request.End,
+		Capacity:   request.Capacity,
+		Force:      force,
+	})
+	if err != nil {
+		return respondError(c, "failed to update availability", err)
+	}
+	return c.JSON(http.StatusOK, api.Envelope[api.AvailabilityChange]{Data: api.FromAvailabilityChange(change, time.Now())})
+}
+
+// HandleV1DeleteAvailability removes an availability, under the same rules as HandleV1UpdateAvailability
+func (h *Handler) HandleV1DeleteAvailability(c echo.Context) (err error) {
+	var (
+		force  bool
+		change model.AvailabilityChange
+	)
+	force, err = parseForce(c)
+	if err != nil {
+		return respondError(c, "failed to delete availability", err)
+	}
+
+	change, err = h.controller.DeleteAvailability(c.Request().Context(), model.DeleteAvailability{
+		ID:         c.Param(AvailabilityIdParam),
+		ProviderID: c.Param(ProviderIdParam),
+		Force:      force,
+	})
+	if err != nil {
+		return respondError(c, "failed to delete availability", err)
+	}
+	return c.JSON(http.StatusOK, api.Envelope[api.AvailabilityChange]{Data: api.FromAvailabilityChange(change, time.Now())})
+}
+
+func parseForce(c echo.Context) (force bool, err error) {
+	if raw := c.QueryParam(ForceParam); raw != "" {
+		force, err = strconv.ParseBool(raw)
+		if err != nil {
+			err = fmt.Errorf("%w: force must be true or false", controller.ErrInvalid)
+		}
+	}
+	return
+}
+
+// HandleV1ImportAvailabilities creates a batch of availabilities from a CSV or JSON body. A batch with rejected rows,
+// or a dry run, comes back as a 200 with the report and nothing inserted, a committed one as a 201
+func (h *Handler) HandleV1ImportAvailabilities(c echo.Context) (err error) {
+	var (
+		format string
+		rows   []model.ImportRow
+		dryRun bool
+		report model.ImportReport
+	)
+	format, err = bulk.FormatOfContentType(c.Request().Header.Get(echo.HeaderContentType))
+	if err != nil {
+		return respondError(c, "failed to import availabilities", fmt.Errorf("%w: %s", controller.ErrInvalid, err.Error()))
+	}
+	if raw := c.QueryParam(DryRunParam); raw != "" {
+		dryRun, err = strconv.ParseBool(raw)
+		if err != nil {
+			return respondError(c, "failed to import availabilities", fmt.Errorf("%w: dryRun must be true or false", controller.ErrInvalid))
+		}
+	}
+	rows, err = bulk.ParseAvailabilities(c.Request().Body, format)
+	if err != nil {
+		return respondError(c, "failed to import availabilities", fmt.Errorf("%w: %s", controller.ErrInvalid, err.Error()))
+	}
+
+	report, err = h.controller.ImportAvailabilities(c.Request().Context(), model.ImportAvailabilities{
+		ProviderID: c.Param(ProviderIdParam),
+		Rows:       rows,
+		DryRun:     dryRun,
+	})
+	if err != nil {
+		return respondError(c, "failed to import availabilities", err)
+	}
+	status := http.StatusOK
+	if report.Committed {
+		status = http.StatusCreated
+	}
+	return c.JSON(status, api.Envelope[api.ImportReport]{Data: api.FromImportReport(report)})
+}
+
+func (h *Handler) HandleV1CreateReservation(c echo.Context) (err error) {
+	var (
+		request     = api.CreateReservation{}
+		reservation model.Reservation
+	)
+	err = c.Bind(&request)
+	if err != nil {
+		return respondError(c, "failed to parse create reservation request", fmt.Errorf("%w: %s", controller.ErrInvalid, err.Error()))
+	}
+
+	reservation, err = h.controller.CreateReservation(c.Request().Context(), model.CreateReservation{
+		ClientID:   request.ClientID,
+		ProviderID: request.ProviderID,
+		TimeRange: model.TimeRange{
+			Start: request.Start,
+			End:   request.End,
+		},
+		Modality:        request.Modality,
+		AppointmentType: request.AppointmentType,
+		Reason:          request.Reason,
+	})
+	if err != nil {
+		return respondError(c, "failed to create reservation", err)
+	}
+	response := api.FromReservation(reservation, time.Now())
+	response.ConfirmationID = reservation.ConfirmationID
+	c.Response().Header().Set(echo.HeaderLocation, fmt.Sprintf("%s/reservations/%s", V1Prefix, reservation.ID))
+	return c.JSON(http.StatusCreated, api.Envelope[api.Reservation]{Data: response})
+}
+
+func (h *Handler) HandleV1GetReservation(c echo.Context) (err error) {
+	var reservation model.Reservation
+
+	reservation, err = h.controller.GetReservation(c.Request().Context(), c.Param(ReservationIdParam))
+	if err != nil {
+		return respondError(c, "failed to get reservation", err)
+	}
+	return c.JSON(http.StatusOK, api.Envelope[api.Reservation]{Data: api.FromReservation(reservation, time.Now())})
+}
+
+func (h *Handler) HandleV1ConfirmReservation(c echo.Context) (err error) {
+	var reservation model.Reservation
+
+	reservation, err = h.controller.ConfirmReservation(c.Request().Context(), c.Param(ConfirmationIdParam))
+	if err != nil {
+		return respondError(c, "failed to confirm reservation", err)
+	}
+	return c.JSON(http.StatusOK, api.Envelope[api.Reservation]{Data: api.FromReservation(reservation, time.Now())})
+}
+
+// HandleV1ConfirmReservationByToken confirms with the token of a confirmation link, a GET so the link works with
+// one click. The token is checked instead of the API key
+func (h *Handler) HandleV1ConfirmReservationByToken(c echo.Context) (err error) {
+	var reservation model.Reservation
+
+	reservation, err = h.controller.ConfirmReservationByToken(c.Request().Context(), c.QueryParam(TokenParam))
+	if err != nil {
+		return respondError(c, "failed to confirm reservation", err)
+	}
+	return c.JSON(http.StatusOK, api.Envelope[api.Reservation]{Data: api.FromReservation(reservation, time.Now())})
+}
+
+func (h *Handler) HandleV1GetUser(c echo.Context) (err error) {
+	var user model.User
+
+	user, err = h.controller.GetUser(c.Request().Context(), c.Param(UserIdParam))
+	if err != nil {
+		return respondError(c, "failed to get user", err)
+	}
+	return c.JSON(http.StatusOK, api.Envelope[api.User]{Data: api.FromUser(user)})
+}
+
+// HandleV1UpdateUser records where the user is located
+func (h *Handler) HandleV1UpdateUser(c echo.Context) (err error) {
+	var (
+		body = api.UpdateUser{}
+		user model.User
+	)
+	err = c.Bind(&body)
+	if err != nil {
+		return respondError(c, "failed to parse update user request", fmt.Errorf("%w: %s", controller.ErrInvalid, err.Error()))
+	}
+
+	user, err = h.controller.UpdateUser(c.Request().Context(), model.UpdateUser{ID: c.Param(UserIdParam), State: body.State})
+	if err != nil {
+		return respondError(c, "failed to update user", err)
+	}
+	return c.JSON(http.StatusOK, api.Envelope[api.User]{Data: api.FromUser(user)})
+}
+
+// Deprecated marks the unversioned routes, pointing callers at their /v1 successor
+func Deprecated(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		c.Response().Header().Set("Deprecation", "true")
+		c.Response().Header().Set("Link", fmt.Sprintf("<%s%s>; rel=\"successor-version\"", V1Prefix, c.Request().URL.Path))
+		return next(c)
+	}
+}
+
+// statusFor maps the controller's error kinds onto HTTP statuses, anything else is our fault
+func statusFor(err error) int {
+	switch {
+	case errors.Is(err, controller.ErrInvalid):
+		return http.StatusBadRequest
+	case errors.Is(err, controller.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, controller.ErrConflict):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// respondError logs the failure once, here at the edge, and writes it back enveloped.
+// Internal errors are logged in full but the caller only gets a generic message
+func respondError(c echo.Context, msg string, err error) error {
+	status := statusFor(err)
+	message := fmt.Sprintf("%s: %s", msg, err.Error())
+	if status == http.StatusInternalServerError {
+		slog.ErrorContext(c.Request().Context(), msg, "error", err, "method", c.Request().Method, "path", c.Path())
+		message = msg
+	} else {
+		logFailure(c, msg, err)
+	}
+	return writeError(c, status, message)
+}
+
+func writeError(c echo.Context, status int, message string) error {
+	return c.JSON(status, api.ErrorEnvelope{Error: api.Error{
+		Status:    status,
+		Code:      codeFor(status),
+		Message:   message,
+		RequestID: logging.RequestID(c.Request().Context()),
+	}})
+}
+
+func codeFor(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return api.CodeInvalidRequest
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return api.CodeUnauthorized
+	case http.StatusNotFound:
+		return api.CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return api.CodeMethodNotAllowed
+	case http.StatusConflict:
+		return api.CodeConflict
+	case http.StatusUnprocessableEntity:
+		return api.CodeUnprocessable
+	case http.StatusTooManyRequests:
+		return api.CodeRateLimited
+	case http.StatusServiceUnavailable:
+		return api.CodeUnavailable
+	}
+	return api.CodeInternal
+}
+
+// ErrorHandler renders errors that didn't come from a handler, ex: a failed API key check, a request that
+// doesn't match the spec or an unknown route. Under /v1 they get the same envelope as every other error,
+// the deprecated routes keep echo's default format
+func ErrorHandler(e *echo.Echo) echo.HTTPErrorHandler {
+	return func(err error, c echo.Context) {
+		if c.Response().Committed {
+			return
+		}
+		if !strings.HasPrefix(c.Request().URL.Path, V1Prefix+"/") {
+			e.DefaultHTTPErrorHandler(err, c)
+			return
+		}
+
+		status := http.StatusInternalServerError
+		message := http.StatusText(status)
+		var httpErr *echo.HTTPError
+		if errors.As(err, &httpErr) {
+			status = httpErr.Code
+			message = fmt.Sprint(httpErr.Message)
+		} else {
+			slog.ErrorContext(c.Request().Context(), "unhandled error", "error", err, "method", c.Request().Method, "path", c.Path())
+		}
+		if c.Request().Method == http.MethodHead {
+			_ = c.NoContent(status)
+			return
+		}
+		_ = writeError(c, status, message)
+	}
+}
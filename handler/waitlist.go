@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"fmt"
+	"henrymeds-takehome/api"
+	"henrymeds-takehome/controller"
+	"henrymeds-takehome/model"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const WaitlistEntryIdParam = "entryId"
+
+// HandleV1CreateWaitlistEntry puts a client in line for a slot, for when the time they want is booked
+func (h *Handler) HandleV1CreateWaitlistEntry(c echo.Context) (err error) {
+	var (
+		body  = api.CreateWaitlistEntry{}
+		entry model.WaitlistEntry
+	)
+	err = c.Bind(&body)
+	if err != nil {
+		return respondError(c, "failed to parse create waitlist entry request", fmt.Errorf("%w: %s", controller.ErrInvalid, err.Error()))
+	}
+
+	entry, err = h.controller.CreateWaitlistEntry(c.Request().Context(), model.CreateWaitlistEntry{
+		ClientID:        body.ClientID,
+		ProviderID:      body.ProviderID,
+		AppointmentType: body.AppointmentType,
+		Modality:        body.Modality,
+		Priority:        body.Priority,
+		TimeRange: model.TimeRange{
+			Start: body.Start,
+			End:   body.End,
+		},
+	})
+	if err != nil {
+		return respondError(c, "failed to create waitlist entry", err)
+	}
+	c.Response().Header().Set(echo.HeaderLocation, fmt.Sprintf("%s/waitlist/%s", V1Prefix, entry.ID))
+	return c.JSON(http.StatusCreated, api.Envelope[api.WaitlistEntry]{Data: api.FromWaitlistEntry(entry, time.Now())})
+}
+
+// HandleV1GetWaitlistEntry returns the entry, with the slot it's holding while it has an open offer
+func (h *Handler) HandleV1GetWaitlistEntry(c echo.Context) (err error) {
+	var (
+		entry model.WaitlistEntry
+		offer model.Reservation
+	)
+
+	entry, offer, err = h.controller.GetWaitlistEntry(c.Request().Context(), c.Param(WaitlistEntryIdParam))
+	if err != nil {
+		return respondError(c, "failed to get waitlist entry", err)
+	}
+	return c.JSON(http.StatusOK, api.Envelope[api.WaitlistEntry]{Data: api.FromWaitlistOffer(entry, offer, time.Now())})
+}
+
+// HandleV1CancelWaitlistEntry takes the client out of line, a slot they were offered goes to the next in line
+func (h *Handler) HandleV1CancelWaitlistEntry(c echo.Context) (err error) {
+	err = h.controller.CancelWaitlistEntry(c.Request().Context(), c.Param(WaitlistEntryIdParam))
+	if err != nil {
+		return respondError(c, "failed to cancel waitlist entry", err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
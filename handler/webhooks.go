@@ -0,0 +1,147 @@
+package handler
+
+import (
+	"fmt"
+	"henrymeds-takehome/api"
+	"henrymeds-takehome/controller"
+	"henrymeds-takehome/model"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// the path parameter of a subscription, and the query parameter that filters the delivery log by one
+	WebhookIdParam         = "webhookId"
+	WebhookDeliveryIdParam = "deliveryId"
+	StatusParam            = "status"
+)
+
+// HandleV1CreateWebhookSubscription subscribes a URL to event types, the response is the only time the secret is shown
+func (h *Handler) HandleV1CreateWebhookSubscription(c echo.Context) (err error) {
+	var (
+		body         = api.CreateWebhookSubscription{}
+		subscription model.WebhookSubscription
+	)
+	err = c.Bind(&body)
+	if err != nil {
+		return respondError(c, "failed to parse create webhook request", fmt.Errorf("%w: %s", controller.ErrInvalid, err.Error()))
+	}
+
+	subscription, err = h.controller.CreateWebhookSubscription(c.Request().Context(), model.CreateWebhookSubscription{
+		OrganizationID: body.OrganizationID,
+		URL:            body.URL,
+		EventTypes:     body.EventTypes,
+		Secret:         body.Secret,
+	})
+	if err != nil {
+		return respondError(c, "failed to create webhook", err)
+	}
+	created := api.FromWebhookSubscription(subscription)
+	created.Secret = subscription.Secret
+	c.Response().Header().Set(echo.HeaderLocation, fmt.Sprintf("%s/admin/webhooks/%s", V1Prefix, subscription.ID))
+	return c.JSON(http.StatusCreated, api.Envelope[api.WebhookSubscription]{Data: created})
+}
+
+func (h *Handler) HandleV1GetWebhookSubscriptions(c echo.Context) (err error) {
+	var subscriptions []model.WebhookSubscription
+
+	subscriptions, err = h.controller.GetWebhookSubscriptions(c.Request().Context())
+	if err != nil {
+		return respondError(c, "failed to get webhooks", err)
+	}
+	return c.JSON(http.StatusOK, api.Envelope[[]api.WebhookSubscription]{Data: api.FromWebhookSubscriptions(subscriptions)})
+}
+
+func (h *Handler) HandleV1GetWebhookSubscription(c echo.Context) (err error) {
+	var subscription model.WebhookSubscription
+
+	subscription, err = h.controller.GetWebhookSubscription(c.Request().Context(), c.Param(WebhookIdParam))
+	if err != nil {
+		return respondError(c, "failed to get webhook", err)
+	}
+	return c.JSON(http.StatusOK, api.Envelope[api.WebhookSubscription]{Data: api.FromWebhookSubscription(subscription)})
+}
+
+// HandleV1UpdateWebhookSubscription changes the URL, the event types or pauses and resumes the subscription
+func (h *Handler) HandleV1UpdateWebhookSubscription(c echo.Context) (err error) {
+	var (
+		body         = api.UpdateWebhookSubscription{}
+		subscription model.WebhookSubscription
+	)
+	err = c.Bind(&body)
+	if err != nil {
+		return respondError(c, "failed to parse update webhook request", fmt.Errorf("%w: %s", controller.ErrInvalid, err.Error()))
+	}
+
+	subscription, err = h.controller.UpdateWebhookSubscription(c.Request().Context(), model.UpdateWebhookSubscription{
+		ID:         c.Param(WebhookIdParam),
+		URL:        body.URL,
+		EventTypes: body.EventTypes,
+		Active:     body.Active,
+	})
+	if err != nil {
+		return respondError(c, "failed to update webhook", err)
+	}
+	return c.JSON(http.StatusOK, api.Envelope[api.WebhookSubscription]{Data: api.FromWebhookSubscription(subscription)})
+}
+
+// HandleV1DeleteWebhookSubscription removes the subscription along with its delivery log
+func (h *Handler) HandleV1DeleteWebhookSubscription(c echo.Context) (err error) {
+	err = h.controller.DeleteWebhookSubscription(c.Request().Context(), c.Param(WebhookIdParam))
+	if err != nil {
+		return respondError(c, "failed to delete webhook", err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// HandleV1GetWebhookDeliveries pages through the delivery log newest first, optionally for one subscription or one
+// status. The dead letters are status=dead
+func (h *Handler) HandleV1GetWebhookDeliveries(c echo.Context) (err error) {
+	var (
+		page       model.Page
+		deliveries []model.WebhookDelivery
+		next       *model.PageKey
+	)
+	page, err = parseSortedPage(c, model.SortStartDesc)
+	if err != nil {
+		return respondError(c, "failed to get webhook deliveries", err)
+	}
+
+	deliveries, next, err = h.controller.GetWebhookDeliveries(c.Request().Context(), model.GetWebhookDeliveries{
+		SubscriptionID: c.QueryParam(WebhookIdParam),
+		Status:         c.QueryParam(StatusParam),
+		Page:           page,
+	})
+	if err != nil {
+		return respondError(c, "failed to get webhook deliveries", err)
+	}
+	return c.JSON(http.StatusOK, api.Envelope[[]api.WebhookDelivery]{
+		Data: api.FromWebhookDeliveries(deliveries),
+		Page: &api.Page{Next: encodeCursor(next, page.Sort)},
+	})
+}
+
+// HandleV1GetWebhookDelivery returns the delivery with its payload and every attempt at it
+func (h *Handler) HandleV1GetWebhookDelivery(c echo.Context) (err error) {
+	var (
+		delivery model.WebhookDelivery
+		attempts []model.WebhookAttempt
+	)
+	delivery, attempts, err = h.controller.GetWebhookDelivery(c.Request().Context(), c.Param(WebhookDeliveryIdParam))
+	if err != nil {
+		return respondError(c, "failed to get webhook delivery", err)
+	}
+	return c.JSON(http.StatusOK, api.Envelope[api.WebhookDelivery]{Data: api.FromWebhookDeliveryDetail(delivery, attempts)})
+}
+
+// HandleV1RedeliverWebhook queues the delivery to be sent again, answering 202 since it's sent in the background
+func (h *Handler) HandleV1RedeliverWebhook(c echo.Context) (err error) {
+	var delivery model.WebhookDelivery
+
+	delivery, err = h.controller.RedeliverWebhook(c.Request().Context(), c.Param(WebhookDeliveryIdParam))
+	if err != nil {
+		return respondError(c, "failed to redeliver webhook", err)
+	}
+	return c.JSON(http.StatusAccepted, api.Envelope[api.WebhookDelivery]{Data: api.FromWebhookDelivery(delivery)})
+}
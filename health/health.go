@@ -0,0 +1,102 @@
+package health
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	gopg "github.com/go-pg/pg/v10"
+	"github.com/labstack/echo/v4"
+)
+
+// BuildInfo is injected at link time, see the build target in the Makefile
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"buildTime"`
+}
+
+func NewChecker(db *gopg.DB, expectedMigration int64, timeout time.Duration, build BuildInfo) *Checker {
+	return &Checker{
+		db:                db,
+		expectedMigration: expectedMigration,
+		timeout:           timeout,
+		build:             build,
+	}
+}
+
+// Checker serves the endpoints the orchestrator probes
+type Checker struct {
+	db                *gopg.DB
+	expectedMigration int64
+	timeout           time.Duration
+	build             BuildInfo
+	draining          atomic.Bool
+}
+
+type readiness struct {
+	Status            string `json:"status"`
+	Database          string `json:"database"`
+	MigrationVersion  int64  `json:"migrationVersion"`
+	ExpectedMigration int64  `json:"expectedMigration"`
+	Draining          bool   `json:"draining"`
+}
+
+// Drain makes readiness fail so the orchestrator stops sending traffic while in-flight requests finish
+func (h *Checker) Drain() {
+	h.draining.Store(true)
+}
+
+// HandleHealthz reports liveness, if the process can answer it is alive
+func (h *Checker) HandleHealthz(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// HandleReadyz reports whether this instance should receive traffic: postgres answers within the timeout,
+// the schema is at least at the version this binary was built against and the server isn't shutting down
+func (h *Checker) HandleReadyz(c echo.Context) error {
+	var (
+		response = readiness{
+			Status:            "ok",
+			Database:          "ok",
+			ExpectedMigration: h.expectedMigration,
+			Draining:          h.draining.Load(),
+		}
+		status = http.StatusOK
+	)
+	ctx, cancel := context.WithTimeout(c.Request().Context(), h.timeout)
+	defer cancel()
+
+	// /readyz answers anyone, the error itself only goes to the log
+	err := h.db.Ping(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "readiness check failed to reach postgres", "error", err)
+		response.Database = "unreachable"
+	} else {
+		response.MigrationVersion, err = h.migrationVersion(ctx)
+		if err != nil {
+			slog.ErrorContext(ctx, "readiness check failed to read the migration version", "error", err)
+			response.Database = "failed to read migration version"
+		}
+	}
+
+	if err != nil || response.Draining || response.MigrationVersion < response.ExpectedMigration {
+		response.Status = "unavailable"
+		status = http.StatusServiceUnavailable
+	}
+	return c.JSON(status, response)
+}
+
+// HandleVersion reports the build this instance is running
+func (h *Checker) HandleVersion(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.build)
+}
+
+// migrationVersion reads the newest applied version from goose's bookkeeping table
+func (h *Checker) migrationVersion(ctx context.Context) (version int64, err error) {
+	_, err = h.db.QueryOneContext(ctx, gopg.Scan(&version),
+		"SELECT version_id FROM goose_db_version WHERE is_applied ORDER BY id DESC LIMIT 1")
+	return
+}
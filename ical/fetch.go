@@ -0,0 +1,78 @@
+package ical
+
+import (
+	"context"
+	"fmt"
+	"henrymeds-takehome/netguard"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Fetcher downloads a calendar someone else serves
+type Fetcher interface {
+	Fetch(ctx context.Context, calendarURL string) ([]byte, error)
+}
+
+// ErrPrivateAddress is returned for URLs that lead to loopback, private or link local addresses
+var ErrPrivateAddress = netguard.ErrPrivateAddress
+
+// NewHTTPFetcher fetches over http and https, a webcal URL is fetched over https. Calendars larger than maxBytes
+// are refused, allowPrivate lets the fetch reach private addresses, ex: a stand-in server in tests
+func NewHTTPFetcher(timeout time.Duration, maxBytes int64, allowPrivate bool) *httpFetcher {
+	dialer := netguard.Dialer(timeout, allowPrivate)
+	return &httpFetcher{
+		maxBytes: maxBytes,
+		client: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				DialContext:         dialer.DialContext,
+				TLSHandshakeTimeout: timeout,
+				// proxies from the environment would be dialed instead of the calendar's host and skip the check
+				Proxy: nil,
+			},
+		},
+	}
+}
+
+type httpFetcher struct {
+	client   *http.Client
+	maxBytes int64
+}
+
+func (f *httpFetcher) Fetch(ctx context.Context, calendarURL string) (calendar []byte, err error) {
+	parsed, err := url.Parse(calendarURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid calendar URL: %w", err)
+	}
+	switch strings.ToLower(parsed.Scheme) {
+	case "http", "https":
+	case "webcal":
+		parsed.Scheme = "https"
+	default:
+		return nil, fmt.Errorf("the calendar URL must be http, https or webcal, got %q", parsed.Scheme)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, parsed.String(), nil)
+	if err != nil {
+		return
+	}
+	request.Header.Set("Accept", "text/calendar")
+	response, err := f.client.Do(request)
+	if err != nil {
+		return
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("the calendar server answered %s", response.Status)
+	}
+
+	// one byte past the limit tells a calendar that's exactly the limit from one that's too large
+	calendar, err = io.ReadAll(io.LimitReader(response.Body, f.maxBytes+1))
+	if err == nil && int64(len(calendar)) > f.maxBytes {
+		return nil, fmt.Errorf("the calendar is larger than %d bytes", f.maxBytes)
+	}
+	return
+}
@@ -0,0 +1,70 @@
+package ical
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPFetcher(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		accept string
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			accept = r.Header.Get("Accept")
+			switch r.URL.Path {
+			case "/calendar.ics":
+				w.Header().Set("Content-Type", ContentType)
+				w.Write([]byte("BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n"))
+			case "/large.ics":
+				w.Write([]byte(strings.Repeat("X", 1025)))
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+	)
+	defer server.Close()
+	fetcher := NewHTTPFetcher(time.Second, 1024, true)
+
+	t.Run("fetches", func(t *testing.T) {
+		calendar, err := fetcher.Fetch(ctx, server.URL+"/calendar.ics")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(calendar) != "BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n" {
+			t.Fatalf("got %q", calendar)
+		}
+		if accept != "text/calendar" {
+			t.Fatalf("asked for %q", accept)
+		}
+	})
+
+	t.Run("refuses what isn't a 200", func(t *testing.T) {
+		if _, err := fetcher.Fetch(ctx, server.URL+"/missing.ics"); err == nil || !strings.Contains(err.Error(), "404") {
+			t.Fatalf("got %v, want the status", err)
+		}
+	})
+
+	t.Run("refuses calendars over the limit", func(t *testing.T) {
+		if _, err := fetcher.Fetch(ctx, server.URL+"/large.ics"); err == nil || !strings.Contains(err.Error(), "larger than 1024 bytes") {
+			t.Fatalf("got %v, want the size refused", err)
+		}
+	})
+
+	t.Run("refuses other schemes", func(t *testing.T) {
+		if _, err := fetcher.Fetch(ctx, "file:///etc/passwd"); err == nil || !strings.Contains(err.Error(), "http, https or webcal") {
+			t.Fatalf("got %v, want the scheme refused", err)
+		}
+	})
+
+	t.Run("refuses private addresses", func(t *testing.T) {
+		_, err := NewHTTPFetcher(time.Second, 1024, false).Fetch(ctx, server.URL+"/calendar.ics")
+		if !errors.Is(err, ErrPrivateAddress) {
+			t.Fatalf("got %v, want ErrPrivateAddress", err)
+		}
+	})
+}
@@ -0,0 +1,156 @@
+package ical
+
+import (
+	"bufio"
+	"fmt"
+	"henrymeds-takehome/model"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ContentType is what a feed or an invite is served as
+const ContentType = "text/calendar; charset=utf-8"
+
+// the product identifier every calendar carries, RFC 5545 3.7.3
+const prodID = "-//henrymeds-takehome//scheduling//EN"
+
+// RFC 5545 3.8.1.11, the event statuses calendar apps understand
+const (
+	StatusTentative = "TENTATIVE"
+	StatusConfirmed = "CONFIRMED"
+	StatusCancelled = "CANCELLED"
+)
+
+// Calendar is a VCALENDAR of published events, both the feeds and the single event invites are one of these
+type Calendar struct {
+	// shown as the calendar's name by the apps that subscribe to it
+	Name   string
+	Events []Event
+}
+
+type Event struct {
+	// stable across every feed and invite the event shows up in, it's how calendar apps match updates to the event
+	UID   string
+	Start time.Time
+	End   time.Time
+	// when this copy of the event was generated
+	Stamp       time.Time
+	Summary     string
+	Description string
+	// where it happens, the room link of a video visit
+	Location string
+	Status   string
+	// goes up every time the event changes in a way the apps should pick up, ex: confirmed then cancelled
+	Sequence int
+}
+
+// ReservationEvent describes a reservation as an event, ok is false for an expired hold, which never happened and
+// has nothing to show
+func ReservationEvent(reservation model.Reservation, now time.Time) (event Event, ok bool) {
+	event = Event{
+		UID:         reservation.ID + "@henrymeds-takehome",
+		Start:       reservation.Start,
+		End:         reservation.End,
+		Stamp:       now,
+		Summary:     "Appointment",
+		Description: "Reservation " + reservation.ID,
+		Location:    reservation.VideoURL,
+	}
+	// the sequence follows the lifecycle, it only ever moves forward
+	switch reservation.Status(now) {
+	case model.ReservationStatusHeld:
+		event.Status, event.Sequence = StatusTentative, 0
+		event.Summary = "Appointment (unconfirmed)"
+	case model.ReservationStatusConfirmed:
+		event.Status, event.Sequence = StatusConfirmed, 1
+	case model.ReservationStatusCancelled:
+		event.Status, event.Sequence = StatusCancelled, 2
+		event.Summary = "Cancelled: Appointment"
+	default:
+		return Event{}, false
+	}
+	return event, true
+}
+
+// Encode writes the calendar in the RFC 5545 format, CRLF line endings and long lines folded
+func Encode(w io.Writer, calendar Calendar) error {
+	out := &writer{w: bufio.NewWriter(w)}
+	out.line("BEGIN", "VCALENDAR")
+	out.line("VERSION", "2.0")
+	out.line("PRODID", prodID)
+	out.line("CALSCALE", "GREGORIAN")
+	out.line("METHOD", "PUBLISH")
+	if calendar.Name != "" {
+		out.line("X-WR-CALNAME", escape(calendar.Name))
+	}
+	for _, event := range calendar.Events {
+		out.line("BEGIN", "VEVENT")
+		out.line("UID", escape(event.UID))
+		out.line("DTSTAMP", formatTime(event.Stamp))
+		out.line("DTSTART", formatTime(event.Start))
+		out.line("DTEND", formatTime(event.End))
+		out.line("SEQUENCE", fmt.Sprint(event.Sequence))
+		out.line("STATUS", event.Status)
+		out.line("SUMMARY", escape(event.Summary))
+		if event.Description != "" {
+			out.line("DESCRIPTION", escape(event.Description))
+		}
+		if event.Location != "" {
+			out.line("LOCATION", escape(event.Location))
+		}
+		out.line("END", "VEVENT")
+	}
+	out.line("END", "VCALENDAR")
+	if out.err != nil {
+		return out.err
+	}
+	return out.w.Flush()
+}
+
+// times are always written in UTC, ex: 20231111T151500Z, so there's no VTIMEZONE to ship
+func formatTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// the characters that are special in a TEXT value, RFC 5545 3.3.11
+var textEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`;`, `\;`,
+	`,`, `\,`,
+	"\r\n", `\n`,
+	"\n", `\n`,
+)
+
+func escape(text string) string {
+	return textEscaper.Replace(text)
+}
+
+type writer struct {
+	w   *bufio.Writer
+	err error
+}
+
+// line writes a content line, folded so no line is longer than 75 octets without splitting a UTF-8 character
+func (w *writer) line(name string, value string) {
+	if w.err != nil {
+		return
+	}
+	content := name + ":" + value
+	limit := 75
+	for len(content) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(content[cut]) {
+			cut--
+		}
+		_, w.err = w.w.WriteString(content[:cut] + "\r\n ")
+		if w.err != nil {
+			return
+		}
+		content = content[cut:]
+		// continuation lines start with the space, it counts against their 75
+		limit = 74
+	}
+	_, w.err = w.w.WriteString(content + "\r\n")
+}
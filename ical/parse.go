@@ -0,0 +1,524 @@
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"henrymeds-takehome/model"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// limits on what one calendar can expand to, a runaway RRULE or a huge export shouldn't take the service down
+const (
+	maxBusyIntervals = 20000
+	maxOccurrences   = 100000
+)
+
+// Busy is what a calendar says about when its owner is busy
+type Busy struct {
+	// merged, sorted and clipped to the window
+	Intervals []model.TimeRange
+	// events that were left out because they use recurrence rules this parser doesn't understand
+	Skipped int
+}
+
+// ParseBusy reads the busy time out of an RFC 5545 calendar, within window. Events count unless they're cancelled or
+// transparent (shown as free), VFREEBUSY periods count unless they're free. Recurring events are expanded for the
+// common rules: DAILY, WEEKLY (with BYDAY), MONTHLY and YEARLY, with INTERVAL, COUNT, UNTIL, EXDATE and overridden
+// instances. Times with a TZID are read in that zone, floating times as UTC, all day events cover their whole dates
+func ParseBusy(r io.Reader, window model.TimeRange) (busy Busy, err error) {
+	var (
+		lines     []contentLine
+		events    []*vevent
+		intervals []model.TimeRange
+		// overridden instances, by UID, their start replaces the master's occurrence
+		overrides = map[string][]time.Time{}
+	)
+	lines, err = readLines(r)
+	if err != nil {
+		return
+	}
+	if len(lines) == 0 || lines[0].name != "BEGIN" || !strings.EqualFold(lines[0].value, "VCALENDAR") {
+		return busy, errors.New("not an iCalendar file, it should start with BEGIN:VCALENDAR")
+	}
+
+	var (
+		depth   []string
+		current *vevent
+	)
+	for _, line := range lines {
+		switch line.name {
+		case "BEGIN":
+			component := strings.ToUpper(line.value)
+			depth = append(depth, component)
+			if component == "VEVENT" && len(depth) == 2 {
+				current = &vevent{}
+			}
+			continue
+		case "END":
+			if len(depth) == 0 {
+				return busy, fmt.Errorf("END:%s without a BEGIN", line.value)
+			}
+			if depth[len(depth)-1] == "VEVENT" && current != nil && len(depth) == 2 {
+				events = append(events, current)
+				current = nil
+			}
+			depth = depth[:len(depth)-1]
+			continue
+		}
+		if len(depth) != 2 {
+			// alarms inside events, timezone definitions and the calendar's own properties don't say anything about busy time
+			continue
+		}
+		switch depth[1] {
+		case "VEVENT":
+			err = current.set(line)
+		case "VFREEBUSY":
+			if line.name == "FREEBUSY" {
+				var periods []model.TimeRange
+				periods, err = parseFreeBusy(line)
+				intervals = append(intervals, periods...)
+			}
+		}
+		if err != nil {
+			return busy, fmt.Errorf("%s: %w", line.name, err)
+		}
+	}
+	if len(depth) != 0 {
+		return busy, fmt.Errorf("BEGIN:%s is never closed", depth[len(depth)-1])
+	}
+
+	for _, event := range events {
+		if event.recurrenceID != nil {
+			overrides[event.uid] = append(overrides[event.uid], *event.recurrenceID)
+		}
+	}
+	for _, event := range events {
+		if !event.busy() {
+			continue
+		}
+		if event.recurrenceID != nil || event.rrule == "" {
+			intervals = append(intervals, event.timeRange())
+			continue
+		}
+		var occurrences []model.TimeRange
+		occurrences, err = event.expand(window, overrides[event.uid])
+		if errors.Is(err, errUnsupportedRule) {
+			busy.Skipped++
+			err = nil
+			continue
+		}
+		if err != nil {
+			return busy, fmt.Errorf("RRULE %q: %w", event.rrule, err)
+		}
+		intervals = append(intervals, occurrences...)
+	}
+
+	busy.Intervals = mergeWithin(intervals, window)
+	if len(busy.Intervals) > maxBusyIntervals {
+		return Busy{}, fmt.Errorf("the calendar has more than %d busy intervals in the next %s", maxBusyIntervals, window.End.Sub(window.Start))
+	}
+	return
+}
+
+type contentLine struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// readLines unfolds the content lines and splits them into name, parameters and value
+func readLines(r io.Reader) (lines []contentLine, err error) {
+	var (
+		scanner  = bufio.NewScanner(r)
+		unfolded []string
+	)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if len(unfolded) == 0 {
+			text = strings.TrimPrefix(text, "\ufeff")
+		}
+		if (strings.HasPrefix(text, " ") || strings.HasPrefix(text, "\t")) && len(unfolded) > 0 {
+			unfolded[len(unfolded)-1] += text[1:]
+			continue
+		}
+		if text != "" {
+			unfolded = append(unfolded, text)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	for number, text := range unfolded {
+		line, ok := parseContentLine(text)
+		if !ok {
+			return nil, fmt.Errorf("line %d is not a content line: %q", number+1, text)
+		}
+		lines = append(lines, line)
+	}
+	return
+}
+
+// parseContentLine splits name;param=value;param="quoted:value":value, colons inside quotes don't end the parameters
+func parseContentLine(text string) (line contentLine, ok bool) {
+	quoted := false
+	colon := -1
+	for i, r := range text {
+		if r == '"' {
+			quoted = !quoted
+		} else if r == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon <= 0 {
+		return line, false
+	}
+	line.value = text[colon+1:]
+	parts := strings.Split(text[:colon], ";")
+	line.name = strings.ToUpper(parts[0])
+	line.params = map[string]string{}
+	for _, part := range parts[1:] {
+		key, value, _ := strings.Cut(part, "=")
+		line.params[strings.ToUpper(key)] = strings.Trim(value, `"`)
+	}
+	return line, true
+}
+
+type vevent struct {
+	uid          string
+	start        time.Time
+	end          *time.Time
+	duration     *time.Duration
+	allDay       bool
+	status       string
+	transparent  bool
+	rrule        string
+	exdates      []time.Time
+	recurrenceID *time.Time
+}
+
+func (e *vevent) set(line contentLine) (err error) {
+	switch line.name {
+	case "UID":
+		e.uid = line.value
+	case "DTSTART":
+		e.start, e.allDay, err = parseDateTime(line)
+	case "DTEND":
+		var end time.Time
+		end, _, err = parseDateTime(line)
+		e.end = &end
+	case "DURATION":
+		var duration time.Duration
+		duration, err = parseDuration(line.value)
+		e.duration = &duration
+	case "STATUS":
+		e.status = strings.ToUpper(line.value)
+	case "TRANSP":
+		e.transparent = strings.EqualFold(line.value, "TRANSPARENT")
+	case "RRULE":
+		e.rrule = line.value
+	case "EXDATE":
+		for _, value := range strings.Split(line.value, ",") {
+			var exdate time.Time
+			exdate, _, err = parseDateTime(contentLine{params: line.params, value: value})
+			if err != nil {
+				return
+			}
+			e.exdates = append(e.exdates, exdate)
+		}
+	case "RECURRENCE-ID":
+		var id time.Time
+		id, _, err = parseDateTime(line)
+		e.recurrenceID = &id
+	}
+	return
+}
+
+func (e *vevent) busy() bool {
+	return !e.start.IsZero() && !e.transparent && e.status != "CANCELLED"
+}
+
+func (e *vevent) length() time.Duration {
+	switch {
+	case e.end != nil:
+		return e.end.Sub(e.start)
+	case e.duration != nil:
+		return *e.duration
+	case e.allDay:
+		// an all day event without an end is the one day, RFC 5545 3.6.1
+		return 24 * time.Hour
+	}
+	return 0
+}
+
+func (e *vevent) timeRange() model.TimeRange {
+	return model.TimeRange{Start: e.start, End: e.start.Add(e.length())}
+}
+
+var errUnsupportedRule = errors.New("unsupported recurrence rule")
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+// expand lists the occurrences of a recurring event that overlap the window, minus the excluded and overridden ones
+func (e *vevent) expand(window model.TimeRange, overridden []time.Time) (occurrences []model.TimeRange, err error) {
+	var (
+		frequency string
+		interval  = 1
+		count     int
+		until     time.Time
+		byDay     []time.Weekday
+		length    = e.length()
+	)
+	for _, part := range strings.Split(e.rrule, ";") {
+		key, value, _ := strings.Cut(part, "=")
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			frequency = strings.ToUpper(value)
+		case "INTERVAL":
+			interval, err = strconv.Atoi(value)
+			if err != nil || interval < 1 {
+				return nil, fmt.Errorf("invalid INTERVAL %q", value)
+			}
+		case "COUNT":
+			count, err = strconv.Atoi(value)
+			if err != nil || count < 1 {
+				return nil, fmt.Errorf("invalid COUNT %q", value)
+			}
+		case "UNTIL":
+			until, _, err = parseDateTime(contentLine{value: value, params: map[string]string{}})
+			if err != nil {
+				return nil, fmt.Errorf("invalid UNTIL %q", value)
+			}
+		case "BYDAY":
+			for _, day := range strings.Split(value, ",") {
+				weekday, ok := weekdays[strings.ToUpper(day)]
+				if !ok {
+					// ex: 2MO, the second monday, only makes sense with MONTHLY or YEARLY and isn't handled
+					return nil, errUnsupportedRule
+				}
+				byDay = append(byDay, weekday)
+			}
+		case "WKST", "":
+		default:
+			return nil, errUnsupportedRule
+		}
+	}
+	if len(byDay) > 0 && frequency != "WEEKLY" {
+		return nil, errUnsupportedRule
+	}
+	if frequency == "WEEKLY" && len(byDay) == 0 {
+		byDay = []time.Weekday{e.start.Weekday()}
+	}
+
+	var (
+		excluded = append(append([]time.Time{}, e.exdates...), overridden...)
+		emitted  int
+	)
+	// add reports whether to keep going, occurrences are generated in order so the first one past the window or
+	// the rule's end is the last one
+	add := func(start time.Time) bool {
+		if start.Before(e.start) {
+			return true
+		}
+		if (!until.IsZero() && start.After(until)) || (count > 0 && emitted >= count) || !start.Before(window.End) {
+			return false
+		}
+		emitted++
+		for _, exdate := range excluded {
+			if exdate.Equal(start) {
+				return true
+			}
+		}
+		if end := start.Add(length); end.After(window.Start) {
+			occurrences = append(occurrences, model.TimeRange{Start: start, End: end})
+		}
+		return true
+	}
+
+	var (
+		location             = e.start.Location()
+		year, month, day     = e.start.Date()
+		hour, minute, second = e.start.Clock()
+		at                   = func(y int, m time.Month, d int) time.Time {
+			return time.Date(y, m, d, hour, minute, second, 0, location)
+		}
+	)
+	for step := 0; step < maxOccurrences; step++ {
+		switch frequency {
+		case "DAILY":
+			if !add(at(year, month, day+step*interval)) {
+				return
+			}
+		case "WEEKLY":
+			// the week that holds the start, from its sunday, every interval weeks
+			weekStart := day - int(e.start.Weekday()) + step*7*interval
+			for _, weekday := range sortedWeekdays(byDay) {
+				if !add(at(year, month, weekStart+int(weekday))) {
+					return
+				}
+			}
+		case "MONTHLY":
+			// months without the day, ex: the 31st, are skipped, RFC 5545 3.3.10
+			start := at(year, month+time.Month(step*interval), day)
+			if start.Day() == day && !add(start) {
+				return
+			}
+		case "YEARLY":
+			start := at(year+step*interval, month, day)
+			if start.Day() == day && !add(start) {
+				return
+			}
+		default:
+			return nil, errUnsupportedRule
+		}
+	}
+	return
+}
+
+func sortedWeekdays(days []time.Weekday) []time.Weekday {
+	sorted := append([]time.Weekday{}, days...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
+
+// parseDateTime reads a DATE or DATE-TIME value, in UTC when it ends in Z, in the TZID parameter's zone if it has one
+// and in UTC otherwise
+func parseDateTime(line contentLine) (t time.Time, allDay bool, err error) {
+	location := time.UTC
+	if tzid := line.params["TZID"]; tzid != "" {
+		if loaded, loadErr := time.LoadLocation(tzid); loadErr == nil {
+			location = loaded
+		}
+	}
+	value := strings.TrimSpace(line.value)
+	switch {
+	case strings.EqualFold(line.params["VALUE"], "DATE") || len(value) == 8:
+		t, err = time.ParseInLocation("20060102", value, location)
+		allDay = true
+	case strings.HasSuffix(value, "Z"):
+		t, err = time.Parse("20060102T150405Z", value)
+	default:
+		t, err = time.ParseInLocation("20060102T150405", value, location)
+	}
+	if err != nil {
+		err = fmt.Errorf("invalid date or time %q", value)
+	}
+	return
+}
+
+// parseDuration reads an RFC 5545 duration, ex: PT1H30M, P1D, P2W
+func parseDuration(value string) (duration time.Duration, err error) {
+	var (
+		rest     = strings.TrimPrefix(strings.TrimPrefix(value, "+"), "-")
+		negative = strings.HasPrefix(value, "-")
+		inTime   bool
+		number   string
+	)
+	if !strings.HasPrefix(rest, "P") || len(rest) < 3 {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	for _, r := range rest[1:] {
+		if r >= '0' && r <= '9' {
+			number += string(r)
+			continue
+		}
+		if r == 'T' {
+			inTime = true
+			continue
+		}
+		n, convErr := strconv.Atoi(number)
+		if convErr != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		number = ""
+		switch {
+		case r == 'W' && !inTime:
+			duration += time.Duration(n) * 7 * 24 * time.Hour
+		case r == 'D' && !inTime:
+			duration += time.Duration(n) * 24 * time.Hour
+		case r == 'H' && inTime:
+			duration += time.Duration(n) * time.Hour
+		case r == 'M' && inTime:
+			duration += time.Duration(n) * time.Minute
+		case r == 'S' && inTime:
+			duration += time.Duration(n) * time.Second
+		default:
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+	}
+	if number != "" {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	if negative {
+		duration = -duration
+	}
+	return
+}
+
+// parseFreeBusy reads the busy periods of a FREEBUSY property, each start/end or start/duration
+func parseFreeBusy(line contentLine) (periods []model.TimeRange, err error) {
+	if kind := strings.ToUpper(line.params["FBTYPE"]); kind == "FREE" {
+		return
+	}
+	for _, period := range strings.Split(line.value, ",") {
+		startValue, endValue, ok := strings.Cut(period, "/")
+		if !ok {
+			return nil, fmt.Errorf("invalid period %q", period)
+		}
+		var timeRange model.TimeRange
+		timeRange.Start, _, err = parseDateTime(contentLine{value: startValue, params: map[string]string{}})
+		if err != nil {
+			return
+		}
+		if strings.HasPrefix(endValue, "P") {
+			var duration time.Duration
+			duration, err = parseDuration(endValue)
+			timeRange.End = timeRange.Start.Add(duration)
+		} else {
+			timeRange.End, _, err = parseDateTime(contentLine{value: endValue, params: map[string]string{}})
+		}
+		if err != nil {
+			return
+		}
+		periods = append(periods, timeRange)
+	}
+	return
+}
+
+// mergeWithin clips the intervals to the window, drops the empty ones and merges the ones that overlap or touch
+func mergeWithin(intervals []model.TimeRange, window model.TimeRange) (merged []model.TimeRange) {
+	var clipped []model.TimeRange
+	for _, interval := range intervals {
+		interval.Start, interval.End = interval.Start.UTC(), interval.End.UTC()
+		if interval.Start.Before(window.Start) {
+			interval.Start = window.Start
+		}
+		if interval.End.After(window.End) {
+			interval.End = window.End
+		}
+		if interval.Start.Before(interval.End) {
+			clipped = append(clipped, interval)
+		}
+	}
+	sort.Slice(clipped, func(i, j int) bool { return clipped[i].Start.Before(clipped[j].Start) })
+	for _, interval := range clipped {
+		last := len(merged) - 1
+		if last >= 0 && !interval.Start.After(merged[last].End) {
+			if interval.End.After(merged[last].End) {
+				merged[last].End = interval.End
+			}
+			continue
+		}
+		merged = append(merged, interval)
+	}
+	return
+}
@@ -0,0 +1,146 @@
+package ical
+
+import (
+	"bytes"
+	"context"
+	"henrymeds-takehome/model"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the window the fixtures in testdata are read in
+var fixtureWindow = model.TimeRange{
+	Start: time.Date(2030, 1, 7, 0, 0, 0, 0, time.UTC),
+	End:   time.Date(2030, 3, 31, 0, 0, 0, 0, time.UTC),
+}
+
+func at(month time.Month, day, hour, minute int) time.Time {
+	return time.Date(2030, month, day, hour, minute, 0, 0, time.UTC)
+}
+
+// fetchFixture imports a calendar in testdata the way a busy source with a URL is, fetched from a server then
+// parsed
+func fetchFixture(t *testing.T, name string, window model.TimeRange) (busy Busy, err error) {
+	server := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer server.Close()
+
+	calendar, err := NewHTTPFetcher(time.Second, 1<<20, true).Fetch(context.Background(), server.URL+"/"+name)
+	if err != nil {
+		t.Fatalf("failed to fetch %s: %v", name, err)
+	}
+	return ParseBusy(bytes.NewReader(calendar), window)
+}
+
+func TestParseBusy(t *testing.T) {
+	tests := []struct {
+		fixture   string
+		intervals []model.TimeRange
+		skipped   int
+	}{
+		{
+			// a weekly rule with an excluded and a moved occurrence, a daily one every other day, a rule that
+			// isn't understood, and events that are cancelled or shown as free
+			fixture: "recurring.ics",
+			intervals: []model.TimeRange{
+				{Start: at(1, 7, 9, 0), End: at(1, 7, 9, 30)},
+				{Start: at(1, 7, 12, 0), End: at(1, 7, 13, 0)},
+				{Start: at(1, 9, 12, 0), End: at(1, 9, 13, 0)},
+				{Start: at(1, 11, 12, 0), End: at(1, 11, 13, 0)},
+				{Start: at(1, 14, 15, 0), End: at(1, 14, 15, 30)},
+				{Start: at(1, 16, 9, 0), End: at(1, 16, 9, 30)},
+				{Start: at(1, 21, 9, 0), End: at(1, 21, 9, 30)},
+				{Start: at(1, 23, 9, 0), End: at(1, 23, 9, 30)},
+			},
+			skipped: 1,
+		},
+		{
+			// dates cover the whole day, one without an end is the one day, and the free periods aren't busy
+			fixture: "allday.ics",
+			intervals: []model.TimeRange{
+				{Start: at(1, 10, 0, 0), End: at(1, 12, 0, 0)},
+				{Start: at(1, 15, 0, 0), End: at(1, 16, 0, 0)},
+				{Start: at(1, 17, 10, 0), End: at(1, 17, 12, 0)},
+				{Start: at(1, 18, 10, 0), End: at(1, 18, 11, 0)},
+			},
+		},
+		{
+			// New York is 5 hours behind in January, and 4 after the clocks change on March 10th. Floating times
+			// are UTC
+			fixture: "timezone.ics",
+			intervals: []model.TimeRange{
+				{Start: at(1, 10, 14, 0), End: at(1, 10, 15, 0)},
+				{Start: at(1, 11, 9, 0), End: at(1, 11, 10, 0)},
+				{Start: at(3, 5, 14, 0), End: at(3, 5, 14, 30)},
+				{Start: at(3, 12, 13, 0), End: at(3, 12, 13, 30)},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.fixture, func(t *testing.T) {
+			busy, err := fetchFixture(t, test.fixture, fixtureWindow)
+			if err != nil {
+				t.Fatalf("failed to parse: %v", err)
+			}
+			if busy.Skipped != test.skipped {
+				t.Fatalf("skipped %d events, want %d", busy.Skipped, test.skipped)
+			}
+			if len(busy.Intervals) != len(test.intervals) {
+				t.Fatalf("got %v, want %v", busy.Intervals, test.intervals)
+			}
+			for i, interval := range busy.Intervals {
+				if !interval.Start.Equal(test.intervals[i].Start) || !interval.End.Equal(test.intervals[i].End) {
+					t.Fatalf("interval %d is %v, want %v", i, interval, test.intervals[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseBusyClipsToTheWindow(t *testing.T) {
+	busy, err := fetchFixture(t, "allday.ics", model.TimeRange{Start: at(1, 11, 0, 0), End: at(1, 15, 12, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []model.TimeRange{
+		{Start: at(1, 11, 0, 0), End: at(1, 12, 0, 0)},
+		{Start: at(1, 15, 0, 0), End: at(1, 15, 12, 0)},
+	}
+	if len(busy.Intervals) != len(want) {
+		t.Fatalf("got %v, want %v", busy.Intervals, want)
+	}
+	for i, interval := range busy.Intervals {
+		if !interval.Start.Equal(want[i].Start) || !interval.End.Equal(want[i].End) {
+			t.Fatalf("interval %d is %v, want %v", i, interval, want[i])
+		}
+	}
+}
+
+func TestParseBusyMalformed(t *testing.T) {
+	if _, err := fetchFixture(t, "malformed.ics", fixtureWindow); err == nil || !strings.Contains(err.Error(), "never closed") {
+		t.Fatalf("a truncated calendar got %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		calendar string
+		error    string
+	}{
+		{name: "not a calendar", calendar: "<html>not found</html>", error: "not a content line"},
+		{name: "no VCALENDAR", calendar: "BEGIN:VEVENT\r\nEND:VEVENT\r\n", error: "BEGIN:VCALENDAR"},
+		{name: "END without a BEGIN", calendar: "BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\nEND:VEVENT\r\n", error: "without a BEGIN"},
+		{name: "bad date", calendar: "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nDTSTART:2030-01-10\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n", error: "invalid date or time"},
+		{name: "bad duration", calendar: "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nDTSTART:20300110T090000Z\r\nDURATION:1 hour\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n", error: "invalid duration"},
+		{name: "bad COUNT", calendar: "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nDTSTART:20300110T090000Z\r\nRRULE:FREQ=DAILY;COUNT=-1\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n", error: "invalid COUNT"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseBusy(strings.NewReader(test.calendar), fixtureWindow)
+			if err == nil || !strings.Contains(err.Error(), test.error) {
+				t.Fatalf("got %v, want an error about %q", err, test.error)
+			}
+		})
+	}
+}
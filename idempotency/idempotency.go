@@ -0,0 +1,122 @@
+package idempotency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"henrymeds-takehome/dao"
+	"henrymeds-takehome/model"
+	"henrymeds-takehome/tenant"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	HeaderKey = "Idempotency-Key"
+	// set on a response that was replayed from an earlier request with the same key
+	HeaderReplayed = "Idempotent-Replayed"
+
+	maxKeyLength = 255
+)
+
+// Middleware makes mutating requests safe to retry. The first request with a given Idempotency-Key runs
+// and its response is stored, any retry with the same key gets that response back without running again.
+// A retry that arrives while the first is still running gets a 409, reusing a key for a different request a 422.
+// Server errors aren't stored, the key is released so the retry actually retries.
+func Middleware(store dao.IdempotencyDao) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			var (
+				request = c.Request()
+				ctx     = request.Context()
+				key     = request.Header.Get(HeaderKey)
+			)
+			if key == "" || request.Method == http.MethodGet || request.Method == http.MethodHead {
+				return next(c)
+			}
+			if len(key) > maxKeyLength {
+				return echo.NewHTTPError(http.StatusBadRequest, "Idempotency-Key must be at most 255 characters")
+			}
+			// keys are picked by the callers, two tenants can pick the same one
+			if id, ok := tenant.FromContext(ctx); ok {
+				key = id + "/" + key
+			}
+
+			var (
+				body, err = io.ReadAll(request.Body)
+				httpErr   *echo.HTTPError
+			)
+			if errors.As(err, &httpErr) {
+				// the body limit's 413
+				return httpErr
+			}
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "failed to read request body")
+			}
+			request.Body = io.NopCloser(bytes.NewReader(body))
+
+			existing, claimed, err := store.ClaimIdempotencyKey(ctx, key, fingerprint(request, body))
+			if err != nil {
+				slog.ErrorContext(ctx, "failed to claim idempotency key", "error", err)
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to check idempotency key")
+			}
+			if !claimed {
+				switch {
+				case existing.Fingerprint != fingerprint(request, body):
+					return echo.NewHTTPError(http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request")
+				case !existing.Completed:
+					c.Response().Header().Set("Retry-After", "1")
+					return echo.NewHTTPError(http.StatusConflict, "a request with this Idempotency-Key is still in progress")
+				}
+				c.Response().Header().Set(HeaderReplayed, "true")
+				return c.Blob(existing.Status, existing.ContentType, existing.Body)
+			}
+
+			recorder := &bodyRecorder{ResponseWriter: c.Response().Writer}
+			c.Response().Writer = recorder
+			err = next(c)
+			if err != nil {
+				c.Error(err)
+			}
+
+			status := c.Response().Status
+			if status >= http.StatusInternalServerError {
+				err = store.ReleaseIdempotencyKey(ctx, key)
+			} else {
+				err = store.CompleteIdempotencyKey(ctx, model.IdempotencyRecord{
+					Key:         key,
+					Status:      status,
+					ContentType: c.Response().Header().Get(echo.HeaderContentType),
+					Body:        recorder.body.Bytes(),
+				})
+			}
+			if err != nil {
+				// the response already went out, a retry will be rejected as in progress until the key expires
+				slog.ErrorContext(ctx, "failed to store idempotent response", "error", err)
+			}
+			return nil
+		}
+	}
+}
+
+func fingerprint(request *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(request.Method + " " + request.URL.Path + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// bodyRecorder keeps a copy of the response body on its way out so it can be stored
+type bodyRecorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *bodyRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
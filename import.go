@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"henrymeds-takehome/bulk"
+	"henrymeds-takehome/model"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// importAvailabilities is `import-availabilities`, the CLI twin of POST /v1/users/:providerId/availabilities:bulk
+// for loading a provider's schedule straight into the database, ex:
+//
+//	henrymeds-takehome import-availabilities -provider <id> [-dry-run] shifts.csv [config flags]
+//
+// The file is CSV or JSON, picked by its extension or -format, - reads stdin. Anything after the file is read as
+// the usual config flags. Exits 1 if any row was rejected, nothing is imported then
+func importAvailabilities(args []string) int {
+	var (
+		fs         = flag.NewFlagSet("import-availabilities", flag.ContinueOnError)
+		providerID = fs.String("provider", "", "the provider the availabilities are for")
+		dryRun     = fs.Bool("dry-run", false, "check the rows without importing them")
+		format     = fs.String("format", "", "csv or json, defaults to the file's extension")
+		input      io.Reader
+		rows       []model.ImportRow
+		report     model.ImportReport
+		err        error
+	)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: import-availabilities -provider <id> [-dry-run] [-format csv|json] <file> [config flags]")
+		fs.PrintDefaults()
+	}
+	if err = fs.Parse(args); err != nil {
+		return 2
+	}
+	if *providerID == "" || fs.NArg() < 1 {
+		fs.Usage()
+		return 2
+	}
+	path := fs.Arg(0)
+
+	if *format == "" {
+		*format, err = bulk.FormatOfFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s, or pass -format\n", path, err)
+			return 2
+		}
+	}
+	if path == "-" {
+		input = os.Stdin
+	} else {
+		var file *os.File
+		file, err = os.Open(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		defer file.Close()
+		input = file
+	}
+	rows, err = bulk.ParseAvailabilities(input, *format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+		return 2
+	}
+
+	config := readConfigs(fs.Args()[1:])
+	setupLogger(config.Log)
+	db, err := createGoPgDB(config.DB)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to setup DB connection:", err)
+		return 1
+	}
+	defer db.Close()
+	encryptor, err := setupEncryptor(config.Encryption)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to setup encryption:", err)
+		return 1
+	}
+
+	// nothing is confirmed from here, the controller doesn't need a signer
+	report, err = setupController(db, config, nil, encryptor).ImportAvailabilities(context.Background(), model.ImportAvailabilities{
+		ProviderID: *providerID,
+		Rows:       rows,
+		DryRun:     *dryRun,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to import availabilities:", err)
+		return 1
+	}
+	printImportReport(os.Stdout, report)
+	if report.Rejected() > 0 {
+		return 1
+	}
+	return 0
+}
+
+func printImportReport(w io.Writer, report model.ImportReport) {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "ROW\tSTATUS\tSTART\tEND\tSEATS\tDETAIL")
+	for _, result := range report.Results {
+		start, end, seats := "-", "-", "-"
+		if !result.Start.IsZero() {
+			start, end, seats = result.Start.Format(time.RFC3339), result.End.Format(time.RFC3339), strconv.Itoa(result.Capacity)
+		}
+		detail := result.Error
+		if detail == "" {
+			detail = result.AvailabilityID
+		}
+		fmt.Fprintf(table, "%d\t%s\t%s\t%s\t%s\t%s\n", result.Row, result.Status, start, end, seats, detail)
+	}
+	table.Flush()
+
+	switch {
+	case report.Committed:
+		fmt.Fprintf(w, "imported %d availabilities\n", len(report.Results))
+	case report.Rejected() > 0:
+		fmt.Fprintf(w, "%d of %d rows rejected, nothing was imported\n", report.Rejected(), len(report.Results))
+	default:
+		fmt.Fprintf(w, "dry run, %d rows would be imported\n", len(report.Results))
+	}
+}
@@ -0,0 +1,90 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+type contextKey struct{}
+
+var requestIDKey = contextKey{}
+
+// attribute keys that carry patient identifiers, their values never make it into the logs
+var redactedKeys = map[string]bool{
+	"client_id": true,
+}
+
+// Patient marks a value that identifies a patient under a key that doesn't always, ex: a user ID that may be a
+// client's, or a client's state. It's redacted whatever the key
+type Patient string
+
+// New builds a logger that writes JSON or text at the given level. Every record logged with a context
+// picks up the request ID stored in that context, and patient identifiers are redacted.
+func New(w io.Writer, level string, format string) (*slog.Logger, error) {
+	var (
+		lvl     slog.Level
+		handler slog.Handler
+	)
+	err := lvl.UnmarshalText([]byte(level))
+	if err != nil {
+		return nil, err
+	}
+	options := &slog.HandlerOptions{
+		Level:       lvl,
+		ReplaceAttr: redact,
+	}
+	switch strings.ToLower(format) {
+	case "json":
+		handler = slog.NewJSONHandler(w, options)
+	case "text":
+		handler = slog.NewTextHandler(w, options)
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+	return slog.New(contextHandler{handler}), nil
+}
+
+// WithRequestID stores the request ID so everything further down the stack logs it
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey, requestID)
+}
+
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+func redact(_ []string, attr slog.Attr) slog.Attr {
+	if _, patient := attr.Value.Any().(Patient); patient || redactedKeys[attr.Key] {
+		attr.Value = slog.StringValue("[REDACTED]")
+	}
+	return attr
+}
+
+// contextHandler adds values stored in the context to each record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		record.AddAttrs(slog.String("trace_id", span.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
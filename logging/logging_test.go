@@ -0,0 +1,56 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	for _, format := range []string{"json", "text"} {
+		t.Run(format, func(t *testing.T) {
+			var logs bytes.Buffer
+			logger, err := New(&logs, "info", format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			logger.InfoContext(WithRequestID(context.Background(), "request"), "booked",
+				"client_id", "client-secret",
+				"user_id", Patient("user-secret"),
+				"state", Patient("NY"),
+				"provider_id", "provider",
+			)
+			logger.WithGroup("reservation").Info("grouped", "client_id", "grouped-secret")
+
+			for _, secret := range []string{"client-secret", "user-secret", "NY", "grouped-secret"} {
+				if strings.Contains(logs.String(), secret) {
+					t.Errorf("%s made it into the logs: %s", secret, logs.String())
+				}
+			}
+			for _, kept := range []string{"provider", "request", "[REDACTED]"} {
+				if !strings.Contains(logs.String(), kept) {
+					t.Errorf("%s is missing from the logs: %s", kept, logs.String())
+				}
+			}
+		})
+	}
+}
+
+func TestRedactKeepsTheKey(t *testing.T) {
+	var logs bytes.Buffer
+	logger, err := New(&logs, "info", "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("notification", "user_id", Patient("user"))
+
+	var record map[string]any
+	if err = json.Unmarshal(logs.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record["user_id"] != "[REDACTED]" {
+		t.Fatalf("logged %s", logs.String())
+	}
+}
@@ -1,80 +1,660 @@
 package main
 
 import (
-	"flag"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
 	"fmt"
+	"henrymeds-takehome/audit"
+	cfg "henrymeds-takehome/config"
+	"henrymeds-takehome/confirmation"
 	c "henrymeds-takehome/controller"
 	d "henrymeds-takehome/dao"
+	"henrymeds-takehome/encryption"
 	h "henrymeds-takehome/handler"
-	"log"
+	"henrymeds-takehome/health"
+	"henrymeds-takehome/ical"
+	"henrymeds-takehome/idempotency"
+	"henrymeds-takehome/logging"
+	"henrymeds-takehome/metrics"
+	"henrymeds-takehome/migrations"
+	"henrymeds-takehome/notify"
+	"henrymeds-takehome/openapi"
+	"henrymeds-takehome/outbox"
+	"henrymeds-takehome/ratelimit"
+	"henrymeds-takehome/reminder"
+	"henrymeds-takehome/tenant"
+	"henrymeds-takehome/tracing"
+	"henrymeds-takehome/video"
+	"henrymeds-takehome/waitlist"
+	"henrymeds-takehome/webhook"
+	"log/slog"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	gopg "github.com/go-pg/pg/v10"
 	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
 )
 
+// set at link time, see the build target in the Makefile
 var (
-	port  = flag.String("port", "9001", "the port that the service will listen on")
-	dbUrl = flag.String("db", "", "the database connection string")
+	version   = "dev"
+	commit    = ""
+	buildTime = ""
 )
 
 func main() {
-	config := readConfigs()
-	handler := setupService(config)
-	e := setupServer(handler)
-	// start the server, e.Start() returns an error
-	// e.Start() pauses the main goroutine(like a thread)
-	// and runs the server in a separate goroutine
-	// normally I'd have a sig term litener setup to shutdown
-	// gracefully but I'm out of time
-	log.Println(e.Start(":" + config.port))
+	// `config print` shows the effective config without starting the service
+	if len(os.Args) > 2 && os.Args[1] == "config" && os.Args[2] == "print" {
+		os.Exit(printConfig(os.Args[3:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import-availabilities" {
+		os.Exit(importAvailabilities(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "reencrypt" {
+		os.Exit(reEncrypt(os.Args[2:]))
+	}
+
+	config := readConfigs(os.Args[1:])
+	setupLogger(config.Log)
+	shutdownTracing := setupTracing(config.Tracing)
+	defer shutdownTracing(context.Background())
+	db, err := createGoPgDB(config.DB)
+	if err != nil {
+		panic("failed to setup DB connection:" + err.Error())
+	}
+	defer db.Close()
+	signer := setupSigner(config.Confirmation)
+	encryptor, err := setupEncryptor(config.Encryption)
+	if err != nil {
+		panic("failed to setup encryption: " + err.Error())
+	}
+	handler, controller := setupService(db, config, signer, encryptor)
+	workers, sink := setupWorkers(db, controller, signer, encryptor, config)
+	defer sink.Close()
+	checker := health.NewChecker(db, migrations.Latest(), config.DB.PingTimeout, buildInfo())
+	validator, err := openapi.NewValidator(config.Server.ValidateResponses)
+	if err != nil {
+		panic(err.Error())
+	}
+	idempotencyDao := d.NewIdempotencyDao(db, config.Server.IdempotencyTTL, encryptor)
+	e := setupServer(handler, checker, validator, idempotencyDao, d.NewOrganizationDao(db), setupLimiter(db, config.RateLimit), config)
+	// a route without a spec, or a spec without a route, is a bug that should never make it past startup
+	if err = validator.CheckRoutes(e.Routes()); err != nil {
+		panic(err.Error())
+	}
+	run(e, checker, config, workers...)
 }
 
-type config struct {
-	port  string
-	dbUrl string
+// run serves until SIGINT or SIGTERM, then drains: readiness fails for the drain delay so the orchestrator
+// stops routing to us, then in-flight requests get the shutdown timeout to finish.
+// The workers run alongside the server and are stopped once it is, so nothing they do is cut off by the drain
+func run(e *echo.Echo, checker *health.Checker, config cfg.Config, workers ...func(context.Context)) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	var running sync.WaitGroup
+	for _, worker := range workers {
+		running.Add(1)
+		go func(worker func(context.Context)) {
+			defer running.Done()
+			worker(workerCtx)
+		}(worker)
+	}
+	defer func() {
+		stopWorkers()
+		running.Wait()
+		slog.Info("workers stopped")
+	}()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- e.Start(":" + config.Port)
+	}()
+
+	select {
+	case err := <-serverErr:
+		slog.Error("server stopped", "error", err)
+		return
+	case <-ctx.Done():
+	}
+
+	slog.Info("shutting down, draining", "drain_delay", config.Server.DrainDelay)
+	checker.Drain()
+	time.Sleep(config.Server.DrainDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.Server.ShutdownTimeout)
+	defer cancel()
+	if err := e.Shutdown(shutdownCtx); err != nil {
+		slog.Error("failed to shut down gracefully", "error", err)
+		return
+	}
+	slog.Info("server stopped")
 }
 
-func readConfigs() config {
-	flag.Parse()
-	if *dbUrl == "" {
-		panic("db flag not set, please provide a db url, see README for more info")
+func buildInfo() health.BuildInfo {
+	info := health.BuildInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildTime: buildTime,
+	}
+	// fall back on what the go toolchain stamped, `go build` inside a git checkout records the commit
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range build.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = setting.Value
+			case setting.Key == "vcs.time" && info.BuildTime == "":
+				info.BuildTime = setting.Value
+			}
+		}
 	}
-	if *port == "" {
-		fmt.Println("port not set, defaulting to 9001")
+	return info
+}
+
+func readConfigs(args []string) cfg.Config {
+	config, err := cfg.Load(args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
-	return config{
-		port:  *port,
-		dbUrl: *dbUrl,
+	if err = config.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
+	return config
 }
 
-func setupService(config config) *h.Handler {
-	db, err := createGoPgDB(config.dbUrl)
+func printConfig(args []string) int {
+	config, err := cfg.Load(args)
 	if err != nil {
-		panic("failed to setup DB connection:" + err.Error())
+		fmt.Fprintln(os.Stderr, err)
+		return 2
 	}
-	dao := d.NewReservationDao(db)
-	controller := c.NewController(dao)
+	config.Print(os.Stdout)
+	if err = config.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func setupLogger(config cfg.Log) {
+	logger, err := logging.New(os.Stderr, config.Level, config.Format)
+	if err != nil {
+		panic("failed to setup logger: " + err.Error())
+	}
+	slog.SetDefault(logger)
+}
+
+func setupTracing(config cfg.Tracing) func(context.Context) error {
+	shutdown, err := tracing.Setup(context.Background(), config.Exporter, config.Endpoint, config.SampleRatio)
+	if err != nil {
+		panic("failed to setup tracing: " + err.Error())
+	}
+	return shutdown
+}
+
+func setupService(db *gopg.DB, config cfg.Config, signer *confirmation.Signer, encryptor *encryption.Encryptor) (*h.Handler, c.Controller) {
+	metrics.RegisterPoolStats(db)
+	db.AddQueryHook(tracing.QueryHook{})
+	controller := setupController(db, config, signer, encryptor)
 	handler := h.NewHandler(controller)
-	return handler
+	return handler, controller
+}
+
+// setupWorkers builds what runs alongside the server: the outbox dispatcher, the expiry sweep and, when they're
+// enabled, the webhook deliverer, the reminder sender and the pruning of the rate limit buckets kept in postgres. Confirmation links and waitlist offers go out from sinks
+// the dispatcher publishes to. The sink is returned to be closed once they've stopped
+func setupWorkers(db *gopg.DB, controller c.Controller, signer *confirmation.Signer, encryptor *encryption.Encryptor, config cfg.Config) (workers []func(context.Context), sink outbox.Sink) {
+	eventSink, err := setupSink(config.Outbox)
+	if err != nil {
+		panic("failed to setup the outbox sink: " + err.Error())
+	}
+	// the names are recorded with the events the sinks took, don't rename them
+	sinks := []outbox.NamedSink{{Name: "sink", Sink: eventSink}}
+	notifier, err := setupNotifier(config.Notify)
+	if err != nil {
+		panic("failed to setup the notifier: " + err.Error())
+	}
+	workers = []func(context.Context){
+		func(ctx context.Context) {
+			sweepExpiredHolds(ctx, controller, config.Outbox.PollInterval, config.Outbox.BatchSize)
+		},
+	}
+	if config.Webhooks.Enabled {
+		webhookDao := d.NewWebhookDao(db)
+		sinks = append(sinks, outbox.NamedSink{Name: "webhooks", Sink: webhook.NewFanoutSink(webhookDao)})
+		deliverer := webhook.NewDeliverer(webhookDao, webhook.Options{
+			PollInterval: config.Webhooks.PollInterval,
+			BatchSize:    config.Webhooks.BatchSize,
+			Lease:        config.Webhooks.Lease,
+			Timeout:      config.Webhooks.Timeout,
+			MinBackoff:   config.Webhooks.MinBackoff,
+			MaxBackoff:   config.Webhooks.MaxBackoff,
+			MaxAttempts:  config.Webhooks.MaxAttempts,
+			AllowPrivate: config.Webhooks.AllowPrivate,
+		})
+		workers = append(workers, deliverer.Run)
+	}
+	if config.Confirmation.SendLinks {
+		sinks = append(sinks, outbox.NamedSink{Name: "confirmation", Sink: confirmation.NewEmailSink(signer, notifier, controller, config.Confirmation.LinkURL)})
+	}
+	if config.Waitlist.Enabled {
+		sinks = append(sinks, outbox.NamedSink{Name: "waitlist", Sink: waitlist.NewSink(controller)})
+	}
+	if config.Reminders.Enabled {
+		sender := reminder.NewSender(d.NewReminderDao(db, encryptor), notifier, reminder.Options{
+			PollInterval: config.Reminders.PollInterval,
+			BatchSize:    config.Reminders.BatchSize,
+			Lease:        config.Reminders.Lease,
+			MinBackoff:   config.Reminders.MinBackoff,
+			MaxBackoff:   config.Reminders.MaxBackoff,
+			MaxAttempts:  config.Reminders.MaxAttempts,
+		})
+		workers = append(workers, sender.Run)
+	}
+	if config.RateLimit.Enabled && strings.EqualFold(config.RateLimit.Backend, "postgres") {
+		workers = append(workers, func(ctx context.Context) {
+			pruneRateLimitBuckets(ctx, d.NewRateLimitDao(db), config.RateLimit.PruneInterval)
+		})
+	}
+	sink = outbox.NewMultiSink(sinks...)
+	dispatcher := outbox.NewDispatcher(d.NewOutboxDao(db), sink, outbox.Options{
+		PollInterval:   config.Outbox.PollInterval,
+		BatchSize:      config.Outbox.BatchSize,
+		Lease:          config.Outbox.Lease,
+		MinBackoff:     config.Outbox.MinBackoff,
+		MaxBackoff:     config.Outbox.MaxBackoff,
+		PublishTimeout: config.Outbox.PublishTimeout,
+	})
+	workers = append(workers, dispatcher.Run)
+	return
 }
 
-func setupServer(handler *h.Handler) (e *echo.Echo) {
+func setupSink(config cfg.Outbox) (outbox.Sink, error) {
+	switch strings.ToLower(config.Sink) {
+	case "webhook":
+		return outbox.NewWebhookSink(config.WebhookURL, config.PublishTimeout), nil
+	case "nats":
+		return outbox.NewNATSSink(config.NATSURL, config.NATSSubject, config.PublishTimeout)
+	default:
+		return outbox.NewLogSink(), nil
+	}
+}
+
+func setupSigner(config cfg.Confirmation) *confirmation.Signer {
+	if config.Secret != "" {
+		return confirmation.NewSigner([]byte(config.Secret))
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic("failed to generate the confirmation secret: " + err.Error())
+	}
+	slog.Warn("confirmation.secret is not set, using a random one. Confirmation links stop working on restart and only work on the instance that sent them")
+	return confirmation.NewSigner(secret)
+}
+
+// setupEncryptor builds what patient details are encrypted with, nil when encryption.kms is none
+func setupEncryptor(config cfg.Encryption) (*encryption.Encryptor, error) {
+	if strings.ToLower(config.KMS) != "file" {
+		slog.Warn("encryption.kms is none, patient details are stored in plaintext")
+		return nil, nil
+	}
+	kms, err := encryption.NewFileKMS(config.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return encryption.NewEncryptor(kms, config.DataKeyTTL), nil
+}
+
+// setupLimiter builds where the rate limits keep their buckets
+func setupLimiter(db *gopg.DB, config cfg.RateLimit) ratelimit.Limiter {
+	if strings.EqualFold(config.Backend, "postgres") {
+		return d.NewRateLimitDao(db)
+	}
+	return ratelimit.NewMemoryLimiter()
+}
+
+func setupNotifier(config cfg.Notify) (notify.Notifier, error) {
+	switch strings.ToLower(config.Channel) {
+	case "email":
+		return notify.NewEmailNotifier(notify.EmailOptions{
+			Addr:     config.SMTPAddr,
+			Username: config.SMTPUsername,
+			Password: config.SMTPPassword,
+			From:     config.EmailFrom,
+			Timeout:  config.Timeout,
+		})
+	case "sms":
+		return notify.NewSMSNotifier(notify.NewFakeSMSProvider(0)), nil
+	default:
+		return notify.NewLogNotifier(), nil
+	}
+}
+
+// sweepExpiredHolds records the expiry of holds as they run out until ctx is cancelled, a hold expiring is the one
+// change no request makes
+func sweepExpiredHolds(ctx context.Context, controller c.Controller, interval time.Duration, batchSize int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		// a full batch means there may be more, keep going until it's drained
+		for ctx.Err() == nil {
+			expired, err := controller.ExpireReservations(ctx, batchSize)
+			if err != nil {
+				if ctx.Err() == nil {
+					slog.ErrorContext(ctx, "failed to record expired holds", "error", err)
+				}
+				break
+			}
+			if expired < batchSize {
+				break
+			}
+		}
+	}
+}
+
+// pruneRateLimitBuckets deletes the buckets that filled back up every interval until ctx is cancelled, the table
+// would otherwise keep a row for every caller ever seen
+func pruneRateLimitBuckets(ctx context.Context, rateLimitDao d.RateLimitDao, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, err := rateLimitDao.PruneRateLimitBuckets(ctx); err != nil && ctx.Err() == nil {
+			slog.ErrorContext(ctx, "failed to prune rate limit buckets", "error", err)
+		}
+	}
+}
+
+func setupController(db *gopg.DB, config cfg.Config, signer *confirmation.Signer, encryptor *encryption.Encryptor) c.Controller {
+	dao := d.NewTracedDao(d.NewInstrumentedDao(d.NewReservationDao(db, encryptor)))
+	var reminderLeads []time.Duration
+	if config.Reminders.Enabled {
+		// checked by Validate, the error can't happen here
+		reminderLeads, _ = config.Reminders.LeadDurations()
+	}
+	// likewise
+	appointmentTypes, _ := config.Waitlist.AppointmentTypeLengths()
+	appointmentModalities, _ := config.Waitlist.AppointmentTypeModalities()
+	return c.NewTracedController(c.NewController(dao, c.Policy{
+		LeadTime:              config.Booking.LeadTime,
+		HoldDuration:          config.Booking.HoldDuration,
+		SlotInterval:          config.Booking.SlotInterval,
+		MaxHolds:              config.Booking.MaxHolds,
+		DefaultLimit:          config.Paging.DefaultLimit,
+		MaxLimit:              config.Paging.MaxLimit,
+		CalendarLookback:      config.Calendar.Lookback,
+		CalendarHorizon:       config.Calendar.Horizon,
+		ReminderLeads:         reminderLeads,
+		AppointmentTypes:      appointmentTypes,
+		AppointmentModalities: appointmentModalities,
+		WaitlistOfferHold:     config.Waitlist.OfferHold,
+		WaitlistByPriority:    strings.EqualFold(config.Waitlist.Order, "priority"),
+		WaitlistBatchSize:     config.Waitlist.BatchSize,
+	}, ical.NewHTTPFetcher(config.Calendar.FetchTimeout, int64(config.Calendar.FetchMaxBytes), config.Calendar.AllowPrivate), signer,
+		video.NewFakeRoomProvider(config.Video.RoomBaseURL)))
+}
+
+// routes under it only take auth.adminAPIKey
+const adminPrefix = h.V1Prefix + "/admin/"
+
+// routes called by things that don't hold an API key: operational endpoints probed and scraped by infrastructure,
+// and calendar feeds and confirmation links, which check their own token instead
+var publicPaths = map[string]bool{
+	"/metrics":      true,
+	"/healthz":      true,
+	"/readyz":       true,
+	"/version":      true,
+	"/openapi.json": true,
+
+	h.V1Prefix + "/users/:userId/calendar.ics": true,
+	h.V1Prefix + "/reservations/confirm":       true,
+}
+
+// probed and scraped by infrastructure, they're left out of the rate limits
+var unlimitedPaths = map[string]bool{
+	"/metrics": true,
+	"/healthz": true,
+	"/readyz":  true,
+}
+
+// imports of calendars and CSVs, they take bodies of up to 5MB. Every other body is a small JSON object, 64KB at most
+var largeBodyPaths = map[string]bool{
+	h.V1Prefix + "/users/:providerId/availabilities\\:bulk":         true,
+	h.V1Prefix + "/users/:providerId/busy-sources":                  true,
+	h.V1Prefix + "/users/:providerId/busy-sources/:sourceId/import": true,
+}
+
+func setupServer(handler *h.Handler, checker *health.Checker, validator *openapi.Validator, idempotencyDao d.IdempotencyDao, organizationDao d.OrganizationDao, limiter ratelimit.Limiter, config cfg.Config) (e *echo.Echo) {
 	e = echo.New()
-	e.Router().Add("GET", "/users/:providerId/availabilities", handler.HandleGetAvailabilitiesRequest)
-	e.Router().Add("POST", "/users/:providerId/availabilities", handler.HandleCreateAvailabilityRequest)
-	e.Router().Add("POST", "/reservations", handler.HandleCreateReservationRequest)
-	e.Router().Add("POST", "/reservations/confirm/:confirmationId", handler.HandleConfirmReservationRequest)
+	// X-Forwarded-For is whatever the caller says it is unless a proxy in front of us sets it
+	e.IPExtractor = echo.ExtractIPDirect()
+	if config.RateLimit.TrustForwardedFor {
+		e.IPExtractor = echo.ExtractIPFromXFFHeader()
+	}
+	e.Server.ReadTimeout = config.Server.ReadTimeout
+	e.Server.WriteTimeout = config.Server.WriteTimeout
+	e.HideBanner = true
+	e.HTTPErrorHandler = h.ErrorHandler(e)
+	// tracing goes first so the log lines below carry the trace ID
+	e.Use(tracing.Middleware())
+	// the request ID is taken from the X-Request-Id header if the caller sent one, otherwise generated
+	e.Use(middleware.RequestIDWithConfig(middleware.RequestIDConfig{
+		RequestIDHandler: func(c echo.Context, requestID string) {
+			c.SetRequest(c.Request().WithContext(logging.WithRequestID(c.Request().Context(), requestID)))
+		},
+	}))
+	// anonymous until the API key check says who's calling
+	e.Use(audit.Middleware())
+	e.Use(middleware.RequestLoggerWithConfig(middleware.RequestLoggerConfig{
+		LogMethod:  true,
+		LogURIPath: true,
+		LogStatus:  true,
+		LogLatency: true,
+		LogValuesFunc: func(c echo.Context, v middleware.RequestLoggerValues) error {
+			slog.InfoContext(c.Request().Context(), "request",
+				"method", v.Method,
+				"path", v.URIPath,
+				"status", v.Status,
+				"latency", v.Latency,
+			)
+			return nil
+		},
+	}))
+	e.Use(metrics.Middleware())
+	// the validator, the idempotency check and the client limit read the body before any handler does, the limits go
+	// ahead of all of them
+	e.Use(middleware.BodyLimitWithConfig(middleware.BodyLimitConfig{
+		Limit: "64K",
+		Skipper: func(c echo.Context) bool {
+			return largeBodyPaths[c.Path()]
+		},
+	}))
+	e.Use(middleware.BodyLimitWithConfig(middleware.BodyLimitConfig{
+		Limit: "5M",
+		Skipper: func(c echo.Context) bool {
+			return !largeBodyPaths[c.Path()]
+		},
+	}))
+	var booking []echo.MiddlewareFunc
+	if config.RateLimit.Enabled {
+		// ahead of the API key check, so guessing keys is limited too
+		e.Use(ratelimit.Middleware(limiter, ratelimit.Rule{
+			Name:  "ip",
+			Rate:  float64(config.RateLimit.IPPerMinute) / 60,
+			Burst: config.RateLimit.IPBurst,
+			Key: func(c echo.Context) string {
+				if unlimitedPaths[c.Path()] {
+					return ""
+				}
+				return ratelimit.ByIP(c)
+			},
+		}))
+		// holding slots is what gets abused, the routes that do are limited harder. Route middleware runs after the API
+		// key check, so the principal limit knows who's calling
+		booking = append(booking,
+			ratelimit.Middleware(limiter, ratelimit.Rule{
+				Name:  "booking_ip",
+				Rate:  float64(config.RateLimit.BookingIPPerMinute) / 60,
+				Burst: config.RateLimit.BookingIPBurst,
+				Key:   ratelimit.ByIP,
+			}),
+			ratelimit.Middleware(limiter, ratelimit.Rule{
+				Name:  "principal",
+				Rate:  float64(config.RateLimit.PrincipalPerMinute) / 60,
+				Burst: config.RateLimit.PrincipalBurst,
+				Key:   ratelimit.ByPrincipal,
+			}),
+			ratelimit.Middleware(limiter, ratelimit.Rule{
+				Name:  "client",
+				Rate:  float64(config.RateLimit.ClientPerMinute) / 60,
+				Burst: config.RateLimit.ClientBurst,
+				Key:   ratelimit.ByClientID,
+			}),
+		)
+	}
+	if config.Auth.Enabled {
+		e.Use(middleware.KeyAuthWithConfig(middleware.KeyAuthConfig{
+			Skipper: func(c echo.Context) bool {
+				return publicPaths[c.Path()]
+			},
+			Validator: func(key string, c echo.Context) (bool, error) {
+				admin := config.Auth.AdminAPIKey != "" && subtle.ConstantTimeCompare([]byte(key), []byte(config.Auth.AdminAPIKey)) == 1
+				if admin {
+					withActor(c, audit.ActorAdmin)
+				}
+				if strings.HasPrefix(c.Path(), adminPrefix) {
+					return admin, nil
+				}
+				if admin {
+					return true, nil
+				}
+				if subtle.ConstantTimeCompare([]byte(key), []byte(config.Auth.APIKey)) == 1 {
+					withActor(c, audit.ActorAPIKey)
+					return true, nil
+				}
+				return organizationKey(c, organizationDao, key)
+			},
+		}))
+	}
+	// checked by Validate, the error can't happen here
+	headerActors, _ := config.Tenancy.HeaderActors()
+	e.Use(tenant.Middleware(config.Tenancy.Required, func(c echo.Context) bool {
+		return headerActors[audit.Actor(c.Request().Context())]
+	}, func(c echo.Context) bool {
+		return publicPaths[c.Path()] || strings.HasPrefix(c.Path(), adminPrefix)
+	}))
+	e.Use(validator.Middleware())
+	e.Use(idempotency.Middleware(idempotencyDao))
+	e.GET("/metrics", echo.WrapHandler(metrics.Handler()))
+	e.GET("/healthz", checker.HandleHealthz)
+	e.GET("/readyz", checker.HandleReadyz)
+	e.GET("/version", checker.HandleVersion)
+	e.GET("/openapi.json", validator.HandleSpec)
+
+	v1 := e.Group(h.V1Prefix)
+	v1.GET("/users/:userId", handler.HandleV1GetUser)
+	v1.PATCH("/users/:userId", handler.HandleV1UpdateUser)
+	v1.GET("/users/:userId/calendar.ics", handler.HandleV1GetCalendar)
+	v1.POST("/users/:userId/calendar/token", handler.HandleV1RotateCalendarToken)
+	v1.GET("/users/:providerId/availabilities", handler.HandleV1GetAvailabilities)
+	v1.POST("/users/:providerId/availabilities", handler.HandleV1CreateAvailability)
+	// the colon is escaped, :bulk is part of the path and not a parameter
+	v1.POST("/users/:providerId/availabilities\\:bulk", handler.HandleV1ImportAvailabilities)
+	v1.GET("/users/:providerId/availabilities/:availabilityId", handler.HandleV1GetAvailability)
+	v1.PATCH("/users/:providerId/availabilities/:availabilityId", handler.HandleV1UpdateAvailability)
+	v1.DELETE("/users/:providerId/availabilities/:availabilityId", handler.HandleV1DeleteAvailability)
+	v1.GET("/users/:providerId/slots", handler.HandleV1GetSlots)
+	v1.GET("/users/:providerId/licenses", handler.HandleV1GetProviderLicenses)
+	v1.PUT("/users/:providerId/licenses/:state", handler.HandleV1PutProviderLicense)
+	v1.DELETE("/users/:providerId/licenses/:state", handler.HandleV1DeleteProviderLicense)
+	v1.GET("/users/:providerId/busy-sources", handler.HandleV1GetBusySources)
+	v1.POST("/users/:providerId/busy-sources", handler.HandleV1CreateBusySource)
+	v1.GET("/users/:providerId/busy-sources/:sourceId", handler.HandleV1GetBusySource)
+	v1.DELETE("/users/:providerId/busy-sources/:sourceId", handler.HandleV1DeleteBusySource)
+	v1.POST("/users/:providerId/busy-sources/:sourceId/import", handler.HandleV1ImportBusySource)
+	v1.POST("/reservations", handler.HandleV1CreateReservation, booking...)
+	v1.GET("/reservations/:reservationId", handler.HandleV1GetReservation)
+	v1.GET("/reservations/:reservationId/calendar.ics", handler.HandleV1GetReservationCalendar)
+	v1.POST("/reservations/confirm/:confirmationId", handler.HandleV1ConfirmReservation)
+	v1.GET("/reservations/confirm", handler.HandleV1ConfirmReservationByToken)
+	v1.POST("/locations", handler.HandleV1CreateLocation)
+	v1.GET("/locations", handler.HandleV1GetLocations)
+	v1.GET("/locations/:locationId", handler.HandleV1GetLocation)
+	v1.POST("/waitlist", handler.HandleV1CreateWaitlistEntry, booking...)
+	v1.GET("/waitlist/:entryId", handler.HandleV1GetWaitlistEntry)
+	v1.DELETE("/waitlist/:entryId", handler.HandleV1CancelWaitlistEntry)
+	v1.POST("/admin/organizations", handler.HandleV1CreateOrganization)
+	v1.GET("/admin/organizations", handler.HandleV1GetOrganizations)
+	v1.GET("/admin/organizations/:organizationId", handler.HandleV1GetOrganization)
+	v1.POST("/admin/organizations/:organizationId/key", handler.HandleV1RotateOrganizationKey)
+	v1.POST("/admin/webhooks", handler.HandleV1CreateWebhookSubscription)
+	v1.GET("/admin/webhooks", handler.HandleV1GetWebhookSubscriptions)
+	v1.GET("/admin/webhooks/:webhookId", handler.HandleV1GetWebhookSubscription)
+	v1.PATCH("/admin/webhooks/:webhookId", handler.HandleV1UpdateWebhookSubscription)
+	v1.DELETE("/admin/webhooks/:webhookId", handler.HandleV1DeleteWebhookSubscription)
+	v1.GET("/admin/webhook-deliveries", handler.HandleV1GetWebhookDeliveries)
+	v1.GET("/admin/webhook-deliveries/:deliveryId", handler.HandleV1GetWebhookDelivery)
+	v1.POST("/admin/webhook-deliveries/:deliveryId/redeliver", handler.HandleV1RedeliverWebhook)
+	v1.GET("/admin/audit-log", handler.HandleV1GetAuditEntries)
+	v1.GET("/admin/audit-log/verify", handler.HandleV1VerifyAuditLog)
+
+	// the unversioned API, kept as is for existing callers until they move to /v1
+	e.GET("/users/:userId", handler.HandleGetUserRequest, h.Deprecated)
+	e.GET("/users/:providerId/availabilities", handler.HandleGetAvailabilitiesRequest, h.Deprecated)
+	e.POST("/users/:providerId/availabilities", handler.HandleCreateAvailabilityRequest, h.Deprecated)
+	e.POST("/reservations", handler.HandleCreateReservationRequest, append([]echo.MiddlewareFunc{h.Deprecated}, booking...)...)
+	e.POST("/reservations/confirm/:confirmationId", handler.HandleConfirmReservationRequest, h.Deprecated)
 	return
 }
 
-func createGoPgDB(url string) (*gopg.DB, error) {
-	options, err := gopg.ParseURL(url)
+// organizationKey accepts an organization's API key and scopes the request to the organization
+func organizationKey(c echo.Context, organizationDao d.OrganizationDao, key string) (bool, error) {
+	organization, err := organizationDao.GetOrganizationByKeyHash(c.Request().Context(), tenant.HashKey(key))
+	if errors.Is(err, d.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	c.SetRequest(c.Request().WithContext(tenant.WithID(c.Request().Context(), organization.ID)))
+	withActor(c, audit.OrganizationActor(organization.ID))
+	return true, nil
+}
+
+// withActor records who the request's changes are made by in the audit log
+func withActor(c echo.Context, actor string) {
+	c.SetRequest(c.Request().WithContext(audit.WithActor(c.Request().Context(), actor)))
+}
+
+func createGoPgDB(config cfg.DB) (*gopg.DB, error) {
+	options, err := gopg.ParseURL(config.URL)
 	if err != nil {
 		return nil, err
 	}
-	options.DialTimeout = 20 * time.Second
+	options.PoolSize = config.PoolSize
+	options.DialTimeout = config.DialTimeout
+	options.ReadTimeout = config.ReadTimeout
+	options.WriteTimeout = config.WriteTimeout
 	db := gopg.Connect(options)
 
 	// check connection
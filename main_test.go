@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"henrymeds-takehome/api"
+	cfg "henrymeds-takehome/config"
+	d "henrymeds-takehome/dao"
+	"henrymeds-takehome/encryption"
+	h "henrymeds-takehome/handler"
+	"henrymeds-takehome/idempotency"
+	"henrymeds-takehome/model"
+	"henrymeds-takehome/openapi"
+	"henrymeds-takehome/ratelimit"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	gopg "github.com/go-pg/pg/v10"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// the server refuses to start when they disagree too, this catches it before a deploy does
+func TestRoutesMatchTheSpec(t *testing.T) {
+	validator, err := openapi.NewValidator(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := cfg.Default()
+	config.Auth.Enabled = true
+	e := setupServer(h.NewHandler(nil), nil, validator, nil, nil, ratelimit.NewMemoryLimiter(), config)
+	if err = validator.CheckRoutes(e.Routes()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// the limits are checked before the validator and the idempotency check read the body
+func TestBodyLimits(t *testing.T) {
+	validator, err := openapi.NewValidator(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := cfg.Default()
+	config.Auth.Enabled = true
+	config.Auth.APIKey = "key"
+	config.Tenancy.Required = false
+	e := setupServer(h.NewHandler(nil), nil, validator, nil, nil, ratelimit.NewMemoryLimiter(), config)
+
+	// under its limit a body gets as far as the validator, which refuses the blanks
+	for name, c := range map[string]struct {
+		path    string
+		size    int
+		chunked bool
+		want    int
+	}{
+		"booking":                         {path: "/v1/reservations", size: 100 << 10, want: http.StatusRequestEntityTooLarge},
+		"chunked booking":                 {path: "/v1/reservations", size: 100 << 10, chunked: true, want: http.StatusRequestEntityTooLarge},
+		"legacy booking":                  {path: "/reservations", size: 100 << 10, want: http.StatusRequestEntityTooLarge},
+		"webhook subscription":            {path: "/v1/admin/webhooks", size: 100 << 10, want: http.StatusRequestEntityTooLarge},
+		"import under its limit":          {path: "/v1/users/" + uuid.NewString() + "/availabilities:bulk", size: 100 << 10, want: http.StatusBadRequest},
+		"import over its limit":           {path: "/v1/users/" + uuid.NewString() + "/availabilities:bulk", size: 6 << 20, want: http.StatusRequestEntityTooLarge},
+		"calendar import over its limit":  {path: "/v1/users/" + uuid.NewString() + "/busy-sources", size: 6 << 20, want: http.StatusRequestEntityTooLarge},
+		"calendar import under its limit": {path: "/v1/users/" + uuid.NewString() + "/busy-sources", size: 100 << 10, want: http.StatusBadRequest},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var body io.Reader = bytes.NewReader(bytes.Repeat([]byte(" "), c.size))
+			if c.chunked {
+				// no Content-Length, only reading tells how large it is
+				body = io.MultiReader(body)
+			}
+			request := httptest.NewRequest(http.MethodPost, c.path, body)
+			request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			request.Header.Set(echo.HeaderAuthorization, "Bearer key")
+			recorder := httptest.NewRecorder()
+			e.ServeHTTP(recorder, request)
+			if recorder.Code != c.want {
+				t.Fatalf("got %d %s, want %d", recorder.Code, recorder.Body, c.want)
+			}
+		})
+	}
+}
+
+// testDB connects to the database in HENRY_TEST_DB_URL, migrated with `make migrate`, and skips the test without
+// one. Like the DAO's tests, the rows are left behind
+func testDB(t *testing.T) *gopg.DB {
+	url := os.Getenv("HENRY_TEST_DB_URL")
+	if url == "" {
+		t.Skip("HENRY_TEST_DB_URL is not set")
+	}
+	options, err := gopg.ParseURL(url)
+	if err != nil {
+		t.Fatalf("HENRY_TEST_DB_URL: %v", err)
+	}
+	db := gopg.Connect(options)
+	t.Cleanup(func() {
+		db.Close()
+	})
+	return db
+}
+
+// testEncryptor encrypts with a file KMS. The key is the one the DAO's tests re-encrypt to, the rows left behind here
+// have to decrypt there
+func testEncryptor(t *testing.T) *encryption.Encryptor {
+	path := filepath.Join(t.TempDir(), "keys.txt")
+	if err := os.WriteFile(path, []byte("test Y4t461jz2tc7wBI3xl/JW8CrlAuwPE0P36QG7MqnsAA=\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	kms, err := encryption.NewFileKMS(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encryption.NewEncryptor(kms, time.Hour)
+}
+
+// testServer serves the API over the database without authentication or tenancy, the tests make their own
+// organizations
+func testServer(t *testing.T, db *gopg.DB, encryptor *encryption.Encryptor) *echo.Echo {
+	validator, err := openapi.NewValidator(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := cfg.Default()
+	config.Auth.Enabled = false
+	config.Tenancy.Required = false
+	handler, _ := setupService(db, config, setupSigner(config.Confirmation), encryptor)
+	return setupServer(handler, nil, validator, d.NewIdempotencyDao(db, time.Hour, encryptor), nil, ratelimit.NewMemoryLimiter(), config)
+}
+
+// a booking goes through every table it touches: the reservation, its audit entry, its event and the response kept
+// for its Idempotency-Key. The reason must not be in any of them in plaintext
+func TestReasonIsNeverStoredInPlaintext(t *testing.T) {
+	var (
+		ctx       = context.Background()
+		db        = testDB(t)
+		encryptor = testEncryptor(t)
+		dao       = d.NewReservationDao(db, encryptor)
+		reason    = "a reason no other row has " + uuid.NewString()
+		start     = time.Now().UTC().Truncate(time.Hour).Add(72 * time.Hour)
+	)
+	organization, err := dao.InsertOrganization(ctx, model.Organization{Name: "test " + uuid.NewString()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := []model.User{
+		{ID: uuid.NewString(), Username: "provider", OrganizationID: organization.ID},
+		{ID: uuid.NewString(), Username: "client", OrganizationID: organization.ID, State: "CA"},
+	}
+	if _, err = db.ModelContext(ctx, &users).Insert(); err != nil {
+		t.Fatal(err)
+	}
+	license := model.ProviderLicense{
+		ID:             uuid.NewString(),
+		OrganizationID: organization.ID,
+		ProviderID:     users[0].ID,
+		State:          "CA",
+		LicenseNumber:  "A1",
+		ExpiresOn:      start.AddDate(1, 0, 0),
+		CreatedAt:      time.Now(),
+	}
+	if _, err = db.ModelContext(ctx, &license).Insert(); err != nil {
+		t.Fatal(err)
+	}
+	_, err = dao.InsertAvailabilities(ctx, []model.Availability{{
+		ProviderID: users[0].ID,
+		TimeRange:  model.TimeRange{Start: start, End: start.Add(2 * time.Hour)},
+		Capacity:   1,
+		Modalities: []string{model.ModalityVideo},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := testServer(t, db, encryptor)
+	body, err := json.Marshal(api.CreateReservation{
+		ClientID:   users[1].ID,
+		ProviderID: users[0].ID,
+		Start:      start,
+		End:        start.Add(30 * time.Minute),
+		Reason:     reason,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := uuid.NewString()
+	// the second is replayed from the stored response, which has to decrypt back to the first
+	for _, replayed := range []string{"", "true"} {
+		request := httptest.NewRequest(http.MethodPost, "/v1/reservations", bytes.NewReader(body))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set(idempotency.HeaderKey, key)
+		recorder := httptest.NewRecorder()
+		e.ServeHTTP(recorder, request)
+		if recorder.Code != http.StatusCreated || recorder.Header().Get(idempotency.HeaderReplayed) != replayed {
+			t.Fatalf("got %d %s, replayed %q", recorder.Code, recorder.Body, recorder.Header().Get(idempotency.HeaderReplayed))
+		}
+		var reservation api.Reservation
+		if err = json.Unmarshal(recorder.Body.Bytes(), &reservation); err != nil || reservation.Reason != reason {
+			t.Fatalf("got %s, err %v", recorder.Body, err)
+		}
+	}
+
+	var tables []string
+	_, err = db.QueryContext(ctx, &tables, "SELECT table_name FROM information_schema.tables WHERE table_schema = current_schema() AND table_type = 'BASE TABLE'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a row as text has its bytea columns in hex
+	for _, table := range tables {
+		var found int
+		_, err = db.QueryOneContext(ctx, gopg.Scan(&found), "SELECT count(*) FROM ? AS t WHERE t::text LIKE ? OR t::text LIKE ?",
+			gopg.Ident(table), "%"+reason+"%", "%"+hex.EncodeToString([]byte(reason))+"%")
+		if err != nil {
+			t.Fatalf("failed to search %s: %v", table, err)
+		}
+		if found > 0 {
+			t.Errorf("%s has the reason in plaintext in %d rows", table, found)
+		}
+	}
+	if !strings.Contains(strings.Join(tables, ","), "idempotency_keys") {
+		t.Fatalf("only searched %v", tables)
+	}
+}
+
+// an import that's a dry run, or has a rejected row, reports on every row and leaves the provider's availabilities as
+// they were
+func TestImportAvailabilities(t *testing.T) {
+	var (
+		ctx       = context.Background()
+		db        = testDB(t)
+		encryptor = testEncryptor(t)
+		dao       = d.NewReservationDao(db, encryptor)
+		start     = time.Now().UTC().Truncate(time.Hour).Add(72 * time.Hour)
+		e         = testServer(t, db, encryptor)
+	)
+	organization, err := dao.InsertOrganization(ctx, model.Organization{Name: "test " + uuid.NewString()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider := model.User{ID: uuid.NewString(), Username: "provider", OrganizationID: organization.ID}
+	if _, err = db.ModelContext(ctx, &provider).Insert(); err != nil {
+		t.Fatal(err)
+	}
+	_, err = dao.InsertAvailabilities(ctx, []model.Availability{{
+		ProviderID: provider.ID,
+		TimeRange:  model.TimeRange{Start: start, End: start.Add(time.Hour)},
+		Capacity:   1,
+		Modalities: []string{model.ModalityVideo},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	csv := func(hours ...int) string {
+		rows := []string{"start,end"}
+		for _, hour := range hours {
+			from := start.Add(time.Duration(hour) * time.Hour)
+			rows = append(rows, from.Format(time.RFC3339)+","+from.Add(time.Hour).Format(time.RFC3339))
+		}
+		return strings.Join(rows, "\n") + "\n"
+	}
+	for _, test := range []struct {
+		name   string
+		body   string
+		dryRun bool
+		want   int
+		// the rows' statuses
+		statuses []string
+		// the provider's availabilities after
+		availabilities int
+	}{
+		{name: "dry run", body: csv(1, 2), dryRun: true, want: http.StatusOK, statuses: []string{model.ImportRowValid, model.ImportRowValid}, availabilities: 1},
+		{name: "dry run over what's there", body: csv(0, 1), dryRun: true, want: http.StatusOK, statuses: []string{model.ImportRowConflict, model.ImportRowValid}, availabilities: 1},
+		{name: "over what's there", body: csv(0, 1), want: http.StatusOK, statuses: []string{model.ImportRowConflict, model.ImportRowValid}, availabilities: 1},
+		{name: "overlapping each other", body: csv(1, 1), want: http.StatusOK, statuses: []string{model.ImportRowConflict, model.ImportRowConflict}, availabilities: 1},
+		{name: "committed", body: csv(1, 2), want: http.StatusCreated, statuses: []string{model.ImportRowCreated, model.ImportRowCreated}, availabilities: 3},
+	} {
+		request := httptest.NewRequest(http.MethodPost, "/v1/users/"+provider.ID+"/availabilities:bulk?dryRun="+strconv.FormatBool(test.dryRun), strings.NewReader(test.body))
+		request.Header.Set(echo.HeaderContentType, "text/csv")
+		recorder := httptest.NewRecorder()
+		e.ServeHTTP(recorder, request)
+		if recorder.Code != test.want {
+			t.Fatalf("%s: got %d %s", test.name, recorder.Code, recorder.Body)
+		}
+		var report api.Envelope[api.ImportReport]
+		if err = json.Unmarshal(recorder.Body.Bytes(), &report); err != nil {
+			t.Fatalf("%s: got %s, err %v", test.name, recorder.Body, err)
+		}
+		var statuses []string
+		for _, row := range report.Data.Rows {
+			statuses = append(statuses, row.Status)
+		}
+		if !slices.Equal(statuses, test.statuses) {
+			t.Fatalf("%s: the rows are %q, want %q", test.name, statuses, test.statuses)
+		}
+		availabilities, err := db.ModelContext(ctx, (*model.Availability)(nil)).Where("provider_id = ?", provider.ID).Count()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if availabilities != test.availabilities {
+			t.Fatalf("%s: the provider has %d availabilities, want %d", test.name, availabilities, test.availabilities)
+		}
+	}
+}
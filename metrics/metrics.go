@@ -0,0 +1,196 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	gopg "github.com/go-pg/pg/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "henrymeds"
+
+// reservation lifecycle events, used as the event label on Reservations
+const (
+	ReservationCreated    = "created"
+	ReservationConfirmed  = "confirmed"
+	ReservationExpired    = "expired"
+	ReservationConflicted = "conflicted"
+	ReservationInvalid    = "invalid"
+	ReservationCancelled  = "cancelled"
+	// the provider isn't licensed where the client is located
+	ReservationIneligible = "ineligible"
+	// the client already holds as many unconfirmed reservations as they can
+	ReservationTooManyHolds = "too_many_holds"
+)
+
+// outbox publish outcomes, used as the outcome label on OutboxEvents
+const (
+	OutboxPublished = "published"
+	OutboxFailed    = "failed"
+)
+
+// webhook delivery outcomes, used as the outcome label on WebhookDeliveries
+const (
+	WebhookDelivered = "delivered"
+	WebhookFailed    = "failed"
+	WebhookDead      = "dead"
+)
+
+// reminder outcomes, used as the outcome label on Reminders
+const (
+	ReminderSent      = "sent"
+	ReminderRetried   = "retried"
+	ReminderFailed    = "failed"
+	ReminderCancelled = "cancelled"
+)
+
+// Registry holds every collector the service exposes. A dedicated registry rather than the global default
+// keeps anything a dependency registers from leaking onto /metrics
+var Registry = prometheus.NewRegistry()
+
+var (
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	Reservations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reservations_total",
+		Help:      "Reservation lifecycle events: created, confirmed, expired, conflicted, invalid and cancelled.",
+	}, []string{"event"})
+
+	OutboxEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "outbox_events_total",
+		Help:      "Outbox publish attempts by event type and outcome: published or failed.",
+	}, []string{"type", "outcome"})
+
+	WebhookDeliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_deliveries_total",
+		Help:      "Webhook delivery attempts by outcome: delivered, failed, and dead once a delivery is given up on.",
+	}, []string{"outcome"})
+
+	Reminders = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reminders_total",
+		Help:      "Appointment reminders by channel and outcome: sent, retried after a failure, failed for good, and cancelled when the reservation was.",
+	}, []string{"channel", "outcome"})
+
+	RateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_requests_total",
+		Help:      "Requests turned away with a 429 by the limit they ran into: ip, booking_ip, principal or client.",
+	}, []string{"limit"})
+
+	WaitlistOffers = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "waitlist_offers_total",
+		Help:      "Slots offered to waitlisted clients as holds.",
+	})
+
+	DaoQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "dao_query_duration_seconds",
+		Help:      "ReservationDao call latency by operation and outcome.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation", "outcome"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequestDuration,
+		Reservations,
+		OutboxEvents,
+		WebhookDeliveries,
+		Reminders,
+		WaitlistOffers,
+		RateLimited,
+		DaoQueryDuration,
+	)
+	// make sure every event shows up as 0 rather than being missing until it first happens
+	for _, event := range []string{ReservationCreated, ReservationConfirmed, ReservationExpired, ReservationConflicted, ReservationInvalid, ReservationCancelled} {
+		Reservations.WithLabelValues(event)
+	}
+	for _, outcome := range []string{WebhookDelivered, WebhookFailed, WebhookDead} {
+		WebhookDeliveries.WithLabelValues(outcome)
+	}
+}
+
+// Handler serves everything in Registry in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// Middleware records the latency of every request. The route label is the registered path, ex:
+// /users/:providerId/availabilities, so the cardinality stays bounded no matter what IDs are requested
+func Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			err := next(c)
+			if err != nil {
+				// let echo write the response so the status below is the real one
+				c.Error(err)
+			}
+			route := c.Path()
+			if route == "" {
+				route = "unmatched"
+			}
+			HTTPRequestDuration.WithLabelValues(
+				c.Request().Method,
+				route,
+				strconv.Itoa(c.Response().Status),
+			).Observe(time.Since(start).Seconds())
+			return nil
+		}
+	}
+}
+
+// RegisterPoolStats exposes the go-pg connection pool stats of db
+func RegisterPoolStats(db *gopg.DB) {
+	Registry.MustRegister(poolCollector{db: db})
+}
+
+var (
+	poolHits       = prometheus.NewDesc(namespace+"_db_pool_hits_total", "Times a free connection was found in the pool.", nil, nil)
+	poolMisses     = prometheus.NewDesc(namespace+"_db_pool_misses_total", "Times a free connection was not found in the pool.", nil, nil)
+	poolTimeouts   = prometheus.NewDesc(namespace+"_db_pool_timeouts_total", "Times a wait for a connection timed out.", nil, nil)
+	poolTotalConns = prometheus.NewDesc(namespace+"_db_pool_connections", "Connections in the pool.", nil, nil)
+	poolIdleConns  = prometheus.NewDesc(namespace+"_db_pool_idle_connections", "Idle connections in the pool.", nil, nil)
+	poolStaleConns = prometheus.NewDesc(namespace+"_db_pool_stale_connections_total", "Stale connections removed from the pool.", nil, nil)
+)
+
+// poolCollector reads the pool stats at scrape time rather than polling them
+type poolCollector struct {
+	db *gopg.DB
+}
+
+func (p poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolHits
+	ch <- poolMisses
+	ch <- poolTimeouts
+	ch <- poolTotalConns
+	ch <- poolIdleConns
+	ch <- poolStaleConns
+}
+
+func (p poolCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := p.db.PoolStats()
+	ch <- prometheus.MustNewConstMetric(poolHits, prometheus.CounterValue, float64(stats.Hits))
+	ch <- prometheus.MustNewConstMetric(poolMisses, prometheus.CounterValue, float64(stats.Misses))
+	ch <- prometheus.MustNewConstMetric(poolTimeouts, prometheus.CounterValue, float64(stats.Timeouts))
+	ch <- prometheus.MustNewConstMetric(poolTotalConns, prometheus.GaugeValue, float64(stats.TotalConns))
+	ch <- prometheus.MustNewConstMetric(poolIdleConns, prometheus.GaugeValue, float64(stats.IdleConns))
+	ch <- prometheus.MustNewConstMetric(poolStaleConns, prometheus.CounterValue, float64(stats.StaleConns))
+}
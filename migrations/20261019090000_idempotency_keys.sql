@@ -0,0 +1,21 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+
+-- responses to requests sent with an Idempotency-Key header, so a retried request gets the original response
+-- instead of being applied twice
+CREATE TABLE idempotency_keys (
+  key VARCHAR(255) PRIMARY KEY,
+  -- method, path and body hash, a key can't be reused for a different request
+  fingerprint VARCHAR(255) NOT NULL,
+  completed boolean NOT NULL DEFAULT false,
+  status integer,
+  content_type VARCHAR(255),
+  body bytea,
+  created_at TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT now()
+);
+CREATE INDEX idempotency_keys_created_at ON idempotency_keys (created_at);
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+
+DROP TABLE idempotency_keys;
@@ -0,0 +1,15 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+
+-- list queries filter by provider or client and page through (start_time, id), these let postgres walk straight
+-- from a page's cursor instead of sorting everything the filter matches
+CREATE INDEX availabilities_provider_start ON availabilities (provider_id, start_time, id);
+CREATE INDEX reservations_provider_start ON reservations (provider_id, start_time, id);
+CREATE INDEX reservations_client_start ON reservations (client_id, start_time, id);
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+
+DROP INDEX reservations_client_start;
+DROP INDEX reservations_provider_start;
+DROP INDEX availabilities_provider_start;
@@ -0,0 +1,22 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+
+-- reservations are cancelled when the availability under them is removed, the row stays so the client can still
+-- look it up and see what happened to it
+ALTER TABLE reservations ADD COLUMN cancelled_at TIMESTAMP WITHOUT TIME ZONE;
+
+-- a cancelled reservation gives its slot back, only the live ones have to be unique
+ALTER TABLE reservations DROP CONSTRAINT reservations_provider_id_start_time_end_time_key;
+ALTER TABLE reservations DROP CONSTRAINT reservations_client_id_start_time_end_time_key;
+CREATE UNIQUE INDEX reservations_provider_times ON reservations (provider_id, start_time, end_time) WHERE cancelled_at IS NULL;
+CREATE UNIQUE INDEX reservations_client_times ON reservations (client_id, start_time, end_time) WHERE cancelled_at IS NULL;
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+
+DROP INDEX reservations_client_times;
+DROP INDEX reservations_provider_times;
+DELETE FROM reservations WHERE cancelled_at IS NOT NULL;
+ALTER TABLE reservations ADD CONSTRAINT reservations_client_id_start_time_end_time_key UNIQUE (client_id, start_time, end_time);
+ALTER TABLE reservations ADD CONSTRAINT reservations_provider_id_start_time_end_time_key UNIQUE (provider_id, start_time, end_time);
+ALTER TABLE reservations DROP COLUMN cancelled_at;
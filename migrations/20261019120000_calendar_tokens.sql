@@ -0,0 +1,10 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+
+-- the secret in a user's calendar feed URL. Only its SHA-256 is kept, the token itself is shown once when it's issued
+ALTER TABLE users ADD COLUMN calendar_token_hash bytea;
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+
+ALTER TABLE users DROP COLUMN calendar_token_hash;
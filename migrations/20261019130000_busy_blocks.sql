@@ -0,0 +1,35 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+
+-- a provider's outside calendar, either uploaded or fetched from a URL. Re-importing it replaces its busy blocks
+CREATE TABLE busy_sources (
+  id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+  provider_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  -- upload or url
+  kind VARCHAR(10) NOT NULL,
+  -- only set for url sources
+  url TEXT,
+  blocks integer NOT NULL DEFAULT 0,
+  -- events left out because of recurrence rules the parser doesn't understand
+  skipped integer NOT NULL DEFAULT 0,
+  imported_at TIMESTAMP WITHOUT TIME ZONE,
+  created_at TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT now()
+);
+CREATE INDEX busy_sources_provider ON busy_sources (provider_id);
+
+-- time the provider is busy elsewhere, nothing can be booked over it
+CREATE TABLE busy_blocks (
+  id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+  source_id uuid NOT NULL REFERENCES busy_sources(id) ON DELETE CASCADE,
+  provider_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  start_time TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+  end_time TIMESTAMP WITHOUT TIME ZONE NOT NULL
+);
+CREATE INDEX busy_blocks_provider_times ON busy_blocks (provider_id, start_time, end_time);
+CREATE INDEX busy_blocks_source ON busy_blocks (source_id);
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+
+DROP TABLE busy_blocks;
+DROP TABLE busy_sources;
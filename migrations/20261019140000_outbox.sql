@@ -0,0 +1,34 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+
+-- domain events, written in the same transaction as the change they describe and published from here by the
+-- dispatcher. A row stays until it's published, so an event is never lost to a crash between the commit and the publish
+CREATE TABLE outbox (
+  id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+  type VARCHAR(100) NOT NULL,
+  -- the reservation or availability the event is about
+  aggregate_id uuid NOT NULL,
+  payload jsonb NOT NULL,
+  created_at TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+  attempts integer NOT NULL DEFAULT 0,
+  -- when the event is next up for publishing, claiming an event pushes this out by the lease
+  next_attempt_at TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+  published_at TIMESTAMP WITHOUT TIME ZONE,
+  last_error TEXT
+);
+CREATE INDEX outbox_pending ON outbox (next_attempt_at) WHERE published_at IS NULL;
+
+-- nothing happens to a hold when it runs out, the expiry sweep records it here once it has published the event
+ALTER TABLE reservations ADD COLUMN expiry_published_at TIMESTAMP WITHOUT TIME ZONE;
+-- holds that ran out before there was an outbox are history, don't flood the sinks with them
+UPDATE reservations SET expiry_published_at = now()
+  WHERE confirmed = false AND cancelled_at IS NULL AND expires_at < now();
+CREATE INDEX reservations_unpublished_expiries ON reservations (expires_at)
+  WHERE confirmed = false AND cancelled_at IS NULL AND expiry_published_at IS NULL;
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+
+DROP INDEX reservations_unpublished_expiries;
+ALTER TABLE reservations DROP COLUMN expiry_published_at;
+DROP TABLE outbox;
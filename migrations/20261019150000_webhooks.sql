@@ -0,0 +1,54 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+
+-- receivers of the domain events, managed through the admin API
+CREATE TABLE webhook_subscriptions (
+  id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+  url TEXT NOT NULL,
+  event_types TEXT[] NOT NULL,
+  -- the HMAC key the payloads are signed with, the receiver has the same one to check them
+  secret TEXT NOT NULL,
+  active BOOLEAN NOT NULL DEFAULT true,
+  created_at TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+  updated_at TIMESTAMP WITHOUT TIME ZONE NOT NULL
+);
+
+-- one row per event per subscription, from the fan out until it's delivered or given up on. The dead ones are the
+-- dead letters, kept until they're redelivered or the subscription is deleted
+CREATE TABLE webhook_deliveries (
+  id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+  subscription_id uuid NOT NULL REFERENCES webhook_subscriptions(id) ON DELETE CASCADE,
+  event_id uuid NOT NULL,
+  event_type VARCHAR(100) NOT NULL,
+  payload jsonb NOT NULL,
+  -- pending, delivered or dead
+  status VARCHAR(20) NOT NULL DEFAULT 'pending',
+  attempts integer NOT NULL DEFAULT 0,
+  next_attempt_at TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+  last_status_code integer,
+  last_error TEXT,
+  created_at TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+  delivered_at TIMESTAMP WITHOUT TIME ZONE,
+  -- the outbox delivers at least once, a second fan out of the same event must not deliver it twice
+  UNIQUE (subscription_id, event_id)
+);
+CREATE INDEX webhook_deliveries_pending ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX webhook_deliveries_log ON webhook_deliveries (created_at, id);
+
+-- every attempt at a delivery, what the receiver answered or why it couldn't be reached
+CREATE TABLE webhook_attempts (
+  id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+  delivery_id uuid NOT NULL REFERENCES webhook_deliveries(id) ON DELETE CASCADE,
+  attempted_at TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+  status_code integer,
+  error TEXT,
+  duration_ms integer NOT NULL
+);
+CREATE INDEX webhook_attempts_delivery ON webhook_attempts (delivery_id, attempted_at);
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+
+DROP TABLE webhook_attempts;
+DROP TABLE webhook_deliveries;
+DROP TABLE webhook_subscriptions;
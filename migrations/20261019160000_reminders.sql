@@ -0,0 +1,35 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+
+-- where reminders reach a user, either can be missing and the channels that need it skip the user
+ALTER TABLE users ADD COLUMN email VARCHAR(254);
+ALTER TABLE users ADD COLUMN phone VARCHAR(20);
+UPDATE users SET email = username || '@example.com', phone = '+15555550100' WHERE username IN ('client1', 'client2');
+
+-- appointment reminders, scheduled when a reservation is confirmed and sent by the reminder worker once due. The rows
+-- are the schedule, so it survives restarts
+CREATE TABLE reminders (
+  id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+  reservation_id uuid NOT NULL REFERENCES reservations(id) ON DELETE CASCADE,
+  -- how long before the start of the reservation the reminder goes out
+  lead_seconds integer NOT NULL,
+  due_at TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+  -- pending, sent, failed or cancelled
+  status VARCHAR(20) NOT NULL DEFAULT 'pending',
+  attempts integer NOT NULL DEFAULT 0,
+  -- when the reminder is next up for sending, claiming it pushes this out by the lease
+  next_attempt_at TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+  last_error TEXT,
+  sent_at TIMESTAMP WITHOUT TIME ZONE,
+  created_at TIMESTAMP WITHOUT TIME ZONE NOT NULL
+);
+-- rescheduling cancels the pending reminders and adds new ones, only one of each lead can be waiting to go out
+CREATE UNIQUE INDEX reminders_pending_leads ON reminders (reservation_id, lead_seconds) WHERE status = 'pending';
+CREATE INDEX reminders_pending ON reminders (next_attempt_at) WHERE status = 'pending';
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+
+DROP TABLE reminders;
+ALTER TABLE users DROP COLUMN phone;
+ALTER TABLE users DROP COLUMN email;
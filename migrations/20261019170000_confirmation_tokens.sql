@@ -0,0 +1,17 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+
+-- confirmation links that were used. The tokens themselves are verified by their signature, this is only what stops
+-- one being used twice. A row is dead weight once its token expired, those can be deleted at any time
+CREATE TABLE spent_confirmation_tokens (
+  nonce VARCHAR(64) PRIMARY KEY,
+  reservation_id uuid NOT NULL REFERENCES reservations(id) ON DELETE CASCADE,
+  expires_at TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+  spent_at TIMESTAMP WITHOUT TIME ZONE NOT NULL
+);
+CREATE INDEX spent_confirmation_tokens_expiry ON spent_confirmation_tokens (expires_at);
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+
+DROP TABLE spent_confirmation_tokens;
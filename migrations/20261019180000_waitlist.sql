@@ -0,0 +1,30 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+
+-- clients waiting for time to free up. When it does, the next entry in line is offered a slot inside its window as a
+-- hold, the reservation_id, that it confirms like any other
+CREATE TABLE waitlist_entries (
+  id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+  client_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  -- NULL when any provider will do
+  provider_id uuid REFERENCES users(id) ON DELETE CASCADE,
+  appointment_type VARCHAR(50) NOT NULL,
+  -- the client takes any time inside it
+  start_time TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+  end_time TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+  -- higher goes first when offers are made in priority order, ties go to whoever joined first
+  priority integer NOT NULL DEFAULT 0,
+  -- waiting, offered, booked, expired or cancelled
+  status VARCHAR(20) NOT NULL DEFAULT 'waiting',
+  reservation_id uuid REFERENCES reservations(id) ON DELETE SET NULL,
+  offered_at TIMESTAMP WITHOUT TIME ZONE,
+  created_at TIMESTAMP WITHOUT TIME ZONE NOT NULL
+);
+-- the line, in either order
+CREATE INDEX waitlist_entries_waiting ON waitlist_entries (priority DESC, created_at, id) WHERE status = 'waiting';
+CREATE INDEX waitlist_entries_reservation ON waitlist_entries (reservation_id) WHERE reservation_id IS NOT NULL;
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+
+DROP TABLE waitlist_entries;
@@ -0,0 +1,16 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+
+-- how many clients an availability takes at the same time, ex: a group class with 8 seats. 1 is a one on one
+ALTER TABLE availabilities ADD COLUMN capacity INTEGER NOT NULL DEFAULT 1 CHECK (capacity > 0);
+
+-- the provider can have several reservations for the same time now, bookings lock the provider and count the active
+-- ones against the capacity instead. A client still can't be booked twice for the same time
+DROP INDEX reservations_provider_times;
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+
+-- fails while a provider has more than one live reservation for the same time, those have to be cancelled first
+CREATE UNIQUE INDEX reservations_provider_times ON reservations (provider_id, start_time, end_time) WHERE cancelled_at IS NULL;
+ALTER TABLE availabilities DROP COLUMN capacity;
@@ -0,0 +1,108 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+
+-- a brand or clinic, it owns its users and everything about them. Requests are scoped to one, see the tenant package
+CREATE TABLE organizations (
+  id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+  name VARCHAR(100) NOT NULL,
+  -- SHA-256 of the organization's API key, NULL until one is issued
+  api_key_hash bytea UNIQUE,
+  created_at TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT now()
+);
+
+-- everything that's already here belongs to the one organization there was so far
+INSERT INTO organizations (id, name) VALUES ('00000000-0000-4000-8000-000000000001', 'default');
+
+ALTER TABLE users ADD COLUMN organization_id uuid NOT NULL DEFAULT '00000000-0000-4000-8000-000000000001' REFERENCES organizations(id);
+ALTER TABLE users ALTER COLUMN organization_id DROP DEFAULT;
+-- what the rows below point at, so a row can't mix users of two organizations
+ALTER TABLE users ADD CONSTRAINT users_organization_id UNIQUE (organization_id, id);
+
+ALTER TABLE availabilities ADD COLUMN organization_id uuid NOT NULL DEFAULT '00000000-0000-4000-8000-000000000001';
+ALTER TABLE availabilities ALTER COLUMN organization_id DROP DEFAULT;
+ALTER TABLE availabilities ADD FOREIGN KEY (organization_id, provider_id) REFERENCES users (organization_id, id);
+
+ALTER TABLE reservations ADD COLUMN organization_id uuid NOT NULL DEFAULT '00000000-0000-4000-8000-000000000001';
+ALTER TABLE reservations ALTER COLUMN organization_id DROP DEFAULT;
+ALTER TABLE reservations ADD FOREIGN KEY (organization_id, provider_id) REFERENCES users (organization_id, id);
+ALTER TABLE reservations ADD FOREIGN KEY (organization_id, client_id) REFERENCES users (organization_id, id);
+
+ALTER TABLE busy_sources ADD COLUMN organization_id uuid NOT NULL DEFAULT '00000000-0000-4000-8000-000000000001';
+ALTER TABLE busy_sources ALTER COLUMN organization_id DROP DEFAULT;
+ALTER TABLE busy_sources ADD FOREIGN KEY (organization_id, provider_id) REFERENCES users (organization_id, id);
+
+ALTER TABLE busy_blocks ADD COLUMN organization_id uuid NOT NULL DEFAULT '00000000-0000-4000-8000-000000000001';
+ALTER TABLE busy_blocks ALTER COLUMN organization_id DROP DEFAULT;
+ALTER TABLE busy_blocks ADD FOREIGN KEY (organization_id, provider_id) REFERENCES users (organization_id, id);
+
+ALTER TABLE waitlist_entries ADD COLUMN organization_id uuid NOT NULL DEFAULT '00000000-0000-4000-8000-000000000001';
+ALTER TABLE waitlist_entries ALTER COLUMN organization_id DROP DEFAULT;
+ALTER TABLE waitlist_entries ADD FOREIGN KEY (organization_id, client_id) REFERENCES users (organization_id, id);
+-- a NULL provider_id, any provider, skips the check
+ALTER TABLE waitlist_entries ADD FOREIGN KEY (organization_id, provider_id) REFERENCES users (organization_id, id);
+
+-- the backstop under the DAO's own scoping. Transactions of a request set app.tenant_id and only see and write that
+-- organization's rows, everything else, the workers and reads outside a transaction, leaves it unset and sees them all.
+-- FORCE holds the table owner to it too, superusers and BYPASSRLS roles skip it regardless
+-- +goose StatementBegin
+CREATE FUNCTION current_tenant() RETURNS uuid AS $$
+  SELECT NULLIF(current_setting('app.tenant_id', true), '')::uuid
+$$ LANGUAGE sql STABLE;
+-- +goose StatementEnd
+
+ALTER TABLE users ENABLE ROW LEVEL SECURITY;
+ALTER TABLE users FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON users USING (current_tenant() IS NULL OR organization_id = current_tenant());
+ALTER TABLE availabilities ENABLE ROW LEVEL SECURITY;
+ALTER TABLE availabilities FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON availabilities USING (current_tenant() IS NULL OR organization_id = current_tenant());
+ALTER TABLE reservations ENABLE ROW LEVEL SECURITY;
+ALTER TABLE reservations FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON reservations USING (current_tenant() IS NULL OR organization_id = current_tenant());
+ALTER TABLE busy_sources ENABLE ROW LEVEL SECURITY;
+ALTER TABLE busy_sources FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON busy_sources USING (current_tenant() IS NULL OR organization_id = current_tenant());
+ALTER TABLE busy_blocks ENABLE ROW LEVEL SECURITY;
+ALTER TABLE busy_blocks FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON busy_blocks USING (current_tenant() IS NULL OR organization_id = current_tenant());
+ALTER TABLE waitlist_entries ENABLE ROW LEVEL SECURITY;
+ALTER TABLE waitlist_entries FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON waitlist_entries USING (current_tenant() IS NULL OR organization_id = current_tenant());
+
+-- the stored key is prefixed with the tenant so two organizations can use the same Idempotency-Key
+ALTER TABLE idempotency_keys ALTER COLUMN key TYPE VARCHAR(300);
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+
+DROP POLICY tenant_isolation ON waitlist_entries;
+ALTER TABLE waitlist_entries NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE waitlist_entries DISABLE ROW LEVEL SECURITY;
+DROP POLICY tenant_isolation ON busy_blocks;
+ALTER TABLE busy_blocks NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE busy_blocks DISABLE ROW LEVEL SECURITY;
+DROP POLICY tenant_isolation ON busy_sources;
+ALTER TABLE busy_sources NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE busy_sources DISABLE ROW LEVEL SECURITY;
+DROP POLICY tenant_isolation ON reservations;
+ALTER TABLE reservations NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE reservations DISABLE ROW LEVEL SECURITY;
+DROP POLICY tenant_isolation ON availabilities;
+ALTER TABLE availabilities NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE availabilities DISABLE ROW LEVEL SECURITY;
+DROP POLICY tenant_isolation ON users;
+ALTER TABLE users NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE users DISABLE ROW LEVEL SECURITY;
+DROP FUNCTION current_tenant();
+
+-- fails once keys longer than 255 characters are stored, those have to be deleted first
+ALTER TABLE idempotency_keys ALTER COLUMN key TYPE VARCHAR(255);
+
+ALTER TABLE waitlist_entries DROP COLUMN organization_id;
+ALTER TABLE busy_blocks DROP COLUMN organization_id;
+ALTER TABLE busy_sources DROP COLUMN organization_id;
+ALTER TABLE reservations DROP COLUMN organization_id;
+ALTER TABLE availabilities DROP COLUMN organization_id;
+ALTER TABLE users DROP CONSTRAINT users_organization_id;
+ALTER TABLE users DROP COLUMN organization_id;
+DROP TABLE organizations;
@@ -0,0 +1,33 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+
+-- where the client is located, as a two letter state code. A provider can only see clients in states they're licensed
+-- in, a client without one can't be booked
+ALTER TABLE users ADD COLUMN state CHAR(2);
+UPDATE users SET state = 'CA' WHERE username IN ('client1', 'client2');
+
+-- the states a provider is licensed in, one license per state, replaced on renewal
+CREATE TABLE provider_licenses (
+  id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+  organization_id uuid NOT NULL,
+  provider_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  state CHAR(2) NOT NULL,
+  license_number VARCHAR(50) NOT NULL,
+  -- the last day the license is valid
+  expires_on DATE NOT NULL,
+  created_at TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT now(),
+  UNIQUE (provider_id, state),
+  FOREIGN KEY (organization_id, provider_id) REFERENCES users (organization_id, id)
+);
+INSERT INTO provider_licenses (organization_id, provider_id, state, license_number, expires_on, created_at)
+SELECT organization_id, id, 'CA', 'EXAMPLE-' || username, '2030-12-31', now() FROM users WHERE username IN ('provider1', 'provider2');
+
+ALTER TABLE provider_licenses ENABLE ROW LEVEL SECURITY;
+ALTER TABLE provider_licenses FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON provider_licenses USING (current_tenant() IS NULL OR organization_id = current_tenant());
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+
+DROP TABLE provider_licenses;
+ALTER TABLE users DROP COLUMN state;
@@ -0,0 +1,47 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+
+-- where in-person visits happen, the time zone is the one the visit's times are given in
+CREATE TABLE locations (
+  id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+  organization_id uuid NOT NULL REFERENCES organizations(id),
+  name VARCHAR(100) NOT NULL,
+  address VARCHAR(500) NOT NULL,
+  -- IANA, ex: America/Los_Angeles
+  time_zone VARCHAR(64) NOT NULL,
+  created_at TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT now(),
+  -- what availabilities and reservations point at, so a location can't be used by another organization
+  UNIQUE (organization_id, id)
+);
+ALTER TABLE locations ENABLE ROW LEVEL SECURITY;
+ALTER TABLE locations FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON locations USING (current_tenant() IS NULL OR organization_id = current_tenant());
+
+-- how a visit can happen during the availability: video, phone or in_person, the last only at the location. What's
+-- already here was telehealth
+ALTER TABLE availabilities ADD COLUMN modalities TEXT[] NOT NULL DEFAULT '{video,phone}';
+ALTER TABLE availabilities ADD COLUMN location_id uuid;
+ALTER TABLE availabilities ADD FOREIGN KEY (organization_id, location_id) REFERENCES locations (organization_id, id);
+ALTER TABLE availabilities ADD CONSTRAINT availabilities_in_person_location CHECK (NOT 'in_person' = ANY (modalities) OR location_id IS NOT NULL);
+
+-- the modality the client picked, the location of an in_person visit and the room of a video one, opened on confirmation
+ALTER TABLE reservations ADD COLUMN modality VARCHAR(20) NOT NULL DEFAULT 'video';
+ALTER TABLE reservations ALTER COLUMN modality DROP DEFAULT;
+ALTER TABLE reservations ADD COLUMN location_id uuid;
+ALTER TABLE reservations ADD FOREIGN KEY (organization_id, location_id) REFERENCES locations (organization_id, id);
+ALTER TABLE reservations ADD COLUMN video_url TEXT;
+
+-- NULL waits for any modality
+ALTER TABLE waitlist_entries ADD COLUMN modality VARCHAR(20);
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+
+ALTER TABLE waitlist_entries DROP COLUMN modality;
+ALTER TABLE reservations DROP COLUMN video_url;
+ALTER TABLE reservations DROP COLUMN location_id;
+ALTER TABLE reservations DROP COLUMN modality;
+ALTER TABLE availabilities DROP CONSTRAINT availabilities_in_person_location;
+ALTER TABLE availabilities DROP COLUMN location_id;
+ALTER TABLE availabilities DROP COLUMN modalities;
+DROP TABLE locations;
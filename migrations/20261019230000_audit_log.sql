@@ -0,0 +1,49 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+
+-- every change the DAO makes to a row, written in the transaction that makes it. Each organization's entries form a
+-- chain: an entry's hash covers its fields and the hash of the entry before it, so editing or deleting one breaks the
+-- chain from there on. Rows that aren't any organization's, ex: webhook subscriptions, chain under a NULL organization
+CREATE TABLE audit_log (
+  id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+  -- the entry's place in its chain
+  seq BIGSERIAL NOT NULL UNIQUE,
+  organization_id uuid REFERENCES organizations(id),
+  occurred_at TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+  -- the API key the request was made with, ex: organization:<id>, or system for the workers and the CLI
+  actor VARCHAR(100) NOT NULL,
+  action VARCHAR(50) NOT NULL,
+  entity_type VARCHAR(50) NOT NULL,
+  entity_id VARCHAR(100) NOT NULL,
+  request_id VARCHAR(100),
+  -- json and not jsonb, the hash is over the text exactly as it was written
+  before json,
+  after json,
+  prev_hash bytea,
+  hash bytea NOT NULL
+);
+CREATE INDEX audit_log_chain ON audit_log (organization_id, seq);
+CREATE INDEX audit_log_entity ON audit_log (entity_type, entity_id, occurred_at);
+CREATE INDEX audit_log_actor ON audit_log (actor, occurred_at);
+CREATE INDEX audit_log_occurred_at ON audit_log (occurred_at, id);
+
+ALTER TABLE audit_log ENABLE ROW LEVEL SECURITY;
+ALTER TABLE audit_log FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON audit_log USING (current_tenant() IS NULL OR organization_id = current_tenant());
+
+-- append only, the hashes show tampering after the fact, this stops it through the app's own role
+-- +goose StatementBegin
+CREATE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+  RAISE EXCEPTION 'audit_log is append only';
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE OR TRUNCATE ON audit_log
+  FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only();
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+
+DROP TABLE audit_log;
+DROP FUNCTION audit_log_append_only();
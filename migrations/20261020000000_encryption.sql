@@ -0,0 +1,18 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+
+-- patient details are encrypted by the service, see the encryption package. An envelope is a lot longer than what it
+-- holds, so the columns are text. What's already here stays plaintext until the reencrypt command runs
+ALTER TABLE users ALTER COLUMN email TYPE TEXT;
+ALTER TABLE users ALTER COLUMN phone TYPE TEXT;
+
+-- why the client is booking, in their words
+ALTER TABLE reservations ADD COLUMN reason TEXT;
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+
+ALTER TABLE reservations DROP COLUMN reason;
+-- fails while there are envelopes that don't fit, nothing decrypts them back in place
+ALTER TABLE users ALTER COLUMN phone TYPE VARCHAR(20);
+ALTER TABLE users ALTER COLUMN email TYPE VARCHAR(254);
@@ -0,0 +1,21 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+
+-- the token buckets of the rate limits when ratelimit.backend is postgres, shared by every instance. UNLOGGED, losing
+-- them in a crash only hands everyone a full bucket again and they're written on every request
+CREATE UNLOGGED TABLE rate_limit_buckets (
+  -- the limit and the caller, ex: booking_ip:203.0.113.7
+  key VARCHAR(300) PRIMARY KEY,
+  tokens DOUBLE PRECISION NOT NULL,
+  -- whether the last request took a token
+  allowed boolean NOT NULL,
+  updated_at TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+  -- when the bucket is full again, from then on the row is the same as no row and can be pruned
+  full_at TIMESTAMP WITHOUT TIME ZONE NOT NULL
+);
+CREATE INDEX rate_limit_buckets_full_at ON rate_limit_buckets (full_at);
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+
+DROP TABLE rate_limit_buckets;
@@ -0,0 +1,17 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+
+-- a subscription gets the events of one organization. The ones from before are the default organization's, like the
+-- rest of the rows from before organizations
+ALTER TABLE webhook_subscriptions ADD COLUMN organization_id uuid NOT NULL DEFAULT '00000000-0000-4000-8000-000000000001' REFERENCES organizations(id);
+ALTER TABLE webhook_subscriptions ALTER COLUMN organization_id DROP DEFAULT;
+
+-- the organization of the reservation or availability the event is about, what the fan out picks subscriptions by
+ALTER TABLE outbox ADD COLUMN organization_id uuid REFERENCES organizations(id);
+UPDATE outbox SET organization_id = (payload->>'organizationId')::uuid WHERE payload->>'organizationId' IS NOT NULL;
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+
+ALTER TABLE outbox DROP COLUMN organization_id;
+ALTER TABLE webhook_subscriptions DROP COLUMN organization_id;
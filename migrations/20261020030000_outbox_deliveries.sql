@@ -0,0 +1,10 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+
+-- the sinks that took the event, a retry after one of them failed skips them. NULL is none of them yet
+ALTER TABLE outbox ADD COLUMN delivered_to text[];
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+
+ALTER TABLE outbox DROP COLUMN delivered_to;
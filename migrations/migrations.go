@@ -0,0 +1,26 @@
+package migrations
+
+import (
+	"embed"
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+// FS holds every goose migration so the running binary knows which schema version it expects
+//
+//go:embed *.sql
+var FS embed.FS
+
+// Latest returns the version of the newest migration, goose versions are the numeric prefix of the file name
+func Latest() (latest int64) {
+	files, _ := fs.Glob(FS, "*.sql")
+	for _, name := range files {
+		prefix, _, _ := strings.Cut(name, "_")
+		version, err := strconv.ParseInt(prefix, 10, 64)
+		if err == nil && version > latest {
+			latest = version
+		}
+	}
+	return
+}
@@ -1,10 +1,29 @@
 package model
 
-import "time"
+import (
+	"encoding/json"
+	"slices"
+	"time"
+)
 
 type User struct {
-	ID       string
-	Username string
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	// the organization the user belongs to, everything about the user is kept in it
+	OrganizationID string `json:"-"`
+	// SHA-256 of the token that unlocks the user's calendar feed, nil until one is issued
+	CalendarTokenHash []byte `json:"-"`
+	// where notifications reach the user, empty when there's none on file. Encrypted at rest
+	Email string `json:"-" encrypted:"true"`
+	Phone string `json:"-" encrypted:"true"`
+	// where the user is located, a two letter state code, empty when it isn't on file. Only clients need one
+	State string `json:"-"`
+}
+
+// UpdateUser sets where the user is located, an empty state clears it
+type UpdateUser struct {
+	ID    string
+	State string
 }
 
 type TimeRange struct {
@@ -14,28 +33,217 @@ type TimeRange struct {
 
 type Reservation struct {
 	ID             string
+	OrganizationID string `json:"-"`
 	ClientID       string
 	ProviderID     string
 	Confirmed      bool
 	ConfirmationID string
 	ExpiresAt      time.Time
+	// zero unless the reservation was cancelled, stored as NULL
+	CancelledAt time.Time
+	// when the reservation.expired event went into the outbox, zero until the hold runs out and the sweep notices
+	ExpiryPublishedAt time.Time
 	TimeRange
+	Modality string
+	// where an in_person visit happens
+	LocationID string
+	// the room of a video visit, opened when the reservation is confirmed
+	VideoURL string
+	// why the client is booking, in their words. Encrypted at rest, and kept out of events
+	Reason string `encrypted:"true"`
+}
+
+// a reservation is held until it is confirmed or its hold expires, either can be cancelled
+const (
+	ReservationStatusHeld      = "held"
+	ReservationStatusConfirmed = "confirmed"
+	ReservationStatusExpired   = "expired"
+	ReservationStatusCancelled = "cancelled"
+)
+
+func (r Reservation) Status(now time.Time) string {
+	if !r.CancelledAt.IsZero() {
+		return ReservationStatusCancelled
+	}
+	if r.Confirmed {
+		return ReservationStatusConfirmed
+	}
+	if now.Before(r.ExpiresAt) {
+		return ReservationStatusHeld
+	}
+	return ReservationStatusExpired
+}
+
+// Active reports whether the reservation is holding its slot, confirmed or held and not yet expired
+func (r Reservation) Active(now time.Time) bool {
+	status := r.Status(now)
+	return status == ReservationStatusConfirmed || status == ReservationStatusHeld
+}
+
+// list queries are ordered by start time, then ID so rows starting at the same time still have a stable order
+const (
+	SortStartAsc  = "start"
+	SortStartDesc = "-start"
+)
+
+// PageKey is a row's position in the (start time, ID) order, a page starts right after it
+type PageKey struct {
+	Start time.Time
+	ID    string
+}
+
+// Page bounds a list query. The zero value is every row in ascending order
+type Page struct {
+	// at most this many rows, 0 for no limit
+	Limit int
+	// SortStartAsc or SortStartDesc, empty is ascending
+	Sort string
+	// only rows after this key in the sort order, nil starts from the first row
+	After *PageKey
+}
+
+// Descending reports whether the page is sorted latest first
+func (p Page) Descending() bool {
+	return p.Sort == SortStartDesc
 }
 
 type CreateAvailabilities struct {
 	ProviderID string
 	TimeRange
+	// 0 for one seat
+	Capacity int
+	// empty for video and phone
+	Modalities []string
+	// required for in_person
+	LocationID string
+}
+
+// ImportAvailabilities creates many availabilities for a provider at once, all of them or none
+type ImportAvailabilities struct {
+	ProviderID string
+	Rows       []ImportRow
+	// validate and report without inserting anything
+	DryRun bool
+}
+
+type ImportRow struct {
+	// 1 based position in the source, so the report can point back at it
+	Row int
+	TimeRange
+	// 0 when the source leaves it out, for one seat
+	Capacity int
+	// empty when the source leaves them out, for the defaults
+	Modalities []string
+	LocationID string
+	// set when the row couldn't be read, it's reported as invalid
+	ParseError string
+}
+
+// what happened to each row of an import
+const (
+	ImportRowCreated  = "created"
+	ImportRowValid    = "valid"
+	ImportRowInvalid  = "invalid"
+	ImportRowConflict = "conflict"
+)
+
+type ImportResult struct {
+	Row    int
+	Status string
+	TimeRange
+	Capacity   int
+	Modalities []string
+	LocationID string
+	// only set for created rows
+	AvailabilityID string
+	// why the row was rejected
+	Error string
+}
+
+type ImportReport struct {
+	DryRun bool
+	// whether the rows were inserted, false for a dry run or when any row was rejected
+	Committed bool
+	Results   []ImportResult
+}
+
+// Rejected counts the rows that kept the import from being committed
+func (r ImportReport) Rejected() (rejected int) {
+	for _, result := range r.Results {
+		if result.Status == ImportRowInvalid || result.Status == ImportRowConflict {
+			rejected++
+		}
+	}
+	return
+}
+
+// UpdateAvailability moves either end of an availability or changes its capacity, nil fields are left as they are
+type UpdateAvailability struct {
+	ID         string
+	ProviderID string
+	Start      *time.Time
+	End        *time.Time
+	Capacity   *int
+	// cancel the confirmed reservations the change would leave without availability, instead of refusing it
+	Force bool
+}
+
+type DeleteAvailability struct {
+	ID         string
+	ProviderID string
+	Force      bool
+}
+
+// AvailabilityChange is the outcome of an update or delete, the reservations cancelled because they were no longer
+// covered by availability come with it
+type AvailabilityChange struct {
+	// the availability after the change, zero for a delete
+	Availability Availability
+	Cancelled    []Reservation
 }
 
 type GetAvailabilities struct {
+	ID         string
 	ProviderID string
 	TimeRange
+	Page Page
+}
+
+// GetSlots lists the bookable slots of a provider inside the time range
+type GetSlots struct {
+	ProviderID string
+	TimeRange
+	// sets the length of the slots, empty for slots one slot interval long
+	AppointmentType string
+	// only the slots that can be booked as the modality, at the location when it's set
+	Modality   string
+	LocationID string
+	// leaves out the slots the client can't book with the provider, see the licenses. Empty to list them all
+	ClientID string
+}
+
+// Slot is a stretch of a provider's time that can be booked as one reservation
+type Slot struct {
+	TimeRange
+	// the fewest seats the availability has anywhere in the slot
+	Capacity int
+	// seats not taken by active reservations, 0 when the slot is full
+	Remaining int
+	// how the visit can happen, and where when in_person is one of them
+	Modalities []string
+	LocationID string
 }
 
 type CreateReservation struct {
 	ClientID   string `json:"clientId"`
 	ProviderID string `json:"providerId"`
 	TimeRange
+	// one the availability offers, empty for the first one it lists
+	Modality string `json:"modality"`
+	// when set, the reservation has to be as long as the appointment type and in a modality it allows
+	AppointmentType string `json:"appointmentType"`
+	// why the client is booking, optional
+	Reason string `json:"reason"`
 }
 
 type GetReservations struct {
@@ -44,10 +252,401 @@ type GetReservations struct {
 	ClientID       string
 	ConfirmationID string
 	*TimeRange
+	Page Page
 }
 
 type Availability struct {
+	ID             string `json:"id"`
+	OrganizationID string `json:"-"`
+	ProviderID     string `json:"providerId"`
+	TimeRange
+	// how many clients can book the same time, 1 for one on one appointments
+	Capacity int `json:"-"`
+	// how the visits can happen, see the modalities
+	Modalities []string `json:"-" pg:",array"`
+	// where in_person visits happen, empty when they can't
+	LocationID string `json:"-"`
+}
+
+// Offers reports whether a visit of the modality can happen during the availability
+func (a Availability) Offers(modality string) bool {
+	return slices.Contains(a.Modalities, modality)
+}
+
+// how a visit happens, a client picks one of the availability's when booking
+const (
+	ModalityVideo    = "video"
+	ModalityPhone    = "phone"
+	ModalityInPerson = "in_person"
+)
+
+// Modalities are all of them, in the order they're listed
+var Modalities = []string{ModalityVideo, ModalityPhone, ModalityInPerson}
+
+// Location is a place in-person visits happen at
+type Location struct {
+	ID             string
+	OrganizationID string
+	Name           string
+	Address        string
+	// IANA, the visit's times are given in it, ex: America/Los_Angeles
+	TimeZone  string
+	CreatedAt time.Time
+}
+
+type CreateLocation struct {
+	Name     string
+	Address  string
+	TimeZone string
+}
+
+// where a busy source's calendar comes from
+const (
+	BusySourceUpload = "upload"
+	BusySourceURL    = "url"
+)
+
+// BusySource is a provider's calendar kept somewhere else, its busy time blocks bookings
+type BusySource struct {
+	ID             string
+	OrganizationID string
+	ProviderID     string
+	// BusySourceUpload or BusySourceURL
+	Kind string
+	// only set for a BusySourceURL
+	URL string
+	// how many busy blocks the last import stored
+	Blocks int `pg:",use_zero"`
+	// recurring events the last import couldn't expand and left out
+	Skipped    int `pg:",use_zero"`
+	ImportedAt time.Time
+	CreatedAt  time.Time
+}
+
+// BusyBlock is one stretch of a provider's outside busy time
+type BusyBlock struct {
+	ID             string
+	OrganizationID string
+	SourceID       string
+	ProviderID     string
+	TimeRange
+}
+
+// CreateBusySource registers a calendar for the provider and imports it, from the URL or the uploaded calendar,
+// exactly one of the two
+// ProviderLicense lets the provider see clients located in its state until it expires
+type ProviderLicense struct {
+	ID             string
+	OrganizationID string
+	ProviderID     string
+	// two letter state code, ex: CA
+	State         string
+	LicenseNumber string
+	// the last day the license is valid, stored as a DATE
+	ExpiresOn time.Time
+	CreatedAt time.Time
+}
+
+// ValidUntil reports whether the license is still valid at t. It lapses at the end of its expiry day, UTC
+func (l ProviderLicense) ValidUntil(t time.Time) bool {
+	year, month, day := l.ExpiresOn.Date()
+	return t.Before(time.Date(year, month, day+1, 0, 0, 0, 0, time.UTC))
+}
+
+type CreateBusySource struct {
+	ProviderID string
+	URL        string
+	Calendar   []byte
+}
+
+// ImportBusySource replaces a source's busy blocks. A URL source is fetched again, an upload source needs the new
+// calendar
+type ImportBusySource struct {
+	ID         string
+	ProviderID string
+	Calendar   []byte
+}
+
+type GetBusySources struct {
 	ID         string
 	ProviderID string
+}
+
+type GetBusyBlocks struct {
+	ProviderID string
 	TimeRange
 }
+
+// Event is a domain event in the outbox, written with the change it describes and published after
+type Event struct {
+	tableName struct{} `pg:"outbox"`
+
+	ID   string
+	Type string
+	// the reservation or availability the event is about
+	AggregateID string
+	// the aggregate's organization, the webhook subscriptions of no other get the event
+	OrganizationID string
+	// the API representation of the aggregate after the change
+	Payload   json.RawMessage
+	CreatedAt time.Time
+	// publish attempts that failed so far
+	Attempts      int `pg:",use_zero"`
+	NextAttemptAt time.Time
+	// zero until a sink accepted the event
+	PublishedAt time.Time
+	LastError   string
+	// the sinks of a multi sink that took the event so far, by name, a retry only goes to the others
+	DeliveredTo []string `pg:",array"`
+}
+
+// webhook delivery statuses
+const (
+	WebhookPending   = "pending"
+	WebhookDelivered = "delivered"
+	// given up on after too many failed attempts, a dead letter until it's redelivered
+	WebhookDead = "dead"
+)
+
+// Organization is a tenant, a brand or clinic with its own users, availabilities and reservations
+type Organization struct {
+	ID   string
+	Name string
+	// SHA-256 of the API key that scopes requests to the organization, nil until one is issued
+	APIKeyHash []byte
+	CreatedAt  time.Time
+}
+
+type CreateOrganization struct {
+	Name string
+}
+
+type WebhookSubscription struct {
+	ID string
+	// the organization whose events the subscription gets
+	OrganizationID string
+	URL            string
+	EventTypes     []string `pg:",array"`
+	// the HMAC-SHA256 key the payloads are signed with
+	Secret    string
+	Active    bool `pg:",use_zero"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// WebhookDelivery is an event on its way to one subscription
+type WebhookDelivery struct {
+	ID             string
+	SubscriptionID string
+	EventID        string
+	EventType      string
+	// the event envelope exactly as it's POSTed
+	Payload       json.RawMessage
+	Status        string
+	Attempts      int `pg:",use_zero"`
+	NextAttemptAt time.Time
+	// zero when the last attempt got no response
+	LastStatusCode int
+	LastError      string
+	CreatedAt      time.Time
+	DeliveredAt    time.Time
+}
+
+type WebhookAttempt struct {
+	ID          string
+	DeliveryID  string
+	AttemptedAt time.Time
+	// zero when the receiver couldn't be reached
+	StatusCode int
+	Error      string
+	DurationMs int `pg:",use_zero"`
+}
+
+type CreateWebhookSubscription struct {
+	OrganizationID string
+	URL            string
+	EventTypes     []string
+	// generated when empty
+	Secret string
+}
+
+// UpdateWebhookSubscription changes the fields that are set, nil ones stay as they are
+type UpdateWebhookSubscription struct {
+	ID         string
+	URL        *string
+	EventTypes []string
+	Active     *bool
+}
+
+// GetWebhookDeliveries selects from the delivery log, newest first. The page key's start is the delivery's creation time
+type GetWebhookDeliveries struct {
+	ID             string
+	SubscriptionID string
+	Status         string
+	Page           Page
+}
+
+// reminder statuses
+const (
+	ReminderPending = "pending"
+	ReminderSent    = "sent"
+	// given up on, the user can't be reached on the channel or every attempt failed
+	ReminderFailed = "failed"
+	// the reservation was cancelled or moved before the reminder went out
+	ReminderCancelled = "cancelled"
+)
+
+// Reminder is a notification to the client that their appointment is coming up
+type Reminder struct {
+	ID            string
+	ReservationID string
+	// how long before the start of the reservation it goes out
+	LeadSeconds   int
+	DueAt         time.Time
+	Status        string
+	Attempts      int `pg:",use_zero"`
+	NextAttemptAt time.Time
+	LastError     string
+	// zero until the reminder is sent
+	SentAt    time.Time
+	CreatedAt time.Time
+}
+
+func (r Reminder) Lead() time.Duration {
+	return time.Duration(r.LeadSeconds) * time.Second
+}
+
+// SpentConfirmationToken records a confirmation link that was used, so it can't be used again
+type SpentConfirmationToken struct {
+	Nonce         string `pg:",pk"`
+	ReservationID string
+	ExpiresAt     time.Time
+	SpentAt       time.Time
+}
+
+// waitlist entry statuses
+const (
+	WaitlistWaiting = "waiting"
+	// a slot is held for the client, the entry's reservation
+	WaitlistOffered = "offered"
+	// the client confirmed the slot they were offered
+	WaitlistBooked = "booked"
+	// the offer ran out without being confirmed, or the window passed without one
+	WaitlistExpired   = "expired"
+	WaitlistCancelled = "cancelled"
+)
+
+// WaitlistEntry is a client waiting for a slot with a provider, or any provider, somewhere inside a window
+type WaitlistEntry struct {
+	ID             string
+	OrganizationID string
+	ClientID       string
+	// empty for any provider
+	ProviderID      string
+	AppointmentType string
+	// empty for any modality the appointment type allows
+	Modality string
+	// the client takes any slot inside it
+	TimeRange
+	// higher goes first when offers are made in priority order
+	Priority int `pg:",use_zero"`
+	Status   string
+	// the hold the entry was offered, empty until it's offered one
+	ReservationID string
+	OfferedAt     time.Time
+	CreatedAt     time.Time
+}
+
+// StatusAt is the entry's status as of now, a waiting entry whose window passed is expired
+func (e WaitlistEntry) StatusAt(now time.Time) string {
+	if e.Status == WaitlistWaiting && !now.Before(e.End) {
+		return WaitlistExpired
+	}
+	return e.Status
+}
+
+type CreateWaitlistEntry struct {
+	ClientID string
+	// empty for any provider
+	ProviderID      string
+	AppointmentType string
+	// empty for any
+	Modality string
+	Priority int
+	TimeRange
+}
+
+// GetWaitlistCandidates selects the waiting entries a slot with the provider inside the time range could go to,
+// in the order they're offered slots
+type GetWaitlistCandidates struct {
+	ProviderID string
+	TimeRange
+	// highest priority first, otherwise first come first served
+	ByPriority bool
+	Limit      int
+}
+
+// ReleaseExpiredHolds selects the client's holds that ran out on exactly the time range. They keep their place in the
+// client's unique constraint until they're released
+type ReleaseExpiredHolds struct {
+	ClientID string
+	TimeRange
+	Now time.Time
+}
+
+type IdempotencyRecord struct {
+	tableName struct{} `pg:"idempotency_keys"`
+
+	Key         string `pg:",pk"`
+	Fingerprint string
+	Completed   bool `pg:",use_zero"`
+	Status      int
+	ContentType string
+	Body        []byte
+	CreatedAt   time.Time
+}
+
+// AuditEntry is one change to one row. Entries chain by organization, see the audit package
+type AuditEntry struct {
+	tableName struct{} `pg:"audit_log"`
+
+	ID string
+	// the entry's place in its chain
+	Seq int64
+	// empty for rows that aren't any organization's, ex: webhook subscriptions
+	OrganizationID string
+	OccurredAt     time.Time
+	// who made the change, see the audit package
+	Actor string
+	// what was done, ex: create, confirm, cancel
+	Action string
+	// the table and primary key of the row
+	EntityType string
+	EntityID   string
+	// empty for changes no request made
+	RequestID string
+	// the row before and after the change by column, secrets left out. Nil before an insert and after a delete
+	Before json.RawMessage
+	After  json.RawMessage
+	// nil for the first entry of a chain
+	PrevHash []byte
+	Hash     []byte
+}
+
+type GetAuditEntries struct {
+	OrganizationID string
+	EntityType     string
+	EntityID       string
+	Actor          string
+	Page           Page
+}
+
+// AuditVerification is the outcome of walking an organization's chain from its first entry
+type AuditVerification struct {
+	OrganizationID string
+	// how many entries were checked, up to and including the first broken one
+	Checked int
+	Valid   bool
+	// the first entry that doesn't chain to the one before it, or whose hash doesn't match its fields. Empty if valid
+	BrokenAt string
+}
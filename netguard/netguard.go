@@ -0,0 +1,34 @@
+package netguard
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"time"
+)
+
+// ErrPrivateAddress is returned for connections to loopback, private or link local addresses. Outgoing requests
+// to URLs someone else gave us run from inside our network and must not become a way into it
+var ErrPrivateAddress = errors.New("the URL points at a private address")
+
+// Dialer returns a dialer that refuses private addresses unless allowPrivate is set, ex: for a stand-in server in
+// tests. The transport using it must not have a proxy, the proxy would be dialed instead of the URL's host
+func Dialer(timeout time.Duration, allowPrivate bool) *net.Dialer {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate {
+		// checked on the resolved address of every connection, redirects included, so DNS can't get around it
+		dialer.Control = func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+				ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+				return ErrPrivateAddress
+			}
+			return nil
+		}
+	}
+	return dialer
+}
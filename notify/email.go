@@ -0,0 +1,152 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+type EmailOptions struct {
+	// the SMTP server, host:port
+	Addr string
+	// PLAIN auth credentials, no auth when Username is empty. The server has to offer STARTTLS for them to be sent,
+	// unless it's on localhost
+	Username string
+	Password string
+	// the From address, ex: Henry Meds <no-reply@henrymeds.example>
+	From string
+	// how long a whole send may take, from dialing to QUIT
+	Timeout time.Duration
+}
+
+// NewEmailNotifier sends the messages as plain text emails over SMTP, upgrading to TLS when the server offers STARTTLS.
+// Any SMTP server works, ex: a local fake like MailHog or smtp4dev while testing
+func NewEmailNotifier(options EmailOptions) (*emailNotifier, error) {
+	from, err := mail.ParseAddress(options.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid from address: %w", err)
+	}
+	host, _, err := net.SplitHostPort(options.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP address: %w", err)
+	}
+	return &emailNotifier{
+		options: options,
+		from:    from,
+		host:    host,
+	}, nil
+}
+
+type emailNotifier struct {
+	options EmailOptions
+	from    *mail.Address
+	host    string
+}
+
+func (n *emailNotifier) Notify(ctx context.Context, message Message) (err error) {
+	if message.To.Email == "" {
+		return ErrNoAddress
+	}
+	to, err := mail.ParseAddress(message.To.Email)
+	if err != nil {
+		return fmt.Errorf("%w: invalid email address on file: %w", ErrNoAddress, err)
+	}
+	body, err := n.compose(to, message)
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, n.options.Timeout)
+	defer cancel()
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", n.options.Addr)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	// net/smtp doesn't take a context, the deadline is what bounds a server that stops answering
+	deadline, _ := ctx.Deadline()
+	if err = conn.SetDeadline(deadline); err != nil {
+		return
+	}
+
+	client, err := smtp.NewClient(conn, n.host)
+	if err != nil {
+		return
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err = client.StartTLS(&tls.Config{ServerName: n.host}); err != nil {
+			return
+		}
+	}
+	if n.options.Username != "" {
+		if err = client.Auth(smtp.PlainAuth("", n.options.Username, n.options.Password, n.host)); err != nil {
+			return
+		}
+	}
+	if err = client.Mail(n.from.Address); err != nil {
+		return
+	}
+	if err = client.Rcpt(to.Address); err != nil {
+		return
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return
+	}
+	if _, err = writer.Write(body); err != nil {
+		return
+	}
+	if err = writer.Close(); err != nil {
+		return
+	}
+	return client.Quit()
+}
+
+func (n *emailNotifier) Channel() string {
+	return "email"
+}
+
+// compose builds the RFC 5322 message, the headers then the UTF-8 body with CRLF line endings
+func (n *emailNotifier) compose(to *mail.Address, message Message) ([]byte, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	header := func(name, value string) {
+		// nothing from the message may start a header of its own
+		value = strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+		fmt.Fprintf(&b, "%s: %s\r\n", name, value)
+	}
+	header("From", n.from.String())
+	header("To", to.String())
+	header("Subject", mime.QEncoding.Encode("utf-8", message.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", "<"+hex.EncodeToString(id)+"@"+domain(n.from.Address)+">")
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "8bit")
+	b.WriteString("\r\n")
+	body := strings.ReplaceAll(message.Body, "\r\n", "\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return b.Bytes(), nil
+}
+
+func domain(address string) string {
+	if at := strings.LastIndexByte(address, '@'); at >= 0 {
+		return address[at+1:]
+	}
+	return "localhost"
+}
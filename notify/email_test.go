@@ -0,0 +1,283 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"henrymeds-takehome/model"
+	"io"
+	"mime"
+	"net"
+	"net/mail"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// smtpServer is a fake SMTP server on localhost, it takes every message and keeps the last one. It doesn't offer
+// STARTTLS, so the notifier talks to it in the clear
+type smtpServer struct {
+	listener net.Listener
+
+	mu sync.Mutex
+	// replies overrides the reply to a command, ex: "RCPT" to refuse the recipient
+	replies map[string]string
+	// stall stops answering after the greeting, like a server that hangs
+	stall bool
+	auth  string
+	from  string
+	rcpt  string
+	data  string
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpServer{listener: listener, replies: map[string]string{}}
+	t.Cleanup(func() {
+		listener.Close()
+	})
+	go s.serve()
+	return s
+}
+
+func (s *smtpServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *smtpServer) handle(conn net.Conn) {
+	defer conn.Close()
+	var (
+		r     = bufio.NewReader(conn)
+		reply = func(line string) {
+			conn.Write([]byte(line + "\r\n"))
+		}
+	)
+	reply("220 localhost fake SMTP")
+	s.mu.Lock()
+	stall := s.stall
+	s.mu.Unlock()
+	if stall {
+		// until the notifier gives up and hangs up
+		io.Copy(io.Discard, r)
+		return
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		command, argument, _ := strings.Cut(line, " ")
+		command = strings.ToUpper(command)
+		s.mu.Lock()
+		override, ok := s.replies[command]
+		s.mu.Unlock()
+		if ok {
+			reply(override)
+			continue
+		}
+		switch command {
+		case "EHLO":
+			reply("250-localhost")
+			reply("250 AUTH PLAIN")
+		case "AUTH":
+			s.mu.Lock()
+			s.auth = argument
+			s.mu.Unlock()
+			reply("235 accepted")
+		case "MAIL":
+			s.mu.Lock()
+			s.from = argument
+			s.mu.Unlock()
+			reply("250 ok")
+		case "RCPT":
+			s.mu.Lock()
+			s.rcpt = argument
+			s.mu.Unlock()
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+			s.mu.Lock()
+			s.data = data.String()
+			s.mu.Unlock()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+// refuse answers command with reply from now on
+func (s *smtpServer) refuse(command, reply string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.replies[command] = reply
+}
+
+func (s *smtpServer) hang() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stall = true
+}
+
+// envelope is what the last message was sent with, the AUTH, MAIL and RCPT arguments
+func (s *smtpServer) envelope() (auth, from, rcpt string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.auth, s.from, s.rcpt
+}
+
+// message is the last message the server took, split into its headers and body
+func (s *smtpServer) message(t *testing.T) (*mail.Message, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data == "" {
+		t.Fatal("the server didn't take a message")
+	}
+	headers, body, ok := strings.Cut(s.data, "\r\n\r\n")
+	if !ok {
+		t.Fatalf("the message has no body: %q", s.data)
+	}
+	message, err := mail.ReadMessage(strings.NewReader(headers + "\r\n\r\n"))
+	if err != nil {
+		t.Fatalf("failed to read the message: %v", err)
+	}
+	return message, body
+}
+
+func newTestEmailNotifier(t *testing.T, s *smtpServer, username string) *emailNotifier {
+	n, err := NewEmailNotifier(EmailOptions{
+		Addr:     s.listener.Addr().String(),
+		Username: username,
+		Password: "hunter2",
+		From:     "Henry Meds <no-reply@henrymeds.example>",
+		Timeout:  200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+var patient = model.User{Email: "Pat Ient <pat@example.com>"}
+
+func TestEmailNotify(t *testing.T) {
+	var (
+		ctx = context.Background()
+		s   = newSMTPServer(t)
+	)
+	err := newTestEmailNotifier(t, s, "").Notify(ctx, Message{
+		To:      patient,
+		Subject: "Confirm your réservation\r\nBcc: everyone@example.com",
+		Body:    "Hi Pat,\nconfirm here\r\n.\nthanks",
+	})
+	if err != nil {
+		t.Fatalf("failed to send: %v", err)
+	}
+	auth, from, rcpt := s.envelope()
+	if auth != "" {
+		t.Fatalf("authenticated as %q without a username", auth)
+	}
+	if from != "FROM:<no-reply@henrymeds.example>" || !strings.HasPrefix(rcpt, "TO:<pat@example.com>") {
+		t.Fatalf("the envelope is from %q to %q", from, rcpt)
+	}
+
+	message, body := s.message(t)
+	if to := message.Header.Get("To"); to != `"Pat Ient" <pat@example.com>` {
+		t.Fatalf("the message is to %q", to)
+	}
+	date, err := message.Header.Date()
+	if err != nil || time.Since(date) > time.Minute {
+		t.Fatalf("the message is dated %v, err %v", date, err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(message.Header.Get("Subject"))
+	// the line break is encoded into the word, it can't end the header
+	if err != nil || subject != "Confirm your réservation\r\nBcc: everyone@example.com" {
+		t.Fatalf("the subject is %q, err %v", subject, err)
+	}
+	if bcc := message.Header.Get("Bcc"); bcc != "" {
+		t.Fatalf("the subject started a Bcc header: %q", bcc)
+	}
+	if id := message.Header.Get("Message-Id"); !strings.HasSuffix(id, "@henrymeds.example>") {
+		t.Fatalf("the message ID is %q", id)
+	}
+	// every line ends in CRLF, and the lone dot went through the dot stuffing and back
+	if body != "Hi Pat,\r\nconfirm here\r\n.\r\nthanks\r\n" {
+		t.Fatalf("the body is %q", body)
+	}
+}
+
+func TestEmailNotifyAuthenticates(t *testing.T) {
+	s := newSMTPServer(t)
+	if err := newTestEmailNotifier(t, s, "henry").Notify(context.Background(), Message{To: patient, Body: "hi"}); err != nil {
+		t.Fatalf("failed to send: %v", err)
+	}
+	auth, _, _ := s.envelope()
+	mechanism, credentials, _ := strings.Cut(auth, " ")
+	decoded, err := base64.StdEncoding.DecodeString(credentials)
+	if mechanism != "PLAIN" || err != nil || string(decoded) != "\x00henry\x00hunter2" {
+		t.Fatalf("authenticated with %q", auth)
+	}
+}
+
+func TestEmailNotifyFails(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("no address", func(t *testing.T) {
+		n := newTestEmailNotifier(t, newSMTPServer(t), "")
+		if err := n.Notify(ctx, Message{Body: "hi"}); !errors.Is(err, ErrNoAddress) {
+			t.Fatalf("got %v, want ErrNoAddress", err)
+		}
+		if err := n.Notify(ctx, Message{To: model.User{Email: "not an address"}, Body: "hi"}); !errors.Is(err, ErrNoAddress) {
+			t.Fatalf("got %v, want ErrNoAddress", err)
+		}
+	})
+
+	t.Run("the recipient is refused", func(t *testing.T) {
+		s := newSMTPServer(t)
+		s.refuse("RCPT", "550 no such user")
+		err := newTestEmailNotifier(t, s, "").Notify(ctx, Message{To: patient, Body: "hi"})
+		if err == nil || !strings.Contains(err.Error(), "no such user") {
+			t.Fatalf("got %v, want the server's refusal", err)
+		}
+	})
+
+	t.Run("the server stops answering", func(t *testing.T) {
+		s := newSMTPServer(t)
+		s.hang()
+		start := time.Now()
+		err := newTestEmailNotifier(t, s, "").Notify(ctx, Message{To: patient, Body: "hi"})
+		var netErr net.Error
+		if !errors.As(err, &netErr) || !netErr.Timeout() {
+			t.Fatalf("got %v, want a timeout", err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("gave up after %v, the timeout is 200ms", elapsed)
+		}
+	})
+}
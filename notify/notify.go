@@ -0,0 +1,49 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"henrymeds-takehome/logging"
+	"henrymeds-takehome/model"
+	"log/slog"
+)
+
+// ErrNoAddress is returned when the user has nothing on file for the channel, ex: no phone number for SMS. Trying
+// again won't help
+var ErrNoAddress = errors.New("the user has no address for this channel")
+
+// Message is a plain text notification to a user
+type Message struct {
+	// the channels pick the address they need off the user
+	To      model.User
+	Subject string
+	Body    string
+}
+
+// Notifier sends messages to users over one channel
+type Notifier interface {
+	Notify(ctx context.Context, message Message) error
+	// Channel names the channel for logs and metrics, ex: email
+	Channel() string
+}
+
+// NewLogNotifier logs that a message went out instead of sending it, for local runs. The body isn't logged, a hold's
+// carries its confirmation link and the token in it is as good as the client's click
+func NewLogNotifier() *logNotifier {
+	return &logNotifier{}
+}
+
+type logNotifier struct{}
+
+func (n *logNotifier) Notify(ctx context.Context, message Message) error {
+	slog.InfoContext(ctx, "notification",
+		"user_id", logging.Patient(message.To.ID),
+		"subject", message.Subject,
+		"length", len(message.Body),
+	)
+	return nil
+}
+
+func (n *logNotifier) Channel() string {
+	return "log"
+}
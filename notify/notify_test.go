@@ -0,0 +1,34 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"henrymeds-takehome/model"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// captureLogs sends the default logger to a buffer for the rest of the test
+func captureLogs(t *testing.T) *bytes.Buffer {
+	var (
+		logs     bytes.Buffer
+		previous = slog.Default()
+	)
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	t.Cleanup(func() {
+		slog.SetDefault(previous)
+	})
+	return &logs
+}
+
+func TestLogNotifyLeavesOutTheBody(t *testing.T) {
+	logs := captureLogs(t)
+	err := NewLogNotifier().Notify(context.Background(), Message{To: model.User{ID: "user"}, Subject: "Confirm", Body: "https://example.com/confirm?token=secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(logs.String(), "secret") || !strings.Contains(logs.String(), "Confirm") {
+		t.Fatalf("logged %s", logs)
+	}
+}
@@ -0,0 +1,88 @@
+package notify
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// SMSProvider sends a text message through an SMS gateway
+type SMSProvider interface {
+	SendSMS(ctx context.Context, to string, body string) error
+}
+
+// NewSMSNotifier texts the messages to the user's phone number through the provider. SMS has no subject, it goes in
+// front of the body
+func NewSMSNotifier(provider SMSProvider) *smsNotifier {
+	return &smsNotifier{
+		provider: provider,
+	}
+}
+
+type smsNotifier struct {
+	provider SMSProvider
+}
+
+func (n *smsNotifier) Notify(ctx context.Context, message Message) error {
+	if message.To.Phone == "" {
+		return ErrNoAddress
+	}
+	body := message.Body
+	if message.Subject != "" {
+		body = message.Subject + "\n" + body
+	}
+	return n.provider.SendSMS(ctx, message.To.Phone, body)
+}
+
+func (n *smsNotifier) Channel() string {
+	return "sms"
+}
+
+// SMS is a text message the fake provider took
+type SMS struct {
+	To   string
+	Body string
+}
+
+// NewFakeSMSProvider logs that a text was sent instead of sending it, for local runs and tests. It keeps the last
+// keep texts for Sent, 0 keeps none. The bodies are never logged, they carry confirmation links
+func NewFakeSMSProvider(keep int) *fakeSMSProvider {
+	return &fakeSMSProvider{
+		keep: keep,
+	}
+}
+
+type fakeSMSProvider struct {
+	keep int
+	mu   sync.Mutex
+	sent []SMS
+}
+
+func (p *fakeSMSProvider) SendSMS(ctx context.Context, to string, body string) error {
+	if p.keep > 0 {
+		p.mu.Lock()
+		p.sent = append(p.sent, SMS{To: to, Body: body})
+		if len(p.sent) > p.keep {
+			p.sent = append([]SMS(nil), p.sent[len(p.sent)-p.keep:]...)
+		}
+		p.mu.Unlock()
+	}
+	slog.InfoContext(ctx, "fake sms sent", "to", maskPhone(to), "length", len(body))
+	return nil
+}
+
+// Sent returns the texts it kept, oldest first
+func (p *fakeSMSProvider) Sent() []SMS {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]SMS(nil), p.sent...)
+}
+
+// maskPhone keeps the last 4 digits, phone numbers don't belong in logs
+func maskPhone(phone string) string {
+	if len(phone) <= 4 {
+		return strings.Repeat("*", len(phone))
+	}
+	return strings.Repeat("*", len(phone)-4) + phone[len(phone)-4:]
+}
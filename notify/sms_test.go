@@ -0,0 +1,49 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"henrymeds-takehome/model"
+	"strings"
+	"testing"
+)
+
+func TestSMSNotify(t *testing.T) {
+	var (
+		ctx      = context.Background()
+		logs     = captureLogs(t)
+		provider = NewFakeSMSProvider(2)
+		n        = NewSMSNotifier(provider)
+	)
+	if err := n.Notify(ctx, Message{To: model.User{Email: "pat@example.com"}, Body: "hi"}); !errors.Is(err, ErrNoAddress) {
+		t.Fatalf("a user without a phone number got %v, want ErrNoAddress", err)
+	}
+	for i := 0; i < 3; i++ {
+		err := n.Notify(ctx, Message{To: model.User{Phone: "+15555550123"}, Subject: "Confirm", Body: fmt.Sprintf("token %d", i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sent := provider.Sent()
+	if len(sent) != 2 || sent[0].Body != "Confirm\ntoken 1" || sent[1].Body != "Confirm\ntoken 2" {
+		t.Fatalf("kept %v, want the last 2 texts with the subject in front", sent)
+	}
+	if strings.Contains(logs.String(), "token") || strings.Contains(logs.String(), "5555550123") {
+		t.Fatalf("the logs have the text or the whole number: %s", logs)
+	}
+	if !strings.Contains(logs.String(), "0123") {
+		t.Fatalf("the logs don't say who the text went to: %s", logs)
+	}
+}
+
+func TestFakeSMSProviderKeepsNothing(t *testing.T) {
+	provider := NewFakeSMSProvider(0)
+	if err := provider.SendSMS(context.Background(), "+15555550123", "token"); err != nil {
+		t.Fatal(err)
+	}
+	if sent := provider.Sent(); len(sent) != 0 {
+		t.Fatalf("kept %v", sent)
+	}
+}
@@ -0,0 +1,197 @@
+package openapi
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/labstack/echo/v4"
+)
+
+// the spec is the source of truth for the API, README only describes it
+//
+//go:embed openapi.yaml
+var specYAML []byte
+
+func init() {
+	// the schema dump that comes with every validation error is noise for API clients
+	openapi3.SchemaErrorDetailsDisabled = true
+	openapi3.DefineStringFormat("uuid", `^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	// the default CSV decoder fails the whole request on the first bad row, an import reports each row on its own
+	openapi3filter.RegisterBodyDecoder("text/csv", textBodyDecoder)
+	openapi3filter.RegisterBodyDecoder("text/calendar", textBodyDecoder)
+}
+
+// textBodyDecoder hands the body over as is, to be checked against a string schema
+func textBodyDecoder(body io.Reader, _ http.Header, _ *openapi3.SchemaRef, _ openapi3filter.EncodingFn) (interface{}, error) {
+	raw, err := io.ReadAll(body)
+	return string(raw), err
+}
+
+// NewValidator loads and validates the embedded spec
+func NewValidator(validateResponses bool) (*Validator, error) {
+	spec, err := openapi3.NewLoader().LoadFromData(specYAML)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load OpenAPI spec: %w", err)
+	}
+	err = spec.Validate(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI spec: %w", err)
+	}
+	specJSON, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	return &Validator{
+		spec:              spec,
+		specJSON:          specJSON,
+		validateResponses: validateResponses,
+	}, nil
+}
+
+type Validator struct {
+	spec              *openapi3.T
+	specJSON          []byte
+	validateResponses bool
+}
+
+// HandleSpec serves the spec as JSON
+func (v *Validator) HandleSpec(c echo.Context) error {
+	return c.JSONBlob(http.StatusOK, v.specJSON)
+}
+
+// echo writes path params as :name, OpenAPI as {name}. A colon escaped with a backslash is a literal colon,
+// ex: /availabilities\:bulk
+var echoParam = regexp.MustCompile(`(^|[^\\]):([^/]+)`)
+
+func specPath(echoPath string) string {
+	return strings.ReplaceAll(echoParam.ReplaceAllString(echoPath, "$1{$2}"), `\:`, ":")
+}
+
+// CheckRoutes makes sure the spec and the router describe the same API.
+// Every route registered in echo needs an operation in the spec and every operation in the spec needs a route
+func (v *Validator) CheckRoutes(routes []*echo.Route) error {
+	var (
+		problems   []string
+		registered = map[string]bool{}
+	)
+	for _, route := range routes {
+		path := specPath(route.Path)
+		registered[route.Method+" "+path] = true
+		pathItem := v.spec.Paths.Find(path)
+		if pathItem == nil || pathItem.GetOperation(route.Method) == nil {
+			problems = append(problems, fmt.Sprintf("route %s %s is missing from the OpenAPI spec", route.Method, route.Path))
+		}
+	}
+	for path, pathItem := range v.spec.Paths.Map() {
+		for method := range pathItem.Operations() {
+			if !registered[method+" "+path] {
+				problems = append(problems, fmt.Sprintf("operation %s %s in the OpenAPI spec has no route", method, path))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("OpenAPI spec and routes disagree:\n%s", strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+// Middleware rejects requests that don't match the spec with a 400. When response validation is on,
+// responses that don't match are logged, the client still gets them since the mistake is ours and not theirs
+func (v *Validator) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			var (
+				request  = c.Request()
+				ctx      = request.Context()
+				pathItem = v.spec.Paths.Find(specPath(c.Path()))
+			)
+			if pathItem == nil || pathItem.GetOperation(request.Method) == nil {
+				// unmatched routes are echo's to 404/405
+				return next(c)
+			}
+
+			params := map[string]string{}
+			for i, name := range c.ParamNames() {
+				params[name] = c.ParamValues()[i]
+			}
+			input := &openapi3filter.RequestValidationInput{
+				Request:    request,
+				PathParams: params,
+				Route: &routers.Route{
+					Spec:      v.spec,
+					Path:      specPath(c.Path()),
+					PathItem:  pathItem,
+					Method:    request.Method,
+					Operation: pathItem.GetOperation(request.Method),
+				},
+				Options: &openapi3filter.Options{
+					// auth has its own middleware
+					AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+				},
+			}
+			var (
+				err     = openapi3filter.ValidateRequest(ctx, input)
+				httpErr *echo.HTTPError
+			)
+			if errors.As(err, &httpErr) {
+				// the body limit's 413, the body was too large to check
+				return httpErr
+			}
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("request does not match the API spec: %s", err.Error()))
+			}
+
+			if !v.validateResponses {
+				return next(c)
+			}
+
+			recorder := &bodyRecorder{ResponseWriter: c.Response().Writer}
+			c.Response().Writer = recorder
+			err = next(c)
+			if err != nil {
+				c.Error(err)
+			}
+			err = openapi3filter.ValidateResponse(ctx, &openapi3filter.ResponseValidationInput{
+				RequestValidationInput: input,
+				Status:                 c.Response().Status,
+				Header:                 c.Response().Header(),
+				Body:                   io.NopCloser(bytes.NewReader(recorder.body.Bytes())),
+				Options:                &openapi3filter.Options{IncludeResponseStatus: true},
+			})
+			if err != nil {
+				slog.ErrorContext(ctx, "response does not match the API spec",
+					"method", request.Method,
+					"route", c.Path(),
+					"status", c.Response().Status,
+					"error", err,
+				)
+			}
+			return nil
+		}
+	}
+}
+
+// bodyRecorder keeps a copy of the response body on its way out so it can be validated
+type bodyRecorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *bodyRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
@@ -0,0 +1,82 @@
+package openapi
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+var specParam = regexp.MustCompile(`\{([^}]+)\}`)
+
+// specRoutes are the routes that make up exactly the spec's operations
+func specRoutes(t *testing.T, v *Validator) (routes []*echo.Route) {
+	for path, pathItem := range v.spec.Paths.Map() {
+		echoPath := specParam.ReplaceAllString(strings.ReplaceAll(path, ":", `\:`), ":$1")
+		for method := range pathItem.Operations() {
+			routes = append(routes, &echo.Route{Method: method, Path: echoPath})
+		}
+	}
+	if len(routes) == 0 {
+		t.Fatal("the spec has no operations")
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].Path+routes[i].Method < routes[j].Path+routes[j].Method
+	})
+	return
+}
+
+func TestCheckRoutes(t *testing.T) {
+	v, err := NewValidator(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("agree", func(t *testing.T) {
+		if err := v.CheckRoutes(specRoutes(t, v)); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("route missing from the spec", func(t *testing.T) {
+		routes := append(specRoutes(t, v), &echo.Route{Method: http.MethodPost, Path: "/v1/users/:userId/undocumented"})
+		err := v.CheckRoutes(routes)
+		if err == nil || !strings.Contains(err.Error(), "route POST /v1/users/:userId/undocumented is missing from the OpenAPI spec") {
+			t.Fatalf("got %v", err)
+		}
+	})
+
+	t.Run("method missing from the spec", func(t *testing.T) {
+		routes := append(specRoutes(t, v), &echo.Route{Method: http.MethodDelete, Path: "/healthz"})
+		err := v.CheckRoutes(routes)
+		if err == nil || !strings.Contains(err.Error(), "route DELETE /healthz is missing from the OpenAPI spec") {
+			t.Fatalf("got %v", err)
+		}
+	})
+
+	t.Run("spec operation without a route", func(t *testing.T) {
+		routes := specRoutes(t, v)
+		dropped := routes[0]
+		err := v.CheckRoutes(routes[1:])
+		want := "operation " + dropped.Method + " " + specPath(dropped.Path) + " in the OpenAPI spec has no route"
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("got %v, want it to mention %q", err, want)
+		}
+	})
+}
+
+func TestSpecPath(t *testing.T) {
+	for echoPath, want := range map[string]string{
+		"/healthz":                    "/healthz",
+		"/v1/users/:userId":           "/v1/users/{userId}",
+		"/v1/users/:providerId/slots": "/v1/users/{providerId}/slots",
+		`/v1/users/:providerId/availabilities\:bulk`: "/v1/users/{providerId}/availabilities:bulk",
+	} {
+		if got := specPath(echoPath); got != want {
+			t.Errorf("specPath(%q) = %q, want %q", echoPath, got, want)
+		}
+	}
+}
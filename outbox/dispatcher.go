@@ -0,0 +1,135 @@
+package outbox
+
+import (
+	"context"
+	"henrymeds-takehome/api"
+	"henrymeds-takehome/dao"
+	"henrymeds-takehome/metrics"
+	"henrymeds-takehome/model"
+	"log/slog"
+	"math/rand"
+	"time"
+)
+
+type Options struct {
+	// how long the dispatcher waits before looking again once the outbox is drained
+	PollInterval time.Duration
+	// how many events are claimed at a time
+	BatchSize int
+	// how long a claimed batch is reserved for this dispatcher, it has to cover publishing the whole batch
+	Lease time.Duration
+	// the wait before retrying a failed event, doubled with every failure up to MaxBackoff
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// how long a single publish may take
+	PublishTimeout time.Duration
+}
+
+// NewDispatcher builds the dispatcher that moves events from the outbox to the sink. Delivery is at least once:
+// an event is marked published only after the sink took it, so a crash in between publishes it again. Events are
+// published oldest first, but a failed event is retried after its backoff and later events don't wait for it
+func NewDispatcher(outboxDao dao.OutboxDao, sink Sink, options Options) *Dispatcher {
+	return &Dispatcher{
+		outboxDao: outboxDao,
+		sink:      sink,
+		options:   options,
+	}
+}
+
+type Dispatcher struct {
+	outboxDao dao.OutboxDao
+	sink      Sink
+	options   Options
+}
+
+// Run publishes events until ctx is cancelled. Several dispatchers, one per instance, can run against the same
+// outbox, a batch is only ever claimed by one of them at a time
+func (d *Dispatcher) Run(ctx context.Context) {
+	for {
+		claimed, err := d.dispatch(ctx)
+		if err != nil && ctx.Err() == nil {
+			slog.ErrorContext(ctx, "failed to dispatch events", "error", err)
+		}
+		// a full batch means there's probably more waiting, go straight back for it
+		if err == nil && claimed == d.options.BatchSize {
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(d.options.PollInterval):
+		}
+	}
+}
+
+// dispatch claims a batch and publishes it, it returns how many events were claimed
+func (d *Dispatcher) dispatch(ctx context.Context) (claimed int, err error) {
+	var events []model.Event
+	events, err = d.outboxDao.ClaimEvents(ctx, d.options.BatchSize, d.options.Lease)
+	if err != nil {
+		return
+	}
+	for _, event := range events {
+		// on shutdown the rest of the batch is left for the lease to run out on, another instance picks it up
+		if ctx.Err() != nil {
+			break
+		}
+		d.publish(ctx, event)
+	}
+	return len(events), nil
+}
+
+func (d *Dispatcher) publish(ctx context.Context, event model.Event) {
+	var (
+		publishCtx, cancel = context.WithTimeout(ctx, d.options.PublishTimeout)
+		err                error
+	)
+	// a sink made of several only publishes to the ones that didn't take the event on an earlier attempt
+	if partial, ok := d.sink.(PartialSink); ok {
+		event.DeliveredTo, err = partial.PublishExcept(publishCtx, api.FromEvent(event), event.DeliveredTo)
+	} else {
+		err = d.sink.Publish(publishCtx, api.FromEvent(event))
+	}
+	cancel()
+	// the bookkeeping below runs even if shutdown started during the publish, so a delivered event isn't sent again
+	ctx = context.WithoutCancel(ctx)
+
+	if err == nil {
+		metrics.OutboxEvents.WithLabelValues(event.Type, metrics.OutboxPublished).Inc()
+		if err = d.outboxDao.MarkEventPublished(ctx, event.ID, time.Now()); err != nil {
+			// the event is published again once its lease runs out, that's the at least once in at least once
+			slog.ErrorContext(ctx, "failed to mark event published", "event_id", event.ID, "error", err)
+		}
+		return
+	}
+
+	metrics.OutboxEvents.WithLabelValues(event.Type, metrics.OutboxFailed).Inc()
+	event.Attempts++
+	event.NextAttemptAt = time.Now().Add(Backoff(event.Attempts, d.options.MinBackoff, d.options.MaxBackoff))
+	event.LastError = err.Error()
+	slog.WarnContext(ctx, "failed to publish event",
+		"event_id", event.ID,
+		"type", event.Type,
+		"attempts", event.Attempts,
+		"next_attempt_at", event.NextAttemptAt,
+		"error", err,
+	)
+	if err = d.outboxDao.MarkEventFailed(ctx, event); err != nil {
+		slog.ErrorContext(ctx, "failed to record event failure", "event_id", event.ID, "error", err)
+	}
+}
+
+// Backoff is the wait after the given number of failed attempts, minBackoff doubled for every attempt after the first
+// and capped at maxBackoff. The wait is picked at random from its upper half, so things that failed together, ex:
+// while the sink was down, don't all retry together
+func Backoff(attempts int, minBackoff time.Duration, maxBackoff time.Duration) time.Duration {
+	backoff := minBackoff
+	for i := 1; i < attempts && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	backoff = min(backoff, maxBackoff)
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
@@ -0,0 +1,72 @@
+package outbox
+
+import (
+	"context"
+	"henrymeds-takehome/model"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeOutboxDao is an outbox of one event that's always due
+type fakeOutboxDao struct {
+	mu    sync.Mutex
+	event model.Event
+}
+
+func (d *fakeOutboxDao) ClaimEvents(ctx context.Context, limit int, lease time.Duration) ([]model.Event, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.event.PublishedAt.IsZero() {
+		return nil, nil
+	}
+	return []model.Event{d.event}, nil
+}
+
+func (d *fakeOutboxDao) MarkEventPublished(ctx context.Context, id string, at time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.event.PublishedAt = at
+	return nil
+}
+
+func (d *fakeOutboxDao) MarkEventFailed(ctx context.Context, event model.Event) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.event = event
+	return nil
+}
+
+func TestDispatcherRetriesTheFailedSinks(t *testing.T) {
+	var (
+		ctx          = context.Background()
+		outboxDao    = &fakeOutboxDao{event: model.Event{ID: "event", Type: "reservation.held", Payload: []byte(`{}`)}}
+		events       = &fakeSink{}
+		confirmation = &fakeSink{failing: true}
+		dispatcher   = NewDispatcher(outboxDao, NewMultiSink(NamedSink{Name: "sink", Sink: events}, NamedSink{Name: "confirmation", Sink: confirmation}), Options{
+			BatchSize:      10,
+			MinBackoff:     time.Millisecond,
+			MaxBackoff:     time.Millisecond,
+			PublishTimeout: time.Second,
+		})
+	)
+	for i := 0; i < 2; i++ {
+		if _, err := dispatcher.dispatch(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if outboxDao.event.Attempts != 2 || outboxDao.event.LastError == "" || !outboxDao.event.PublishedAt.IsZero() {
+		t.Fatalf("the failures weren't recorded: %+v", outboxDao.event)
+	}
+
+	confirmation.setFailing(false)
+	if _, err := dispatcher.dispatch(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if outboxDao.event.PublishedAt.IsZero() {
+		t.Fatal("the event wasn't marked published")
+	}
+	if events.count() != 1 || confirmation.count() != 1 {
+		t.Fatalf("published %d times to the sink and %d to the confirmation, want once each", events.count(), confirmation.count())
+	}
+}
@@ -0,0 +1,55 @@
+package outbox
+
+import (
+	"encoding/json"
+	"henrymeds-takehome/api"
+	"henrymeds-takehome/model"
+	"time"
+)
+
+// event types, the payload of a reservation event is the api.Reservation and of an availability event the
+// api.Availability, both as they were after the change
+const (
+	ReservationHeld      = "reservation.held"
+	ReservationConfirmed = "reservation.confirmed"
+	ReservationCancelled = "reservation.cancelled"
+	ReservationExpired   = "reservation.expired"
+	AvailabilityCreated  = "availability.created"
+	AvailabilityUpdated  = "availability.updated"
+	AvailabilityDeleted  = "availability.deleted"
+)
+
+// Types lists every event type
+var Types = []string{
+	ReservationHeld,
+	ReservationConfirmed,
+	ReservationCancelled,
+	ReservationExpired,
+	AvailabilityCreated,
+	AvailabilityUpdated,
+	AvailabilityDeleted,
+}
+
+// ReservationEvent builds the event for a change to the reservation that happened at at. The payload never carries
+// the confirmation ID, it's only for the client that made the reservation, nor the reason for the visit, events are
+// stored and sent on in plaintext
+func ReservationEvent(eventType string, reservation model.Reservation, at time.Time) (model.Event, error) {
+	reservation.Reason = ""
+	return newEvent(eventType, reservation.ID, reservation.OrganizationID, api.FromReservation(reservation, at), at)
+}
+
+func AvailabilityEvent(eventType string, availability model.Availability, at time.Time) (model.Event, error) {
+	return newEvent(eventType, availability.ID, availability.OrganizationID, api.FromAvailability(availability), at)
+}
+
+func newEvent(eventType string, aggregateID string, organizationID string, data any, at time.Time) (event model.Event, err error) {
+	event = model.Event{
+		Type:           eventType,
+		AggregateID:    aggregateID,
+		OrganizationID: organizationID,
+		CreatedAt:      at,
+		NextAttemptAt:  at,
+	}
+	event.Payload, err = json.Marshal(data)
+	return
+}
@@ -0,0 +1,167 @@
+package outbox
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"henrymeds-takehome/api"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NewNATSSink publishes every event to NATS core on subject.<event type>, ex: henrymeds.reservation.held, so
+// subscribers can pick event types with wildcards. natsURL is nats://[user:password@]host[:port].
+// It speaks the text protocol itself rather than pulling in a client library, all it needs is PUB followed by a
+// PING, the server answers the PONG once it has processed the PUB before it
+func NewNATSSink(natsURL string, subject string, timeout time.Duration) (*natsSink, error) {
+	parsed, err := url.Parse(natsURL)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Scheme != "nats" || parsed.Hostname() == "" {
+		return nil, fmt.Errorf("expected a nats://host:port URL, got %q", natsURL)
+	}
+	address := parsed.Host
+	if parsed.Port() == "" {
+		address = net.JoinHostPort(parsed.Hostname(), "4222")
+	}
+	return &natsSink{
+		address: address,
+		user:    parsed.User,
+		subject: subject,
+		timeout: timeout,
+	}, nil
+}
+
+type natsSink struct {
+	address string
+	user    *url.Userinfo
+	subject string
+	timeout time.Duration
+
+	// one connection, opened on the first publish and again after any error
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func (s *natsSink) Publish(ctx context.Context, event api.Event) (err error) {
+	var payload []byte
+	payload, err = json.Marshal(event)
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		if err = s.connect(ctx); err != nil {
+			return
+		}
+	}
+	// whatever went wrong, the connection is in an unknown state and the next publish starts over
+	defer func() {
+		if err != nil {
+			s.closeConn()
+		}
+	}()
+
+	deadline := time.Now().Add(s.timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if err = s.conn.SetDeadline(deadline); err != nil {
+		return
+	}
+	_, err = fmt.Fprintf(s.conn, "PUB %s.%s %d\r\n%s\r\nPING\r\n", s.subject, event.Type, len(payload), payload)
+	if err != nil {
+		return
+	}
+	return s.awaitPong()
+}
+
+func (s *natsSink) connect(ctx context.Context) (err error) {
+	dialer := net.Dialer{Timeout: s.timeout}
+	s.conn, err = dialer.DialContext(ctx, "tcp", s.address)
+	if err != nil {
+		return
+	}
+	s.reader = bufio.NewReader(s.conn)
+	defer func() {
+		if err != nil {
+			s.closeConn()
+		}
+	}()
+	if err = s.conn.SetDeadline(time.Now().Add(s.timeout)); err != nil {
+		return
+	}
+
+	// the server opens with INFO
+	var line string
+	line, err = s.readLine()
+	if err != nil {
+		return
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return fmt.Errorf("unexpected greeting from nats: %q", line)
+	}
+	options := map[string]any{"verbose": false, "pedantic": false, "name": "henrymeds-takehome"}
+	if s.user != nil {
+		options["user"] = s.user.Username()
+		if password, ok := s.user.Password(); ok {
+			options["pass"] = password
+		}
+	}
+	connect, _ := json.Marshal(options)
+	_, err = fmt.Fprintf(s.conn, "CONNECT %s\r\nPING\r\n", connect)
+	if err != nil {
+		return
+	}
+	// a refused CONNECT shows up as -ERR instead of the PONG
+	return s.awaitPong()
+}
+
+func (s *natsSink) awaitPong() error {
+	for {
+		line, err := s.readLine()
+		if err != nil {
+			return err
+		}
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err = s.conn.Write([]byte("PONG\r\n")); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return errors.New("nats: " + strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+		// +OK and INFO updates need no answer
+	}
+}
+
+func (s *natsSink) readLine() (string, error) {
+	line, err := s.reader.ReadString('\n')
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+func (s *natsSink) closeConn() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+		s.reader = nil
+	}
+}
+
+func (s *natsSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closeConn()
+	return nil
+}
@@ -0,0 +1,151 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"henrymeds-takehome/api"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// Sink is where the dispatcher publishes events
+type Sink interface {
+	// Publish delivers the event, an error means it may not have been and it will be published again later.
+	// A consumer can get the same event more than once and should dedupe on its ID
+	Publish(ctx context.Context, event api.Event) error
+	Close() error
+}
+
+// PartialSink is a sink made of several that keeps track of which of them took an event, so a retry only goes to
+// the ones that failed
+type PartialSink interface {
+	Sink
+	// PublishExcept publishes the event to the sinks that aren't in delivered. It returns delivered with the ones
+	// that took the event added, also when another of them failed
+	PublishExcept(ctx context.Context, event api.Event, delivered []string) ([]string, error)
+}
+
+// NamedSink is one of the sinks of a multi sink. The name is what the outbox records the sink took an event under,
+// it has to stay the same between deploys
+type NamedSink struct {
+	Name string
+	Sink Sink
+}
+
+// NewMultiSink publishes every event to each of the sinks in turn. When one of them fails, the retry only goes to
+// the ones that didn't take the event yet
+func NewMultiSink(sinks ...NamedSink) *multiSink {
+	return &multiSink{
+		sinks: sinks,
+	}
+}
+
+type multiSink struct {
+	sinks []NamedSink
+}
+
+func (s *multiSink) Publish(ctx context.Context, event api.Event) error {
+	_, err := s.PublishExcept(ctx, event, nil)
+	return err
+}
+
+func (s *multiSink) PublishExcept(ctx context.Context, event api.Event, delivered []string) ([]string, error) {
+	var errs []error
+	delivered = slices.Clone(delivered)
+	for _, sink := range s.sinks {
+		if slices.Contains(delivered, sink.Name) {
+			continue
+		}
+		if err := sink.Sink.Publish(ctx, event); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", sink.Name, err))
+			continue
+		}
+		delivered = append(delivered, sink.Name)
+	}
+	return delivered, errors.Join(errs...)
+}
+
+func (s *multiSink) Close() error {
+	var errs []error
+	for _, sink := range s.sinks {
+		errs = append(errs, sink.Sink.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// NewLogSink writes every event to the log, for local runs and for deployments that don't consume the events yet
+func NewLogSink() *logSink {
+	return &logSink{}
+}
+
+type logSink struct{}
+
+func (s *logSink) Publish(ctx context.Context, event api.Event) error {
+	slog.InfoContext(ctx, "event published",
+		"event_id", event.ID,
+		"type", event.Type,
+		"occurred_at", event.OccurredAt,
+		"data", string(event.Data),
+	)
+	return nil
+}
+
+func (s *logSink) Close() error {
+	return nil
+}
+
+// NewWebhookSink POSTs every event as JSON to url, any response other than a 2xx is a failed delivery
+func NewWebhookSink(url string, timeout time.Duration) *webhookSink {
+	return &webhookSink{
+		url:        url,
+		httpClient: &http.Client{Timeout: timeout},
+	}
+}
+
+type webhookSink struct {
+	url        string
+	httpClient *http.Client
+}
+
+func (s *webhookSink) Publish(ctx context.Context, event api.Event) (err error) {
+	var (
+		body     []byte
+		request  *http.Request
+		response *http.Response
+	)
+	body, err = json.Marshal(event)
+	if err != nil {
+		return
+	}
+	request, err = http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	request.Header.Set("Content-Type", "application/json")
+	// the same ID on every delivery of the event, receivers dedupe on it
+	request.Header.Set("X-Event-Id", event.ID)
+	request.Header.Set("X-Event-Type", event.Type)
+
+	response, err = s.httpClient.Do(request)
+	if err != nil {
+		return
+	}
+	defer response.Body.Close()
+	// read a little of the body so the connection can be reused, a receiver has no reason to send much back
+	_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		err = fmt.Errorf("webhook responded %s", response.Status)
+	}
+	return
+}
+
+func (s *webhookSink) Close() error {
+	s.httpClient.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,72 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"henrymeds-takehome/api"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// fakeSink counts the events it took, and fails while failing is set
+type fakeSink struct {
+	mu        sync.Mutex
+	failing   bool
+	published []string
+}
+
+func (s *fakeSink) Publish(ctx context.Context, event api.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failing {
+		return errors.New("the sink is down")
+	}
+	s.published = append(s.published, event.ID)
+	return nil
+}
+
+func (s *fakeSink) Close() error {
+	return nil
+}
+
+func (s *fakeSink) setFailing(failing bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failing = failing
+}
+
+func (s *fakeSink) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.published)
+}
+
+func TestMultiSinkPublishesToTheOnesThatFailed(t *testing.T) {
+	var (
+		ctx          = context.Background()
+		events       = &fakeSink{}
+		confirmation = &fakeSink{failing: true}
+		sink         = NewMultiSink(NamedSink{Name: "sink", Sink: events}, NamedSink{Name: "confirmation", Sink: confirmation})
+		event        = api.Event{ID: "event"}
+	)
+	delivered, err := sink.PublishExcept(ctx, event, nil)
+	if err == nil {
+		t.Fatal("a failed sink didn't fail the publish")
+	}
+	if !slices.Equal(delivered, []string{"sink"}) {
+		t.Fatalf("delivered to %v, want the sink that took it", delivered)
+	}
+
+	confirmation.setFailing(false)
+	delivered, err = sink.PublishExcept(ctx, event, delivered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events.count() != 1 || confirmation.count() != 1 {
+		t.Fatalf("published %d and %d times, want once to each", events.count(), confirmation.count())
+	}
+	if !slices.Equal(delivered, []string{"sink", "confirmation"}) {
+		t.Fatalf("delivered to %v", delivered)
+	}
+}
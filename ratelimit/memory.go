@@ -0,0 +1,55 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// how often the buckets that filled back up are dropped, a full bucket is the same as none
+const sweepInterval = time.Minute
+
+// NewMemoryLimiter keeps the buckets in memory, every instance limits on its own so the limits add up with the
+// number of instances
+func NewMemoryLimiter() *memoryLimiter {
+	return &memoryLimiter{
+		buckets: map[string]*bucket{},
+	}
+}
+
+type memoryLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+	// when it's full again
+	fullAt time.Time
+}
+
+func (l *memoryLimiter) TakeToken(ctx context.Context, key string, rate float64, burst int) (allowed bool, retryAfter time.Duration, err error) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		for key, b := range l.buckets {
+			if !now.Before(b.fullAt) {
+				delete(l.buckets, key)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(burst), updatedAt: now}
+		l.buckets[key] = b
+	}
+	b.tokens, allowed, retryAfter = take(b.tokens, b.updatedAt, now, rate, burst)
+	b.updatedAt = now
+	b.fullAt = now.Add(time.Duration((float64(burst) - b.tokens) / rate * float64(time.Second)))
+	return
+}
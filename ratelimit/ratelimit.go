@@ -0,0 +1,120 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"henrymeds-takehome/audit"
+	"henrymeds-takehome/metrics"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Limiter keeps token buckets. A bucket holds up to burst tokens and refills at rate tokens a second, every request
+// takes one, and a bucket that's seen for the first time starts full
+type Limiter interface {
+	// TakeToken takes a token from the key's bucket. When it's empty allowed is false and retryAfter is how long
+	// until it has a token again
+	TakeToken(ctx context.Context, key string, rate float64, burst int) (allowed bool, retryAfter time.Duration, err error)
+}
+
+// Rule is a limit on one kind of caller
+type Rule struct {
+	// names the limit in bucket keys, logs and metrics, ex: ip
+	Name string
+	// tokens a second and the size of the bucket
+	Rate  float64
+	Burst int
+	// Key is the caller as far as the rule is concerned, each gets a bucket. Empty leaves the request out of it
+	Key func(c echo.Context) string
+}
+
+// Middleware turns away the requests of a caller that ran out of tokens with a 429 and a Retry-After. Requests go
+// through when the limiter fails, a limiter that's down shouldn't take the API down with it
+func Middleware(limiter Limiter, rule Rule) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			key := rule.Key(c)
+			if key == "" {
+				return next(c)
+			}
+			ctx := c.Request().Context()
+			allowed, retryAfter, err := limiter.TakeToken(ctx, rule.Name+":"+key, rule.Rate, rule.Burst)
+			if err != nil {
+				slog.ErrorContext(ctx, "failed to check rate limit", "limit", rule.Name, "error", err)
+				return next(c)
+			}
+			if allowed {
+				return next(c)
+			}
+			seconds := int(math.Ceil(retryAfter.Seconds()))
+			metrics.RateLimited.WithLabelValues(rule.Name).Inc()
+			slog.InfoContext(ctx, "rate limited", "limit", rule.Name, "method", c.Request().Method, "path", c.Path())
+			c.Response().Header().Set("Retry-After", strconv.Itoa(seconds))
+			return echo.NewHTTPError(http.StatusTooManyRequests, fmt.Sprintf("too many requests, retry in %ds", seconds))
+		}
+	}
+}
+
+// ByIP is the caller's address, as the server's IP extractor sees it
+func ByIP(c echo.Context) string {
+	return c.RealIP()
+}
+
+// the most of a body ByClientID reads, a booking is a few hundred bytes
+const maxClientIDBody = 64 << 10
+
+// ByClientID is the clientId of a JSON body, for the booking routes. The body is put back for the handler. A body
+// over 64KB isn't read, the handler gets it whole, the routes limit their bodies below that anyway
+func ByClientID(c echo.Context) string {
+	request := c.Request()
+	if request.Body == nil {
+		return ""
+	}
+	body, err := io.ReadAll(io.LimitReader(request.Body, maxClientIDBody+1))
+	request.Body = readCloser{io.MultiReader(bytes.NewReader(body), request.Body), request.Body}
+	if err != nil || len(body) > maxClientIDBody {
+		return ""
+	}
+	var fields struct {
+		ClientID string `json:"clientId"`
+	}
+	if json.Unmarshal(body, &fields) != nil {
+		return ""
+	}
+	return fields.ClientID
+}
+
+// ByPrincipal is who the API key says is calling, ex: an organization, for the booking routes. Unlike a clientId it
+// can't be made up, rotating clientIds doesn't get a caller a new bucket. Requests without a key are left out, the IP
+// limits are all there is for them
+func ByPrincipal(c echo.Context) string {
+	switch actor := audit.Actor(c.Request().Context()); actor {
+	case audit.ActorAnonymous, audit.ActorSystem:
+		return ""
+	default:
+		return actor
+	}
+}
+
+// readCloser reads the part of a body that was read ahead then the rest, and closes the original
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// take is the token bucket, it takes a token from a bucket that had tokens at updatedAt and returns what's left
+func take(tokens float64, updatedAt time.Time, now time.Time, rate float64, burst int) (left float64, allowed bool, retryAfter time.Duration) {
+	left = math.Min(float64(burst), tokens+now.Sub(updatedAt).Seconds()*rate)
+	if left >= 1 {
+		return left - 1, true, 0
+	}
+	return left, false, time.Duration((1 - left) / rate * float64(time.Second))
+}
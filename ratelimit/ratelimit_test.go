@@ -0,0 +1,87 @@
+package ratelimit
+
+import (
+	"henrymeds-takehome/audit"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestByClientID(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{name: "a booking", body: `{"clientId":"client","providerId":"provider"}`, want: "client"},
+		{name: "not JSON", body: "clientId=client"},
+		{name: "over 64KB", body: `{"clientId":"client","notes":"` + strings.Repeat("a", maxClientIDBody) + `"}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := echo.New().NewContext(httptest.NewRequest(http.MethodPost, "/v1/reservations", strings.NewReader(test.body)), httptest.NewRecorder())
+			if got := ByClientID(c); got != test.want {
+				t.Fatalf("got %q, want %q", got, test.want)
+			}
+			body, err := io.ReadAll(c.Request().Body)
+			if err != nil || string(body) != test.body {
+				t.Fatalf("the handler got %d bytes of the %d, err %v", len(body), len(test.body), err)
+			}
+		})
+	}
+}
+
+func TestPrincipalLimitOutlastsNewClientIDs(t *testing.T) {
+	var (
+		e       = echo.New()
+		limiter = NewMemoryLimiter()
+		booked  int
+	)
+	e.POST("/v1/reservations", func(c echo.Context) error {
+		booked++
+		return c.NoContent(http.StatusCreated)
+	}, func(next echo.HandlerFunc) echo.HandlerFunc {
+		// what the API key check does for an organization's key
+		return func(c echo.Context) error {
+			if key := c.Request().Header.Get("X-Api-Key"); key != "" {
+				c.SetRequest(c.Request().WithContext(audit.WithActor(c.Request().Context(), audit.OrganizationActor(key))))
+			}
+			return next(c)
+		}
+	},
+		Middleware(limiter, Rule{Name: "principal", Rate: 1.0 / 60, Burst: 2, Key: ByPrincipal}),
+		Middleware(limiter, Rule{Name: "client", Rate: 1.0 / 60, Burst: 2, Key: ByClientID}),
+	)
+	book := func(key, clientID string) int {
+		request := httptest.NewRequest(http.MethodPost, "/v1/reservations", strings.NewReader(`{"clientId":"`+clientID+`"}`))
+		request.Header.Set("X-Api-Key", key)
+		recorder := httptest.NewRecorder()
+		e.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	for i, clientID := range []string{"a", "b"} {
+		if code := book("organization", clientID); code != http.StatusCreated {
+			t.Fatalf("booking %d got %d", i, code)
+		}
+	}
+	if code := book("organization", "c"); code != http.StatusTooManyRequests {
+		t.Fatalf("a new clientId got %d, want the principal's limit", code)
+	}
+	if code := book("another", "c"); code != http.StatusCreated {
+		t.Fatalf("another organization got %d", code)
+	}
+	if code := book("", "c"); code != http.StatusCreated {
+		t.Fatalf("a request without a key got %d, it's only limited by IP and client", code)
+	}
+	if code := book("", "c"); code != http.StatusTooManyRequests {
+		t.Fatalf("the client's third booking got %d", code)
+	}
+	if booked != 4 {
+		t.Fatalf("booked %d times, want 4", booked)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	d "henrymeds-takehome/dao"
+	"os"
+	"sort"
+)
+
+// reEncrypt is `reencrypt`, it moves the patient details onto the current master key and encrypts the ones stored
+// before encryption was turned on, ex:
+//
+//	henrymeds-takehome reencrypt [-batch-size 500] [-- config flags]
+//
+// Rotating a key is adding the new one to the key file, restarting the service, running this and then removing the
+// old key. It's safe alongside the service and safe to run again, it only rewrites values that aren't under the
+// current key
+func reEncrypt(args []string) int {
+	var (
+		fs        = flag.NewFlagSet("reencrypt", flag.ContinueOnError)
+		batchSize = fs.Int("batch-size", 500, "how many rows are rewritten per transaction")
+	)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: reencrypt [-batch-size n] [-- config flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *batchSize < 1 {
+		fs.Usage()
+		return 2
+	}
+
+	config := readConfigs(fs.Args())
+	setupLogger(config.Log)
+	encryptor, err := setupEncryptor(config.Encryption)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to setup encryption:", err)
+		return 1
+	}
+	if encryptor == nil {
+		fmt.Fprintln(os.Stderr, "encryption.kms is none, there's no key to encrypt with")
+		return 2
+	}
+	db, err := createGoPgDB(config.DB)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to setup DB connection:", err)
+		return 1
+	}
+	defer db.Close()
+
+	var rotation d.KeyRotationDao = d.NewReservationDao(db, encryptor)
+	rewritten, err := rotation.ReEncrypt(context.Background(), *batchSize)
+	tables := make([]string, 0, len(rewritten))
+	for table := range rewritten {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		fmt.Printf("%s: re-encrypted %d rows\n", table, rewritten[table])
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to re-encrypt:", err)
+		return 1
+	}
+	return 0
+}
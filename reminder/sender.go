@@ -0,0 +1,256 @@
+package reminder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"henrymeds-takehome/dao"
+	"henrymeds-takehome/metrics"
+	"henrymeds-takehome/model"
+	"henrymeds-takehome/notify"
+	"henrymeds-takehome/outbox"
+	"log/slog"
+	"time"
+)
+
+type Options struct {
+	// how long the sender waits before looking again once nothing is due
+	PollInterval time.Duration
+	// how many reminders are claimed at a time
+	BatchSize int
+	// how long a claimed batch is reserved for this sender, it has to cover sending the whole batch
+	Lease time.Duration
+	// the wait before retrying a failed reminder, doubled with every failure up to MaxBackoff
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// a reminder that failed this many times is given up on
+	MaxAttempts int
+}
+
+// NewSender builds the worker that sends reminders once they're due
+func NewSender(reminderDao dao.ReminderDao, notifier notify.Notifier, options Options) *Sender {
+	return &Sender{
+		reminderDao: reminderDao,
+		notifier:    notifier,
+		options:     options,
+	}
+}
+
+type Sender struct {
+	reminderDao dao.ReminderDao
+	notifier    notify.Notifier
+	options     Options
+}
+
+// Run sends reminders until ctx is cancelled, several senders can run against the same reminders
+func (s *Sender) Run(ctx context.Context) {
+	for {
+		claimed, err := s.sendBatch(ctx)
+		if err != nil && ctx.Err() == nil {
+			slog.ErrorContext(ctx, "failed to send reminders", "error", err)
+		}
+		// a full batch means there's probably more waiting, go straight back for it
+		if err == nil && claimed == s.options.BatchSize {
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.options.PollInterval):
+		}
+	}
+}
+
+// sendBatch claims a batch and sends it, it returns how many reminders were claimed
+func (s *Sender) sendBatch(ctx context.Context) (claimed int, err error) {
+	var (
+		reminders    []model.Reminder
+		reservations []model.Reservation
+		users        []model.User
+		locations    []model.Location
+		ids          []string
+	)
+	reminders, err = s.reminderDao.ClaimReminders(ctx, s.options.BatchSize, s.options.Lease)
+	if err != nil || len(reminders) == 0 {
+		return len(reminders), err
+	}
+	for _, reminder := range reminders {
+		ids = append(ids, reminder.ReservationID)
+	}
+	reservations, err = s.reminderDao.GetReservationsByID(ctx, ids)
+	if err != nil {
+		return
+	}
+	ids = ids[:0]
+	for _, reservation := range reservations {
+		ids = append(ids, reservation.ClientID, reservation.ProviderID)
+	}
+	users, err = s.reminderDao.GetUsersByID(ctx, ids)
+	if err != nil {
+		return
+	}
+	ids = ids[:0]
+	for _, reservation := range reservations {
+		if reservation.LocationID != "" {
+			ids = append(ids, reservation.LocationID)
+		}
+	}
+	locations, err = s.reminderDao.GetLocationsByID(ctx, ids)
+	if err != nil {
+		return
+	}
+	reservationsByID := make(map[string]model.Reservation, len(reservations))
+	for _, reservation := range reservations {
+		reservationsByID[reservation.ID] = reservation
+	}
+	usersByID := make(map[string]model.User, len(users))
+	for _, user := range users {
+		usersByID[user.ID] = user
+	}
+	locationsByID := make(map[string]model.Location, len(locations))
+	for _, location := range locations {
+		locationsByID[location.ID] = location
+	}
+
+	for _, reminder := range reminders {
+		// on shutdown the rest of the batch is left for the lease to run out on
+		if ctx.Err() != nil {
+			break
+		}
+		reservation := reservationsByID[reminder.ReservationID]
+		s.send(ctx, reminder, reservation, usersByID[reservation.ClientID], usersByID[reservation.ProviderID], locationsByID[reservation.LocationID])
+	}
+	return len(reminders), nil
+}
+
+func (s *Sender) send(ctx context.Context, reminder model.Reminder, reservation model.Reservation, client model.User, provider model.User, location model.Location) {
+	var (
+		now     = time.Now()
+		outcome string
+		err     error
+	)
+	// the reservation is checked again here rather than trusting the schedule, so a cancellation or move that
+	// didn't go through the controller still can't send a reminder for an appointment that isn't there
+	switch {
+	case reservation.ID == "" || !reservation.Confirmed || !reservation.CancelledAt.IsZero():
+		reminder.Status = model.ReminderCancelled
+		outcome = metrics.ReminderCancelled
+	case !reservation.Start.Add(-reminder.Lead()).Equal(reminder.DueAt):
+		// moved, follow it if the new due time is still ahead
+		reminder.DueAt = reservation.Start.Add(-reminder.Lead())
+		reminder.NextAttemptAt = reminder.DueAt
+		if !reminder.DueAt.After(now) {
+			reminder.Status = model.ReminderCancelled
+			outcome = metrics.ReminderCancelled
+		}
+	case !now.Before(reservation.Start):
+		reminder.Status = model.ReminderFailed
+		reminder.LastError = "the appointment started before the reminder could be sent"
+		outcome = metrics.ReminderFailed
+	default:
+		err = s.notifier.Notify(ctx, message(reminder, reservation, client, provider, location))
+		outcome = s.record(&reminder, err, now)
+	}
+	// the bookkeeping below runs even if shutdown started during the send, so a sent reminder isn't sent again
+	ctx = context.WithoutCancel(ctx)
+	if outcome != "" {
+		metrics.Reminders.WithLabelValues(s.notifier.Channel(), outcome).Inc()
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "failed to send reminder",
+			"reminder_id", reminder.ID,
+			"reservation_id", reminder.ReservationID,
+			"channel", s.notifier.Channel(),
+			"attempts", reminder.Attempts,
+			"status", reminder.Status,
+			"error", err,
+		)
+	}
+	if err = s.reminderDao.UpdateReminder(ctx, reminder); err != nil {
+		// a sent reminder is sent again once its lease runs out
+		slog.ErrorContext(ctx, "failed to record reminder", "reminder_id", reminder.ID, "error", err)
+	}
+}
+
+// record updates the reminder with the outcome of sending it and returns the metric outcome
+func (s *Sender) record(reminder *model.Reminder, err error, now time.Time) string {
+	if err == nil {
+		reminder.Status = model.ReminderSent
+		reminder.SentAt = now
+		reminder.LastError = ""
+		return metrics.ReminderSent
+	}
+	reminder.Attempts++
+	reminder.LastError = err.Error()
+	// no address on file won't fix itself by trying again
+	if errors.Is(err, notify.ErrNoAddress) || reminder.Attempts >= s.options.MaxAttempts {
+		reminder.Status = model.ReminderFailed
+		return metrics.ReminderFailed
+	}
+	reminder.NextAttemptAt = now.Add(outbox.Backoff(reminder.Attempts, s.options.MinBackoff, s.options.MaxBackoff))
+	return metrics.ReminderRetried
+}
+
+// message is the reminder's text with how to join the visit. Times are in UTC, or in the location's time zone for
+// in_person visits
+func message(reminder model.Reminder, reservation model.Reservation, client model.User, provider model.User, location model.Location) notify.Message {
+	zone := time.UTC
+	if reservation.Modality == model.ModalityInPerson && location.TimeZone != "" {
+		// checked when the location was made, UTC is only left for a zone the server's tzdata doesn't know anymore
+		if loaded, err := time.LoadLocation(location.TimeZone); err == nil {
+			zone = loaded
+		}
+	}
+	start := reservation.Start.In(zone)
+	with := ""
+	if provider.Username != "" {
+		with = " with " + provider.Username
+	}
+	return notify.Message{
+		To:      client,
+		Subject: fmt.Sprintf("Reminder: your appointment%s on %s", with, start.Format("Mon Jan 2 at 15:04 MST")),
+		Body: fmt.Sprintf("Hi %s,\n\nThis is a reminder that your appointment%s starts in %s, on %s and ends at %s.\n\n%sReservation %s",
+			client.Username,
+			with,
+			humanize(reminder.Lead()),
+			start.Format("Monday, January 2 at 15:04 MST"),
+			reservation.End.In(zone).Format("15:04 MST"),
+			join(reservation, location),
+			reservation.ID,
+		),
+	}
+}
+
+// join says how the client joins the visit, a paragraph of the reminder or nothing when there's nothing to say
+func join(reservation model.Reservation, location model.Location) string {
+	switch {
+	case reservation.Modality == model.ModalityVideo && reservation.VideoURL != "":
+		return fmt.Sprintf("Join the video call at %s\n\n", reservation.VideoURL)
+	case reservation.Modality == model.ModalityPhone:
+		return "Your provider will call you at the phone number on file.\n\n"
+	case reservation.Modality == model.ModalityInPerson && location.ID != "":
+		return fmt.Sprintf("The visit is in person at %s, %s.\n\n", location.Name, location.Address)
+	}
+	return ""
+}
+
+// humanize writes the lead the way a person would, ex: 24 hours, 1 hour, 30 minutes
+func humanize(d time.Duration) string {
+	switch {
+	case d >= time.Hour && d%time.Hour == 0:
+		return plural(int(d/time.Hour), "hour")
+	case d >= time.Minute && d%time.Minute == 0:
+		return plural(int(d/time.Minute), "minute")
+	}
+	return d.String()
+}
+
+func plural(n int, unit string) string {
+	if n == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
@@ -0,0 +1,71 @@
+package tenant
+
+import (
+	"context"
+	"crypto/sha256"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// HeaderID names the tenant of a request, for the callers allowed to pick one. Organization API keys carry their own
+const HeaderID = "X-Tenant-Id"
+
+type contextKey struct{}
+
+// WithID scopes everything further down the stack to the organization, the DAO only reads and writes its rows
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext is the organization the work is scoped to, ok is false for work that isn't, ex: the workers
+func FromContext(ctx context.Context) (id string, ok bool) {
+	id, ok = ctx.Value(contextKey{}).(string)
+	return
+}
+
+// HashKey is what's stored of an organization API key, the key itself is only shown when it's made
+func HashKey(key string) []byte {
+	sum := sha256.Sum256([]byte(key))
+	return sum[:]
+}
+
+// Middleware resolves the tenant of the request, after the API key check has had its say: a request made with an
+// organization's key is already scoped to it, and may only name that organization in the X-Tenant-Id header. The
+// header is only taken from callers headerAllowed lets pick a tenant, ex: the admin key, anyone else naming one is
+// refused. Without a tenant the request sees every organization, unless required is set. skip leaves out the routes
+// that aren't scoped to a tenant, ex: the admin ones
+func Middleware(required bool, headerAllowed func(echo.Context) bool, skip func(echo.Context) bool) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if skip(c) {
+				return next(c)
+			}
+			var (
+				request   = c.Request()
+				header    = request.Header.Get(HeaderID)
+				id, keyed = FromContext(request.Context())
+			)
+			if header != "" {
+				parsed, err := uuid.Parse(header)
+				if err != nil {
+					return echo.NewHTTPError(http.StatusBadRequest, "X-Tenant-Id must be a UUID")
+				}
+				header = parsed.String()
+			}
+			switch {
+			case keyed && header != "" && header != id:
+				return echo.NewHTTPError(http.StatusForbidden, "the API key belongs to another tenant")
+			case keyed:
+			case header != "" && !headerAllowed(c):
+				return echo.NewHTTPError(http.StatusForbidden, "X-Tenant-Id isn't accepted from this caller, use the organization's API key")
+			case header != "":
+				c.SetRequest(request.WithContext(WithID(request.Context(), header)))
+			case required:
+				return echo.NewHTTPError(http.StatusBadRequest, "a tenant is required, use an organization API key")
+			}
+			return next(c)
+		}
+	}
+}
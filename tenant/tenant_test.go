@@ -0,0 +1,96 @@
+package tenant
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	organizationA = "00000000-0000-4000-8000-00000000000a"
+	organizationB = "00000000-0000-4000-8000-00000000000b"
+)
+
+func TestMiddleware(t *testing.T) {
+	tests := []struct {
+		name string
+		// the organization the API key check scoped the request to, empty for the shared keys
+		keyed         string
+		header        string
+		headerAllowed bool
+		required      bool
+		// the status, and the tenant the handler saw when it got through
+		status int
+		tenant string
+	}{
+		{name: "organization key", keyed: organizationA, status: http.StatusOK, tenant: organizationA},
+		{name: "organization key naming itself", keyed: organizationA, header: organizationA, status: http.StatusOK, tenant: organizationA},
+		{name: "organization key naming another", keyed: organizationA, header: organizationB, status: http.StatusForbidden},
+		{name: "organization key naming another, header allowed", keyed: organizationA, header: organizationB, headerAllowed: true, status: http.StatusForbidden},
+		{name: "header from a caller that may send it", header: organizationB, headerAllowed: true, status: http.StatusOK, tenant: organizationB},
+		{name: "header from a caller that may not send it", header: organizationB, required: true, status: http.StatusForbidden},
+		{name: "header from a caller that may not send it, not required", header: organizationB, status: http.StatusForbidden},
+		{name: "header that isn't a UUID", header: "clinic-b", headerAllowed: true, status: http.StatusBadRequest},
+		{name: "no tenant, required", required: true, status: http.StatusBadRequest},
+		{name: "no tenant, not required", status: http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				e       = echo.New()
+				request = httptest.NewRequest(http.MethodGet, "/v1/users/x", nil)
+				seen    string
+			)
+			if test.keyed != "" {
+				request = request.WithContext(WithID(request.Context(), test.keyed))
+			}
+			if test.header != "" {
+				request.Header.Set(HeaderID, test.header)
+			}
+			handler := Middleware(test.required, func(echo.Context) bool {
+				return test.headerAllowed
+			}, func(echo.Context) bool {
+				return false
+			})(func(c echo.Context) error {
+				seen, _ = FromContext(c.Request().Context())
+				return c.NoContent(http.StatusOK)
+			})
+			recorder := httptest.NewRecorder()
+			err := handler(e.NewContext(request, recorder))
+
+			status := recorder.Code
+			if httpErr, ok := err.(*echo.HTTPError); ok {
+				status = httpErr.Code
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if status != test.status {
+				t.Fatalf("got status %d, want %d", status, test.status)
+			}
+			if seen != test.tenant {
+				t.Fatalf("the handler saw tenant %q, want %q", seen, test.tenant)
+			}
+		})
+	}
+}
+
+func TestMiddlewareSkip(t *testing.T) {
+	e := echo.New()
+	request := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	request.Header.Set(HeaderID, organizationB)
+	handler := Middleware(true, func(echo.Context) bool {
+		return false
+	}, func(echo.Context) bool {
+		return true
+	})(func(c echo.Context) error {
+		if id, ok := FromContext(c.Request().Context()); ok {
+			t.Fatalf("a skipped route was scoped to %s", id)
+		}
+		return c.NoContent(http.StatusOK)
+	})
+	if err := handler(e.NewContext(request, httptest.NewRecorder())); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,46 @@
+package tracing
+
+import (
+	"context"
+
+	gopg "github.com/go-pg/pg/v10"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// QueryHook puts every go-pg query in its own client span. The SQL is recorded unformatted,
+// with placeholders rather than parameters, so patient data never ends up in a trace
+type QueryHook struct{}
+
+var _ gopg.QueryHook = QueryHook{}
+
+type spanKey struct{}
+
+func (QueryHook) BeforeQuery(ctx context.Context, event *gopg.QueryEvent) (context.Context, error) {
+	ctx, span := Tracer().Start(ctx, "db.query",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL),
+	)
+	if query, err := event.UnformattedQuery(); err == nil {
+		span.SetAttributes(semconv.DBStatement(string(query)))
+	}
+	if event.Stash == nil {
+		event.Stash = map[interface{}]interface{}{}
+	}
+	event.Stash[spanKey{}] = span
+	return ctx, nil
+}
+
+func (QueryHook) AfterQuery(ctx context.Context, event *gopg.QueryEvent) error {
+	span, ok := event.Stash[spanKey{}].(trace.Span)
+	if !ok {
+		return nil
+	}
+	if event.Err != nil {
+		span.RecordError(event.Err)
+		span.SetStatus(codes.Error, event.Err.Error())
+	}
+	span.End()
+	return nil
+}
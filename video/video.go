@@ -0,0 +1,61 @@
+package video
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"henrymeds-takehome/model"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// RoomProvider opens video rooms through a video conferencing service
+type RoomProvider interface {
+	// CreateRoom opens a room for the reservation and returns the link both the client and the provider join with.
+	// Called again for the same reservation it returns the same link
+	CreateRoom(ctx context.Context, reservation model.Reservation) (url string, err error)
+}
+
+// NewFakeRoomProvider makes up room links under baseURL instead of opening rooms anywhere, for local runs and tests
+func NewFakeRoomProvider(baseURL string) *fakeRoomProvider {
+	return &fakeRoomProvider{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		rooms:   map[string]string{},
+	}
+}
+
+type fakeRoomProvider struct {
+	baseURL string
+	mu      sync.Mutex
+	// reservation ID to room link
+	rooms map[string]string
+}
+
+func (p *fakeRoomProvider) CreateRoom(ctx context.Context, reservation model.Reservation) (url string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if room, ok := p.rooms[reservation.ID]; ok {
+		return room, nil
+	}
+	// unguessable like a real service's, the link is all it takes to join
+	raw := make([]byte, 16)
+	if _, err = rand.Read(raw); err != nil {
+		return "", err
+	}
+	url = p.baseURL + "/" + base64.RawURLEncoding.EncodeToString(raw)
+	p.rooms[reservation.ID] = url
+	slog.InfoContext(ctx, "fake video room created", "reservation_id", reservation.ID)
+	return url, nil
+}
+
+// Rooms returns the links handed out so far by reservation ID
+func (p *fakeRoomProvider) Rooms() map[string]string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	rooms := make(map[string]string, len(p.rooms))
+	for id, url := range p.rooms {
+		rooms[id] = url
+	}
+	return rooms
+}
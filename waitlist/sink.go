@@ -0,0 +1,56 @@
+package waitlist
+
+import (
+	"context"
+	"encoding/json"
+	"henrymeds-takehome/api"
+	"henrymeds-takehome/model"
+	"henrymeds-takehome/outbox"
+)
+
+// Offerer offers a provider's time that freed up to the waitlist
+type Offerer interface {
+	OfferWaitlistSlots(ctx context.Context, providerID string, timeRange model.TimeRange) (offered int, err error)
+}
+
+// NewSink is the outbox sink that offers time to the waitlist whenever it frees up: a reservation cancelled, a hold
+// expired, or availability added or extended. Going through the outbox means nothing is missed when an offer fails,
+// the event is published again and the time is looked at again
+func NewSink(offerer Offerer) *sink {
+	return &sink{
+		offerer: offerer,
+	}
+}
+
+type sink struct {
+	offerer Offerer
+}
+
+func (s *sink) Publish(ctx context.Context, event api.Event) (err error) {
+	var (
+		providerID string
+		timeRange  model.TimeRange
+	)
+	switch event.Type {
+	case outbox.ReservationCancelled, outbox.ReservationExpired:
+		var reservation api.Reservation
+		if err = json.Unmarshal(event.Data, &reservation); err != nil {
+			return
+		}
+		providerID, timeRange = reservation.ProviderID, model.TimeRange{Start: reservation.Start, End: reservation.End}
+	case outbox.AvailabilityCreated, outbox.AvailabilityUpdated:
+		var availability api.Availability
+		if err = json.Unmarshal(event.Data, &availability); err != nil {
+			return
+		}
+		providerID, timeRange = availability.ProviderID, model.TimeRange{Start: availability.Start, End: availability.End}
+	default:
+		return
+	}
+	_, err = s.offerer.OfferWaitlistSlots(ctx, providerID, timeRange)
+	return
+}
+
+func (s *sink) Close() error {
+	return nil
+}
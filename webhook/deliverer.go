@@ -0,0 +1,199 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"henrymeds-takehome/dao"
+	"henrymeds-takehome/metrics"
+	"henrymeds-takehome/model"
+	"henrymeds-takehome/netguard"
+	"henrymeds-takehome/outbox"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+type Options struct {
+	// how long the deliverer waits before looking again once nothing is due
+	PollInterval time.Duration
+	// how many deliveries are claimed at a time
+	BatchSize int
+	// how long a claimed batch is reserved for this deliverer, it has to cover sending the whole batch
+	Lease time.Duration
+	// how long a receiver gets to answer
+	Timeout time.Duration
+	// the wait before retrying a failed delivery, doubled with every failure up to MaxBackoff
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// a delivery that failed this many times is dead, it waits in the dead letters until it's redelivered
+	MaxAttempts int
+	// let subscriptions reach loopback and private addresses, ex: an httptest receiver
+	AllowPrivate bool
+}
+
+// NewDeliverer builds the worker that POSTs the enqueued deliveries to their subscriptions
+func NewDeliverer(webhookDao dao.WebhookDao, options Options) *Deliverer {
+	dialer := netguard.Dialer(options.Timeout, options.AllowPrivate)
+	return &Deliverer{
+		webhookDao: webhookDao,
+		options:    options,
+		client: &http.Client{
+			Timeout: options.Timeout,
+			Transport: &http.Transport{
+				DialContext:         dialer.DialContext,
+				TLSHandshakeTimeout: options.Timeout,
+				// proxies from the environment would be dialed instead of the receiver and skip the address check
+				Proxy: nil,
+			},
+			// a receiver that moved has to be updated in its subscription, the signature is only for the URL it names
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+type Deliverer struct {
+	webhookDao dao.WebhookDao
+	options    Options
+	client     *http.Client
+}
+
+// Run delivers until ctx is cancelled, several deliverers can run against the same deliveries
+func (d *Deliverer) Run(ctx context.Context) {
+	for {
+		claimed, err := d.deliverBatch(ctx)
+		if err != nil && ctx.Err() == nil {
+			slog.ErrorContext(ctx, "failed to deliver webhooks", "error", err)
+		}
+		// a full batch means there's probably more waiting, go straight back for it
+		if err == nil && claimed == d.options.BatchSize {
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(d.options.PollInterval):
+		}
+	}
+}
+
+// deliverBatch claims a batch and sends it, it returns how many deliveries were claimed
+func (d *Deliverer) deliverBatch(ctx context.Context) (claimed int, err error) {
+	var (
+		deliveries    []model.WebhookDelivery
+		subscriptions []model.WebhookSubscription
+		ids           []string
+	)
+	deliveries, err = d.webhookDao.ClaimWebhookDeliveries(ctx, d.options.BatchSize, d.options.Lease)
+	if err != nil || len(deliveries) == 0 {
+		return len(deliveries), err
+	}
+	for _, delivery := range deliveries {
+		ids = append(ids, delivery.SubscriptionID)
+	}
+	subscriptions, err = d.webhookDao.GetWebhookSubscriptionsByID(ctx, ids)
+	if err != nil {
+		return
+	}
+	byID := make(map[string]model.WebhookSubscription, len(subscriptions))
+	for _, subscription := range subscriptions {
+		byID[subscription.ID] = subscription
+	}
+
+	for _, delivery := range deliveries {
+		// on shutdown the rest of the batch is left for the lease to run out on
+		if ctx.Err() != nil {
+			break
+		}
+		d.deliver(ctx, delivery, byID[delivery.SubscriptionID])
+	}
+	return len(deliveries), nil
+}
+
+func (d *Deliverer) deliver(ctx context.Context, delivery model.WebhookDelivery, subscription model.WebhookSubscription) {
+	var (
+		attempt = model.WebhookAttempt{DeliveryID: delivery.ID, AttemptedAt: time.Now()}
+		dead    bool
+	)
+	// deleted since the batch was claimed, its deliveries went with it
+	if subscription.ID == "" {
+		return
+	}
+	if subscription.Active {
+		attempt.StatusCode, attempt.Error = d.post(ctx, delivery, subscription)
+	} else {
+		// deactivated since the event was enqueued, it goes straight to the dead letters so it can be redelivered
+		// if the subscription comes back
+		attempt.Error = "the subscription is inactive"
+		dead = true
+	}
+	attempt.DurationMs = int(time.Since(attempt.AttemptedAt).Milliseconds())
+	// the bookkeeping below runs even if shutdown started during the POST, so a delivered event isn't sent again
+	ctx = context.WithoutCancel(ctx)
+
+	delivery.Attempts++
+	delivery.LastStatusCode = attempt.StatusCode
+	delivery.LastError = attempt.Error
+	outcome := metrics.WebhookDelivered
+	switch {
+	case attempt.Error == "":
+		delivery.Status = model.WebhookDelivered
+		delivery.DeliveredAt = attempt.AttemptedAt
+	case dead || delivery.Attempts >= d.options.MaxAttempts:
+		delivery.Status = model.WebhookDead
+		outcome = metrics.WebhookDead
+		slog.WarnContext(ctx, "webhook delivery is dead",
+			"delivery_id", delivery.ID,
+			"subscription_id", delivery.SubscriptionID,
+			"event_id", delivery.EventID,
+			"attempts", delivery.Attempts,
+			"error", attempt.Error,
+		)
+	default:
+		delivery.NextAttemptAt = time.Now().Add(outbox.Backoff(delivery.Attempts, d.options.MinBackoff, d.options.MaxBackoff))
+		outcome = metrics.WebhookFailed
+	}
+	metrics.WebhookDeliveries.WithLabelValues(outcome).Inc()
+
+	if err := d.webhookDao.RecordWebhookAttempt(ctx, delivery, attempt); err != nil {
+		// a delivered one is sent again once its lease runs out, receivers dedupe on the event ID
+		slog.ErrorContext(ctx, "failed to record webhook attempt", "delivery_id", delivery.ID, "error", err)
+	}
+}
+
+// post sends the delivery, errorMessage is empty when the receiver took it
+func (d *Deliverer) post(ctx context.Context, delivery model.WebhookDelivery, subscription model.WebhookSubscription) (statusCode int, errorMessage string) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err.Error()
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "henrymeds-webhooks")
+	request.Header.Set("X-Event-Id", delivery.EventID)
+	request.Header.Set("X-Event-Type", delivery.EventType)
+	request.Header.Set("X-Webhook-Delivery", delivery.ID)
+	// signed on every attempt, the timestamp is when it was sent and not when the event happened
+	request.Header.Set(SignatureHeader, Sign(subscription.Secret, time.Now(), delivery.Payload))
+
+	response, err := d.client.Do(request)
+	if errors.Is(err, netguard.ErrPrivateAddress) {
+		return 0, "the subscription URL points at a private address"
+	}
+	if err != nil {
+		return 0, err.Error()
+	}
+	defer response.Body.Close()
+	// read a little of the body so the connection can be reused, a receiver has no reason to send much back
+	_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Sprintf("the receiver answered %s", response.Status)
+	}
+	return response.StatusCode, ""
+}
@@ -0,0 +1,330 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"henrymeds-takehome/api"
+	"henrymeds-takehome/model"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	organizationA = "00000000-0000-4000-8000-00000000000a"
+	organizationB = "00000000-0000-4000-8000-00000000000b"
+	secret        = "a-secret-long-enough"
+)
+
+// fakeWebhookDao keeps the subscriptions and deliveries in memory, the way the webhook tables hold them
+type fakeWebhookDao struct {
+	mu            sync.Mutex
+	subscriptions []model.WebhookSubscription
+	deliveries    map[string]model.WebhookDelivery
+	attempts      []model.WebhookAttempt
+}
+
+func newFakeWebhookDao(subscriptions ...model.WebhookSubscription) *fakeWebhookDao {
+	return &fakeWebhookDao{subscriptions: subscriptions, deliveries: map[string]model.WebhookDelivery{}}
+}
+
+func (d *fakeWebhookDao) EnqueueWebhookDeliveries(ctx context.Context, organizationID string, eventID string, eventType string, payload json.RawMessage, at time.Time) (enqueued int, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+next:
+	for _, subscription := range d.subscriptions {
+		if !subscription.Active || subscription.OrganizationID != organizationID {
+			continue
+		}
+		for _, delivery := range d.deliveries {
+			if delivery.SubscriptionID == subscription.ID && delivery.EventID == eventID {
+				continue next
+			}
+		}
+		for _, subscribed := range subscription.EventTypes {
+			if subscribed == eventType {
+				id := uuid.NewString()
+				d.deliveries[id] = model.WebhookDelivery{
+					ID:             id,
+					SubscriptionID: subscription.ID,
+					EventID:        eventID,
+					EventType:      eventType,
+					Payload:        payload,
+					Status:         model.WebhookPending,
+					NextAttemptAt:  at,
+					CreatedAt:      at,
+				}
+				enqueued++
+			}
+		}
+	}
+	return
+}
+
+func (d *fakeWebhookDao) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) (deliveries []model.WebhookDelivery, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	for id, delivery := range d.deliveries {
+		if len(deliveries) == limit {
+			break
+		}
+		if delivery.Status == model.WebhookPending && !delivery.NextAttemptAt.After(now) {
+			delivery.NextAttemptAt = now.Add(lease)
+			d.deliveries[id] = delivery
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].CreatedAt.Before(deliveries[j].CreatedAt) })
+	return
+}
+
+func (d *fakeWebhookDao) GetWebhookSubscriptionsByID(ctx context.Context, ids []string) (subscriptions []model.WebhookSubscription, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, subscription := range d.subscriptions {
+		for _, id := range ids {
+			if subscription.ID == id {
+				subscriptions = append(subscriptions, subscription)
+				break
+			}
+		}
+	}
+	return
+}
+
+func (d *fakeWebhookDao) RecordWebhookAttempt(ctx context.Context, delivery model.WebhookDelivery, attempt model.WebhookAttempt) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.deliveries[delivery.ID] = delivery
+	d.attempts = append(d.attempts, attempt)
+	return nil
+}
+
+// only returns the one delivery the tests make
+func (d *fakeWebhookDao) delivery(t *testing.T) model.WebhookDelivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.deliveries) != 1 {
+		t.Fatalf("there are %d deliveries, want 1", len(d.deliveries))
+	}
+	for _, delivery := range d.deliveries {
+		return delivery
+	}
+	return model.WebhookDelivery{}
+}
+
+func subscription(organizationID string, url string, eventTypes ...string) model.WebhookSubscription {
+	return model.WebhookSubscription{
+		ID:             uuid.NewString(),
+		OrganizationID: organizationID,
+		URL:            url,
+		EventTypes:     eventTypes,
+		Secret:         secret,
+		Active:         true,
+	}
+}
+
+func event(organizationID string, eventType string) api.Event {
+	return api.Event{
+		ID:             uuid.NewString(),
+		Type:           eventType,
+		OrganizationID: organizationID,
+		OccurredAt:     time.Now(),
+		Data:           json.RawMessage(`{"id":"d3c1f0a2-0000-4000-8000-000000000001"}`),
+	}
+}
+
+var options = Options{
+	BatchSize:    10,
+	Lease:        time.Minute,
+	Timeout:      time.Second,
+	MinBackoff:   time.Millisecond,
+	MaxBackoff:   2 * time.Millisecond,
+	MaxAttempts:  3,
+	AllowPrivate: true,
+}
+
+// deliverUntilSettled runs the deliverer until nothing is left to claim, waiting out the backoffs between attempts
+func deliverUntilSettled(t *testing.T, deliverer *Deliverer, webhookDao *fakeWebhookDao) {
+	for i := 0; i < 100; i++ {
+		if _, err := deliverer.deliverBatch(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if webhookDao.delivery(t).Status != model.WebhookPending {
+			return
+		}
+		time.Sleep(options.MaxBackoff)
+	}
+	t.Fatal("the delivery never settled")
+}
+
+func TestDeliverSignsTheEvent(t *testing.T) {
+	var (
+		received = make(chan *http.Request, 1)
+		body     []byte
+		receiver = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ = io.ReadAll(r.Body)
+			received <- r
+		}))
+	)
+	defer receiver.Close()
+	webhookDao := newFakeWebhookDao(subscription(organizationA, receiver.URL, "reservation.held"))
+	sent := event(organizationA, "reservation.held")
+	if err := NewFanoutSink(webhookDao).Publish(context.Background(), sent); err != nil {
+		t.Fatal(err)
+	}
+
+	deliverUntilSettled(t, NewDeliverer(webhookDao, options), webhookDao)
+	request := <-received
+	if err := Verify(secret, request.Header.Get(SignatureHeader), body, time.Now(), time.Minute); err != nil {
+		t.Fatalf("the signature doesn't verify: %v", err)
+	}
+	if err := Verify("another-secret-entirely", request.Header.Get(SignatureHeader), body, time.Now(), time.Minute); !errors.Is(err, ErrSignatureMismatch) {
+		t.Fatalf("the signature verifies with another secret, got %v", err)
+	}
+	if request.Header.Get("X-Event-Id") != sent.ID || request.Header.Get("X-Event-Type") != sent.Type {
+		t.Fatalf("sent the headers %v", request.Header)
+	}
+	var delivered api.Event
+	if err := json.Unmarshal(body, &delivered); err != nil || delivered.ID != sent.ID || delivered.OrganizationID != organizationA {
+		t.Fatalf("delivered %s, err %v", body, err)
+	}
+
+	delivery := webhookDao.delivery(t)
+	if delivery.Status != model.WebhookDelivered || delivery.Attempts != 1 || delivery.LastStatusCode != http.StatusOK || delivery.DeliveredAt.IsZero() {
+		t.Fatalf("the delivery is %+v", delivery)
+	}
+}
+
+func TestDeliverRetries(t *testing.T) {
+	var (
+		calls    atomic.Int32
+		receiver = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// down for the first attempt
+			if calls.Add(1) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}))
+	)
+	defer receiver.Close()
+	webhookDao := newFakeWebhookDao(subscription(organizationA, receiver.URL, "reservation.held"))
+	if err := NewFanoutSink(webhookDao).Publish(context.Background(), event(organizationA, "reservation.held")); err != nil {
+		t.Fatal(err)
+	}
+	deliverer := NewDeliverer(webhookDao, options)
+
+	if _, err := deliverer.deliverBatch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	delivery := webhookDao.delivery(t)
+	if delivery.Status != model.WebhookPending || delivery.Attempts != 1 || delivery.LastStatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("after a failure the delivery is %+v", delivery)
+	}
+	if wait := time.Until(delivery.NextAttemptAt); wait > options.MaxBackoff {
+		t.Fatalf("the retry is %s away, past the max backoff", wait)
+	}
+
+	deliverUntilSettled(t, deliverer, webhookDao)
+	delivery = webhookDao.delivery(t)
+	if delivery.Status != model.WebhookDelivered || delivery.Attempts != 2 || delivery.LastError != "" {
+		t.Fatalf("after the retry the delivery is %+v", delivery)
+	}
+	if len(webhookDao.attempts) != 2 || calls.Load() != 2 {
+		t.Fatalf("made %d attempts and %d calls, want 2", len(webhookDao.attempts), calls.Load())
+	}
+}
+
+func TestDeliverDeadLetters(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		// the subscription is paused
+		inactive bool
+		attempts int
+		error    string
+	}{
+		{
+			name:     "a receiver that keeps failing",
+			handler:  func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusInternalServerError) },
+			attempts: options.MaxAttempts,
+			error:    "500",
+		},
+		{
+			name:     "a receiver that redirects",
+			handler:  func(w http.ResponseWriter, r *http.Request) { http.Redirect(w, r, "/elsewhere", http.StatusFound) },
+			attempts: options.MaxAttempts,
+			error:    "302",
+		},
+		{
+			name:     "a paused subscription",
+			handler:  func(w http.ResponseWriter, r *http.Request) {},
+			inactive: true,
+			attempts: 1,
+			error:    "inactive",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls atomic.Int32
+			receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				test.handler(w, r)
+			}))
+			defer receiver.Close()
+			webhookDao := newFakeWebhookDao(subscription(organizationA, receiver.URL, "reservation.held"))
+			if err := NewFanoutSink(webhookDao).Publish(context.Background(), event(organizationA, "reservation.held")); err != nil {
+				t.Fatal(err)
+			}
+			// paused after the event was enqueued
+			webhookDao.subscriptions[0].Active = !test.inactive
+
+			deliverer := NewDeliverer(webhookDao, options)
+			deliverUntilSettled(t, deliverer, webhookDao)
+			delivery := webhookDao.delivery(t)
+			if delivery.Status != model.WebhookDead || delivery.Attempts != test.attempts || !strings.Contains(delivery.LastError, test.error) {
+				t.Fatalf("the delivery is %+v", delivery)
+			}
+			if test.inactive && calls.Load() != 0 {
+				t.Fatal("a paused subscription was sent the event")
+			}
+
+			// the dead letters aren't picked up again
+			if claimed, err := deliverer.deliverBatch(context.Background()); err != nil || claimed != 0 {
+				t.Fatalf("claimed %d dead deliveries, err %v", claimed, err)
+			}
+		})
+	}
+}
+
+func TestDeliverRefusesPrivateAddresses(t *testing.T) {
+	var calls atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	}))
+	defer receiver.Close()
+	webhookDao := newFakeWebhookDao(subscription(organizationA, receiver.URL, "reservation.held"))
+	if err := NewFanoutSink(webhookDao).Publish(context.Background(), event(organizationA, "reservation.held")); err != nil {
+		t.Fatal(err)
+	}
+
+	guarded := options
+	guarded.AllowPrivate = false
+	deliverUntilSettled(t, NewDeliverer(webhookDao, guarded), webhookDao)
+	delivery := webhookDao.delivery(t)
+	if delivery.Status != model.WebhookDead || !strings.Contains(delivery.LastError, "private address") {
+		t.Fatalf("the delivery is %+v", delivery)
+	}
+	if calls.Load() != 0 {
+		t.Fatalf("the receiver on a loopback address was called %d times", calls.Load())
+	}
+}
@@ -0,0 +1,46 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"henrymeds-takehome/api"
+	"henrymeds-takehome/dao"
+	"log/slog"
+	"time"
+)
+
+// NewFanoutSink is the outbox sink that hands every event to the webhook subscriptions to its type of the event's
+// organization, it only enqueues the deliveries, the Deliverer sends them
+func NewFanoutSink(webhookDao dao.WebhookDao) *fanoutSink {
+	return &fanoutSink{
+		webhookDao: webhookDao,
+	}
+}
+
+type fanoutSink struct {
+	webhookDao dao.WebhookDao
+}
+
+func (s *fanoutSink) Publish(ctx context.Context, event api.Event) (err error) {
+	var (
+		payload  []byte
+		enqueued int
+	)
+	// subscriptions belong to an organization, an event that isn't any organization's is none of theirs
+	if event.OrganizationID == "" {
+		return
+	}
+	payload, err = json.Marshal(event)
+	if err != nil {
+		return
+	}
+	enqueued, err = s.webhookDao.EnqueueWebhookDeliveries(ctx, event.OrganizationID, event.ID, event.Type, payload, time.Now())
+	if err == nil && enqueued > 0 {
+		slog.DebugContext(ctx, "webhook deliveries enqueued", "event_id", event.ID, "type", event.Type, "count", enqueued)
+	}
+	return
+}
+
+func (s *fanoutSink) Close() error {
+	return nil
+}
@@ -0,0 +1,41 @@
+package webhook
+
+import (
+	"context"
+	"testing"
+)
+
+func TestFanoutStaysInTheOrganization(t *testing.T) {
+	var (
+		ctx        = context.Background()
+		ofA        = subscription(organizationA, "https://a.example.com/hooks", "reservation.held", "reservation.confirmed")
+		ofB        = subscription(organizationB, "https://b.example.com/hooks", "reservation.held")
+		webhookDao = newFakeWebhookDao(ofA, ofB)
+		sink       = NewFanoutSink(webhookDao)
+	)
+	for _, sent := range []struct {
+		organizationID string
+		eventType      string
+	}{
+		{organizationA, "reservation.held"},
+		{organizationA, "reservation.confirmed"},
+		{organizationB, "reservation.confirmed"},
+		// not any organization's, no subscription gets it
+		{"", "reservation.held"},
+	} {
+		if err := sink.Publish(ctx, event(sent.organizationID, sent.eventType)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := map[string][]string{}
+	for _, delivery := range webhookDao.deliveries {
+		got[delivery.SubscriptionID] = append(got[delivery.SubscriptionID], delivery.EventType)
+	}
+	if len(got[ofA.ID]) != 2 {
+		t.Fatalf("a's subscription got %v, want its own two events", got[ofA.ID])
+	}
+	if len(got[ofB.ID]) != 0 {
+		t.Fatalf("b's subscription got %v, want nothing, b's one event is of a type it isn't subscribed to", got[ofB.ID])
+	}
+}
@@ -0,0 +1,65 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureHeader carries the payload's signature, t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>">.
+// The timestamp is signed too, a receiver that checks it against its clock can't be replayed an old delivery
+const SignatureHeader = "X-Webhook-Signature"
+
+var (
+	ErrMalformedSignature = errors.New("malformed webhook signature")
+	ErrSignatureMismatch  = errors.New("webhook signature doesn't match")
+	ErrSignatureExpired   = errors.New("webhook signature is too old")
+)
+
+// Sign returns the SignatureHeader value for body sent at at
+func Sign(secret string, at time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac(secret, timestamp, body))
+}
+
+// Verify checks a SignatureHeader value against the body, for receivers. A signature made more than tolerance away
+// from now is refused, 0 skips the check
+func Verify(secret string, header string, body []byte, now time.Time, tolerance time.Duration) error {
+	var (
+		timestamp string
+		signature []byte
+	)
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signature, _ = hex.DecodeString(value)
+		}
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signature) == 0 {
+		return ErrMalformedSignature
+	}
+	if !hmac.Equal(signature, mac(secret, timestamp, body)) {
+		return ErrSignatureMismatch
+	}
+	if age := now.Sub(time.Unix(seconds, 0)).Abs(); tolerance > 0 && age > tolerance {
+		return fmt.Errorf("%w: signed %s from now", ErrSignatureExpired, age.Round(time.Second))
+	}
+	return nil
+}
+
+func mac(secret string, timestamp string, body []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(timestamp))
+	h.Write([]byte("."))
+	h.Write(body)
+	return h.Sum(nil)
+}